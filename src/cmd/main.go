@@ -14,13 +14,19 @@ import (
 
 	"github.com/LombardiDaniel/goliath/src/internal/handlers"
 	"github.com/LombardiDaniel/goliath/src/internal/middlewares"
+	"github.com/LombardiDaniel/goliath/src/internal/models"
 	"github.com/LombardiDaniel/goliath/src/internal/services"
+	"github.com/LombardiDaniel/goliath/src/internal/templates"
 	"github.com/LombardiDaniel/goliath/src/pkg/common"
 	"github.com/LombardiDaniel/goliath/src/pkg/constants"
 	"github.com/LombardiDaniel/goliath/src/pkg/daemons"
 	"github.com/LombardiDaniel/goliath/src/pkg/it"
 	"github.com/LombardiDaniel/goliath/src/pkg/logger"
+	"github.com/LombardiDaniel/goliath/src/pkg/mail"
 	"github.com/LombardiDaniel/goliath/src/pkg/oauth"
+	"github.com/LombardiDaniel/goliath/src/pkg/payments"
+	"github.com/LombardiDaniel/goliath/src/pkg/storage"
+	"github.com/LombardiDaniel/goliath/src/pkg/token"
 	_ "github.com/lib/pq"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
@@ -45,21 +51,50 @@ var (
 	ctx    context.Context
 	router *gin.Engine
 
-	authService         services.AuthService
-	userService         services.UserService
-	emailService        services.EmailService
-	organizationService services.OrganizationService
-	objectService       services.ObjectService
-	billingService      services.BillingService
-	telemetryService    services.TelemetryService
+	authService           services.AuthService
+	userService           services.UserService
+	emailService          services.EmailService
+	outboxService         services.OutboxService
+	organizationService   services.OrganizationService
+	objectService         services.ObjectService
+	billingService        services.BillingService
+	planService           services.PlanService
+	entitlementService    services.EntitlementService
+	telemetryService      services.TelemetryService
+	usageService          services.UsageService
+	invoiceService        services.InvoiceService
+	couponService         services.CouponService
+	dunningService        services.DunningService
+	deliverabilityService services.DeliverabilityService
+	preferenceService     services.PreferenceService
+	notificationService   services.NotificationService
+	messageService        services.MessageService
+	uploadService         services.UploadService
 
 	authHandler         handlers.AuthHandler
 	userHandler         handlers.UserHandler
 	organizationHandler handlers.OrganizationHandler
 	billingHandler      handlers.BillingHandler
+	planHandler         handlers.PlanHandler
+	couponHandler       handlers.CouponHandler
+	dunningHandler      handlers.DunningHandler
+	emailHandler        handlers.EmailHandler
+	notificationHandler handlers.NotificationHandler
+	objectHandler       handlers.ObjectHandler
+	uploadHandler       handlers.UploadHandler
 
-	authMiddleware      middlewares.AuthMiddleware
-	telemetryMiddleware middlewares.TelemetryMiddleware
+	authMiddleware        middlewares.AuthMiddleware
+	telemetryMiddleware   middlewares.TelemetryMiddleware
+	entitlementMiddleware middlewares.EntitlementMiddleware
+	localeMiddleware      middlewares.LocaleMiddleware
+
+	paymentGateway payments.Gateway
+	fakeGateway    *payments.FakeGateway
+
+	objectUrlSigner *storage.UrlSigner
+
+	mailbox      *mail.Mailbox
+	emailWebhook mail.WebhookParser
 
 	taskRunner daemons.TaskRunner
 )
@@ -120,40 +155,139 @@ func init() {
 		Endpoint: github.Endpoint,
 	})
 
-	s3Host := it.Must(common.ExtractHostFromUrl(constants.S3Endpoint))
-	s3Secure := it.Must(common.UrlIsSecure(constants.S3Endpoint))
-	minioClient := it.Must(minio.New(
-		s3Host,
-		&minio.Options{
-			Creds: credentials.NewStaticV4(
-				os.Getenv("S3_ACCESS_KEY_ID"),
-				os.Getenv("S3_SECRET_ACCESS_KEY"),
-				"",
-			),
-			Region: constants.S3Region,
-			Secure: s3Secure,
-		},
-	))
+	switch common.GetEnvVarDefault("BILLING_GATEWAY", payments.STRIPE_GATEWAY) {
+	case payments.STRIPE_GATEWAY:
+		paymentGateway = payments.NewStripeGateway(os.Getenv("STRIPE_API_KEY"), os.Getenv("STRIPE_WEBHOOK_SECRET"), nil)
+	case payments.FAKE_GATEWAY:
+		// anyone can pay the checkouts of the fake gateway, it is only for development
+		if os.Getenv("GIN_MODE") == "release" {
+			panic("BILLING_GATEWAY fake cannot be used with GIN_MODE release")
+		}
+		// both ends of the webhook live in this process, so the secret never leaves it
+		fakeGateway = payments.NewFakeGateway(
+			constants.ApiHostUrl+"fake-gateway",
+			constants.ApiHostUrl+"v1/billing/webhook",
+			it.Must(common.GenerateRandomString(32)),
+		)
+		paymentGateway = fakeGateway
+	default:
+		panic("BILLING_GATEWAY must be one of: stripe, fake")
+	}
+
+	// the backends without signed URLs of their own have theirs served by the objectHandler
+	objectsUrl := constants.ApiHostUrl + "v1/objects"
+	objectStorage := common.GetEnvVarDefault("OBJECT_STORAGE", storage.S3_BACKEND)
+	if objectStorage != storage.S3_BACKEND {
+		objectUrlSigner = storage.NewUrlSigner(objectsUrl, it.Must(token.DeriveKey([]byte(os.Getenv("JWT_SECRET_KEY")), "object-urls")))
+		if os.Getenv("OBJECTS_PUBLIC_URL") == "" {
+			constants.ObjectsPublicUrl = objectsUrl
+		}
+	}
+	switch objectStorage {
+	case storage.S3_BACKEND:
+		s3Host := it.Must(common.ExtractHostFromUrl(constants.S3Endpoint))
+		s3Secure := it.Must(common.UrlIsSecure(constants.S3Endpoint))
+		minioClient := it.Must(minio.New(
+			s3Host,
+			&minio.Options{
+				Creds: credentials.NewStaticV4(
+					os.Getenv("S3_ACCESS_KEY_ID"),
+					os.Getenv("S3_SECRET_ACCESS_KEY"),
+					"",
+				),
+				Region: constants.S3Region,
+				Secure: s3Secure,
+			},
+		))
+		objectService = services.NewObjectServiceMinioImpl(minioClient)
+	case storage.FS_BACKEND:
+		objectService = it.Must(services.NewObjectServiceFsImpl(common.GetEnvVarDefault("OBJECT_STORAGE_DIR", "tmp/objects"), objectUrlSigner))
+	case storage.MEMORY_BACKEND:
+		objectService = services.NewObjectServiceMemoryImpl(objectUrlSigner)
+	default:
+		panic("OBJECT_STORAGE must be one of: s3, fs, memory")
+	}
 
 	authService = services.NewAuthServiceJwtImpl(os.Getenv("JWT_SECRET_KEY"), db)
-	userService = services.NewUserServicePgImpl(db)
-	if os.Getenv("RESEND_API_KEY") == "mock" {
-		emailService = &services.EmailServiceMock{}
-	} else {
-		emailService = services.NewEmailServiceResendImpl(os.Getenv("RESEND_API_KEY"), "internal/templates")
+	emailTemplates := it.Must(templates.NewRegistry())
+	// unsubscribe links are signed with a key derived from the JWT secret for them alone,
+	// they expire after constants.UnsubscribeUrlExpiry and can be revoked per user
+	preferenceService = services.NewPreferenceServicePgImpl(db, it.Must(token.DeriveKey([]byte(os.Getenv("JWT_SECRET_KEY")), "unsubscribe-links")))
+	var emailSender services.EmailService
+	// providers without webhooks of their own get their delivery events through a signed relay
+	emailWebhook = mail.NewSignedWebhook(os.Getenv("EMAIL_WEBHOOK_SECRET"))
+	switch common.GetEnvVarDefault("EMAIL_PROVIDER", mail.RESEND_PROVIDER) {
+	case mail.RESEND_PROVIDER:
+		emailWebhook = it.Must(mail.NewResendWebhook(os.Getenv("RESEND_WEBHOOK_SECRET")))
+		if os.Getenv("RESEND_API_KEY") == "mock" {
+			emailSender = &services.EmailServiceMock{}
+		} else {
+			emailSender = services.NewEmailServiceTemplateImpl(mail.NewResendSender(os.Getenv("RESEND_API_KEY")), emailTemplates, preferenceService)
+		}
+	case mail.SMTP_PROVIDER:
+		smtpSender := it.Must(mail.NewSmtpSender(mail.SmtpConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     it.Must(strconv.Atoi(common.GetEnvVarDefault("SMTP_PORT", "587"))),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			Security: mail.SmtpSecurity(common.GetEnvVarDefault("SMTP_SECURITY", string(mail.SmtpStartTls))),
+		}))
+		emailSender = services.NewEmailServiceTemplateImpl(smtpSender, emailTemplates, preferenceService)
+	case mail.FILE_PROVIDER:
+		sink := it.Must(mail.NewFileSink(common.GetEnvVarDefault("EMAIL_SINK_DIR", "tmp/emails")))
+		emailSender = services.NewEmailServiceTemplateImpl(sink, emailTemplates, preferenceService)
+	case mail.MAILBOX_PROVIDER:
+		// the mailbox serves the emails sent, one-time passwords included, it is only for development
+		if os.Getenv("GIN_MODE") == "release" {
+			panic("EMAIL_PROVIDER mailbox cannot be used with GIN_MODE release")
+		}
+		mailbox = mail.NewMailbox(500)
+		emailSender = services.NewEmailServiceTemplateImpl(mailbox, emailTemplates, preferenceService)
+	case mail.MOCK_PROVIDER:
+		emailSender = &services.EmailServiceMock{}
+	default:
+		panic("EMAIL_PROVIDER must be one of: resend, smtp, file, mailbox, mock")
 	}
-	organizationService = services.NewOrganizationServicePgImpl(db)
-	objectService = services.NewObjectServiceMinioImpl(minioClient)
-	billingService = services.NewBillingService(db, os.Getenv("STRIPE_API_KEY"))
+	deliverabilityService = services.NewDeliverabilityServicePgImpl(db)
+	emailSender = services.NewEmailServiceSuppressionImpl(emailSender, deliverabilityService)
+	// emails are enqueued in the outbox and delivered by its task, not sent within requests
+	outboxService = services.NewOutboxServicePgImpl(db, emailSender, emailTemplates)
+	emailService = outboxService
+	userService = services.NewUserServicePgImpl(db, outboxService)
+	notificationService = services.NewNotificationServicePgImpl(db, pgConnStr)
+	messageService = services.NewMessageServicePgImpl(db, outboxService, notificationService)
+	uploadService = services.NewUploadServicePgImpl(db)
+	organizationService = services.NewOrganizationServicePgImpl(db, outboxService, notificationService)
+	planService = services.NewPlanServicePgImpl(db)
+	entitlementService = services.NewEntitlementServicePgImpl(db, map[models.Limit]int64{
+		models.SeatsLimit:        int64(it.Must(strconv.Atoi(common.GetEnvVarDefault("FREE_PLAN_SEATS", "5")))),
+		models.StorageBytesLimit: int64(it.Must(strconv.Atoi(common.GetEnvVarDefault("FREE_PLAN_STORAGE_BYTES", "104857600")))),
+		models.ApiCallsLimit:     int64(it.Must(strconv.Atoi(common.GetEnvVarDefault("FREE_PLAN_API_CALLS", "10000")))),
+	})
+	billingService = services.NewBillingServicePgImpl(db, paymentGateway)
+	invoiceService = services.NewInvoiceServicePgImpl(db)
+	couponService = services.NewCouponServicePgImpl(db)
+	dunningService = services.NewDunningServicePgImpl(db, paymentGateway)
 	telemetryService = services.NewTelemetryServiceMongoAsyncImpl(mongoClient, metricsCol, eventsCol, 100)
+	usageService = services.NewUsageServicePgAsyncImpl(db)
 
 	authMiddleware = middlewares.NewAuthMiddlewareJwt(authService)
-	telemetryMiddleware = middlewares.NewTelemetryMiddleware(telemetryService)
+	telemetryMiddleware = middlewares.NewTelemetryMiddleware(telemetryService, usageService)
+	entitlementMiddleware = middlewares.NewEntitlementMiddleware(entitlementService, authService)
+	localeMiddleware = middlewares.NewLocaleMiddleware(it.Must(templates.NewCatalog()))
 
 	authHandler = handlers.NewAuthHandler(authService, userService, emailService, oauthConfigMap)
-	userHandler = handlers.NewUserHandler(authService, userService, emailService, objectService)
-	organizationHandler = handlers.NewOrganizationHandler(userService, emailService, organizationService)
-	billingHandler = handlers.NewBillingHandler(billingService, emailService, userService)
+	userHandler = handlers.NewUserHandler(authService, userService, emailService, objectService, entitlementService)
+	organizationHandler = handlers.NewOrganizationHandler(userService, emailService, organizationService, entitlementService)
+	billingHandler = handlers.NewBillingHandler(billingService, planService, couponService, messageService, userService, usageService, invoiceService, objectService, telemetryService)
+	planHandler = handlers.NewPlanHandler(planService, billingService)
+	couponHandler = handlers.NewCouponHandler(couponService, planService, billingService)
+	dunningHandler = handlers.NewDunningHandler(dunningService, billingService, organizationService, userService, messageService, telemetryService)
+	dunningHandler.RegisterWebhookHandlers(&billingHandler)
+	emailHandler = handlers.NewEmailHandler(outboxService, emailTemplates, deliverabilityService, emailWebhook)
+	notificationHandler = handlers.NewNotificationHandler(preferenceService, notificationService)
+	objectHandler = handlers.NewObjectHandler(objectService, objectUrlSigner)
+	uploadHandler = handlers.NewUploadHandler(uploadService, objectService, entitlementService)
 
 	router = gin.Default()
 	router.SetTrustedProxies([]string{"*"})
@@ -162,12 +296,23 @@ func init() {
 	corsCfg.AllowOrigins = []string{constants.ApiHostUrl, constants.AppHostUrl}
 	corsCfg.AllowCredentials = true
 	corsCfg.AddAllowHeaders("Authorization")
+	corsCfg.AddExposeHeaders("X-Error-Code")
 	corsCfg.MaxAge = 24 * time.Hour
 
 	slog.Info(fmt.Sprintf("corsCfg: %+v", corsCfg))
 
 	router.Use(cors.New(corsCfg))
-	router.Use(limits.RequestSizeLimiter(constants.MaxRequestSize))
+	// objects and parts of uploads are streamed to the storage, so they get a larger limit
+	requestSizeLimiter := limits.RequestSizeLimiter(constants.MaxRequestSize)
+	uploadSizeLimiter := limits.RequestSizeLimiter(constants.MaxUploadPartSize)
+	router.Use(func(ctx *gin.Context) {
+		if strings.HasPrefix(ctx.Request.URL.Path, "/v1/objects/") || strings.HasPrefix(ctx.Request.URL.Path, "/v1/uploads/") {
+			uploadSizeLimiter(ctx)
+			return
+		}
+		requestSizeLimiter(ctx)
+	})
+	router.Use(localeMiddleware.NegotiateLocale())
 
 	docs.SwaggerInfo.Title = "Goliath"
 	docs.SwaggerInfo.Description = "Goliath"
@@ -191,6 +336,14 @@ func init() {
 	taskRunner.RegisterTask(24*time.Hour, userService.DeleteExpiredPwResets, 1)
 	taskRunner.RegisterTask(24*time.Hour, organizationService.DeleteExpiredOrgInvites, 1)
 	taskRunner.RegisterTask(time.Second, telemetryService.Upload, 1)
+	taskRunner.RegisterTask(10*time.Second, outboxService.Deliver, 1)
+	taskRunner.RegisterTask(5*time.Second, notificationService.Listen, 1) // only returns if the connection is lost
+	taskRunner.RegisterTask(time.Minute, usageService.Flush, 1)
+	taskRunner.RegisterTask(time.Hour, usageService.SnapshotStorage, 1)
+	taskRunner.RegisterTask(time.Hour, billingService.ReportMeteredUsage, 1)
+	taskRunner.RegisterTask(15*time.Minute, billingHandler.ReconcilePayments, 1)
+	taskRunner.RegisterTask(time.Hour, dunningHandler.ProcessDunning, 1)
+	taskRunner.RegisterTask(15*time.Minute, uploadHandler.DeleteExpiredUploads, 1)
 }
 
 // @securityDefinitions.apiKey JWT
@@ -210,11 +363,31 @@ func main() {
 
 	router.Use(telemetryMiddleware.CollectApiCalls())
 
+	if fakeGateway != nil && os.Getenv("GIN_MODE") != "release" {
+		router.Any("/fake-gateway/*any", gin.WrapH(http.StripPrefix("/fake-gateway", fakeGateway)))
+	}
+
+	if mailbox != nil {
+		router.Any("/debug/mailbox/*any", authMiddleware.AuthorizeAdmin(), gin.WrapH(http.StripPrefix("/debug/mailbox", mailbox)))
+	}
+
 	basePath := router.Group("/v1")
+	// the API calls of organizations are limited by their plan, but signing in, billing and
+	// webhooks stay available so they can upgrade once the limit is reached
+	apiPath := router.Group("/v1", entitlementMiddleware.LimitApiCalls())
 	authHandler.RegisterRoutes(basePath, authMiddleware)
-	userHandler.RegisterRoutes(basePath, authMiddleware)
-	organizationHandler.RegisterRoutes(basePath, authMiddleware)
+	userHandler.RegisterRoutes(apiPath, authMiddleware)
+	organizationHandler.RegisterRoutes(apiPath, authMiddleware)
 	billingHandler.RegisterRoutes(basePath, authMiddleware)
+	planHandler.RegisterRoutes(basePath, authMiddleware)
+	couponHandler.RegisterRoutes(basePath, authMiddleware)
+	dunningHandler.RegisterRoutes(basePath, authMiddleware)
+	emailHandler.RegisterRoutes(basePath, authMiddleware)
+	notificationHandler.RegisterRoutes(apiPath, authMiddleware)
+	if objectUrlSigner != nil {
+		objectHandler.RegisterRoutes(basePath, authMiddleware)
+	}
+	uploadHandler.RegisterRoutes(apiPath, authMiddleware)
 
 	taskRunner.Dispatch()
 
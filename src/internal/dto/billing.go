@@ -0,0 +1,93 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+)
+
+type CreatePlan struct {
+	PlanName string                 `json:"planName" binding:"required"`
+	Features []string               `json:"features"`
+	Limits   map[models.Limit]int64 `json:"limits"`
+}
+
+type EditPlan struct {
+	PlanName string                 `json:"planName" binding:"required"`
+	Features []string               `json:"features"`
+	Limits   map[models.Limit]int64 `json:"limits"`
+	IsActive bool                   `json:"isActive"`
+}
+
+type CreatePrice struct {
+	UnitAmmount  int64  `json:"unitAmmount" binding:"min=0"`
+	UnitCurrency string `json:"unitCurrency" binding:"required,len=3"`
+	Interval     string `json:"interval" binding:"required,oneof=one_time day week month year"`
+	Meter        string `json:"meter" binding:"omitempty,max=64"`
+	TrialDays    int64  `json:"trialDays" binding:"min=0,max=730"`
+}
+
+type EditPrice struct {
+	IsActive bool `json:"isActive"`
+}
+
+type CreateSubscription struct {
+	PriceId    uint32 `json:"priceId" binding:"required"`
+	Seats      int64  `json:"seats" binding:"required,min=1"`
+	CouponCode string `json:"couponCode" binding:"omitempty,max=64"` // only redeemed at checkout
+}
+
+type SetDefaultPaymentMethod struct {
+	PaymentMethodId string `json:"paymentMethodId" binding:"required,max=255"`
+}
+
+type BillingDetails struct {
+	CompanyName  string  `json:"companyName" binding:"required,max=255"`
+	TaxId        *string `json:"taxId" binding:"omitempty,max=64"`
+	AddressLine1 string  `json:"addressLine1" binding:"required,max=255"`
+	AddressLine2 *string `json:"addressLine2" binding:"omitempty,max=255"`
+	City         string  `json:"city" binding:"required,max=100"`
+	State        *string `json:"state" binding:"omitempty,max=100"`
+	PostalCode   *string `json:"postalCode" binding:"omitempty,max=20"`
+	Country      string  `json:"country" binding:"required,iso3166_1_alpha2"`
+}
+
+type CreateRefund struct {
+	Ammount int64  `json:"ammount" binding:"omitempty,min=1"`
+	Reason  string `json:"reason" binding:"required,oneof=duplicate fraudulent requested_by_customer"`
+}
+
+type PaymentDetails struct {
+	Payment models.Payment        `json:"payment" binding:"required"`
+	Events  []models.PaymentEvent `json:"events" binding:"required"`
+}
+
+type CreateCoupon struct {
+	Code           string     `json:"code" binding:"required,alphanum,max=64"`
+	PercentOff     *int64     `json:"percentOff" binding:"required_without=AmmountOff,excluded_with=AmmountOff,omitempty,min=1,max=100"`
+	AmmountOff     *int64     `json:"ammountOff" binding:"required_without=PercentOff,omitempty,min=1"`
+	Currency       *string    `json:"currency" binding:"required_with=AmmountOff,excluded_without=AmmountOff,omitempty,len=3"`
+	Duration       string     `json:"duration" binding:"required,oneof=once forever"`
+	PlanIds        []uint32   `json:"planIds"`
+	MaxRedemptions *int64     `json:"maxRedemptions" binding:"omitempty,min=1"`
+	ExpiresAt      *time.Time `json:"expiresAt"`
+}
+
+type EditCoupon struct {
+	IsActive bool `json:"isActive"`
+}
+
+type CouponQuoteQuery struct {
+	Code    string `form:"code" binding:"required,max=64"`
+	PriceId uint32 `form:"priceId" binding:"required"`
+	Seats   int64  `form:"seats" binding:"omitempty,min=1"`
+}
+
+type CouponQuote struct {
+	Code            string `json:"code" binding:"required"`
+	PriceId         uint32 `json:"priceId" binding:"required"`
+	Ammount         int64  `json:"ammount" binding:"required"`
+	DiscountAmmount int64  `json:"discountAmmount" binding:"required"`
+	Total           int64  `json:"total" binding:"required"`
+	Currency        string `json:"currency" binding:"required"`
+}
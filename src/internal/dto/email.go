@@ -0,0 +1,31 @@
+package dto
+
+import "github.com/LombardiDaniel/goliath/src/internal/models"
+
+type OutboxEmailsQuery struct {
+	PageQuery
+	Status *models.OutboxStatus `form:"status" binding:"omitempty,oneof=pending sent dead suppressed"`
+}
+
+type EmailTemplateOutput struct {
+	Name    string         `json:"name"`
+	Locales []string       `json:"locales"`
+	Sample  map[string]any `json:"sample"`
+}
+
+type RenderEmail struct {
+	Locale string         `json:"locale" example:"pt-BR"`
+	Data   map[string]any `json:"data"` // sample data if absent
+}
+
+type TestEmail struct {
+	RenderEmail
+	To string `json:"to" binding:"required,email"`
+}
+
+type RenderedEmail struct {
+	Locale  string `json:"locale"`
+	Subject string `json:"subject"`
+	Html    string `json:"html"`
+	Text    string `json:"text"`
+}
@@ -7,3 +7,41 @@ type Id struct {
 type Url struct {
 	Url string `json:"url" binding:"required"`
 }
+
+const (
+	DefaultPageSize int = 20
+	MaxPageSize     int = 100
+)
+
+type PageQuery struct {
+	Page     int `form:"page" binding:"omitempty,min=1"`
+	PageSize int `form:"pageSize" binding:"omitempty,min=1,max=100"`
+}
+
+// Offset and Limit apply the defaults to the requested page.
+func (q PageQuery) Offset() int {
+	return (max(q.Page, 1) - 1) * q.Limit()
+}
+
+func (q PageQuery) Limit() int {
+	if q.PageSize == 0 {
+		return DefaultPageSize
+	}
+	return min(q.PageSize, MaxPageSize)
+}
+
+type Page[T any] struct {
+	Items    []T   `json:"items" binding:"required"`
+	Page     int   `json:"page" binding:"required"`
+	PageSize int   `json:"pageSize" binding:"required"`
+	Total    int64 `json:"total" binding:"required"`
+}
+
+func NewPage[T any](items []T, q PageQuery, total int64) Page[T] {
+	return Page[T]{
+		Items:    items,
+		Page:     max(q.Page, 1),
+		PageSize: q.Limit(),
+		Total:    total,
+	}
+}
@@ -0,0 +1,22 @@
+package dto
+
+import "github.com/LombardiDaniel/goliath/src/internal/models"
+
+type SetNotificationPreference struct {
+	Category models.NotificationCategory `json:"category" binding:"required"`
+	Email    *bool                       `json:"email" binding:"required"`
+}
+
+type NotificationsQuery struct {
+	PageQuery
+	Unread bool `form:"unread"`
+}
+
+type NotificationPage struct {
+	Page[models.Notification]
+	Unread int64 `json:"unread" binding:"required"`
+}
+
+type UnreadNotifications struct {
+	Unread int64 `json:"unread" binding:"required"`
+}
@@ -17,3 +17,7 @@ type CreateOrganizationInvite struct {
 	UserEmail string                       `json:"userEmail" binding:"required"`
 	Perms     map[string]models.Permission `json:"perms" binding:"required"`
 }
+
+type SetOrganizationLocale struct {
+	DefaultLocale *string `json:"defaultLocale" binding:"omitempty,oneof=pt-BR en" example:"pt-BR"`
+}
@@ -0,0 +1,42 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+)
+
+type CreateUpload struct {
+	FileName    string `json:"fileName" binding:"required,max=255"`
+	ContentType string `json:"contentType" binding:"required,max=255"`
+}
+
+type UploadCreated struct {
+	UploadId    string `json:"uploadId" binding:"required"`
+	MinPartSize int64  `json:"minPartSize" binding:"required"` // of all parts but the last
+	MaxPartSize int64  `json:"maxPartSize" binding:"required"`
+}
+
+type CreateDirectUpload struct {
+	FileName    string               `json:"fileName" binding:"required,max=255"`
+	ContentType string               `json:"contentType" binding:"required,max=255"`
+	Size        int64                `json:"size" binding:"required,min=1"`
+	Purpose     models.UploadPurpose `json:"purpose" binding:"required,oneof=file image document"`
+}
+
+type DirectUploadCreated struct {
+	UploadId  string            `json:"uploadId" binding:"required"`
+	Url       string            `json:"url" binding:"required"`       // presigned, to PUT the file to
+	Headers   map[string]string `json:"headers" binding:"required"`   // to send along with the file
+	ExpiresAt time.Time         `json:"expiresAt" binding:"required"` // of the URL
+}
+
+type CompleteUpload struct {
+	Parts []models.ObjectPart `json:"parts" binding:"required,min=1,dive"`
+}
+
+type UploadStatus struct {
+	models.Upload
+	Parts []models.ObjectPart `json:"parts,omitempty"` // uploaded so far, while an upload in parts is in progress
+	Url   string              `json:"url,omitempty"`   // signed download URL, once completed
+}
@@ -8,14 +8,27 @@ type CreateUser struct {
 	FirstName   string     `json:"firstName" binding:"required"`
 	LastName    string     `json:"lastName" binding:"required"`
 	DateOfBirth *time.Time `json:"dateOfBirth" example:"2006-01-02T15:04:05-07:00"`
+	Locale      *string    `json:"locale" binding:"omitempty,oneof=pt-BR en" example:"pt-BR"`
 }
 
 type EditUser struct {
 	FirstName   string     `json:"firstName" binding:"required"`
 	LastName    string     `json:"lastName" binding:"required"`
 	DateOfBirth *time.Time `json:"dateOfBirth" example:"2006-01-02T15:04:05-07:00"`
+	Locale      *string    `json:"locale" binding:"omitempty,oneof=pt-BR en" example:"pt-BR"`
 }
 
 type UloadPicture struct {
 	Content string `json:"content" binding:"required"`
 }
+
+type AvatarVariant struct {
+	Size   int    `json:"size" binding:"required"`
+	Format string `json:"format" binding:"required"`
+	Url    string `json:"url" binding:"required"`
+}
+
+type Avatar struct {
+	Url      string          `json:"url" binding:"required"` // of constants.DefaultAvatarSize, the one set on the user
+	Variants []AvatarVariant `json:"variants" binding:"required"`
+}
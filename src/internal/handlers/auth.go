@@ -10,6 +10,7 @@ import (
 	"github.com/LombardiDaniel/goliath/src/internal/middlewares"
 	"github.com/LombardiDaniel/goliath/src/internal/models"
 	"github.com/LombardiDaniel/goliath/src/internal/services"
+	"github.com/LombardiDaniel/goliath/src/internal/templates"
 	"github.com/LombardiDaniel/goliath/src/pkg/constants"
 	"github.com/LombardiDaniel/goliath/src/pkg/oauth"
 	"github.com/LombardiDaniel/goliath/src/pkg/token"
@@ -121,7 +122,7 @@ func (c *AuthHandler) Validate(ctx *gin.Context) {
 
 	userClaims, ok := userClaimsRaw.(models.JwtClaims)
 	if !ok {
-		ctx.String(http.StatusBadGateway, "BadGateay")
+		ctx.String(http.StatusBadGateway, "BadGateway")
 		return
 	}
 
@@ -242,7 +243,8 @@ func (c *AuthHandler) OauthCallback(ctx *gin.Context) {
 		return
 	}
 	if inserted {
-		err = c.emailService.SendAccountCreated(user.Email, user.FirstName)
+		// the user has no locale yet, the one of the request is the best guess
+		err = c.emailService.Send(ctx, templates.AccountCreated, user.Email, middlewares.GetLocale(ctx), map[string]any{"FirstName": user.FirstName})
 		if err != nil {
 			slog.Error(err.Error())
 			ctx.String(http.StatusBadGateway, "BadGateway")
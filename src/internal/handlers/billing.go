@@ -1,140 +1,1075 @@
 package handlers
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/LombardiDaniel/goliath/src/internal/dto"
 	"github.com/LombardiDaniel/goliath/src/internal/middlewares"
 	"github.com/LombardiDaniel/goliath/src/internal/models"
 	"github.com/LombardiDaniel/goliath/src/internal/services"
+	"github.com/LombardiDaniel/goliath/src/internal/templates"
+	"github.com/LombardiDaniel/goliath/src/pkg/common"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/events"
+	"github.com/LombardiDaniel/goliath/src/pkg/payments"
+	"github.com/LombardiDaniel/goliath/src/pkg/storage"
 	"github.com/LombardiDaniel/goliath/src/pkg/token"
 	"github.com/gin-gonic/gin"
-	"github.com/stripe/stripe-go/v81"
+	"github.com/google/uuid"
 )
 
 type BillingHandler struct {
-	billingService services.BillingService
-	emailService   services.EmailService
-	userService    services.UserService
+	billingService    services.BillingService
+	planService       services.PlanService
+	couponService     services.CouponService
+	messageService    services.MessageService
+	userService       services.UserService
+	usageService      services.UsageService
+	invoiceService    services.InvoiceService
+	objService        services.ObjectService
+	telemetryService  services.TelemetryService
+	webhookDispatcher *events.Dispatcher[payments.Event]
 }
 
 func NewBillingHandler(
 	billingService services.BillingService,
-	emailService services.EmailService,
+	planService services.PlanService,
+	couponService services.CouponService,
+	messageService services.MessageService,
 	userService services.UserService,
+	usageService services.UsageService,
+	invoiceService services.InvoiceService,
+	objService services.ObjectService,
+	telemetryService services.TelemetryService,
 ) BillingHandler {
-	return BillingHandler{
-		billingService: billingService,
-		emailService:   emailService,
-		userService:    userService,
+	h := BillingHandler{
+		billingService:    billingService,
+		planService:       planService,
+		couponService:     couponService,
+		messageService:    messageService,
+		userService:       userService,
+		usageService:      usageService,
+		invoiceService:    invoiceService,
+		objService:        objService,
+		telemetryService:  telemetryService,
+		webhookDispatcher: events.NewDispatcher[payments.Event](),
 	}
+
+	h.RegisterWebhookHandler(payments.CheckoutCompletedEvent, h.onCheckoutSessionCompleted)
+	h.RegisterWebhookHandler(payments.CheckoutPaymentFailedEvent, h.onCheckoutSessionPaymentFailed)
+	h.RegisterWebhookHandler(payments.SubscriptionUpdatedEvent, h.onSubscriptionChanged)
+	h.RegisterWebhookHandler(payments.InvoicePaidEvent, h.onInvoicePaid)
+	h.RegisterWebhookHandler(payments.CheckoutExpiredEvent, h.onCheckoutSessionExpired)
+	h.RegisterWebhookHandler(payments.PaymentRefundedEvent, h.onPaymentRefunded)
+	h.RegisterWebhookHandler(payments.DisputeOpenedEvent, h.onDisputeChanged)
+	h.RegisterWebhookHandler(payments.DisputeClosedEvent, h.onDisputeChanged)
+
+	return h
+}
+
+// @Summary CheckoutSessionUrl
+// @Security JWT
+// @Tags Billing
+// @Description Gets the CheckoutSession Url for a one-time price of the catalog
+// @Produce json
+// @Param 	price_id 	path 		string true "price_id"
+// @Param 	coupon 		query 		string false "coupon code"
+// @Success 200 		{object} 	dto.Url
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 409 		{string} 	ErrorResponse "Conflict"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/stripe/get-checkout-session-url/{price_id} [POST]
+func (c *BillingHandler) CheckoutSessionUrl(ctx *gin.Context) {
+	priceId, err := strconv.Atoi(ctx.Param("price_id"))
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "BadRequest")
+		return
+	}
+
+	claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	price, err := c.planService.GetPrice(ctx, uint32(priceId))
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	plan, err := c.planService.GetPlan(ctx, price.PlanId)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	if !price.IsActive || !plan.IsActive {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+
+	if price.Interval != models.OneTimeInterval {
+		ctx.String(http.StatusBadRequest, "RecurringPrice")
+		return
+	}
+
+	coupon, ok := c.getCoupon(ctx, ctx.Query("coupon"), price)
+	if !ok {
+		return
+	}
+
+	url, err := c.billingService.CheckoutURL(ctx, plan, price, claims.UserId, coupon)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.Url{Url: url})
+}
+
+// @Summary SubscriptionCheckoutUrl
+// @Security JWT
+// @Tags Billing
+// @Description Gets the CheckoutSession Url for the Organization to subscribe to a recurring price of the catalog
+// @Consume application/json
+// @Accept json
+// @Produce json
+// @Param	orgId 		path 		string true "Organization Id"
+// @Param   payload 	body 		dto.CreateSubscription true "subscription json"
+// @Success 200 		{object} 	dto.Url
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 409 		{string} 	ErrorResponse "Conflict"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/organizations/{orgId}/subscription/checkout [POST]
+func (c *BillingHandler) SubscriptionCheckoutUrl(ctx *gin.Context) {
+	var createSub dto.CreateSubscription
+
+	if err := ctx.ShouldBind(&createSub); err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	orgId := ctx.Param("orgId")
+
+	plan, price, ok := c.getRecurringPrice(ctx, createSub.PriceId)
+	if !ok {
+		return
+	}
+
+	// a subscription without a gateway subscription is an abandoned checkout
+	sub, err := c.billingService.GetOrganizationSubscription(ctx, orgId)
+	if err == nil && sub.ProviderSubscriptionId != nil {
+		ctx.String(http.StatusConflict, "AlreadySubscribed")
+		return
+	}
+	if err != nil && !errors.Is(err, constants.ErrNoRows) {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	coupon, ok := c.getCoupon(ctx, createSub.CouponCode, price)
+	if !ok {
+		return
+	}
+
+	url, err := c.billingService.SubscriptionCheckoutURL(ctx, plan, price, orgId, createSub.Seats, coupon)
+	if errors.Is(err, constants.ErrDbConflict) {
+		ctx.String(http.StatusConflict, "AlreadySubscribed")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.Url{Url: url})
+}
+
+// @Summary GetSubscription
+// @Security JWT
+// @Tags Billing
+// @Description Gets the current Subscription of the Organization
+// @Produce json
+// @Param	orgId 		path 		string true "Organization Id"
+// @Success 200 		{object} 	models.Subscription
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/organizations/{orgId}/subscription [GET]
+func (c *BillingHandler) GetSubscription(ctx *gin.Context) {
+	sub, err := c.billingService.GetOrganizationSubscription(ctx, ctx.Param("orgId"))
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, sub)
+}
+
+// @Summary ChangeSubscription
+// @Security JWT
+// @Tags Billing
+// @Description Changes the price and/or seats of the Organization Subscription, prorating the difference
+// @Consume application/json
+// @Accept json
+// @Produce json
+// @Param	orgId 		path 		string true "Organization Id"
+// @Param   payload 	body 		dto.CreateSubscription true "subscription json"
+// @Success 200 		{object} 	models.Subscription
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 409 		{string} 	ErrorResponse "Conflict"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/organizations/{orgId}/subscription [PUT]
+func (c *BillingHandler) ChangeSubscription(ctx *gin.Context) {
+	var changeSub dto.CreateSubscription
+
+	if err := ctx.ShouldBind(&changeSub); err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	_, price, ok := c.getRecurringPrice(ctx, changeSub.PriceId)
+	if !ok {
+		return
+	}
+
+	sub, err := c.billingService.GetOrganizationSubscription(ctx, ctx.Param("orgId"))
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	sub, err = c.billingService.ChangeSubscription(ctx, sub, price, changeSub.Seats)
+	if errors.Is(err, constants.ErrDbConflict) {
+		ctx.String(http.StatusConflict, "Conflict")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, sub)
+}
+
+// @Summary CancelSubscription
+// @Security JWT
+// @Tags Billing
+// @Description Cancels the Organization Subscription at the end of the current period
+// @Produce json
+// @Param	orgId 		path 		string true "Organization Id"
+// @Success 200 		{object} 	models.Subscription
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 409 		{string} 	ErrorResponse "Conflict"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/organizations/{orgId}/subscription [DELETE]
+func (c *BillingHandler) CancelSubscription(ctx *gin.Context) {
+	sub, err := c.billingService.GetOrganizationSubscription(ctx, ctx.Param("orgId"))
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	sub, err = c.billingService.CancelSubscription(ctx, sub)
+	if errors.Is(err, constants.ErrDbConflict) {
+		ctx.String(http.StatusConflict, "Conflict")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, sub)
+}
+
+// @Summary GetUsage
+// @Security JWT
+// @Tags Billing
+// @Description Gets the hourly metered usage of the Organization, defaults to the current month
+// @Produce json
+// @Param	orgId 		path 		string true "Organization Id"
+// @Param	from 		query 		string false "RFC3339 start of the range, inclusive"
+// @Param	to 			query 		string false "RFC3339 end of the range, exclusive"
+// @Success 200 		{object} 	models.UsageSummary
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/organizations/{orgId}/usage [GET]
+func (c *BillingHandler) GetUsage(ctx *gin.Context) {
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	to := now
+
+	var err error
+	if q := ctx.Query("from"); q != "" {
+		from, err = time.Parse(constants.TimestampStrFormat, q)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, "BadRequest")
+			return
+		}
+	}
+	if q := ctx.Query("to"); q != "" {
+		to, err = time.Parse(constants.TimestampStrFormat, q)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, "BadRequest")
+			return
+		}
+	}
+
+	if !from.Before(to) {
+		ctx.String(http.StatusBadRequest, "BadRequest")
+		return
+	}
+
+	summary, err := c.usageService.GetUsage(ctx, ctx.Param("orgId"), from, to)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, summary)
+}
+
+// @Summary GetPayments
+// @Security JWT
+// @Tags Billing
+// @Description Gets the payment history of the current User, newest first
+// @Produce json
+// @Param	page 		query 		int false "page, starting at 1"
+// @Param	pageSize 	query 		int false "page size, up to 100"
+// @Success 200 		{object} 	dto.Page[models.Payment]
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/payments [GET]
+func (c *BillingHandler) GetPayments(ctx *gin.Context) {
+	var q dto.PageQuery
+	if err := ctx.ShouldBindQuery(&q); err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	userPayments, total, err := c.billingService.GetUserPayments(ctx, claims.UserId, q.Offset(), q.Limit())
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.NewPage(userPayments, q, total))
+}
+
+// @Summary GetPayment
+// @Security JWT
+// @Tags Billing
+// @Description Gets a payment and its history, for back-office
+// @Produce json
+// @Param	paymentId 	path 		string true "Payment Id"
+// @Success 200 		{object} 	dto.PaymentDetails
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/payments/{paymentId} [GET]
+func (c *BillingHandler) GetPayment(ctx *gin.Context) {
+	payment, ok := c.getPayment(ctx)
+	if !ok {
+		return
+	}
+
+	paymentEvents, err := c.billingService.GetPaymentEvents(ctx, payment.PaymentId)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.PaymentDetails{
+		Payment: payment,
+		Events:  paymentEvents,
+	})
+}
+
+// @Summary RefundPayment
+// @Security JWT
+// @Tags Billing
+// @Description Refunds part or all of a payment, an ammount of 0 refunds what is left of it
+// @Consume application/json
+// @Accept json
+// @Produce json
+// @Param	paymentId 	path 		string true "Payment Id"
+// @Param   payload 	body 		dto.CreateRefund true "refund json"
+// @Success 200 		{object} 	models.Payment
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 409 		{string} 	ErrorResponse "Conflict"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/payments/{paymentId}/refund [POST]
+func (c *BillingHandler) RefundPayment(ctx *gin.Context) {
+	var refund dto.CreateRefund
+
+	if err := ctx.ShouldBind(&refund); err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	payment, ok := c.getPayment(ctx)
+	if !ok {
+		return
+	}
+
+	ammount := refund.Ammount
+	if ammount == 0 {
+		ammount = payment.Refundable()
+	}
+
+	payment, changed, err := c.billingService.RefundPayment(ctx, payment, ammount, payments.RefundReason(refund.Reason), claims.UserId)
+	if errors.Is(err, constants.ErrDbConflict) {
+		ctx.String(http.StatusConflict, "NotRefundable")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	// the refund webhook may have applied it first, and notified the user
+	if changed {
+		err = c.notifyPayment(ctx, templates.PaymentRefunded, models.PaymentRefundedNotification, payment)
+		if err != nil {
+			slog.Error(err.Error())
+		}
+	}
+
+	ctx.JSON(http.StatusOK, payment)
+}
+
+// @Summary CancelPayment
+// @Security JWT
+// @Tags Billing
+// @Description Cancels a pending payment, its checkout can no longer be paid
+// @Produce json
+// @Param	paymentId 	path 		string true "Payment Id"
+// @Success 200 		{object} 	models.Payment
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 409 		{string} 	ErrorResponse "Conflict"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/payments/{paymentId}/cancel [POST]
+func (c *BillingHandler) CancelPayment(ctx *gin.Context) {
+	claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	payment, ok := c.getPayment(ctx)
+	if !ok {
+		return
+	}
+
+	payment, changed, err := c.billingService.CancelPayment(ctx, payment, claims.UserId)
+	if errors.Is(err, constants.ErrDbConflict) {
+		ctx.String(http.StatusConflict, "NotPending")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	if changed {
+		err = c.notifyPayment(ctx, templates.PaymentCanceled, models.PaymentCanceledNotification, payment)
+		if err != nil {
+			slog.Error(err.Error())
+		}
+	}
+
+	ctx.JSON(http.StatusOK, payment)
+}
+
+// getPayment retrieves the payment of the paymentId path param, writing the
+// error response and returning false if it cannot.
+func (c *BillingHandler) getPayment(ctx *gin.Context) (models.Payment, bool) {
+	paymentId := ctx.Param("paymentId")
+	if uuid.Validate(paymentId) != nil {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return models.Payment{}, false
+	}
+
+	payment, err := c.billingService.GetPayment(ctx, paymentId)
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return payment, false
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return payment, false
+	}
+
+	return payment, true
+}
+
+// @Summary GetInvoices
+// @Security JWT
+// @Tags Billing
+// @Description Gets the invoices of the current User, newest first
+// @Produce json
+// @Param	page 		query 		int false "page, starting at 1"
+// @Param	pageSize 	query 		int false "page size, up to 100"
+// @Success 200 		{object} 	dto.Page[models.Invoice]
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/invoices [GET]
+func (c *BillingHandler) GetInvoices(ctx *gin.Context) {
+	var q dto.PageQuery
+	if err := ctx.ShouldBindQuery(&q); err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	invoices, total, err := c.invoiceService.GetUserInvoices(ctx, claims.UserId, q.Offset(), q.Limit())
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.NewPage(invoices, q, total))
+}
+
+// @Summary GetInvoiceReceipt
+// @Security JWT
+// @Tags Billing
+// @Description Gets a signed download url for the PDF receipt of an invoice of the current User
+// @Produce json
+// @Param	invoiceId 	path 		string true "Invoice Id"
+// @Success 200 		{object} 	dto.Url
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/invoices/{invoiceId}/receipt [GET]
+func (c *BillingHandler) GetInvoiceReceipt(ctx *gin.Context) {
+	claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	invoice, ok := c.getInvoice(ctx)
+	if !ok {
+		return
+	}
+
+	if invoice.UserId == nil || *invoice.UserId != claims.UserId {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+
+	c.receiptUrl(ctx, invoice)
+}
+
+// @Summary GetOrganizationInvoices
+// @Security JWT
+// @Tags Billing
+// @Description Gets the invoices of the Organization subscription, newest first
+// @Produce json
+// @Param	orgId 		path 		string true "Organization Id"
+// @Param	page 		query 		int false "page, starting at 1"
+// @Param	pageSize 	query 		int false "page size, up to 100"
+// @Success 200 		{object} 	dto.Page[models.Invoice]
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/organizations/{orgId}/invoices [GET]
+func (c *BillingHandler) GetOrganizationInvoices(ctx *gin.Context) {
+	var q dto.PageQuery
+	if err := ctx.ShouldBindQuery(&q); err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	invoices, total, err := c.invoiceService.GetOrganizationInvoices(ctx, ctx.Param("orgId"), q.Offset(), q.Limit())
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.NewPage(invoices, q, total))
+}
+
+// @Summary GetOrganizationPayments
+// @Security JWT
+// @Tags Billing
+// @Description Gets the payment history of the Organization subscription, paid and failed charges, newest first
+// @Produce json
+// @Param	orgId 		path 		string true "Organization Id"
+// @Param	page 		query 		int false "page, starting at 1"
+// @Param	pageSize 	query 		int false "page size, up to 100"
+// @Success 200 		{object} 	dto.Page[models.SubscriptionPayment]
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/organizations/{orgId}/payments [GET]
+func (c *BillingHandler) GetOrganizationPayments(ctx *gin.Context) {
+	var q dto.PageQuery
+	if err := ctx.ShouldBindQuery(&q); err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	orgPayments, total, err := c.billingService.GetOrganizationPayments(ctx, ctx.Param("orgId"), q.Offset(), q.Limit())
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.NewPage(orgPayments, q, total))
+}
+
+// @Summary GetOrganizationInvoiceReceipt
+// @Security JWT
+// @Tags Billing
+// @Description Gets a signed download url for the PDF receipt of an invoice of the Organization
+// @Produce json
+// @Param	orgId 		path 		string true "Organization Id"
+// @Param	invoiceId 	path 		string true "Invoice Id"
+// @Success 200 		{object} 	dto.Url
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/organizations/{orgId}/invoices/{invoiceId}/receipt [GET]
+func (c *BillingHandler) GetOrganizationInvoiceReceipt(ctx *gin.Context) {
+	invoice, ok := c.getInvoice(ctx)
+	if !ok {
+		return
+	}
+
+	if invoice.OrganizationId == nil || *invoice.OrganizationId != ctx.Param("orgId") {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+
+	c.receiptUrl(ctx, invoice)
+}
+
+// @Summary PortalUrl
+// @Security JWT
+// @Tags Billing
+// @Description Gets the payment gateway portal Url where the Organization manages its payment methods and subscription
+// @Produce json
+// @Param	orgId 		path 		string true "Organization Id"
+// @Success 200 		{object} 	dto.Url
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/organizations/{orgId}/portal [POST]
+func (c *BillingHandler) PortalUrl(ctx *gin.Context) {
+	customer, err := c.billingService.GetOrganizationCustomer(ctx, ctx.Param("orgId"))
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	url, err := c.billingService.PortalURL(ctx, customer)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.Url{Url: url})
 }
 
-// @Summary CheckoutSessionUrl
+// @Summary GetPaymentMethods
+// @Security JWT
+// @Tags Billing
+// @Description Lists the payment methods stored for the Organization
+// @Produce json
+// @Param	orgId 		path 		string true "Organization Id"
+// @Success 200 		{object} 	[]payments.PaymentMethod
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/organizations/{orgId}/payment-methods [GET]
+func (c *BillingHandler) GetPaymentMethods(ctx *gin.Context) {
+	customer, err := c.billingService.FindOrganizationCustomer(ctx, ctx.Param("orgId"))
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.JSON(http.StatusOK, []payments.PaymentMethod{})
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	methods, err := c.billingService.GetPaymentMethods(ctx, customer)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, methods)
+}
+
+// @Summary SetDefaultPaymentMethod
 // @Security JWT
 // @Tags Billing
-// @Description Gets the CheckoutSession Url
+// @Description Sets the payment method the Organization and its subscription are charged with
+// @Consume application/json
+// @Accept json
 // @Produce plain
-// @Param 	product_id 	path 		string true "product_id"
+// @Param	orgId 		path 		string true "Organization Id"
+// @Param   payload 	body 		dto.SetDefaultPaymentMethod true "payment method json"
 // @Success 200 		{string} 	OKResponse "OK"
 // @Failure 400 		{string} 	ErrorResponse "Bad Request"
-// @Failure 409 		{string} 	ErrorResponse "Conflict"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
 // @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
-// @Router /v1/billing/stripe/get-checkout-session-url/{product_id} [POST]
-func (c *BillingHandler) CheckoutSessionUrl(ctx *gin.Context) {
-	prodIdStr := ctx.Param("product_id")
-	var val int64 = 300
-
-	fmt.Printf("prodIdStr: %v\n", prodIdStr)
+// @Router /v1/billing/organizations/{orgId}/payment-methods/default [PUT]
+func (c *BillingHandler) SetDefaultPaymentMethod(ctx *gin.Context) {
+	var setDefault dto.SetDefaultPaymentMethod
+	if err := ctx.ShouldBind(&setDefault); err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
 
-	if prodIdStr != "0" {
-		ctx.String(http.StatusUnauthorized, "Unauthorized")
+	customer, err := c.billingService.FindOrganizationCustomer(ctx, ctx.Param("orgId"))
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
 		return
 	}
 
-	claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](ctx)
+	err = c.billingService.SetDefaultPaymentMethod(ctx, customer, setDefault.PaymentMethodId)
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
 	if err != nil {
 		slog.Error(err.Error())
-		ctx.String(http.StatusUnauthorized, "Unauthorized")
+		ctx.String(http.StatusBadGateway, "BadGateway")
 		return
 	}
 
-	url, err := c.billingService.CheckoutURL(ctx, stripe.CurrencyBRL, val*100, "event", claims.UserId)
+	ctx.String(http.StatusOK, "OK")
+}
+
+// @Summary GetBillingDetails
+// @Security JWT
+// @Tags Billing
+// @Description Gets the billing details printed on the invoices of the Organization
+// @Produce json
+// @Param	orgId 		path 		string true "Organization Id"
+// @Success 200 		{object} 	models.BillingDetails
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/organizations/{orgId}/billing-details [GET]
+func (c *BillingHandler) GetBillingDetails(ctx *gin.Context) {
+	details, err := c.invoiceService.GetBillingDetails(ctx, ctx.Param("orgId"))
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
 	if err != nil {
 		slog.Error(err.Error())
 		ctx.String(http.StatusBadGateway, "BadGateway")
 		return
 	}
 
-	ctx.JSON(http.StatusOK, dto.Url{Url: url})
+	ctx.JSON(http.StatusOK, details)
 }
 
-// @Summary CheckoutSessionCompletedCallback
+// @Summary SetBillingDetails
 // @Security JWT
 // @Tags Billing
-// @Description Completes a SessionCompleted
+// @Description Sets the billing details printed on the invoices of the Organization, already issued invoices are not changed
+// @Consume application/json
+// @Accept json
 // @Produce plain
-// @Param   payload 	body 		any true "stripe.Event json"
+// @Param	orgId 		path 		string true "Organization Id"
+// @Param   payload 	body 		dto.BillingDetails true "billing details json"
 // @Success 200 		{string} 	OKResponse "OK"
 // @Failure 400 		{string} 	ErrorResponse "Bad Request"
-// @Failure 409 		{string} 	ErrorResponse "Conflict"
 // @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
-// @Router /v1/billing/stripe/checkout-session-completed [POST]
-func (c *BillingHandler) CheckoutSessionCompletedCallback(ctx *gin.Context) {
-	var stripeEvent stripe.Event
+// @Router /v1/billing/organizations/{orgId}/billing-details [PUT]
+func (c *BillingHandler) SetBillingDetails(ctx *gin.Context) {
+	var details dto.BillingDetails
 
-	if err := ctx.ShouldBind(&stripeEvent); err != nil {
+	if err := ctx.ShouldBind(&details); err != nil {
 		slog.Error(err.Error())
 		ctx.String(http.StatusBadRequest, err.Error())
 		return
 	}
 
-	var inputCheckoutSession stripe.CheckoutSession
+	err := c.invoiceService.SetBillingDetails(ctx, ctx.Param("orgId"), models.BillingDetails{
+		CompanyName:  details.CompanyName,
+		TaxId:        details.TaxId,
+		AddressLine1: details.AddressLine1,
+		AddressLine2: details.AddressLine2,
+		City:         details.City,
+		State:        details.State,
+		PostalCode:   details.PostalCode,
+		Country:      details.Country,
+	})
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.String(http.StatusOK, "OK")
+}
+
+// getInvoice retrieves the invoice of the invoiceId path param, writing the
+// error response and returning false if it cannot.
+func (c *BillingHandler) getInvoice(ctx *gin.Context) (models.Invoice, bool) {
+	invoiceId := ctx.Param("invoiceId")
+	if uuid.Validate(invoiceId) != nil {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return models.Invoice{}, false
+	}
+
+	invoice, err := c.invoiceService.GetInvoice(ctx, invoiceId)
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return invoice, false
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return invoice, false
+	}
+
+	return invoice, true
+}
+
+// receiptUrl responds with a signed url of the invoice receipt, it is rendered
+// and stored on its first download.
+func (c *BillingHandler) receiptUrl(ctx *gin.Context, invoice models.Invoice) {
+	if invoice.ReceiptPath == nil {
+		receipt := c.invoiceService.RenderReceipt(invoice)
+		objPath := storage.GetPrivatePath(storage.Receipts, invoice.InvoiceNumber+".pdf")
+
+		err := c.objService.Upload(ctx, constants.S3Bucket, objPath, int64(len(receipt)), bytes.NewReader(receipt), models.ObjectOptions{
+			ContentType: "application/pdf",
+		})
+		if err != nil {
+			slog.Error(err.Error())
+			ctx.String(http.StatusBadGateway, "BadGateway")
+			return
+		}
 
-	switch stripeEvent.Type {
-	case stripe.EventTypeCheckoutSessionCompleted:
-		err := json.Unmarshal(stripeEvent.Data.Raw, &inputCheckoutSession)
+		err = c.invoiceService.SetReceiptPath(ctx, invoice.InvoiceId, objPath)
 		if err != nil {
 			slog.Error(err.Error())
-			ctx.String(http.StatusBadRequest, err.Error())
+			ctx.String(http.StatusBadGateway, "BadGateway")
 			return
 		}
-	default:
-		slog.Warn(fmt.Sprintf("Unhandled event type: %s", string(stripeEvent.Type)))
+		invoice.ReceiptPath = &objPath
+	}
+
+	url, err := c.objService.SignedUrl(ctx, constants.S3Bucket, *invoice.ReceiptPath, constants.ReceiptUrlTimeout)
+	if err != nil {
+		slog.Error(err.Error())
 		ctx.String(http.StatusBadGateway, "BadGateway")
 		return
 	}
 
-	checkoutSession, err := c.billingService.CheckoutSession(ctx, inputCheckoutSession.ID)
+	ctx.JSON(http.StatusOK, dto.Url{Url: url})
+}
+
+// getRecurringPrice retrieves an active recurring price and its plan, writing the
+// error response and returning false if it cannot be subscribed to.
+func (c *BillingHandler) getRecurringPrice(ctx *gin.Context, priceId uint32) (models.Plan, models.Price, bool) {
+	price, err := c.planService.GetPrice(ctx, priceId)
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return models.Plan{}, price, false
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return models.Plan{}, price, false
+	}
+
+	plan, err := c.planService.GetPlan(ctx, price.PlanId)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return plan, price, false
+	}
+
+	if !price.IsActive || !plan.IsActive {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return plan, price, false
+	}
+
+	if price.Interval == models.OneTimeInterval {
+		ctx.String(http.StatusBadRequest, "OneTimePrice")
+		return plan, price, false
+	}
+
+	// metered prices can't be created inline in a checkout session
+	if price.Meter != nil && price.ProviderPriceId == nil {
+		ctx.String(http.StatusConflict, "PriceNotSynced")
+		return plan, price, false
+	}
+
+	return plan, price, true
+}
+
+// getCoupon retrieves the coupon of a code to redeem on price, nil if no code was
+// given, writing the error response and returning false if it cannot be redeemed.
+func (c *BillingHandler) getCoupon(ctx *gin.Context, code string, price models.Price) (*models.Coupon, bool) {
+	if code == "" {
+		return nil, true
+	}
+
+	coupon, err := c.couponService.GetRedeemableCoupon(ctx, code, price)
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "CouponNotFound")
+		return nil, false
+	}
+	if errors.Is(err, constants.ErrCouponNotApplicable) {
+		ctx.String(http.StatusConflict, "CouponNotApplicable")
+		return nil, false
+	}
 	if err != nil {
 		slog.Error(err.Error())
 		ctx.String(http.StatusBadGateway, "BadGateway")
+		return nil, false
+	}
+
+	if coupon.ProviderCouponId == nil {
+		ctx.String(http.StatusConflict, "CouponNotSynced")
+		return nil, false
+	}
+
+	return &coupon, true
+}
+
+// @Summary Webhook
+// @Tags Billing
+// @Description Receives payment gateway webhook events, verifies their signature and dispatches them to the registered handlers
+// @Produce plain
+// @Param   payload 			body 		any true "gateway event json"
+// @Success 200 		{string} 	OKResponse "OK"
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 401 		{string} 	ErrorResponse "Unauthorized"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/webhook [POST]
+func (c *BillingHandler) Webhook(ctx *gin.Context) {
+	payload, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadRequest, "BadRequest")
 		return
 	}
 
-	if !isStripeChechouseSessionPaid(checkoutSession) {
+	event, err := c.billingService.ParseWebhookEvent(payload, ctx.Request.Header)
+	if err != nil {
+		slog.Warn(err.Error())
 		ctx.String(http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	payment, err := c.billingService.SetCheckoutSessionAsComplete(ctx, checkoutSession.ID)
+	eventType := string(event.Type)
+	if !c.webhookDispatcher.Handles(eventType) {
+		slog.Info(fmt.Sprintf("Ignoring unhandled event type: %s", eventType))
+		ctx.String(http.StatusOK, "OK")
+		return
+	}
+
+	claimed, err := c.billingService.ClaimWebhookEvent(ctx, event.Id, eventType)
 	if err != nil {
 		slog.Error(err.Error())
 		ctx.String(http.StatusBadGateway, "BadGateway")
 		return
 	}
+	if !claimed {
+		slog.Info(fmt.Sprintf("Skipping already processed or claimed event: %s", event.Id))
+		ctx.String(http.StatusOK, "OK")
+		return
+	}
 
-	user, err := c.userService.GetUserFromId(ctx, payment.UserId)
+	_, err = c.webhookDispatcher.Dispatch(ctx, eventType, event)
 	if err != nil {
 		slog.Error(err.Error())
+		if err := c.billingService.ReleaseWebhookEvent(ctx, event.Id); err != nil {
+			slog.Error(err.Error())
+		}
 		ctx.String(http.StatusBadGateway, "BadGateway")
 		return
 	}
 
-	err = c.emailService.SendPaymentAccepted(user.Email, user.FirstName, payment)
+	// left claimed if this fails, so a retry past the claim timeout handles it again
+	err = c.billingService.CompleteWebhookEvent(ctx, event.Id)
 	if err != nil {
 		slog.Error(err.Error())
 		ctx.String(http.StatusBadGateway, "BadGateway")
@@ -144,9 +1079,338 @@ func (c *BillingHandler) CheckoutSessionCompletedCallback(ctx *gin.Context) {
 	ctx.String(http.StatusOK, "OK")
 }
 
+// RegisterWebhookHandler subscribes a handler to a gateway event type, received by Webhook.
+func (c *BillingHandler) RegisterWebhookHandler(eventType payments.EventType, handler events.Handler[payments.Event]) {
+	c.webhookDispatcher.Register(string(eventType), handler)
+}
+
+func (c *BillingHandler) onCheckoutSessionCompleted(ctx context.Context, event payments.Event) error {
+	if event.CheckoutSession == nil {
+		return fmt.Errorf("event without checkout session: %s", event.Id)
+	}
+
+	// the event payload is not trusted for the payment status, it is fetched back from the gateway
+	checkoutSession, err := c.billingService.CheckoutSession(ctx, event.CheckoutSession.Id)
+	if err != nil {
+		return err
+	}
+
+	if checkoutSession.Mode == payments.SubscriptionMode {
+		if checkoutSession.SubscriptionId == nil {
+			return fmt.Errorf("subscription checkout session without subscription: %s", checkoutSession.Id)
+		}
+		sub, err := c.billingService.SyncSubscription(ctx, *checkoutSession.SubscriptionId)
+		if err != nil {
+			return err
+		}
+		return c.couponService.RedeemForSubscription(ctx, sub)
+	}
+
+	// async payment methods complete the session before the payment is done,
+	// another completed event is sent once it is.
+	if !checkoutSession.IsPaid() {
+		slog.Info(fmt.Sprintf("checkout session not paid yet: %s", checkoutSession.Id))
+		return nil
+	}
+
+	payment, changed, err := c.billingService.SetCheckoutSessionAsComplete(ctx, checkoutSession)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		slog.Info(fmt.Sprintf("payment %s already %s", payment.PaymentId, payment.PaymentStatus))
+		return nil
+	}
+
+	err = c.couponService.RedeemForPayment(ctx, payment)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.invoiceService.CreatePaymentInvoice(ctx, payment)
+	if err != nil {
+		return err
+	}
+
+	return c.notifyPayment(ctx, templates.PaymentAccepted, models.PaymentAcceptedNotification, payment)
+}
+
+func (c *BillingHandler) onSubscriptionChanged(ctx context.Context, event payments.Event) error {
+	if event.Subscription == nil {
+		return fmt.Errorf("event without subscription: %s", event.Id)
+	}
+
+	if _, ok := event.Subscription.Metadata["subscription_id"]; !ok {
+		slog.Warn(fmt.Sprintf("Ignoring subscription created outside checkout: %s", event.Subscription.Id))
+		return nil
+	}
+
+	sub, err := c.billingService.SyncSubscription(ctx, event.Subscription.Id)
+	if err != nil {
+		return err
+	}
+
+	slog.Info(fmt.Sprintf("subscription %s of org %s is %s", sub.SubscriptionId, sub.OrganizationId, sub.Status))
+	return nil
+}
+
+func (c *BillingHandler) onInvoicePaid(ctx context.Context, event payments.Event) error {
+	if event.Invoice == nil {
+		return fmt.Errorf("event without invoice: %s", event.Id)
+	}
+
+	if event.Invoice.SubscriptionId == nil {
+		slog.Info(fmt.Sprintf("Ignoring invoice without subscription: %s", event.Invoice.Id))
+		return nil
+	}
+
+	sub, err := c.billingService.SyncSubscription(ctx, *event.Invoice.SubscriptionId)
+	if err != nil {
+		return err
+	}
+
+	err = c.billingService.RecordSubscriptionPayment(ctx, sub, *event.Invoice, true)
+	if err != nil {
+		return err
+	}
+
+	invoice, err := c.invoiceService.CreateSubscriptionInvoice(ctx, sub, *event.Invoice)
+	if err != nil {
+		return err
+	}
+
+	slog.Info(fmt.Sprintf("invoice %s issued to org %s", invoice.InvoiceNumber, sub.OrganizationId))
+	return nil
+}
+
+func (c *BillingHandler) onCheckoutSessionPaymentFailed(ctx context.Context, event payments.Event) error {
+	if event.CheckoutSession == nil {
+		return fmt.Errorf("event without checkout session: %s", event.Id)
+	}
+
+	payment, changed, err := c.billingService.SetCheckoutSessionAsCanceled(ctx, event.CheckoutSession.Id)
+	if err != nil {
+		return err
+	}
+
+	slog.Info(fmt.Sprintf("payment failed: %s", payment.PaymentId))
+	if !changed {
+		return nil
+	}
+	return c.notifyPayment(ctx, templates.PaymentCanceled, models.PaymentCanceledNotification, payment)
+}
+
+func (c *BillingHandler) onCheckoutSessionExpired(ctx context.Context, event payments.Event) error {
+	if event.CheckoutSession == nil {
+		return fmt.Errorf("event without checkout session: %s", event.Id)
+	}
+
+	// abandoned subscription checkouts are expired when the organization checks out again
+	if event.CheckoutSession.Mode == payments.SubscriptionMode {
+		return nil
+	}
+
+	payment, changed, err := c.billingService.SetCheckoutSessionAsExpired(ctx, event.CheckoutSession.Id)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+
+	slog.Info(fmt.Sprintf("payment expired: %s", payment.PaymentId))
+	return c.notifyPayment(ctx, templates.PaymentCanceled, models.PaymentCanceledNotification, payment)
+}
+
+func (c *BillingHandler) onPaymentRefunded(ctx context.Context, event payments.Event) error {
+	if event.Refund == nil {
+		return fmt.Errorf("event without refund: %s", event.Id)
+	}
+
+	payment, changed, err := c.billingService.SetPaymentRefunded(ctx, *event.Refund)
+	if errors.Is(err, constants.ErrNoRows) {
+		// subscription charges are refunded against their invoice, not a payment
+		slog.Info(fmt.Sprintf("Ignoring refund of unknown payment: %s", event.Refund.ProviderPaymentId))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+
+	slog.Info(fmt.Sprintf("payment %s is %s, %d refunded", payment.PaymentId, payment.PaymentStatus, payment.RefundedAmmount))
+	return c.notifyPayment(ctx, templates.PaymentRefunded, models.PaymentRefundedNotification, payment)
+}
+
+func (c *BillingHandler) onDisputeChanged(ctx context.Context, event payments.Event) error {
+	if event.Dispute == nil {
+		return fmt.Errorf("event without dispute: %s", event.Id)
+	}
+
+	payment, _, err := c.billingService.SetPaymentDispute(ctx, *event.Dispute)
+	if errors.Is(err, constants.ErrNoRows) {
+		slog.Info(fmt.Sprintf("Ignoring dispute of unknown payment: %s", event.Dispute.ProviderPaymentId))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	slog.Warn(fmt.Sprintf("dispute %s is %s, payment %s is %s", event.Dispute.Id, event.Dispute.Status, payment.PaymentId, payment.PaymentStatus))
+	return nil
+}
+
+// ReconcilePayments checks the pending payments that webhooks should have settled by now
+// against the gateway, applying whatever was missed. This method should be called periodically.
+func (c *BillingHandler) ReconcilePayments() error {
+	ctx := context.Background()
+
+	pending, err := c.billingService.GetPendingPayments(ctx, time.Now().Add(-constants.PaymentReconcileGrace), 100)
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	for _, payment := range pending {
+		err := c.reconcilePayment(ctx, payment)
+		attempts, recErr := c.billingService.RecordPaymentReconciliation(ctx, payment.PaymentId, err != nil)
+		if recErr != nil {
+			errs = append(errs, recErr)
+		}
+		if err == nil {
+			continue
+		}
+
+		errs = append(errs, errors.Join(err, fmt.Errorf("could not reconcile payment %s", payment.PaymentId)))
+		if attempts >= constants.PaymentReconcileMaxAttempts {
+			slog.Error(fmt.Sprintf("payment %s is no longer reconciled after %d failed attempts: %s", payment.PaymentId, attempts, err.Error()))
+			c.recordTelemetry(c.telemetryService.RecordEvent(ctx, "payment_reconciliation_abandoned", map[string]any{
+				"paymentId":         payment.PaymentId,
+				"checkoutSessionId": payment.ProviderCheckoutSessionId,
+				"attempts":          attempts,
+				"error":             err.Error(),
+				"createdAt":         payment.CreatedAt,
+			}, map[string]string{"alert": "true"}))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *BillingHandler) reconcilePayment(ctx context.Context, payment models.Payment) error {
+	checkoutSession, err := c.billingService.CheckoutSession(ctx, payment.ProviderCheckoutSessionId)
+	if err != nil {
+		return err
+	}
+
+	// missed events are replayed through their webhook handlers, which are idempotent
+	event := payments.Event{
+		Id:              "reconcile-" + payment.PaymentId,
+		CheckoutSession: &checkoutSession,
+	}
+
+	outcome := "pending"
+	switch {
+	case checkoutSession.Status == payments.CheckoutComplete && checkoutSession.IsPaid():
+		outcome = "missed_completion"
+		err = c.onCheckoutSessionCompleted(ctx, event)
+
+	case checkoutSession.Status == payments.CheckoutExpired:
+		outcome = "missed_expiration"
+		err = c.onCheckoutSessionExpired(ctx, event)
+
+	case checkoutSession.Status == payments.CheckoutOpen && time.Since(payment.CreatedAt) > constants.CheckoutSessionTimeout:
+		outcome = "stale_session"
+		var changed bool
+		payment, changed, err = c.billingService.ExpirePayment(ctx, payment)
+		if err == nil && changed {
+			err = c.notifyPayment(ctx, templates.PaymentCanceled, models.PaymentCanceledNotification, payment)
+		}
+	}
+
+	tags := map[string]string{"outcome": outcome}
+	if outcome == "missed_completion" || outcome == "missed_expiration" {
+		tags["alert"] = "true"
+		slog.Warn(fmt.Sprintf("payment %s was pending but its checkout session is %s/%s", payment.PaymentId, checkoutSession.Status, checkoutSession.PaymentStatus))
+		c.recordTelemetry(c.telemetryService.RecordEvent(ctx, "payment_discrepancy", map[string]any{
+			"paymentId":             payment.PaymentId,
+			"checkoutSessionId":     checkoutSession.Id,
+			"checkoutStatus":        checkoutSession.Status,
+			"checkoutPaymentStatus": checkoutSession.PaymentStatus,
+			"createdAt":             payment.CreatedAt,
+		}, tags))
+	}
+	c.recordTelemetry(c.telemetryService.RecordMetric(ctx, "payment_reconciliation", 1, tags))
+
+	return err
+}
+
+func (c *BillingHandler) recordTelemetry(err error) {
+	if err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+// notifyPayment sends a payment email and notification to the user that made it.
+func (c *BillingHandler) notifyPayment(ctx context.Context, templateName string, kind models.NotificationKind, payment models.Payment) error {
+	user, err := c.userService.GetUserFromId(ctx, payment.UserId)
+	if err != nil {
+		return err
+	}
+
+	return c.messageService.Send(ctx, services.Message{
+		UserId: user.UserId,
+		Kind:   kind,
+		NotificationData: map[string]any{
+			"PaymentId":       payment.PaymentId,
+			"Ammount":         payment.UnitAmmount,
+			"RefundedAmmount": payment.RefundedAmmount,
+			"Currency":        payment.UnitCurrency,
+		},
+		TemplateName: templateName,
+		To:           user.Email,
+		Locale:       common.Deref(user.Locale),
+		EmailData: map[string]any{
+			"FirstName":       user.FirstName,
+			"PaymentId":       payment.PaymentId,
+			"Ammount":         payment.UnitAmmount,
+			"RefundedAmmount": payment.RefundedAmmount,
+			"Currency":        payment.UnitCurrency,
+		},
+	})
+}
+
 func (c *BillingHandler) RegisterRoutes(rg *gin.RouterGroup, authMiddleware middlewares.AuthMiddleware) {
 	g := rg.Group("/billing")
 
-	g.POST("/stripe/get-checkout-session-url/:product_id", authMiddleware.AuthorizeUser(), c.CheckoutSessionUrl)
-	g.POST("/stripe/checkout-session-completed", c.CheckoutSessionCompletedCallback)
+	memberPerms := map[string]models.Permission{}
+	adminPerms := map[string]models.Permission{
+		"admin": models.ReadWritePermission,
+	}
+
+	g.POST("/stripe/get-checkout-session-url/:price_id", authMiddleware.AuthorizeUser(), c.CheckoutSessionUrl)
+	g.POST("/organizations/:orgId/subscription/checkout", authMiddleware.AuthorizeOrganization(adminPerms), c.SubscriptionCheckoutUrl)
+	g.GET("/organizations/:orgId/subscription", authMiddleware.AuthorizeOrganization(memberPerms), c.GetSubscription)
+	g.PUT("/organizations/:orgId/subscription", authMiddleware.AuthorizeOrganization(adminPerms), c.ChangeSubscription)
+	g.DELETE("/organizations/:orgId/subscription", authMiddleware.AuthorizeOrganization(adminPerms), c.CancelSubscription)
+	g.GET("/organizations/:orgId/usage", authMiddleware.AuthorizeOrganization(memberPerms), c.GetUsage)
+	g.GET("/organizations/:orgId/payments", authMiddleware.AuthorizeOrganization(adminPerms), c.GetOrganizationPayments)
+	g.GET("/organizations/:orgId/invoices", authMiddleware.AuthorizeOrganization(adminPerms), c.GetOrganizationInvoices)
+	g.GET("/organizations/:orgId/invoices/:invoiceId/receipt", authMiddleware.AuthorizeOrganization(adminPerms), c.GetOrganizationInvoiceReceipt)
+	g.POST("/organizations/:orgId/portal", authMiddleware.AuthorizeOrganization(adminPerms), c.PortalUrl)
+	g.GET("/organizations/:orgId/payment-methods", authMiddleware.AuthorizeOrganization(adminPerms), c.GetPaymentMethods)
+	g.PUT("/organizations/:orgId/payment-methods/default", authMiddleware.AuthorizeOrganization(adminPerms), c.SetDefaultPaymentMethod)
+	g.GET("/organizations/:orgId/billing-details", authMiddleware.AuthorizeOrganization(adminPerms), c.GetBillingDetails)
+	g.PUT("/organizations/:orgId/billing-details", authMiddleware.AuthorizeOrganization(adminPerms), c.SetBillingDetails)
+	g.GET("/payments", authMiddleware.AuthorizeUser(), c.GetPayments)
+	g.GET("/payments/:paymentId", authMiddleware.AuthorizeAdmin(), c.GetPayment)
+	g.POST("/payments/:paymentId/refund", authMiddleware.AuthorizeAdmin(), c.RefundPayment)
+	g.POST("/payments/:paymentId/cancel", authMiddleware.AuthorizeAdmin(), c.CancelPayment)
+	g.GET("/invoices", authMiddleware.AuthorizeUser(), c.GetInvoices)
+	g.GET("/invoices/:invoiceId/receipt", authMiddleware.AuthorizeUser(), c.GetInvoiceReceipt)
+	g.POST("/webhook", c.Webhook)
+	g.POST("/stripe/webhook", c.Webhook)                    // kept for endpoints registered before /webhook
+	g.POST("/stripe/checkout-session-completed", c.Webhook) // kept for endpoints registered before /stripe/webhook
 }
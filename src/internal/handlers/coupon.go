@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/dto"
+	"github.com/LombardiDaniel/goliath/src/internal/middlewares"
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/services"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/gin-gonic/gin"
+)
+
+type CouponHandler struct {
+	couponService  services.CouponService
+	planService    services.PlanService
+	billingService services.BillingService
+}
+
+func NewCouponHandler(
+	couponService services.CouponService,
+	planService services.PlanService,
+	billingService services.BillingService,
+) CouponHandler {
+	return CouponHandler{
+		couponService:  couponService,
+		planService:    planService,
+		billingService: billingService,
+	}
+}
+
+// @Summary CreateCoupon
+// @Security JWT
+// @Tags Coupon
+// @Description Creates a Coupon and its payment gateway Coupon
+// @Consume application/json
+// @Accept json
+// @Produce json
+// @Param   payload 	body 		dto.CreateCoupon true "coupon json"
+// @Success 200 		{object} 	models.Coupon
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 403 		{string} 	ErrorResponse "Forbidden"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 409 		{string} 	ErrorResponse "Conflict"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/coupons [POST]
+func (c *CouponHandler) CreateCoupon(ctx *gin.Context) {
+	var createCoupon dto.CreateCoupon
+
+	if err := ctx.ShouldBind(&createCoupon); err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if createCoupon.ExpiresAt != nil && createCoupon.ExpiresAt.Before(time.Now()) {
+		ctx.String(http.StatusBadRequest, "CouponAlreadyExpired")
+		return
+	}
+
+	for _, planId := range createCoupon.PlanIds {
+		_, err := c.planService.GetPlan(ctx, planId)
+		if errors.Is(err, constants.ErrNoRows) {
+			ctx.String(http.StatusNotFound, "PlanNotFound")
+			return
+		}
+		if err != nil {
+			slog.Error(err.Error())
+			ctx.String(http.StatusBadGateway, "BadGateway")
+			return
+		}
+	}
+
+	couponId, err := c.couponService.CreateCoupon(ctx, models.Coupon{
+		Code:           createCoupon.Code,
+		PercentOff:     createCoupon.PercentOff,
+		AmmountOff:     createCoupon.AmmountOff,
+		Currency:       createCoupon.Currency,
+		Duration:       models.CouponDuration(createCoupon.Duration),
+		PlanIds:        createCoupon.PlanIds,
+		MaxRedemptions: createCoupon.MaxRedemptions,
+		ExpiresAt:      createCoupon.ExpiresAt,
+	})
+	if errors.Is(err, constants.ErrDbConflict) {
+		ctx.String(http.StatusConflict, "CodeInUse")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	coupon, err := c.couponService.GetCoupon(ctx, couponId)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	coupon, ok := c.syncCoupon(ctx, coupon)
+	if !ok {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, coupon)
+}
+
+// @Summary GetCoupons
+// @Security JWT
+// @Tags Coupon
+// @Description Lists the Coupons, newest first
+// @Produce json
+// @Param	page 		query 		int false "page, starting at 1"
+// @Param	pageSize 	query 		int false "page size, up to 100"
+// @Success 200 		{object} 	dto.Page[models.Coupon]
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 403 		{string} 	ErrorResponse "Forbidden"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/coupons [GET]
+func (c *CouponHandler) GetCoupons(ctx *gin.Context) {
+	var q dto.PageQuery
+	if err := ctx.ShouldBindQuery(&q); err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	coupons, total, err := c.couponService.GetCoupons(ctx, q.Offset(), q.Limit())
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.NewPage(coupons, q, total))
+}
+
+// @Summary GetCoupon
+// @Security JWT
+// @Tags Coupon
+// @Description Gets a Coupon
+// @Produce json
+// @Param	couponId 	path 		string true "Coupon Id"
+// @Success 200 		{object} 	models.Coupon
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 403 		{string} 	ErrorResponse "Forbidden"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/coupons/{couponId} [GET]
+func (c *CouponHandler) GetCoupon(ctx *gin.Context) {
+	coupon, ok := c.getCoupon(ctx)
+	if !ok {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, coupon)
+}
+
+// @Summary EditCoupon
+// @Security JWT
+// @Tags Coupon
+// @Description Activates or deactivates a Coupon, coupons are otherwise immutable. Deactivating deletes the payment gateway Coupon, customers that redeemed it keep their discount
+// @Consume application/json
+// @Accept json
+// @Produce json
+// @Param	couponId 	path 		string true "Coupon Id"
+// @Param   payload 	body 		dto.EditCoupon true "coupon json"
+// @Success 200 		{object} 	models.Coupon
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 403 		{string} 	ErrorResponse "Forbidden"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/coupons/{couponId} [PUT]
+func (c *CouponHandler) EditCoupon(ctx *gin.Context) {
+	var editCoupon dto.EditCoupon
+	if err := ctx.ShouldBind(&editCoupon); err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	coupon, ok := c.getCoupon(ctx)
+	if !ok {
+		return
+	}
+
+	err := c.couponService.SetCouponActive(ctx, coupon.CouponId, editCoupon.IsActive)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+	coupon.IsActive = editCoupon.IsActive
+
+	coupon, ok = c.syncCoupon(ctx, coupon)
+	if !ok {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, coupon)
+}
+
+// @Summary GetCouponRedemptions
+// @Security JWT
+// @Tags Coupon
+// @Description Lists the redemptions of a Coupon, newest first
+// @Produce json
+// @Param	couponId 	path 		string true "Coupon Id"
+// @Param	page 		query 		int false "page, starting at 1"
+// @Param	pageSize 	query 		int false "page size, up to 100"
+// @Success 200 		{object} 	dto.Page[models.CouponRedemption]
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 403 		{string} 	ErrorResponse "Forbidden"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/coupons/{couponId}/redemptions [GET]
+func (c *CouponHandler) GetCouponRedemptions(ctx *gin.Context) {
+	var q dto.PageQuery
+	if err := ctx.ShouldBindQuery(&q); err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	coupon, ok := c.getCoupon(ctx)
+	if !ok {
+		return
+	}
+
+	redemptions, total, err := c.couponService.GetCouponRedemptions(ctx, coupon.CouponId, q.Offset(), q.Limit())
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.NewPage(redemptions, q, total))
+}
+
+// @Summary GetCouponsReport
+// @Security JWT
+// @Tags Coupon
+// @Description Totals the redemptions and discounts of every redeemed Coupon, per currency
+// @Produce json
+// @Success 200 		{object} 	[]models.CouponReport
+// @Failure 403 		{string} 	ErrorResponse "Forbidden"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/coupons/report [GET]
+func (c *CouponHandler) GetCouponsReport(ctx *gin.Context) {
+	report, err := c.couponService.GetCouponsReport(ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
+
+// @Summary QuoteCoupon
+// @Security JWT
+// @Tags Coupon
+// @Description Checks a coupon code can be redeemed on a price and quotes the discount of the first charge
+// @Produce json
+// @Param	code 		query 		string true "coupon code"
+// @Param	priceId 	query 		int true "Price Id"
+// @Param	seats 		query 		int false "seats of a subscription, defaults to 1"
+// @Success 200 		{object} 	dto.CouponQuote
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 409 		{string} 	ErrorResponse "Conflict"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/coupons/quote [GET]
+func (c *CouponHandler) QuoteCoupon(ctx *gin.Context) {
+	var q dto.CouponQuoteQuery
+	if err := ctx.ShouldBindQuery(&q); err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	price, err := c.planService.GetPrice(ctx, q.PriceId)
+	if errors.Is(err, constants.ErrNoRows) || (err == nil && !price.IsActive) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	coupon, err := c.couponService.GetRedeemableCoupon(ctx, q.Code, price)
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "CouponNotFound")
+		return
+	}
+	if errors.Is(err, constants.ErrCouponNotApplicable) {
+		ctx.String(http.StatusConflict, "CouponNotApplicable")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	// metered prices are charged by usage, not by seats
+	var ammount int64
+	if price.Meter == nil {
+		ammount = price.UnitAmmount * max(q.Seats, 1)
+	}
+	discount := coupon.Discount(ammount)
+
+	ctx.JSON(http.StatusOK, dto.CouponQuote{
+		Code:            coupon.Code,
+		PriceId:         price.PriceId,
+		Ammount:         ammount,
+		DiscountAmmount: discount,
+		Total:           ammount - discount,
+		Currency:        price.UnitCurrency,
+	})
+}
+
+// getCoupon retrieves the coupon of the couponId path param, writing the
+// error response and returning false if it cannot.
+func (c *CouponHandler) getCoupon(ctx *gin.Context) (models.Coupon, bool) {
+	couponId, err := strconv.Atoi(ctx.Param("couponId"))
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "BadRequest")
+		return models.Coupon{}, false
+	}
+
+	coupon, err := c.couponService.GetCoupon(ctx, uint32(couponId))
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return coupon, false
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return coupon, false
+	}
+
+	return coupon, true
+}
+
+// syncCoupon creates the gateway coupon of an active coupon, or deletes the one of an
+// inactive coupon, writing the error response and returning false if it fails.
+// Coupons are immutable at the gateway, so reactivating one creates a new gateway coupon.
+func (c *CouponHandler) syncCoupon(ctx *gin.Context, coupon models.Coupon) (models.Coupon, bool) {
+	if coupon.IsActive == (coupon.ProviderCouponId != nil) {
+		return coupon, true
+	}
+
+	providerCouponId, err := c.billingService.SyncCoupon(ctx, coupon)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return coupon, false
+	}
+
+	coupon.ProviderCouponId = nil
+	if coupon.IsActive {
+		coupon.ProviderCouponId = &providerCouponId
+	}
+
+	err = c.couponService.SetCouponProviderCouponId(ctx, coupon.CouponId, coupon.ProviderCouponId)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return coupon, false
+	}
+
+	return coupon, true
+}
+
+func (c *CouponHandler) RegisterRoutes(rg *gin.RouterGroup, authMiddleware middlewares.AuthMiddleware) {
+	g := rg.Group("/billing")
+
+	g.GET("/coupons", authMiddleware.AuthorizeAdmin(), c.GetCoupons)
+	g.POST("/coupons", authMiddleware.AuthorizeAdmin(), c.CreateCoupon)
+	g.GET("/coupons/report", authMiddleware.AuthorizeAdmin(), c.GetCouponsReport)
+	g.GET("/coupons/quote", authMiddleware.AuthorizeUser(), c.QuoteCoupon)
+	g.GET("/coupons/:couponId", authMiddleware.AuthorizeAdmin(), c.GetCoupon)
+	g.PUT("/coupons/:couponId", authMiddleware.AuthorizeAdmin(), c.EditCoupon)
+	g.GET("/coupons/:couponId/redemptions", authMiddleware.AuthorizeAdmin(), c.GetCouponRedemptions)
+}
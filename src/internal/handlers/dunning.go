@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/middlewares"
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/services"
+	"github.com/LombardiDaniel/goliath/src/internal/templates"
+	"github.com/LombardiDaniel/goliath/src/pkg/common"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/payments"
+	"github.com/gin-gonic/gin"
+)
+
+// DunningHandler recovers failed subscription charges. It is driven by the gateway
+// events it registers on the BillingHandler webhook and by ProcessDunning.
+type DunningHandler struct {
+	dunningService   services.DunningService
+	billingService   services.BillingService
+	orgService       services.OrganizationService
+	userService      services.UserService
+	messageService   services.MessageService
+	telemetryService services.TelemetryService
+}
+
+func NewDunningHandler(
+	dunningService services.DunningService,
+	billingService services.BillingService,
+	orgService services.OrganizationService,
+	userService services.UserService,
+	messageService services.MessageService,
+	telemetryService services.TelemetryService,
+) DunningHandler {
+	return DunningHandler{
+		dunningService:   dunningService,
+		billingService:   billingService,
+		orgService:       orgService,
+		userService:      userService,
+		messageService:   messageService,
+		telemetryService: telemetryService,
+	}
+}
+
+// RegisterWebhookHandlers subscribes the dunning handlers to the gateway events received by the
+// BillingHandler, after its own ones so subscriptions are already synced.
+func (c *DunningHandler) RegisterWebhookHandlers(billingHandler *BillingHandler) {
+	billingHandler.RegisterWebhookHandler(payments.InvoicePaymentFailedEvent, c.onInvoicePaymentFailed)
+	billingHandler.RegisterWebhookHandler(payments.InvoicePaidEvent, c.onInvoicePaid)
+	billingHandler.RegisterWebhookHandler(payments.SubscriptionUpdatedEvent, c.onSubscriptionChanged)
+}
+
+// @Summary GetDunningCases
+// @Security JWT
+// @Tags Billing
+// @Description Lists the failed subscription charges of the Organization and how far their recovery is, newest first
+// @Produce json
+// @Param	orgId 		path 		string true "Organization Id"
+// @Success 200 		{object} 	[]models.DunningCase
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/organizations/{orgId}/dunning [GET]
+func (c *DunningHandler) GetDunningCases(ctx *gin.Context) {
+	cases, err := c.dunningService.GetOrganizationCases(ctx, ctx.Param("orgId"))
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, cases)
+}
+
+func (c *DunningHandler) onInvoicePaymentFailed(ctx context.Context, event payments.Event) error {
+	if event.Invoice == nil {
+		return fmt.Errorf("event without invoice: %s", event.Id)
+	}
+
+	if event.Invoice.SubscriptionId == nil {
+		slog.Info(fmt.Sprintf("Ignoring failed invoice without subscription: %s", event.Invoice.Id))
+		return nil
+	}
+
+	sub, err := c.billingService.SyncSubscription(ctx, *event.Invoice.SubscriptionId)
+	if err != nil {
+		return err
+	}
+
+	err = c.billingService.RecordSubscriptionPayment(ctx, sub, *event.Invoice, false)
+	if err != nil {
+		return err
+	}
+
+	// first charges fail in the checkout, only renewals are dunned
+	if sub.Status != models.SubscriptionPastDue && sub.Status != models.SubscriptionUnpaid {
+		slog.Info(fmt.Sprintf("Ignoring failed invoice %s of %s subscription %s", event.Invoice.Id, sub.Status, sub.SubscriptionId))
+		return nil
+	}
+
+	dunningCase, changed, err := c.dunningService.OpenCase(ctx, sub, *event.Invoice)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+
+	c.recordTransition(ctx, dunningCase)
+	return c.notifyOwner(ctx, templates.PaymentFailed, models.PaymentFailedNotification, dunningCase)
+}
+
+func (c *DunningHandler) onInvoicePaid(ctx context.Context, event payments.Event) error {
+	if event.Invoice == nil {
+		return fmt.Errorf("event without invoice: %s", event.Id)
+	}
+
+	dunningCase, changed, err := c.dunningService.RecoverInvoice(ctx, event.Invoice.Id)
+	if err != nil || !changed {
+		return err
+	}
+
+	c.recordTransition(ctx, dunningCase)
+	slog.Info(fmt.Sprintf("dunning case %s of org %s recovered", dunningCase.DunningCaseId, dunningCase.OrganizationId))
+	return nil
+}
+
+func (c *DunningHandler) onSubscriptionChanged(ctx context.Context, event payments.Event) error {
+	if event.Subscription == nil {
+		return fmt.Errorf("event without subscription: %s", event.Id)
+	}
+
+	subscriptionId, ok := event.Subscription.Metadata["subscription_id"]
+	if !ok || models.SubscriptionStatus(event.Subscription.Status) != models.SubscriptionCanceled {
+		return nil
+	}
+
+	return c.dunningService.CancelSubscriptionCases(ctx, subscriptionId)
+}
+
+// ProcessDunning moves the dunning cases whose next step is due: retrying the charge, moving
+// to grace once the retries run out, warning the owner before grace ends and suspending the
+// subscription once it does. Steps that fail are backed off. This method should be called periodically.
+func (c *DunningHandler) ProcessDunning() error {
+	ctx := context.Background()
+
+	due, err := c.dunningService.GetDueCases(ctx, time.Now(), 100)
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	for _, dunningCase := range due {
+		err := c.processCase(ctx, dunningCase)
+		if err == nil {
+			continue
+		}
+
+		errs = append(errs, errors.Join(err, fmt.Errorf("could not process dunning case %s", dunningCase.DunningCaseId)))
+		failures, deferErr := c.dunningService.DeferCase(ctx, dunningCase, err)
+		if deferErr != nil {
+			errs = append(errs, deferErr)
+			continue
+		}
+		if failures >= constants.DunningProcessAlertFailures {
+			slog.Error(fmt.Sprintf("dunning case %s of org %s failed %d times in a row: %s", dunningCase.DunningCaseId, dunningCase.OrganizationId, failures, err.Error()))
+			c.recordTelemetry(c.telemetryService.RecordEvent(ctx, "dunning_stuck", map[string]any{
+				"dunningCaseId":  dunningCase.DunningCaseId,
+				"organizationId": dunningCase.OrganizationId,
+				"status":         dunningCase.Status,
+				"failures":       failures,
+				"error":          err.Error(),
+			}, map[string]string{"alert": "true"}))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *DunningHandler) processCase(ctx context.Context, dunningCase models.DunningCase) error {
+	if dunningCase.Status == models.DunningGrace && dunningCase.WarnedAt == nil && time.Now().Before(*dunningCase.GraceEndsAt) {
+		dunningCase, changed, err := c.dunningService.WarnCase(ctx, dunningCase)
+		if err != nil || !changed {
+			return err
+		}
+
+		c.recordTransition(ctx, dunningCase)
+		return c.notifyOwner(ctx, templates.SuspensionWarning, models.SuspensionWarningNotification, dunningCase)
+	}
+
+	switch dunningCase.Status {
+	case models.DunningRetrying:
+		dunningCase, changed, err := c.dunningService.RetryCase(ctx, dunningCase)
+		if err != nil || !changed {
+			return err
+		}
+
+		c.recordTransition(ctx, dunningCase)
+		if dunningCase.Status == models.DunningRecovered {
+			return nil
+		}
+		return c.notifyOwner(ctx, templates.PaymentFailed, models.PaymentFailedNotification, dunningCase)
+
+	case models.DunningGrace:
+		sub, err := c.billingService.GetOrganizationSubscription(ctx, dunningCase.OrganizationId)
+		if errors.Is(err, constants.ErrNoRows) || (err == nil && sub.SubscriptionId != dunningCase.SubscriptionId) {
+			// the subscription ended without its webhook being processed
+			return c.dunningService.CancelSubscriptionCases(ctx, dunningCase.SubscriptionId)
+		}
+		if err != nil {
+			return err
+		}
+
+		dunningCase, changed, err := c.dunningService.SuspendCase(ctx, dunningCase, sub)
+		if err != nil || !changed {
+			return err
+		}
+
+		c.recordTransition(ctx, dunningCase)
+		slog.Warn(fmt.Sprintf("subscription %s of org %s suspended by dunning case %s", sub.SubscriptionId, sub.OrganizationId, dunningCase.DunningCaseId))
+
+		// the cancellation webhook downgrades the organization too, syncing does not wait for it
+		_, err = c.billingService.SyncSubscription(ctx, *sub.ProviderSubscriptionId)
+		if err != nil {
+			return err
+		}
+		return c.notifyOwner(ctx, templates.SubscriptionSuspended, models.SubscriptionSuspendedNotification, dunningCase)
+	}
+
+	return nil
+}
+
+func (c *DunningHandler) recordTransition(ctx context.Context, dunningCase models.DunningCase) {
+	c.recordTelemetry(c.telemetryService.RecordMetric(ctx, "dunning_transition", 1, map[string]string{
+		"status":  string(dunningCase.Status),
+		"attempt": fmt.Sprint(dunningCase.AttemptCount),
+		"warned":  fmt.Sprint(dunningCase.WarnedAt != nil),
+	}))
+}
+
+func (c *DunningHandler) recordTelemetry(err error) {
+	if err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+// notifyOwner sends a dunning email and notification to the owner of the organization of the
+// case, the email in their locale or else in the one of the organization.
+func (c *DunningHandler) notifyOwner(ctx context.Context, templateName string, kind models.NotificationKind, dunningCase models.DunningCase) error {
+	org, err := c.orgService.GetOrganization(ctx, dunningCase.OrganizationId)
+	if err != nil {
+		return err
+	}
+
+	owner, err := c.userService.GetUserFromId(ctx, org.OwnerUserId)
+	if err != nil {
+		return err
+	}
+
+	locale := cmp.Or(common.Deref(owner.Locale), common.Deref(org.DefaultLocale))
+	return c.messageService.Send(ctx, services.Message{
+		UserId: owner.UserId,
+		Kind:   kind,
+		NotificationData: map[string]any{
+			"OrganizationId":   org.OrganizationId,
+			"OrganizationName": org.OrganizationName,
+			"AmmountDue":       dunningCase.AmmountDue,
+			"Currency":         dunningCase.Currency,
+			"NextAttemptAt":    dunningCase.NextAttemptAt,
+			"GraceEndsAt":      dunningCase.GraceEndsAt,
+		},
+		TemplateName: templateName,
+		To:           owner.Email,
+		Locale:       locale,
+		EmailData: map[string]any{
+			"FirstName":        owner.FirstName,
+			"OrganizationName": org.OrganizationName,
+			"AmmountDue":       dunningCase.AmmountDue,
+			"Currency":         dunningCase.Currency,
+			"NextAttemptAt":    dunningCase.NextAttemptAt,
+			"GraceEndsAt":      dunningCase.GraceEndsAt,
+		},
+	})
+}
+
+func (c *DunningHandler) RegisterRoutes(rg *gin.RouterGroup, authMiddleware middlewares.AuthMiddleware) {
+	g := rg.Group("/billing")
+
+	adminPerms := map[string]models.Permission{
+		"admin": models.ReadWritePermission,
+	}
+
+	g.GET("/organizations/:orgId/dunning", authMiddleware.AuthorizeOrganization(adminPerms), c.GetDunningCases)
+}
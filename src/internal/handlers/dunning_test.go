@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/services"
+	"github.com/LombardiDaniel/goliath/src/internal/templates"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/helpers"
+	"github.com/LombardiDaniel/goliath/src/pkg/payments"
+)
+
+type recordingMessageService struct {
+	mu     sync.Mutex
+	emails []string
+	kinds  []models.NotificationKind
+}
+
+func (s *recordingMessageService) Send(ctx context.Context, msg services.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.emails = append(s.emails, msg.TemplateName)
+	s.kinds = append(s.kinds, msg.Kind)
+	return nil
+}
+
+type recordingTelemetryService struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (s *recordingTelemetryService) RecordEvent(ctx context.Context, eventName string, metadata map[string]any, tags map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, eventName)
+	return nil
+}
+
+func (s *recordingTelemetryService) RecordMetric(ctx context.Context, metricName string, value float64, tags map[string]string) error {
+	return nil
+}
+
+func (s *recordingTelemetryService) Upload() error {
+	return nil
+}
+
+type dunningFixture struct {
+	handler        DunningHandler
+	gateway        *payments.FakeGateway
+	billing        services.BillingService
+	messages       *recordingMessageService
+	telemetry      *recordingTelemetryService
+	exec           func(query string, args ...any)
+	subscriptionId string // at the gateway
+}
+
+// newDunningFixture creates an organization with a subscription whose renewal failed
+// and the handler, the failed invoice is returned to be delivered to it.
+func newDunningFixture(t *testing.T, ctx context.Context) (dunningFixture, payments.Invoice) {
+	t.Helper()
+
+	pgContainer, err := helpers.NewPostgresContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %s", err)
+		}
+	})
+
+	// the webhook events of the gateway are delivered to the handler by the tests
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(webhook.Close)
+	gateway := payments.NewFakeGateway("http://localhost", webhook.URL, "whsec_test")
+
+	const subscriptionId = "00000000-0000-0000-0000-000000000001"
+
+	db := pgContainer.DB
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO users (user_id, email, password_hash, first_name, last_name)
+		VALUES (1, 'owner@email.com', 'hashtest', 'Test', 'Owner');
+
+		INSERT INTO plans (plan_id, plan_name) VALUES (1, 'pro');
+		INSERT INTO prices (price_id, plan_id, unit_ammount, unit_currency, billing_interval, provider_price_id)
+		VALUES (1, 1, 1000, 'usd', 'month', 'price_test');
+
+		INSERT INTO organizations (organization_id, organization_name, owner_user_id, billing_plan_id)
+		VALUES ('ORG01', 'org', 1, 1);
+
+		INSERT INTO subscriptions (subscription_id, organization_id, plan_id, price_id)
+		VALUES ('`+subscriptionId+`', 'ORG01', 1, 1);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seats := int64(1)
+	checkout, err := gateway.CreateCheckoutSession(ctx, payments.CheckoutParams{
+		Mode:     payments.SubscriptionMode,
+		LineItem: payments.LineItem{Name: "pro", Currency: "usd", UnitAmount: 1000, Interval: "month", Quantity: &seats},
+		Metadata: map[string]string{"subscription_id": subscriptionId},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkout, err = gateway.Pay(ctx, checkout.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoice, err := gateway.FailRenewal(ctx, *checkout.SubscriptionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := dunningFixture{
+		gateway:        gateway,
+		billing:        services.NewBillingServicePgImpl(db, gateway),
+		messages:       &recordingMessageService{},
+		telemetry:      &recordingTelemetryService{},
+		subscriptionId: *checkout.SubscriptionId,
+		exec: func(query string, args ...any) {
+			t.Helper()
+			if _, err := db.ExecContext(ctx, query, args...); err != nil {
+				t.Fatal(err)
+			}
+		},
+	}
+	f.handler = NewDunningHandler(
+		services.NewDunningServicePgImpl(db, gateway),
+		f.billing,
+		services.NewOrganizationServicePgImpl(db, nil, nil),
+		services.NewUserServicePgImpl(db, nil),
+		f.messages,
+		f.telemetry,
+	)
+
+	return f, invoice
+}
+
+func TestDunningHandler_ProcessDunning(t *testing.T) {
+	ctx := context.Background()
+	f, invoice := newDunningFixture(t, ctx)
+
+	err := f.handler.onInvoicePaymentFailed(ctx, payments.Event{Id: "evt_failed", Invoice: &invoice})
+	if err != nil {
+		t.Fatalf("DunningHandler.onInvoicePaymentFailed() error = %v", err)
+	}
+
+	// each retry is declined, the last one moves the case to grace
+	for range constants.DunningRetrySchedule {
+		f.exec(`UPDATE dunning_cases SET next_attempt_at = NOW() - INTERVAL '1 minute' WHERE dunning_status = 'retrying';`)
+		if err := f.handler.ProcessDunning(); err != nil {
+			t.Fatalf("DunningHandler.ProcessDunning() error = %v", err)
+		}
+	}
+
+	// the owner is warned once before grace ends
+	f.exec(`UPDATE dunning_cases SET grace_ends_at = NOW() + INTERVAL '1 day';`)
+	for range 2 {
+		if err := f.handler.ProcessDunning(); err != nil {
+			t.Fatalf("DunningHandler.ProcessDunning() error = %v", err)
+		}
+	}
+
+	f.exec(`UPDATE dunning_cases SET grace_ends_at = NOW() - INTERVAL '1 minute';`)
+	if err := f.handler.ProcessDunning(); err != nil {
+		t.Fatalf("DunningHandler.ProcessDunning() error = %v", err)
+	}
+
+	wantEmails := []string{
+		templates.PaymentFailed,
+		templates.PaymentFailed,
+		templates.PaymentFailed,
+		templates.PaymentFailed,
+		templates.SuspensionWarning,
+		templates.SubscriptionSuspended,
+	}
+	if !slices.Equal(f.messages.emails, wantEmails) {
+		t.Errorf("sent emails = %v, want %v", f.messages.emails, wantEmails)
+	}
+	wantKinds := []models.NotificationKind{
+		models.PaymentFailedNotification,
+		models.PaymentFailedNotification,
+		models.PaymentFailedNotification,
+		models.PaymentFailedNotification,
+		models.SuspensionWarningNotification,
+		models.SubscriptionSuspendedNotification,
+	}
+	if !slices.Equal(f.messages.kinds, wantKinds) {
+		t.Errorf("notifications = %v, want %v", f.messages.kinds, wantKinds)
+	}
+
+	providerSub, err := f.gateway.GetSubscription(ctx, f.subscriptionId)
+	if err != nil || providerSub.Status != "canceled" {
+		t.Errorf("the subscription should be canceled at the gateway, got %+v, %v", providerSub, err)
+	}
+	_, err = f.billing.GetOrganizationSubscription(ctx, "ORG01")
+	if err == nil {
+		t.Errorf("the organization should be left without a live subscription")
+	}
+}
+
+func TestDunningHandler_ProcessDunningBackoff(t *testing.T) {
+	ctx := context.Background()
+	f, invoice := newDunningFixture(t, ctx)
+
+	err := f.handler.onInvoicePaymentFailed(ctx, payments.Event{Id: "evt_failed", Invoice: &invoice})
+	if err != nil {
+		t.Fatalf("DunningHandler.onInvoicePaymentFailed() error = %v", err)
+	}
+
+	// the charge cannot be tried once the subscription is gone at the gateway, the case stays due
+	_, err = f.gateway.CancelSubscription(ctx, f.subscriptionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.exec(`UPDATE dunning_cases SET next_attempt_at = NOW() - INTERVAL '1 minute';`)
+
+	for failures := int64(1); failures <= constants.DunningProcessAlertFailures; failures++ {
+		if err := f.handler.ProcessDunning(); err == nil {
+			t.Fatalf("DunningHandler.ProcessDunning() should fail to retry the case")
+		}
+
+		// the failed case is backed off instead of being retried right away
+		if err := f.handler.ProcessDunning(); err != nil {
+			t.Fatalf("a deferred case should not be processed, got %v", err)
+		}
+		f.exec(`UPDATE dunning_cases SET process_after = NOW() - INTERVAL '1 minute';`)
+	}
+
+	if !slices.Equal(f.telemetry.events, []string{"dunning_stuck"}) {
+		t.Errorf("expected one alert once the case failed %d times, got %v", constants.DunningProcessAlertFailures, f.telemetry.events)
+	}
+	if len(f.messages.emails) != 1 {
+		t.Errorf("failed retries should not email the owner, sent %v", f.messages.emails)
+	}
+}
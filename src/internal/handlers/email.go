@@ -0,0 +1,437 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/LombardiDaniel/goliath/src/internal/dto"
+	"github.com/LombardiDaniel/goliath/src/internal/middlewares"
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/services"
+	"github.com/LombardiDaniel/goliath/src/internal/templates"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/mail"
+	"github.com/LombardiDaniel/goliath/src/pkg/token"
+	"github.com/gin-gonic/gin"
+)
+
+type EmailHandler struct {
+	outboxService         services.OutboxService
+	registry              *mail.Registry
+	deliverabilityService services.DeliverabilityService
+	webhookParser         mail.WebhookParser
+}
+
+func NewEmailHandler(
+	outboxService services.OutboxService,
+	registry *mail.Registry,
+	deliverabilityService services.DeliverabilityService,
+	webhookParser mail.WebhookParser,
+) EmailHandler {
+	return EmailHandler{
+		outboxService:         outboxService,
+		registry:              registry,
+		deliverabilityService: deliverabilityService,
+		webhookParser:         webhookParser,
+	}
+}
+
+// @Summary GetEmails
+// @Security JWT
+// @Tags Email
+// @Description Lists the emails of the outbox and their delivery status, newest first
+// @Produce json
+// @Param	status 		query 		string false "pending, sent or dead"
+// @Param	page 		query 		int false "page, starting at 1"
+// @Param	pageSize 	query 		int false "page size, up to 100"
+// @Success 200 		{object} 	dto.Page[models.OutboxEmail]
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 403 		{string} 	ErrorResponse "Forbidden"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/emails [GET]
+func (c *EmailHandler) GetEmails(ctx *gin.Context) {
+	var q dto.OutboxEmailsQuery
+	if err := ctx.ShouldBindQuery(&q); err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	emails, total, err := c.outboxService.GetEmails(ctx, q.Status, q.Offset(), q.Limit())
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.NewPage(emails, q.PageQuery, total))
+}
+
+// @Summary GetEmail
+// @Security JWT
+// @Tags Email
+// @Description Gets the delivery status of an email of the outbox
+// @Produce json
+// @Param	emailId 	path 		string true "Email Id"
+// @Success 200 		{object} 	models.OutboxEmail
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 403 		{string} 	ErrorResponse "Forbidden"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/emails/{emailId} [GET]
+func (c *EmailHandler) GetEmail(ctx *gin.Context) {
+	emailId, err := strconv.ParseInt(ctx.Param("emailId"), 10, 64)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "BadRequest")
+		return
+	}
+
+	email, err := c.outboxService.GetEmail(ctx, emailId)
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, email)
+}
+
+// @Summary RetryEmail
+// @Security JWT
+// @Tags Email
+// @Description Makes a dead email of the outbox pending again, with a fresh set of attempts
+// @Produce json
+// @Param	emailId 	path 		string true "Email Id"
+// @Success 200 		{object} 	models.OutboxEmail
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 403 		{string} 	ErrorResponse "Forbidden"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/emails/{emailId}/retry [POST]
+func (c *EmailHandler) RetryEmail(ctx *gin.Context) {
+	emailId, err := strconv.ParseInt(ctx.Param("emailId"), 10, 64)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "BadRequest")
+		return
+	}
+
+	email, err := c.outboxService.RetryEmail(ctx, emailId)
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, email)
+}
+
+// @Summary GetEmailTemplates
+// @Security JWT
+// @Tags Email
+// @Description Lists the registered email templates, their locales and sample data
+// @Produce json
+// @Success 200 		{object} 	[]dto.EmailTemplateOutput
+// @Failure 403 		{string} 	ErrorResponse "Forbidden"
+// @Router /v1/emails/templates [GET]
+func (c *EmailHandler) GetEmailTemplates(ctx *gin.Context) {
+	out := []dto.EmailTemplateOutput{}
+	for _, name := range c.registry.Names() {
+		out = append(out, dto.EmailTemplateOutput{
+			Name:    name,
+			Locales: c.registry.Locales(name),
+			Sample:  templates.Sample(name),
+		})
+	}
+
+	ctx.JSON(http.StatusOK, out)
+}
+
+// @Summary PreviewEmailTemplate
+// @Security JWT
+// @Tags Email
+// @Description Renders an email template with its sample data, as HTML to open in a browser or as plaintext
+// @Produce html
+// @Param	templateName 	path 		string true "Template Name"
+// @Param	locale 			query 		string false "locale, the default one if absent"
+// @Param	format 			query 		string false "html (default) or text"
+// @Success 200 			{string} 	string "rendered email"
+// @Failure 400 			{string} 	ErrorResponse "Bad Request"
+// @Failure 403 			{string} 	ErrorResponse "Forbidden"
+// @Failure 404 			{string} 	ErrorResponse "Not Found"
+// @Router /v1/emails/templates/{templateName}/preview [GET]
+func (c *EmailHandler) PreviewEmailTemplate(ctx *gin.Context) {
+	name := ctx.Param("templateName")
+
+	rendered, ok := c.render(ctx, name, ctx.Query("locale"), templates.Sample(name))
+	if !ok {
+		return
+	}
+
+	switch ctx.DefaultQuery("format", "html") {
+	case "html":
+		ctx.Data(http.StatusOK, "text/html; charset=utf-8", []byte(rendered.Html))
+	case "text":
+		ctx.String(http.StatusOK, rendered.Text)
+	default:
+		ctx.String(http.StatusBadRequest, "BadRequest")
+	}
+}
+
+// @Summary RenderEmailTemplate
+// @Security JWT
+// @Tags Email
+// @Description Renders an email template with the data given, or its sample data if absent, as HTML and plaintext
+// @Accept json
+// @Produce json
+// @Param	templateName 	path 		string true "Template Name"
+// @Param   payload 		body 		dto.RenderEmail true "render json"
+// @Success 200 			{object} 	dto.RenderedEmail
+// @Failure 400 			{string} 	ErrorResponse "Bad Request"
+// @Failure 403 			{string} 	ErrorResponse "Forbidden"
+// @Failure 404 			{string} 	ErrorResponse "Not Found"
+// @Router /v1/emails/templates/{templateName}/preview [POST]
+func (c *EmailHandler) RenderEmailTemplate(ctx *gin.Context) {
+	var renderEmail dto.RenderEmail
+	if err := ctx.ShouldBind(&renderEmail); err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	name := ctx.Param("templateName")
+	data := renderEmail.Data
+	if data == nil {
+		data = templates.Sample(name)
+	}
+
+	rendered, ok := c.render(ctx, name, renderEmail.Locale, data)
+	if !ok {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.RenderedEmail{
+		Locale:  rendered.Locale,
+		Subject: rendered.Subject,
+		Html:    rendered.Html,
+		Text:    rendered.Text,
+	})
+}
+
+// @Summary SendTestEmail
+// @Security JWT
+// @Tags Email
+// @Description Sends an email template to an address through the outbox, with the data given or its sample data if absent
+// @Accept json
+// @Produce plain
+// @Param	templateName 	path 		string true "Template Name"
+// @Param   payload 		body 		dto.TestEmail true "test email json"
+// @Success 200 			{string} 	OKResponse "OK"
+// @Failure 400 			{string} 	ErrorResponse "Bad Request"
+// @Failure 403 			{string} 	ErrorResponse "Forbidden"
+// @Failure 404 			{string} 	ErrorResponse "Not Found"
+// @Failure 502 			{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/emails/templates/{templateName}/test [POST]
+func (c *EmailHandler) SendTestEmail(ctx *gin.Context) {
+	var testEmail dto.TestEmail
+	if err := ctx.ShouldBind(&testEmail); err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	name := ctx.Param("templateName")
+	data := testEmail.Data
+	if data == nil {
+		data = templates.Sample(name)
+	}
+
+	// rendering first reports bad data now instead of when the outbox delivers it
+	if _, ok := c.render(ctx, name, testEmail.Locale, data); !ok {
+		return
+	}
+
+	err := c.outboxService.Send(ctx, name, testEmail.To, testEmail.Locale, data)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.String(http.StatusOK, "OK")
+}
+
+// @Summary Webhook
+// @Tags Email
+// @Description Receives the delivery events of the email provider, verifies their signature and records bounces, complaints and deliveries
+// @Produce plain
+// @Param   payload 	body 		any true "provider event json"
+// @Success 200 		{string} 	OKResponse "OK"
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 401 		{string} 	ErrorResponse "Unauthorized"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/emails/webhook [POST]
+func (c *EmailHandler) Webhook(ctx *gin.Context) {
+	payload, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadRequest, "BadRequest")
+		return
+	}
+
+	event, err := c.webhookParser.ParseDeliveryEvent(payload, ctx.Request.Header)
+	if err != nil {
+		slog.Warn(err.Error())
+		ctx.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	err = c.deliverabilityService.RecordEvent(ctx, event)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.String(http.StatusOK, "OK")
+}
+
+// @Summary GetEmailDeliverability
+// @Security JWT
+// @Tags Email
+// @Description Gets the deliverability of the User's email address, whether emails reach it and if it is suppressed
+// @Produce json
+// @Success 200 		{object} 	models.EmailDeliverability
+// @Failure 401 		{string} 	ErrorResponse "Unauthorized"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/emails/deliverability [GET]
+func (c *EmailHandler) GetEmailDeliverability(ctx *gin.Context) {
+	claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	c.respondDeliverability(ctx, claims.Email)
+}
+
+// @Summary GetAddressDeliverability
+// @Security JWT
+// @Tags Email
+// @Description Gets the deliverability of an email address
+// @Produce json
+// @Param	email 		path 		string true "Email address"
+// @Success 200 		{object} 	models.EmailDeliverability
+// @Failure 403 		{string} 	ErrorResponse "Forbidden"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/emails/deliverability/{email} [GET]
+func (c *EmailHandler) GetAddressDeliverability(ctx *gin.Context) {
+	c.respondDeliverability(ctx, ctx.Param("email"))
+}
+
+func (c *EmailHandler) respondDeliverability(ctx *gin.Context, email string) {
+	d, err := c.deliverabilityService.GetDeliverability(ctx, email)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, d)
+}
+
+// @Summary GetSuppressions
+// @Security JWT
+// @Tags Email
+// @Description Lists the suppressed email addresses, emails are not sent to them, most recent first
+// @Produce json
+// @Param	page 		query 		int false "page, starting at 1"
+// @Param	pageSize 	query 		int false "page size, up to 100"
+// @Success 200 		{object} 	dto.Page[models.EmailDeliverability]
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 403 		{string} 	ErrorResponse "Forbidden"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/emails/suppressions [GET]
+func (c *EmailHandler) GetSuppressions(ctx *gin.Context) {
+	var q dto.PageQuery
+	if err := ctx.ShouldBindQuery(&q); err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	suppressions, total, err := c.deliverabilityService.GetSuppressions(ctx, q.Offset(), q.Limit())
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.NewPage(suppressions, q, total))
+}
+
+// @Summary RemoveSuppression
+// @Security JWT
+// @Tags Email
+// @Description Removes an email address from the suppression list, so emails are sent to it again
+// @Produce plain
+// @Param	email 		path 		string true "Email address"
+// @Success 200 		{string} 	OKResponse "OK"
+// @Failure 403 		{string} 	ErrorResponse "Forbidden"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/emails/suppressions/{email} [DELETE]
+func (c *EmailHandler) RemoveSuppression(ctx *gin.Context) {
+	err := c.deliverabilityService.RemoveSuppression(ctx, ctx.Param("email"))
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.String(http.StatusOK, "OK")
+}
+
+// render renders the email and responds with the error if it fails, unknown templates are
+// not found and the others failed because of the data.
+func (c *EmailHandler) render(ctx *gin.Context, name string, locale string, data any) (mail.Rendered, bool) {
+	rendered, err := c.registry.Render(name, locale, data)
+	if errors.Is(err, mail.ErrUnknownTemplate) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return rendered, false
+	}
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return rendered, false
+	}
+
+	return rendered, true
+}
+
+func (c *EmailHandler) RegisterRoutes(rg *gin.RouterGroup, authMiddleware middlewares.AuthMiddleware) {
+	g := rg.Group("/emails")
+
+	g.POST("/webhook", c.Webhook)
+	g.GET("/deliverability", authMiddleware.AuthorizeUser(), c.GetEmailDeliverability)
+	g.GET("/deliverability/:email", authMiddleware.AuthorizeAdmin(), c.GetAddressDeliverability)
+	g.GET("/suppressions", authMiddleware.AuthorizeAdmin(), c.GetSuppressions)
+	g.DELETE("/suppressions/:email", authMiddleware.AuthorizeAdmin(), c.RemoveSuppression)
+	g.GET("", authMiddleware.AuthorizeAdmin(), c.GetEmails)
+	g.GET("/templates", authMiddleware.AuthorizeAdmin(), c.GetEmailTemplates)
+	g.GET("/templates/:templateName/preview", authMiddleware.AuthorizeAdmin(), c.PreviewEmailTemplate)
+	g.POST("/templates/:templateName/preview", authMiddleware.AuthorizeAdmin(), c.RenderEmailTemplate)
+	g.POST("/templates/:templateName/test", authMiddleware.AuthorizeAdmin(), c.SendTestEmail)
+	g.GET("/:emailId", authMiddleware.AuthorizeAdmin(), c.GetEmail)
+	g.POST("/:emailId/retry", authMiddleware.AuthorizeAdmin(), c.RetryEmail)
+}
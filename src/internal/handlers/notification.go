@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/dto"
+	"github.com/LombardiDaniel/goliath/src/internal/middlewares"
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/services"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/token"
+	"github.com/gin-gonic/gin"
+)
+
+type NotificationHandler struct {
+	preferenceService   services.PreferenceService
+	notificationService services.NotificationService
+}
+
+func NewNotificationHandler(preferenceService services.PreferenceService, notificationService services.NotificationService) NotificationHandler {
+	return NotificationHandler{
+		preferenceService:   preferenceService,
+		notificationService: notificationService,
+	}
+}
+
+// @Summary GetNotifications
+// @Security JWT
+// @Tags Notification
+// @Description Lists the in-app notifications of the User, newest first, and how many are unread
+// @Produce json
+// @Param	unread 		query 		bool false "only unread notifications"
+// @Param	page 		query 		int false "page, starting at 1"
+// @Param	pageSize 	query 		int false "page size, up to 100"
+// @Success 200 		{object} 	dto.NotificationPage
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 401 		{string} 	ErrorResponse "Unauthorized"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/notifications [GET]
+func (c *NotificationHandler) GetNotifications(ctx *gin.Context) {
+	var q dto.NotificationsQuery
+	if err := ctx.ShouldBindQuery(&q); err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	notifications, total, unread, err := c.notificationService.GetNotifications(ctx, claims.UserId, q.Unread, q.Offset(), q.Limit())
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.NotificationPage{
+		Page:   dto.NewPage(notifications, q.PageQuery, total),
+		Unread: unread,
+	})
+}
+
+// @Summary GetUnreadNotifications
+// @Security JWT
+// @Tags Notification
+// @Description Counts the unread in-app notifications of the User
+// @Produce json
+// @Success 200 		{object} 	dto.UnreadNotifications
+// @Failure 401 		{string} 	ErrorResponse "Unauthorized"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/notifications/unread [GET]
+func (c *NotificationHandler) GetUnread(ctx *gin.Context) {
+	claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	unread, err := c.notificationService.CountUnread(ctx, claims.UserId)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.UnreadNotifications{Unread: unread})
+}
+
+// @Summary MarkNotificationAsRead
+// @Security JWT
+// @Tags Notification
+// @Description Marks an in-app notification of the User as read
+// @Produce plain
+// @Param	notificationId 	path 		string true "Notification Id"
+// @Success 200 			{string} 	OKResponse "OK"
+// @Failure 400 			{string} 	ErrorResponse "Bad Request"
+// @Failure 401 			{string} 	ErrorResponse "Unauthorized"
+// @Failure 404 			{string} 	ErrorResponse "Not Found"
+// @Failure 502 			{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/notifications/{notificationId}/read [PUT]
+func (c *NotificationHandler) MarkAsRead(ctx *gin.Context) {
+	notificationId, err := strconv.ParseInt(ctx.Param("notificationId"), 10, 64)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "BadRequest")
+		return
+	}
+
+	claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	err = c.notificationService.MarkAsRead(ctx, claims.UserId, notificationId)
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.String(http.StatusOK, "OK")
+}
+
+// @Summary MarkAllNotificationsAsRead
+// @Security JWT
+// @Tags Notification
+// @Description Marks every in-app notification of the User as read
+// @Produce plain
+// @Success 200 		{string} 	OKResponse "OK"
+// @Failure 401 		{string} 	ErrorResponse "Unauthorized"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/notifications/read [PUT]
+func (c *NotificationHandler) MarkAllAsRead(ctx *gin.Context) {
+	claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	err = c.notificationService.MarkAllAsRead(ctx, claims.UserId)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.String(http.StatusOK, "OK")
+}
+
+// @Summary StreamNotifications
+// @Security JWT
+// @Tags Notification
+// @Description Server-Sent Events stream of the User: an "unread" event with the unread count when it opens, then a "notification" event for each new notification
+// @Produce text/event-stream
+// @Success 200 		{object} 	models.Notification
+// @Failure 401 		{string} 	ErrorResponse "Unauthorized"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/notifications/stream [GET]
+func (c *NotificationHandler) Stream(ctx *gin.Context) {
+	claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	// subscribing first, the count then covers the notifications created meanwhile
+	notifications, unsubscribe := c.notificationService.Subscribe(claims.UserId)
+	defer unsubscribe()
+
+	unread, err := c.notificationService.CountUnread(ctx, claims.UserId)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.SSEvent("unread", dto.UnreadNotifications{Unread: unread})
+	ctx.Writer.Flush()
+
+	ping := time.NewTicker(constants.NotificationStreamPing)
+	defer ping.Stop()
+
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case n, ok := <-notifications:
+			if !ok {
+				return false
+			}
+			ctx.SSEvent("notification", n)
+			return true
+		case <-ping.C:
+			// comments keep the connection alive without events for the app to handle
+			_, err := io.WriteString(w, ": ping\n\n")
+			return err == nil
+		case <-ctx.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// @Summary GetNotificationPreferences
+// @Security JWT
+// @Tags Notification
+// @Description Gets the email preferences of the User in every notification category
+// @Produce json
+// @Success 200 		{object} 	[]models.NotificationPreference
+// @Failure 401 		{string} 	ErrorResponse "Unauthorized"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/notifications/preferences [GET]
+func (c *NotificationHandler) GetPreferences(ctx *gin.Context) {
+	claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	prefs, err := c.preferenceService.GetPreferences(ctx, claims.UserId)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, prefs)
+}
+
+// @Summary SetNotificationPreference
+// @Security JWT
+// @Tags Notification
+// @Description Sets whether the User gets the emails of a notification category, security emails are always sent
+// @Accept json
+// @Produce plain
+// @Param   payload 	body 		dto.SetNotificationPreference true "preference json"
+// @Success 200 		{string} 	OKResponse "OK"
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 401 		{string} 	ErrorResponse "Unauthorized"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/notifications/preferences [PUT]
+func (c *NotificationHandler) SetPreference(ctx *gin.Context) {
+	var pref dto.SetNotificationPreference
+	if err := ctx.ShouldBind(&pref); err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !pref.Category.OptOutable() {
+		ctx.String(http.StatusBadRequest, "CategoryNotOptOutable")
+		return
+	}
+
+	claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	err = c.preferenceService.SetPreference(ctx, claims.UserId, pref.Category, *pref.Email)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.String(http.StatusOK, "OK")
+}
+
+// @Summary UnsubscribePage
+// @Tags Notification
+// @Description Redirects the unsubscribe link of an email to the confirmation page of the app, which unsubscribes with a POST
+// @Produce plain
+// @Param   token 		query 		string true "unsubscribe token of the email"
+// @Success 302 		{string} 	OKResponse "StatusFound"
+// @Router /v1/notifications/unsubscribe [GET]
+func (c *NotificationHandler) UnsubscribePage(ctx *gin.Context) {
+	// a GET must not unsubscribe, link scanners of mail providers follow them (RFC 8058)
+	location, err := url.JoinPath(constants.AppHostUrl, "/unsubscribe")
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.Header("location", location+"?"+url.Values{"token": {ctx.Query("token")}}.Encode())
+	ctx.String(http.StatusFound, "Found")
+}
+
+// @Summary Unsubscribe
+// @Tags Notification
+// @Description One-click unsubscribe (RFC 8058): opts the recipient of an email out of its notification category
+// @Produce plain
+// @Param   token 		query 		string true "unsubscribe token of the email"
+// @Success 200 		{string} 	OKResponse "OK"
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/notifications/unsubscribe [POST]
+func (c *NotificationHandler) Unsubscribe(ctx *gin.Context) {
+	category, err := c.preferenceService.Unsubscribe(ctx, ctx.Query("token"))
+	if errors.Is(err, token.ErrInvalidToken) {
+		ctx.String(http.StatusBadRequest, "BadRequest")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	slog.Info("unsubscribed from " + string(category))
+	ctx.String(http.StatusOK, "OK")
+}
+
+// @Summary RevokeUnsubscribeLinks
+// @Security JWT
+// @Tags Notification
+// @Description Invalidates the unsubscribe links of the emails sent to the User so far, as when one was forwarded
+// @Produce plain
+// @Success 200 		{string} 	OKResponse "OK"
+// @Failure 401 		{string} 	ErrorResponse "Unauthorized"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/notifications/unsubscribe/revoke [POST]
+func (c *NotificationHandler) RevokeUnsubscribeLinks(ctx *gin.Context) {
+	claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	err = c.preferenceService.RevokeUnsubscribeUrls(ctx, claims.UserId)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.String(http.StatusOK, "OK")
+}
+
+func (c *NotificationHandler) RegisterRoutes(rg *gin.RouterGroup, authMiddleware middlewares.AuthMiddleware) {
+	g := rg.Group("/notifications")
+
+	g.GET("", authMiddleware.AuthorizeUser(), c.GetNotifications)
+	g.GET("/unread", authMiddleware.AuthorizeUser(), c.GetUnread)
+	g.GET("/stream", authMiddleware.AuthorizeUser(), c.Stream)
+	g.PUT("/read", authMiddleware.AuthorizeUser(), c.MarkAllAsRead)
+	g.PUT("/:notificationId/read", authMiddleware.AuthorizeUser(), c.MarkAsRead)
+
+	g.GET("/preferences", authMiddleware.AuthorizeUser(), c.GetPreferences)
+	g.PUT("/preferences", authMiddleware.AuthorizeUser(), c.SetPreference)
+	g.GET("/unsubscribe", c.UnsubscribePage)
+	g.POST("/unsubscribe", c.Unsubscribe)
+	g.POST("/unsubscribe/revoke", authMiddleware.AuthorizeUser(), c.RevokeUnsubscribeLinks)
+}
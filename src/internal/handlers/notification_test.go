@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/services"
+	"github.com/LombardiDaniel/goliath/src/internal/templates"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/helpers"
+	"github.com/gin-gonic/gin"
+)
+
+func TestNotificationHandler_Unsubscribe(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := helpers.NewPostgresContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %s", err)
+		}
+	})
+
+	db := pgContainer.DB
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO users (user_id, email, password_hash, first_name, last_name)
+		VALUES (1, 'jane@email.com', 'hashtest', 'Jane', 'Doe');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preferenceService := services.NewPreferenceServicePgImpl(db, []byte("unsubscribe-key"))
+	handler := NewNotificationHandler(preferenceService, nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/v1/notifications/unsubscribe", handler.UnsubscribePage)
+	router.POST("/v1/notifications/unsubscribe", handler.Unsubscribe)
+
+	rawUrl, err := preferenceService.UnsubscribeUrl(ctx, templates.PaymentAccepted, "jane@email.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method string, query url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, "/v1/notifications/unsubscribe?"+query.Encode(), nil))
+		return w
+	}
+	optedIn := func() bool {
+		t.Helper()
+		send, err := preferenceService.ShouldSend(ctx, templates.PaymentAccepted, "jane@email.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return send
+	}
+
+	// link scanners follow the GET, it only redirects to the confirmation page
+	w := do(http.MethodGet, u.Query())
+	if w.Code != http.StatusFound || !optedIn() {
+		t.Fatalf("the unsubscribe page should redirect without unsubscribing, got %d", w.Code)
+	}
+	location, err := url.Parse(w.Header().Get("location"))
+	if err != nil || location.Query().Get("token") != u.Query().Get("token") {
+		t.Errorf("the redirect should keep the token, got %q", w.Header().Get("location"))
+	}
+
+	if w := do(http.MethodPost, url.Values{"token": {"not-a-token"}}); w.Code != http.StatusBadRequest || !optedIn() {
+		t.Errorf("an invalid token should be rejected, got %d", w.Code)
+	}
+
+	if w := do(http.MethodPost, u.Query()); w.Code != http.StatusOK || optedIn() {
+		t.Errorf("the one-click unsubscribe should opt the user out, got %d", w.Code)
+	}
+
+	if err := preferenceService.RevokeUnsubscribeUrls(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if w := do(http.MethodPost, u.Query()); w.Code != http.StatusBadRequest {
+		t.Errorf("a revoked link should be rejected, got %d", w.Code)
+	}
+}
+
+type memoryPreferenceService struct {
+	services.PreferenceService
+	prefs map[models.NotificationCategory]bool
+}
+
+func (s *memoryPreferenceService) SetPreference(ctx context.Context, userId uint32, category models.NotificationCategory, email bool) error {
+	s.prefs[category] = email
+	return nil
+}
+
+func TestNotificationHandler_SetPreference(t *testing.T) {
+	preferenceService := &memoryPreferenceService{prefs: map[models.NotificationCategory]bool{}}
+	handler := NewNotificationHandler(preferenceService, nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.PUT("/v1/notifications/preferences", func(ctx *gin.Context) {
+		ctx.Set(constants.GinCtxJwtClaimKeyName, models.JwtClaims{UserId: 1})
+	}, handler.SetPreference)
+
+	for body, want := range map[string]int{
+		`{"category": "product_news", "email": true}`: http.StatusOK,
+		`{"category": "billing", "email": false}`:     http.StatusOK,
+		`{"category": "security", "email": false}`:    http.StatusBadRequest,
+		`{"category": "unknown", "email": false}`:     http.StatusBadRequest,
+		`{"category": "billing"}`:                     http.StatusBadRequest,
+	} {
+		req := httptest.NewRequest(http.MethodPut, "/v1/notifications/preferences", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("SetPreference(%s) = %d %s, want %d", body, w.Code, w.Body.String(), want)
+		}
+	}
+
+	if _, ok := preferenceService.prefs[models.SecurityNotifications]; ok || len(preferenceService.prefs) != 2 {
+		t.Errorf("only the categories users can opt out of should be set, got %v", preferenceService.prefs)
+	}
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/LombardiDaniel/goliath/src/internal/middlewares"
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/services"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// ObjectHandler serves the objects of the bucket of the API for the storage backends without
+// URLs of their own (filesystem and memory): public objects to anyone, the others through
+// signed URLs. Its routes are only registered for those backends.
+type ObjectHandler struct {
+	objService services.ObjectService
+	signer     *storage.UrlSigner
+}
+
+func NewObjectHandler(objService services.ObjectService, signer *storage.UrlSigner) ObjectHandler {
+	return ObjectHandler{
+		objService: objService,
+		signer:     signer,
+	}
+}
+
+// objectParams returns the bucket and path of the object, ok is false for buckets other than
+// the one of the API, which are not served, and for paths no object can have.
+func objectParams(ctx *gin.Context) (string, string, bool) {
+	bucket := ctx.Param("bucket")
+	path := strings.TrimPrefix(ctx.Param("path"), "/")
+	return bucket, path, bucket == constants.S3Bucket && fs.ValidPath(path) && path != "."
+}
+
+// parseRange parses a Range header of a single range of bytes of an object of the size, nil
+// if there is none. Other ranges are ignored, as servers may, and ok is false if the range
+// is not satisfiable.
+func parseRange(header string, size int64) (*models.ByteRange, bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return nil, true
+	}
+	startStr, endStr, found := strings.Cut(spec, "-")
+	if !found {
+		return nil, true
+	}
+
+	rng := models.ByteRange{Start: 0, End: size - 1}
+	if startStr == "" { // the last bytes
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n < 0 {
+			return nil, true
+		}
+		if n == 0 || size == 0 {
+			return nil, false
+		}
+		rng.Start = max(size-n, 0)
+		return &rng, true
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return nil, true
+	}
+	rng.Start = start
+	if endStr != "" {
+		end, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return nil, true
+		}
+		rng.End = min(end, size-1)
+	}
+
+	if rng.Start >= size {
+		return nil, false
+	}
+	return &rng, true
+}
+
+// checksumHeader parses the X-Checksum-Sha256 header, the hex sha256 of the body if not empty.
+func checksumHeader(ctx *gin.Context) (string, bool) {
+	checksum := strings.ToLower(ctx.GetHeader("X-Checksum-Sha256"))
+	if checksum == "" {
+		return "", true
+	}
+	b, err := hex.DecodeString(checksum)
+	return checksum, err == nil && len(b) == sha256.Size
+}
+
+// @Summary DownloadObject
+// @Tags Object
+// @Description Streams a public object, or a private one with the query of its signed URL, or a single range of it
+// @Produce octet-stream
+// @Param	bucket 		path 		string true "Bucket"
+// @Param	path 		path 		string true "Object path"
+// @Param	expires 	query 		int false "expiration of the signed URL, unix seconds"
+// @Param	signature 	query 		string false "signature of the signed URL"
+// @Param	Range 		header 		string false "bytes=<start>-<end>"
+// @Success 200 		{file} 		binary "object content"
+// @Success 206 		{file} 		binary "range of the object content"
+// @Failure 403 		{string} 	ErrorResponse "Forbidden"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 416 		{string} 	ErrorResponse "Range Not Satisfiable"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/objects/{bucket}/{path} [GET]
+func (c *ObjectHandler) Download(ctx *gin.Context) {
+	bucket, path, ok := objectParams(ctx)
+	if !ok {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+
+	if !storage.IsPublicPath(path) {
+		if err := c.signer.Verify(http.MethodGet, bucket, path, ctx.Request.URL.Query()); err != nil {
+			ctx.String(http.StatusForbidden, "Forbidden")
+			return
+		}
+	}
+
+	info, err := c.objService.Stat(ctx, bucket, path)
+	if errors.Is(err, constants.ErrObjectNotFound) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	rng, ok := parseRange(ctx.GetHeader("Range"), info.Size)
+	if !ok {
+		ctx.Header("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+		ctx.String(http.StatusRequestedRangeNotSatisfiable, "RangeNotSatisfiable")
+		return
+	}
+
+	obj, err := c.objService.Download(ctx, bucket, path, rng)
+	if errors.Is(err, constants.ErrObjectNotFound) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+	defer obj.Close()
+
+	ctx.Header("ETag", `"`+info.ETag+`"`)
+	ctx.Header("Accept-Ranges", "bytes")
+	if info.SHA256 != "" {
+		ctx.Header("X-Checksum-Sha256", info.SHA256)
+	}
+
+	if rng == nil {
+		ctx.DataFromReader(http.StatusOK, info.Size, info.ContentType, obj, nil)
+		return
+	}
+	ctx.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rng.Start, rng.End, info.Size))
+	ctx.DataFromReader(http.StatusPartialContent, rng.Len(), info.ContentType, obj, nil)
+}
+
+// @Summary UploadObject
+// @Tags Object
+// @Description Uploads an object with the query of its signed upload URL, the body is the content of the size and type the URL was signed for, verified by its checksum if given
+// @Accept octet-stream
+// @Produce plain
+// @Param	bucket 				path 		string true "Bucket"
+// @Param	path 				path 		string true "Object path"
+// @Param	expires 			query 		int true "expiration of the signed URL, unix seconds"
+// @Param	signature 			query 		string true "signature of the signed URL"
+// @Param	size 				query 		int true "size of the content the URL was signed for"
+// @Param	content-type 		query 		string true "content type the URL was signed for"
+// @Param	X-Checksum-Sha256 	header 		string false "hex sha256 of the content"
+// @Success 200 				{string} 	OKResponse "OK"
+// @Failure 400 				{string} 	ErrorResponse "Bad Request"
+// @Failure 403 				{string} 	ErrorResponse "Forbidden"
+// @Failure 404 				{string} 	ErrorResponse "Not Found"
+// @Failure 502 				{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/objects/{bucket}/{path} [PUT]
+func (c *ObjectHandler) Upload(ctx *gin.Context) {
+	bucket, path, ok := objectParams(ctx)
+	if !ok {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+
+	query := ctx.Request.URL.Query()
+	if err := c.signer.Verify(http.MethodPut, bucket, path, query); err != nil {
+		ctx.String(http.StatusForbidden, "Forbidden")
+		return
+	}
+	if err := storage.CheckUpload(query, ctx.Request.ContentLength, ctx.ContentType()); err != nil {
+		ctx.String(http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	checksum, ok := checksumHeader(ctx)
+	if !ok {
+		ctx.String(http.StatusBadRequest, "BadRequest")
+		return
+	}
+
+	err := c.objService.Upload(ctx, bucket, path, ctx.Request.ContentLength, ctx.Request.Body, models.ObjectOptions{
+		ContentType: ctx.ContentType(),
+		SHA256:      checksum,
+	})
+	if errors.Is(err, constants.ErrChecksumMismatch) {
+		ctx.String(http.StatusBadRequest, "ChecksumMismatch")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.String(http.StatusOK, "OK")
+}
+
+func (c *ObjectHandler) RegisterRoutes(rg *gin.RouterGroup, authMiddleware middlewares.AuthMiddleware) {
+	g := rg.Group("/objects")
+
+	g.GET("/:bucket/*path", c.Download)
+	g.PUT("/:bucket/*path", c.Upload)
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/services"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/storage"
+	"github.com/gin-gonic/gin"
+)
+
+func TestObjectHandler(t *testing.T) {
+	ctx := context.Background()
+
+	signer := storage.NewUrlSigner("http://localhost/v1/objects", []byte("object-key"))
+	objService := services.NewObjectServiceMemoryImpl(signer)
+	handler := NewObjectHandler(objService, signer)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler.RegisterRoutes(router.Group("/v1"), nil)
+
+	put := func(rawUrl string, contentType string, body string) int {
+		t.Helper()
+		u, err := url.Parse(rawUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPut, u.RequestURI(), strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	path := storage.GetPrivatePath(storage.Uploads, "1/upload")
+	uploadUrl, err := objService.UploadUrl(ctx, constants.S3Bucket, path, 7, "text/csv", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the body must be of the size and content type the url was signed for
+	if code := put(uploadUrl, "text/csv", "a,b,c,d,e"); code != http.StatusForbidden {
+		t.Errorf("a body of another size should be forbidden, got %d", code)
+	}
+	if code := put(uploadUrl, "text/html", "a,b,c,d"); code != http.StatusForbidden {
+		t.Errorf("a body of another content type should be forbidden, got %d", code)
+	}
+	if code := put(uploadUrl, "text/csv", "a,b,c,d"); code != http.StatusOK {
+		t.Fatalf("the signed upload should succeed, got %d", code)
+	}
+
+	// only the bucket of the api is served
+	otherUrl, err := objService.UploadUrl(ctx, "other-bucket", path, 7, "text/csv", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := put(otherUrl, "text/csv", "a,b,c,d"); code != http.StatusNotFound {
+		t.Errorf("uploads to other buckets should not be served, got %d", code)
+	}
+
+	downloadUrl, err := objService.SignedUrl(ctx, constants.S3Bucket, path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(downloadUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, u.RequestURI(), nil))
+	if w.Code != http.StatusOK || w.Body.String() != "a,b,c,d" {
+		t.Errorf("the signed download should return the object, got %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/objects/"+constants.S3Bucket+"/"+path, nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("private objects should not be served without a signature, got %d", w.Code)
+	}
+
+	// paths no object can have are not found, whether public or not
+	for _, p := range []string{"public/../" + path, "public/", "public//x"} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/objects/"+constants.S3Bucket+"/"+p, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("the invalid path %q should not be found, got %d", p, w.Code)
+		}
+	}
+}
+
+type failingObjectService struct {
+	services.ObjectService
+}
+
+func (s *failingObjectService) Upload(ctx context.Context, bucket string, path string, size int64, r io.Reader, opts models.ObjectOptions) error {
+	return errors.New("storage unavailable")
+}
+
+func TestObjectHandler_UploadFailure(t *testing.T) {
+	signer := storage.NewUrlSigner("http://localhost/v1/objects", []byte("object-key"))
+	handler := NewObjectHandler(&failingObjectService{}, signer)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler.RegisterRoutes(router.Group("/v1"), nil)
+
+	path := storage.GetPrivatePath(storage.Uploads, "1/upload")
+	uploadUrl, err := signer.Sign(http.MethodPut, constants.S3Bucket, path, storage.UploadParams(7, "text/csv"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(uploadUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, u.RequestURI(), strings.NewReader("a,b,c,d"))
+	req.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("a failure of the storage should be a bad gateway, got %d", w.Code)
+	}
+}
@@ -18,20 +18,23 @@ import (
 )
 
 type OrganizationHandler struct {
-	userService  services.UserService
-	emailService services.EmailService
-	orgService   services.OrganizationService
+	userService        services.UserService
+	emailService       services.EmailService
+	orgService         services.OrganizationService
+	entitlementService services.EntitlementService
 }
 
 func NewOrganizationHandler(
 	userService services.UserService,
 	emailService services.EmailService,
 	orgService services.OrganizationService,
+	entitlementService services.EntitlementService,
 ) OrganizationHandler {
 	return OrganizationHandler{
-		userService:  userService,
-		emailService: emailService,
-		orgService:   orgService,
+		userService:        userService,
+		emailService:       emailService,
+		orgService:         orgService,
+		entitlementService: entitlementService,
 	}
 }
 
@@ -96,6 +99,7 @@ func (c *OrganizationHandler) CreateOrganization(ctx *gin.Context) {
 // @Param   payload 	body 		dto.CreateOrganizationInvite true "invite json"
 // @Success 200 		{string} 	OKResponse "OK"
 // @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 402 		{string} 	ErrorResponse "Seat Limit Exceeded"
 // @Failure 409 		{string} 	ErrorResponse "Conflict"
 // @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
 // @Router /v1/organizations/{orgId}/invite [POST]
@@ -115,21 +119,25 @@ func (c *OrganizationHandler) InviteToOrg(ctx *gin.Context) {
 		return
 	}
 
-	otp, err := common.GenerateRandomString(constants.OptLen)
+	err = c.entitlementService.CheckLimit(ctx, *currUser.OrganizationId, models.SeatsLimit, 1)
 	if err != nil {
+		if errors.Is(err, constants.ErrLimitExceeded) {
+			ctx.String(http.StatusPaymentRequired, "SeatLimitExceeded")
+			return
+		}
 		slog.Error(err.Error())
 		ctx.String(http.StatusBadGateway, "BadGateway")
 		return
 	}
 
-	user, err := c.userService.GetUser(ctx, createInv.UserEmail)
+	otp, err := common.GenerateRandomString(constants.OptLen)
 	if err != nil {
 		slog.Error(err.Error())
 		ctx.String(http.StatusBadGateway, "BadGateway")
 		return
 	}
 
-	org, err := c.orgService.GetOrganization(ctx, *currUser.OrganizationId)
+	user, err := c.userService.GetUser(ctx, createInv.UserEmail)
 	if err != nil {
 		slog.Error(err.Error())
 		ctx.String(http.StatusBadGateway, "BadGateway")
@@ -144,13 +152,6 @@ func (c *OrganizationHandler) InviteToOrg(ctx *gin.Context) {
 		return
 	}
 
-	err = c.emailService.SendOrganizationInvite(user.Email, user.FirstName, otp, org.OrganizationName)
-	if err != nil {
-		slog.Error(err.Error())
-		ctx.String(http.StatusBadGateway, "BadGateway")
-		return
-	}
-
 	ctx.String(http.StatusOK, "OK")
 }
 
@@ -180,6 +181,39 @@ func (c *OrganizationHandler) AcceptOrgInvite(ctx *gin.Context) {
 	ctx.String(http.StatusOK, "OK")
 }
 
+// @Summary AcceptPendingOrgInvite
+// @Security JWT
+// @Tags Organization
+// @Description Accepts the pending invite of the User to the Organization, as the app does from its notification
+// @Produce plain
+// @Param	orgId 		path 		string true "Organization Id"
+// @Success 200 		{string} 	OKResponse "OK"
+// @Failure 401 		{string} 	ErrorResponse "Unauthorized"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/organizations/{orgId}/accept-invite [POST]
+func (c *OrganizationHandler) AcceptPendingOrgInvite(ctx *gin.Context) {
+	claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	err = c.orgService.AcceptOrganizationInvite(ctx, ctx.Param("orgId"), claims.UserId)
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.String(http.StatusOK, "OK")
+}
+
 // @Summary RemoveFromOrg
 // @Security JWT
 // @Tags Organization
@@ -283,6 +317,69 @@ func (c *OrganizationHandler) ChangeOwner(ctx *gin.Context) {
 	ctx.String(http.StatusOK, "OK")
 }
 
+// @Summary GetEntitlements
+// @Security JWT
+// @Tags Organization
+// @Description Gets the features and limits granted by the Organization's plan
+// @Produce json
+// @Param	orgId 		path string true "Organization Id"
+// @Success 200 		{object} 	models.Entitlements
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/organizations/{orgId}/entitlements [GET]
+func (c *OrganizationHandler) GetEntitlements(ctx *gin.Context) {
+	ent, err := c.entitlementService.GetEntitlements(ctx, ctx.Param("orgId"))
+	if err != nil {
+		if errors.Is(err, constants.ErrNoRows) {
+			ctx.String(http.StatusNotFound, "NotFound")
+			return
+		}
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, ent)
+}
+
+// @Summary SetDefaultLocale
+// @Security JWT
+// @Tags Organization
+// @Description Sets the locale of the Organization's members without one of their own, null clears it
+// @Consume application/json
+// @Accept json
+// @Produce plain
+// @Param	orgId 		path string true "Organization Id"
+// @Param   payload 	body 		dto.SetOrganizationLocale true "locale json"
+// @Success 200 		{string} 	OKResponse "OK"
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/organizations/{orgId}/locale [PUT]
+func (c *OrganizationHandler) SetDefaultLocale(ctx *gin.Context) {
+	var setLocale dto.SetOrganizationLocale
+
+	if err := ctx.ShouldBind(&setLocale); err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err := c.orgService.SetDefaultLocale(ctx, ctx.Param("orgId"), setLocale.DefaultLocale)
+	if err != nil {
+		if errors.Is(err, constants.ErrNoRows) {
+			ctx.String(http.StatusNotFound, "NotFound")
+			return
+		}
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.String(http.StatusOK, "OK")
+}
+
 func (c *OrganizationHandler) RegisterRoutes(rg *gin.RouterGroup, authMiddleware middlewares.AuthMiddleware) {
 	g := rg.Group("/organizations")
 
@@ -299,5 +396,8 @@ func (c *OrganizationHandler) RegisterRoutes(rg *gin.RouterGroup, authMiddleware
 	g.POST("/:orgId/invite", authMiddleware.AuthorizeOrganization(adminPerms), c.InviteToOrg)
 	g.PUT("/:orgId/owner", authMiddleware.AuthorizeOrganization(ownerPerms), c.ChangeOwner, authMiddleware.Reauthorize())
 	g.GET("/accept-invite", c.AcceptOrgInvite)
+	g.POST("/:orgId/accept-invite", authMiddleware.AuthorizeUser(), c.AcceptPendingOrgInvite)
 	g.DELETE("/:orgId/users/:userId", authMiddleware.AuthorizeOrganization(adminPerms), c.RemoveFromOrg)
+	g.GET("/:orgId/entitlements", authMiddleware.AuthorizeOrganization(map[string]models.Permission{}), c.GetEntitlements)
+	g.PUT("/:orgId/locale", authMiddleware.AuthorizeOrganization(adminPerms), c.SetDefaultLocale)
 }
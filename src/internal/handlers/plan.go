@@ -0,0 +1,429 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/LombardiDaniel/goliath/src/internal/dto"
+	"github.com/LombardiDaniel/goliath/src/internal/middlewares"
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/services"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/gin-gonic/gin"
+)
+
+type PlanHandler struct {
+	planService    services.PlanService
+	billingService services.BillingService
+}
+
+func NewPlanHandler(
+	planService services.PlanService,
+	billingService services.BillingService,
+) PlanHandler {
+	return PlanHandler{
+		planService:    planService,
+		billingService: billingService,
+	}
+}
+
+// @Summary GetPlans
+// @Tags Plan
+// @Description Lists the active Plans and their active Prices
+// @Produce json
+// @Success 200 		{object} 	[]models.Plan
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/plans [GET]
+func (c *PlanHandler) GetPlans(ctx *gin.Context) {
+	plans, err := c.planService.GetPlans(ctx, true)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, plans)
+}
+
+// @Summary GetAllPlans
+// @Security JWT
+// @Tags Plan
+// @Description Lists all Plans and Prices, including inactive ones
+// @Produce json
+// @Success 200 		{object} 	[]models.Plan
+// @Failure 403 		{string} 	ErrorResponse "Forbidden"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/plans/all [GET]
+func (c *PlanHandler) GetAllPlans(ctx *gin.Context) {
+	plans, err := c.planService.GetPlans(ctx, false)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, plans)
+}
+
+// @Summary GetPlan
+// @Tags Plan
+// @Description Gets a Plan and its Prices
+// @Produce json
+// @Param	planId 		path 		string true "Plan Id"
+// @Success 200 		{object} 	models.Plan
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/plans/{planId} [GET]
+func (c *PlanHandler) GetPlan(ctx *gin.Context) {
+	planId, err := strconv.Atoi(ctx.Param("planId"))
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "BadRequest")
+		return
+	}
+
+	plan, err := c.planService.GetPlan(ctx, uint32(planId))
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, plan)
+}
+
+// @Summary CreatePlan
+// @Security JWT
+// @Tags Plan
+// @Description Creates a Plan
+// @Consume application/json
+// @Accept json
+// @Produce json
+// @Param   payload 	body 		dto.CreatePlan true "plan json"
+// @Success 200 		{object} 	dto.Id
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 403 		{string} 	ErrorResponse "Forbidden"
+// @Failure 409 		{string} 	ErrorResponse "Conflict"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/plans [POST]
+func (c *PlanHandler) CreatePlan(ctx *gin.Context) {
+	var createPlan dto.CreatePlan
+
+	if err := ctx.ShouldBind(&createPlan); err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	features := createPlan.Features
+	if features == nil {
+		features = []string{}
+	}
+
+	limits := createPlan.Limits
+	if limits == nil {
+		limits = map[models.Limit]int64{}
+	}
+
+	planId, err := c.planService.CreatePlan(ctx, models.Plan{
+		PlanName: createPlan.PlanName,
+		Features: features,
+		Limits:   limits,
+	})
+	if errors.Is(err, constants.ErrDbConflict) {
+		ctx.String(http.StatusConflict, "Conflict")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.Id{Id: strconv.Itoa(int(planId))})
+}
+
+// @Summary EditPlan
+// @Security JWT
+// @Tags Plan
+// @Description Edits a Plan
+// @Consume application/json
+// @Accept json
+// @Produce plain
+// @Param	planId 		path 		string true "Plan Id"
+// @Param   payload 	body 		dto.EditPlan true "plan json"
+// @Success 200 		{string} 	OKResponse "OK"
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 403 		{string} 	ErrorResponse "Forbidden"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/plans/{planId} [PUT]
+func (c *PlanHandler) EditPlan(ctx *gin.Context) {
+	planId, err := strconv.Atoi(ctx.Param("planId"))
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "BadRequest")
+		return
+	}
+
+	var editPlan dto.EditPlan
+	if err := ctx.ShouldBind(&editPlan); err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if editPlan.Features == nil {
+		editPlan.Features = []string{}
+	}
+
+	if editPlan.Limits == nil {
+		editPlan.Limits = map[models.Limit]int64{}
+	}
+
+	err = c.planService.EditPlan(ctx, uint32(planId), editPlan)
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.String(http.StatusOK, "OK")
+}
+
+// @Summary DeletePlan
+// @Security JWT
+// @Tags Plan
+// @Description Deactivates a Plan and all its Prices
+// @Produce plain
+// @Param	planId 		path 		string true "Plan Id"
+// @Success 200 		{string} 	OKResponse "OK"
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 403 		{string} 	ErrorResponse "Forbidden"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/plans/{planId} [DELETE]
+func (c *PlanHandler) DeletePlan(ctx *gin.Context) {
+	planId, err := strconv.Atoi(ctx.Param("planId"))
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "BadRequest")
+		return
+	}
+
+	err = c.planService.DeactivatePlan(ctx, uint32(planId))
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.String(http.StatusOK, "OK")
+}
+
+// @Summary CreatePrice
+// @Security JWT
+// @Tags Plan
+// @Description Creates a Price for a Plan
+// @Consume application/json
+// @Accept json
+// @Produce json
+// @Param	planId 		path 		string true "Plan Id"
+// @Param   payload 	body 		dto.CreatePrice true "price json"
+// @Success 200 		{object} 	dto.Id
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 403 		{string} 	ErrorResponse "Forbidden"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/plans/{planId}/prices [POST]
+func (c *PlanHandler) CreatePrice(ctx *gin.Context) {
+	planId, err := strconv.Atoi(ctx.Param("planId"))
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "BadRequest")
+		return
+	}
+
+	var createPrice dto.CreatePrice
+	if err := ctx.ShouldBind(&createPrice); err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var meter *models.Meter
+	if createPrice.Meter != "" {
+		if models.BillingInterval(createPrice.Interval) == models.OneTimeInterval {
+			ctx.String(http.StatusBadRequest, "MeteredPriceMustBeRecurring")
+			return
+		}
+		m := models.Meter(createPrice.Meter)
+		meter = &m
+	}
+
+	if createPrice.TrialDays > 0 && models.BillingInterval(createPrice.Interval) == models.OneTimeInterval {
+		ctx.String(http.StatusBadRequest, "TrialPriceMustBeRecurring")
+		return
+	}
+
+	_, err = c.planService.GetPlan(ctx, uint32(planId))
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	priceId, err := c.planService.CreatePrice(ctx, models.Price{
+		PlanId:       uint32(planId),
+		UnitAmmount:  createPrice.UnitAmmount,
+		UnitCurrency: createPrice.UnitCurrency,
+		Interval:     models.BillingInterval(createPrice.Interval),
+		Meter:        meter,
+		TrialDays:    createPrice.TrialDays,
+	})
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.Id{Id: strconv.Itoa(int(priceId))})
+}
+
+// @Summary EditPrice
+// @Security JWT
+// @Tags Plan
+// @Description Activates or deactivates a Price, prices are otherwise immutable
+// @Consume application/json
+// @Accept json
+// @Produce plain
+// @Param	priceId 	path 		string true "Price Id"
+// @Param   payload 	body 		dto.EditPrice true "price json"
+// @Success 200 		{string} 	OKResponse "OK"
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 403 		{string} 	ErrorResponse "Forbidden"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/prices/{priceId} [PUT]
+func (c *PlanHandler) EditPrice(ctx *gin.Context) {
+	priceId, err := strconv.Atoi(ctx.Param("priceId"))
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "BadRequest")
+		return
+	}
+
+	var editPrice dto.EditPrice
+	if err := ctx.ShouldBind(&editPrice); err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = c.planService.SetPriceActive(ctx, uint32(priceId), editPrice.IsActive)
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.String(http.StatusOK, "OK")
+}
+
+// @Summary SyncPlan
+// @Security JWT
+// @Tags Plan
+// @Description Creates or updates the payment gateway Product and Prices of a Plan
+// @Produce json
+// @Param	planId 		path 		string true "Plan Id"
+// @Success 200 		{object} 	models.Plan
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 403 		{string} 	ErrorResponse "Forbidden"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/billing/plans/{planId}/sync [POST]
+func (c *PlanHandler) SyncPlan(ctx *gin.Context) {
+	planId, err := strconv.Atoi(ctx.Param("planId"))
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "BadRequest")
+		return
+	}
+
+	plan, err := c.planService.GetPlan(ctx, uint32(planId))
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	productId, err := c.billingService.SyncProduct(ctx, plan)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	err = c.planService.SetPlanProviderProductId(ctx, plan.PlanId, productId)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+	plan.ProviderProductId = &productId
+
+	for i, price := range plan.Prices {
+		providerPriceId, err := c.billingService.SyncPrice(ctx, price, productId)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error while syncing price '%d': '%s'", price.PriceId, err.Error()))
+			ctx.String(http.StatusBadGateway, "BadGateway")
+			return
+		}
+
+		err = c.planService.SetPriceProviderPriceId(ctx, price.PriceId, providerPriceId)
+		if err != nil {
+			slog.Error(err.Error())
+			ctx.String(http.StatusBadGateway, "BadGateway")
+			return
+		}
+		plan.Prices[i].ProviderPriceId = &providerPriceId
+	}
+
+	ctx.JSON(http.StatusOK, plan)
+}
+
+func (c *PlanHandler) RegisterRoutes(rg *gin.RouterGroup, authMiddleware middlewares.AuthMiddleware) {
+	g := rg.Group("/billing")
+
+	g.GET("/plans", c.GetPlans)
+	g.GET("/plans/all", authMiddleware.AuthorizeAdmin(), c.GetAllPlans)
+	g.GET("/plans/:planId", c.GetPlan)
+	g.POST("/plans", authMiddleware.AuthorizeAdmin(), c.CreatePlan)
+	g.PUT("/plans/:planId", authMiddleware.AuthorizeAdmin(), c.EditPlan)
+	g.DELETE("/plans/:planId", authMiddleware.AuthorizeAdmin(), c.DeletePlan)
+	g.POST("/plans/:planId/prices", authMiddleware.AuthorizeAdmin(), c.CreatePrice)
+	g.POST("/plans/:planId/sync", authMiddleware.AuthorizeAdmin(), c.SyncPlan)
+	g.PUT("/prices/:priceId", authMiddleware.AuthorizeAdmin(), c.EditPrice)
+}
@@ -0,0 +1,732 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/dto"
+	"github.com/LombardiDaniel/goliath/src/internal/middlewares"
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/services"
+	"github.com/LombardiDaniel/goliath/src/pkg/common"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/storage"
+	"github.com/LombardiDaniel/goliath/src/pkg/token"
+	"github.com/gin-gonic/gin"
+)
+
+// UploadHandler serves the uploads of files. Resumable uploads go through the API: the parts
+// are uploaded one request each, those already uploaded are listed to resume, then the upload
+// is completed. Direct uploads go to the storage through a presigned URL, then are confirmed
+// once the file is checked against what was declared.
+type UploadHandler struct {
+	uploadService      services.UploadService
+	objService         services.ObjectService
+	entitlementService services.EntitlementService
+}
+
+// uploadRule limits the direct uploads of a purpose.
+type uploadRule struct {
+	maxSize      int64
+	contentTypes []string // sniffed from the magic bytes of the file, any and unverified if empty
+}
+
+var uploadRules = map[models.UploadPurpose]uploadRule{
+	models.FilePurpose:     {maxSize: 1024 * 1024 * 1024},
+	models.ImagePurpose:    {maxSize: 10 * 1024 * 1024, contentTypes: []string{"image/jpeg", "image/png", "image/gif", "image/webp"}},
+	models.DocumentPurpose: {maxSize: 50 * 1024 * 1024, contentTypes: []string{"application/pdf", "text/plain", "text/csv"}},
+}
+
+// sniffContentType is the media type of the magic bytes of a file, as http.DetectContentType
+// tells them. It cannot tell kinds of text apart, so text is taken as the declared type if it
+// also is text.
+func sniffContentType(head []byte, declared string) string {
+	sniffed, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return ""
+	}
+	if sniffed == "text/plain" && strings.HasPrefix(declared, "text/") {
+		return declared
+	}
+	return sniffed
+}
+
+func NewUploadHandler(uploadService services.UploadService, objService services.ObjectService, entitlementService services.EntitlementService) UploadHandler {
+	return UploadHandler{
+		uploadService:      uploadService,
+		objService:         objService,
+		entitlementService: entitlementService,
+	}
+}
+
+// directUploadPath is where the file of a direct upload is PUT with its presigned URL. It is
+// moved to the path of the upload once confirmed, so the URL, valid a while longer, cannot
+// replace the file that was checked.
+func directUploadPath(upload models.Upload) string {
+	return upload.ObjectPath + ".incoming"
+}
+
+// getUpload retrieves the upload of the path of the request, responding if it could not.
+func (c *UploadHandler) getUpload(ctx *gin.Context) (models.Upload, bool) {
+	claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return models.Upload{}, false
+	}
+
+	upload, err := c.uploadService.GetUpload(ctx, claims.UserId, ctx.Param("uploadId"))
+	if errors.Is(err, constants.ErrNoRows) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return upload, false
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return upload, false
+	}
+
+	return upload, true
+}
+
+// uploadStatus is the status of an upload, with its parts while in progress and its URL once completed.
+func (c *UploadHandler) uploadStatus(ctx *gin.Context, upload models.Upload) (dto.UploadStatus, error) {
+	status := dto.UploadStatus{Upload: upload}
+
+	var err error
+	if upload.CompletedAt == nil {
+		if upload.MultipartId != nil {
+			status.Parts, err = c.objService.ListParts(ctx, constants.S3Bucket, upload.ObjectPath, *upload.MultipartId)
+		}
+	} else {
+		status.Url, err = c.objService.SignedUrl(ctx, constants.S3Bucket, upload.ObjectPath, constants.UploadUrlTimeout)
+	}
+	return status, err
+}
+
+// removeUpload deletes an upload and its file, aborting it if in progress.
+func (c *UploadHandler) removeUpload(ctx context.Context, upload models.Upload) error {
+	var err error
+	if upload.CompletedAt == nil && upload.MultipartId != nil {
+		err = c.objService.AbortMultipartUpload(ctx, constants.S3Bucket, upload.ObjectPath, *upload.MultipartId)
+		if errors.Is(err, constants.ErrUploadNotFound) { // already discarded by the storage
+			err = nil
+		}
+	} else if upload.MultipartId == nil {
+		// direct uploads may or may not have their file uploaded or moved yet, and their URL
+		// may have been used again once confirmed
+		err = c.objService.DeleteMany(ctx, constants.S3Bucket, []string{directUploadPath(upload), upload.ObjectPath})
+	} else {
+		err = c.objService.Delete(ctx, constants.S3Bucket, upload.ObjectPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = c.uploadService.DeleteUpload(ctx, upload.UploadId)
+	if err != nil {
+		return err
+	}
+
+	if upload.CompletedAt != nil {
+		return c.releaseStorage(ctx, upload, common.Deref(upload.Size))
+	}
+	return nil
+}
+
+// releaseStorage removes the size of an upload from the storage of its organization, once
+// deleted or if it could not be completed after reserving it.
+func (c *UploadHandler) releaseStorage(ctx context.Context, upload models.Upload, size int64) error {
+	if upload.OrganizationId == nil {
+		return nil
+	}
+	return c.entitlementService.AddStorageUsage(ctx, *upload.OrganizationId, -size)
+}
+
+// partsSize is the size of the object of the parts to complete an upload with, from the sizes
+// they were uploaded with, ok is false if one was not uploaded.
+func partsSize(uploaded []models.ObjectPart, parts []models.ObjectPart) (int64, bool) {
+	var size int64
+	for _, part := range parts {
+		i := slices.IndexFunc(uploaded, func(p models.ObjectPart) bool {
+			return p.PartNumber == part.PartNumber && strings.Trim(p.ETag, `"`) == strings.Trim(part.ETag, `"`)
+		})
+		if i < 0 {
+			return 0, false
+		}
+		size += uploaded[i].Size
+	}
+	return size, true
+}
+
+// @Summary CreateUpload
+// @Security JWT
+// @Tags Upload
+// @Description Starts a resumable upload of a file, uploaded in parts of up to maxPartSize, all but the last of at least minPartSize
+// @Accept json
+// @Produce json
+// @Param	payload 	body 		dto.CreateUpload true "file"
+// @Success 201 		{object} 	dto.UploadCreated
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 401 		{string} 	ErrorResponse "Unauthorized"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/uploads [POST]
+func (c *UploadHandler) CreateUpload(ctx *gin.Context) {
+	var req dto.CreateUpload
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	uploadId, err := common.GenerateRandomString(32)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	upload := models.Upload{
+		UploadId:       uploadId,
+		UserId:         claims.UserId,
+		OrganizationId: claims.OrganizationId,
+		ObjectPath:     storage.GetPrivatePath(storage.Uploads, fmt.Sprintf("%d/%s", claims.UserId, uploadId)),
+		Purpose:        models.FilePurpose,
+		FileName:       req.FileName,
+		ContentType:    req.ContentType,
+		ExpiresAt:      time.Now().Add(constants.ResumableUploadExpiry),
+	}
+	multipartId, err := c.objService.CreateMultipartUpload(ctx, constants.S3Bucket, upload.ObjectPath, models.ObjectOptions{
+		ContentType: req.ContentType,
+		Metadata:    map[string]string{"file-name": req.FileName},
+	})
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+	upload.MultipartId = &multipartId
+
+	err = c.uploadService.CreateUpload(ctx, upload)
+	if err != nil {
+		// an upload that is not recorded would never expire, so it is aborted now
+		err = errors.Join(err, c.objService.AbortMultipartUpload(ctx, constants.S3Bucket, upload.ObjectPath, multipartId))
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, dto.UploadCreated{
+		UploadId:    uploadId,
+		MinPartSize: constants.MinUploadPartSize,
+		MaxPartSize: constants.MaxUploadPartSize,
+	})
+}
+
+// @Summary GetUpload
+// @Security JWT
+// @Tags Upload
+// @Description Gets an upload of the User, with the parts uploaded so far to resume it, or the URL of the file once completed
+// @Produce json
+// @Param	uploadId 	path 		string true "Upload Id"
+// @Success 200 		{object} 	dto.UploadStatus
+// @Failure 401 		{string} 	ErrorResponse "Unauthorized"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/uploads/{uploadId} [GET]
+func (c *UploadHandler) GetUpload(ctx *gin.Context) {
+	upload, ok := c.getUpload(ctx)
+	if !ok {
+		return
+	}
+
+	status, err := c.uploadStatus(ctx, upload)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, status)
+}
+
+// @Summary UploadPart
+// @Security JWT
+// @Tags Upload
+// @Description Uploads a part of an upload in progress, the body is its content, verified by its checksum if given. A part uploaded again is replaced
+// @Accept octet-stream
+// @Produce json
+// @Param	uploadId 			path 		string true "Upload Id"
+// @Param	partNumber 			path 		int true "Part number, 1 to 10000"
+// @Param	X-Checksum-Sha256 	header 		string false "hex sha256 of the part"
+// @Success 200 				{object} 	models.ObjectPart
+// @Failure 400 				{string} 	ErrorResponse "Bad Request"
+// @Failure 401 				{string} 	ErrorResponse "Unauthorized"
+// @Failure 404 				{string} 	ErrorResponse "Not Found"
+// @Failure 409 				{string} 	ErrorResponse "Conflict"
+// @Failure 411 				{string} 	ErrorResponse "Length Required"
+// @Failure 413 				{string} 	ErrorResponse "Request Entity Too Large"
+// @Failure 502 				{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/uploads/{uploadId}/parts/{partNumber} [PUT]
+func (c *UploadHandler) UploadPart(ctx *gin.Context) {
+	partNumber, err := strconv.Atoi(ctx.Param("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > 10000 {
+		ctx.String(http.StatusBadRequest, "BadRequest")
+		return
+	}
+
+	checksum, ok := checksumHeader(ctx)
+	if !ok {
+		ctx.String(http.StatusBadRequest, "BadRequest")
+		return
+	}
+
+	// the storage needs the size of a part upfront
+	if ctx.Request.ContentLength < 0 {
+		ctx.String(http.StatusLengthRequired, "LengthRequired")
+		return
+	}
+
+	upload, ok := c.getUpload(ctx)
+	if !ok {
+		return
+	}
+	if upload.CompletedAt != nil || upload.MultipartId == nil {
+		ctx.String(http.StatusConflict, "Conflict")
+		return
+	}
+
+	part, err := c.objService.UploadPart(ctx, constants.S3Bucket, upload.ObjectPath, *upload.MultipartId, partNumber, ctx.Request.ContentLength, ctx.Request.Body, checksum)
+	if errors.Is(err, constants.ErrChecksumMismatch) {
+		ctx.String(http.StatusBadRequest, "ChecksumMismatch")
+		return
+	}
+	if errors.Is(err, constants.ErrUploadNotFound) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, part)
+}
+
+// @Summary CompleteUpload
+// @Security JWT
+// @Tags Upload
+// @Description Completes an upload with its parts in ascending order of number, creating the file, which counts in the storage of the Organization. Uploads left incomplete are deleted once expired
+// @Accept json
+// @Produce json
+// @Param	uploadId 	path 		string true "Upload Id"
+// @Param	payload 	body 		dto.CompleteUpload true "parts"
+// @Success 200 		{object} 	dto.UploadStatus
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 401 		{string} 	ErrorResponse "Unauthorized"
+// @Failure 402 		{string} 	ErrorResponse "Storage Limit Exceeded"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 409 		{string} 	ErrorResponse "Conflict"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/uploads/{uploadId}/complete [POST]
+func (c *UploadHandler) CompleteUpload(ctx *gin.Context) {
+	var req dto.CompleteUpload
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	upload, ok := c.getUpload(ctx)
+	if !ok {
+		return
+	}
+	if upload.CompletedAt != nil || upload.MultipartId == nil {
+		ctx.String(http.StatusConflict, "Conflict")
+		return
+	}
+
+	uploaded, err := c.objService.ListParts(ctx, constants.S3Bucket, upload.ObjectPath, *upload.MultipartId)
+	if errors.Is(err, constants.ErrUploadNotFound) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	size, ok := partsSize(uploaded, req.Parts)
+	if !ok {
+		ctx.String(http.StatusBadRequest, "InvalidParts")
+		return
+	}
+
+	// reserved before completing, so concurrent uploads cannot exceed the limit together
+	if upload.OrganizationId != nil {
+		err = c.entitlementService.ReserveStorage(ctx, *upload.OrganizationId, size)
+		if errors.Is(err, constants.ErrLimitExceeded) {
+			ctx.String(http.StatusPaymentRequired, "StorageLimitExceeded")
+			return
+		}
+		if err != nil {
+			slog.Error(err.Error())
+			ctx.String(http.StatusBadGateway, "BadGateway")
+			return
+		}
+	}
+
+	err = c.objService.CompleteMultipartUpload(ctx, constants.S3Bucket, upload.ObjectPath, *upload.MultipartId, req.Parts)
+	if err != nil {
+		if releaseErr := c.releaseStorage(ctx, upload, size); releaseErr != nil {
+			slog.Error(errors.Join(err, releaseErr).Error())
+			ctx.String(http.StatusBadGateway, "BadGateway")
+			return
+		}
+	}
+	if errors.Is(err, constants.ErrInvalidParts) {
+		ctx.String(http.StatusBadRequest, "InvalidParts")
+		return
+	}
+	if errors.Is(err, constants.ErrUploadNotFound) {
+		ctx.String(http.StatusNotFound, "NotFound")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	err = c.uploadService.CompleteUpload(ctx, upload.UploadId, size)
+	if errors.Is(err, constants.ErrNoRows) { // completed concurrently, which recorded the object
+		err = c.releaseStorage(ctx, upload, size)
+		if err != nil {
+			slog.Error(err.Error())
+			ctx.String(http.StatusBadGateway, "BadGateway")
+			return
+		}
+		ctx.String(http.StatusConflict, "Conflict")
+		return
+	}
+	if err != nil {
+		// an object that is not recorded would never be counted nor deleted, so it is deleted now
+		err = errors.Join(err, c.objService.Delete(ctx, constants.S3Bucket, upload.ObjectPath), c.releaseStorage(ctx, upload, size))
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	completedAt := time.Now()
+	upload.Size = &size
+	upload.CompletedAt = &completedAt
+	status, err := c.uploadStatus(ctx, upload)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, status)
+}
+
+// @Summary DeleteUpload
+// @Security JWT
+// @Tags Upload
+// @Description Aborts an upload in progress, or deletes the file of a completed one
+// @Produce plain
+// @Param	uploadId 	path 		string true "Upload Id"
+// @Success 200 		{string} 	OKResponse "OK"
+// @Failure 401 		{string} 	ErrorResponse "Unauthorized"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/uploads/{uploadId} [DELETE]
+func (c *UploadHandler) DeleteUpload(ctx *gin.Context) {
+	upload, ok := c.getUpload(ctx)
+	if !ok {
+		return
+	}
+
+	err := c.removeUpload(ctx, upload)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.String(http.StatusOK, "OK")
+}
+
+// @Summary CreateDirectUpload
+// @Security JWT
+// @Tags Upload
+// @Description Starts a direct upload of a file of a declared size, content type and purpose, which is PUT to the presigned URL with the given headers, then confirmed
+// @Accept json
+// @Produce json
+// @Param	payload 	body 		dto.CreateDirectUpload true "file"
+// @Success 201 		{object} 	dto.DirectUploadCreated
+// @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 401 		{string} 	ErrorResponse "Unauthorized"
+// @Failure 402 		{string} 	ErrorResponse "Storage Limit Exceeded"
+// @Failure 413 		{string} 	ErrorResponse "File Too Large"
+// @Failure 415 		{string} 	ErrorResponse "Unsupported Media Type"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/uploads/direct [POST]
+func (c *UploadHandler) CreateDirectUpload(ctx *gin.Context) {
+	var req dto.CreateDirectUpload
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	contentType, _, err := mime.ParseMediaType(req.ContentType)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "BadRequest")
+		return
+	}
+
+	rule := uploadRules[req.Purpose]
+	if len(rule.contentTypes) > 0 && !slices.Contains(rule.contentTypes, contentType) {
+		ctx.String(http.StatusUnsupportedMediaType, "UnsupportedMediaType")
+		return
+	}
+	if req.Size > rule.maxSize {
+		ctx.String(http.StatusRequestEntityTooLarge, "FileTooLarge")
+		return
+	}
+
+	claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	// checked again once confirmed, this only spares uploading what would not fit
+	if claims.OrganizationId != nil {
+		err = c.entitlementService.CheckLimit(ctx, *claims.OrganizationId, models.StorageBytesLimit, req.Size)
+		if errors.Is(err, constants.ErrLimitExceeded) {
+			ctx.String(http.StatusPaymentRequired, "StorageLimitExceeded")
+			return
+		}
+		if err != nil {
+			slog.Error(err.Error())
+			ctx.String(http.StatusBadGateway, "BadGateway")
+			return
+		}
+	}
+
+	uploadId, err := common.GenerateRandomString(32)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	upload := models.Upload{
+		UploadId:       uploadId,
+		UserId:         claims.UserId,
+		OrganizationId: claims.OrganizationId,
+		ObjectPath:     storage.GetPrivatePath(storage.Uploads, fmt.Sprintf("%d/%s", claims.UserId, uploadId)),
+		Purpose:        req.Purpose,
+		FileName:       req.FileName,
+		ContentType:    contentType,
+		DeclaredSize:   &req.Size,
+		ExpiresAt:      time.Now().Add(constants.DirectUploadExpiry),
+	}
+
+	url, err := c.objService.UploadUrl(ctx, constants.S3Bucket, directUploadPath(upload), req.Size, contentType, constants.UploadUrlTimeout)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	err = c.uploadService.CreateUpload(ctx, upload)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, dto.DirectUploadCreated{
+		UploadId:  uploadId,
+		Url:       url,
+		Headers:   map[string]string{"Content-Type": contentType},
+		ExpiresAt: time.Now().Add(constants.UploadUrlTimeout),
+	})
+}
+
+// @Summary ConfirmDirectUpload
+// @Security JWT
+// @Tags Upload
+// @Description Confirms a direct upload once its file is uploaded, checking its size, content type and magic bytes against those declared. A file that does not match is deleted, and may be uploaded again while the URL is valid
+// @Produce json
+// @Param	uploadId 	path 		string true "Upload Id"
+// @Success 200 		{object} 	dto.UploadStatus
+// @Failure 401 		{string} 	ErrorResponse "Unauthorized"
+// @Failure 402 		{string} 	ErrorResponse "Storage Limit Exceeded"
+// @Failure 404 		{string} 	ErrorResponse "Not Found"
+// @Failure 409 		{string} 	ErrorResponse "Conflict"
+// @Failure 422 		{string} 	ErrorResponse "File Mismatch"
+// @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
+// @Router /v1/uploads/{uploadId}/confirm [POST]
+func (c *UploadHandler) ConfirmDirectUpload(ctx *gin.Context) {
+	upload, ok := c.getUpload(ctx)
+	if !ok {
+		return
+	}
+	if upload.CompletedAt != nil || upload.MultipartId != nil {
+		ctx.String(http.StatusConflict, "Conflict")
+		return
+	}
+
+	// the file is checked once moved out of the reach of the URL, a confirmation that failed
+	// after moving it left it at the path of the upload
+	err := c.objService.Move(ctx, constants.S3Bucket, directUploadPath(upload), upload.ObjectPath)
+	if err != nil && !errors.Is(err, constants.ErrObjectNotFound) {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	info, err := c.objService.Stat(ctx, constants.S3Bucket, upload.ObjectPath)
+	if errors.Is(err, constants.ErrObjectNotFound) {
+		ctx.String(http.StatusConflict, "NotUploaded")
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	mismatch := ""
+	contentType, _, _ := mime.ParseMediaType(info.ContentType)
+	switch {
+	case info.Size != common.Deref(upload.DeclaredSize):
+		mismatch = "SizeMismatch"
+	case contentType != upload.ContentType:
+		mismatch = "ContentTypeMismatch"
+	case len(uploadRules[upload.Purpose].contentTypes) > 0:
+		// http.DetectContentType only looks at the first 512 bytes
+		r, err := c.objService.Download(ctx, constants.S3Bucket, upload.ObjectPath, &models.ByteRange{Start: 0, End: min(info.Size, 512) - 1})
+		if err != nil {
+			slog.Error(err.Error())
+			ctx.String(http.StatusBadGateway, "BadGateway")
+			return
+		}
+		head, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			slog.Error(err.Error())
+			ctx.String(http.StatusBadGateway, "BadGateway")
+			return
+		}
+		if sniffContentType(head, upload.ContentType) != upload.ContentType {
+			mismatch = "MagicBytesMismatch"
+		}
+	}
+	if mismatch != "" {
+		err = c.objService.Delete(ctx, constants.S3Bucket, upload.ObjectPath)
+		if err != nil {
+			slog.Error(err.Error())
+		}
+		ctx.String(http.StatusUnprocessableEntity, mismatch)
+		return
+	}
+
+	// reserved before recording, so concurrent uploads cannot exceed the limit together
+	if upload.OrganizationId != nil {
+		err = c.entitlementService.ReserveStorage(ctx, *upload.OrganizationId, info.Size)
+		if errors.Is(err, constants.ErrLimitExceeded) {
+			ctx.String(http.StatusPaymentRequired, "StorageLimitExceeded")
+			return
+		}
+		if err != nil {
+			slog.Error(err.Error())
+			ctx.String(http.StatusBadGateway, "BadGateway")
+			return
+		}
+	}
+
+	err = c.uploadService.CompleteUpload(ctx, upload.UploadId, info.Size)
+	if errors.Is(err, constants.ErrNoRows) { // confirmed concurrently, which recorded the object
+		err = c.releaseStorage(ctx, upload, info.Size)
+		if err != nil {
+			slog.Error(err.Error())
+			ctx.String(http.StatusBadGateway, "BadGateway")
+			return
+		}
+		ctx.String(http.StatusConflict, "Conflict")
+		return
+	}
+	if err != nil {
+		// an object that is not recorded would never be counted, so it is deleted now
+		err = errors.Join(err, c.objService.Delete(ctx, constants.S3Bucket, upload.ObjectPath), c.releaseStorage(ctx, upload, info.Size))
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	completedAt := time.Now()
+	upload.Size = &info.Size
+	upload.CompletedAt = &completedAt
+	status, err := c.uploadStatus(ctx, upload)
+	if err != nil {
+		slog.Error(err.Error())
+		ctx.String(http.StatusBadGateway, "BadGateway")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, status)
+}
+
+// DeleteExpiredUploads deletes the uploads left incomplete past their expiration, with
+// whatever was uploaded of them.
+func (c *UploadHandler) DeleteExpiredUploads() error {
+	ctx := context.Background()
+
+	expired, err := c.uploadService.GetExpiredUploads(ctx, 100)
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	for _, upload := range expired {
+		err := c.removeUpload(ctx, upload)
+		if err != nil {
+			errs = append(errs, errors.Join(err, fmt.Errorf("could not delete upload %s", upload.UploadId)))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *UploadHandler) RegisterRoutes(rg *gin.RouterGroup, authMiddleware middlewares.AuthMiddleware) {
+	g := rg.Group("/uploads")
+
+	g.POST("", authMiddleware.AuthorizeUser(), c.CreateUpload)
+	g.POST("/direct", authMiddleware.AuthorizeUser(), c.CreateDirectUpload)
+	g.GET("/:uploadId", authMiddleware.AuthorizeUser(), c.GetUpload)
+	g.PUT("/:uploadId/parts/:partNumber", authMiddleware.AuthorizeUser(), c.UploadPart)
+	g.POST("/:uploadId/complete", authMiddleware.AuthorizeUser(), c.CompleteUpload)
+	g.POST("/:uploadId/confirm", authMiddleware.AuthorizeUser(), c.ConfirmDirectUpload)
+	g.DELETE("/:uploadId", authMiddleware.AuthorizeUser(), c.DeleteUpload)
+}
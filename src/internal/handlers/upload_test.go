@@ -0,0 +1,378 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/dto"
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/services"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/storage"
+	"github.com/gin-gonic/gin"
+)
+
+func Test_partsSize(t *testing.T) {
+	uploaded := []models.ObjectPart{
+		{PartNumber: 1, ETag: "etag-1", Size: 5 * 1024 * 1024},
+		{PartNumber: 2, ETag: "etag-2", Size: 5 * 1024 * 1024},
+		{PartNumber: 3, ETag: "etag-3", Size: 1024},
+	}
+
+	tests := []struct {
+		name     string
+		parts    []models.ObjectPart
+		wantSize int64
+		wantOk   bool
+	}{
+		{
+			name:     "all parts",
+			parts:    []models.ObjectPart{{PartNumber: 1, ETag: "etag-1"}, {PartNumber: 2, ETag: "etag-2"}, {PartNumber: 3, ETag: "etag-3"}},
+			wantSize: 10*1024*1024 + 1024,
+			wantOk:   true,
+		},
+		{
+			name:     "some parts, with the sizes they were uploaded with",
+			parts:    []models.ObjectPart{{PartNumber: 1, ETag: `"etag-1"`, Size: 1}, {PartNumber: 3, ETag: "etag-3", Size: 1}},
+			wantSize: 5*1024*1024 + 1024,
+			wantOk:   true,
+		},
+		{
+			name:   "part not uploaded",
+			parts:  []models.ObjectPart{{PartNumber: 1, ETag: "etag-1"}, {PartNumber: 4, ETag: "etag-4"}},
+			wantOk: false,
+		},
+		{
+			name:   "other etag",
+			parts:  []models.ObjectPart{{PartNumber: 1, ETag: "etag-2"}},
+			wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size, ok := partsSize(uploaded, tt.parts)
+			if ok != tt.wantOk || size != tt.wantSize {
+				t.Errorf("partsSize() = %d, %v, want %d, %v", size, ok, tt.wantSize, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_sniffContentType(t *testing.T) {
+	pngHead := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	tests := []struct {
+		name     string
+		head     []byte
+		declared string
+		want     string
+	}{
+		{name: "pdf", head: []byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n"), declared: "application/pdf", want: "application/pdf"},
+		{name: "png", head: pngHead, declared: "image/png", want: "image/png"},
+		{name: "png declared as jpeg", head: pngHead, declared: "image/jpeg", want: "image/png"},
+		{name: "csv", head: []byte("name,email\njane,jane@email.com\n"), declared: "text/csv", want: "text/csv"},
+		{name: "plain text", head: []byte("some notes\n"), declared: "text/plain", want: "text/plain"},
+		{name: "text declared as pdf", head: []byte("name,email\n"), declared: "application/pdf", want: "text/plain"},
+		{name: "html declared as csv", head: []byte("<html><body>hi</body></html>"), declared: "text/csv", want: "text/html"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sniffContentType(tt.head, tt.declared); got != tt.want {
+				t.Errorf("sniffContentType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// memoryUploadService keeps the uploads in memory.
+type memoryUploadService struct {
+	mu      sync.Mutex
+	uploads map[string]models.Upload
+}
+
+func (s *memoryUploadService) CreateUpload(ctx context.Context, upload models.Upload) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload.CreatedAt = time.Now()
+	s.uploads[upload.UploadId] = upload
+	return nil
+}
+
+func (s *memoryUploadService) GetUpload(ctx context.Context, userId uint32, uploadId string) (models.Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[uploadId]
+	if !ok || upload.UserId != userId {
+		return models.Upload{}, constants.ErrNoRows
+	}
+	return upload, nil
+}
+
+func (s *memoryUploadService) CompleteUpload(ctx context.Context, uploadId string, size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[uploadId]
+	if !ok || upload.CompletedAt != nil {
+		return constants.ErrNoRows
+	}
+	now := time.Now()
+	upload.Size = &size
+	upload.CompletedAt = &now
+	s.uploads[uploadId] = upload
+	return nil
+}
+
+func (s *memoryUploadService) GetExpiredUploads(ctx context.Context, limit int) ([]models.Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expired := []models.Upload{}
+	for _, upload := range s.uploads {
+		if upload.CompletedAt == nil && upload.ExpiresAt.Before(time.Now()) && len(expired) < limit {
+			expired = append(expired, upload)
+		}
+	}
+	return expired, nil
+}
+
+func (s *memoryUploadService) DeleteUpload(ctx context.Context, uploadId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.uploads[uploadId]; !ok {
+		return constants.ErrNoRows
+	}
+	delete(s.uploads, uploadId)
+	return nil
+}
+
+// quotaEntitlementService grants a storage limit to every organization.
+type quotaEntitlementService struct {
+	services.EntitlementService
+	mu    sync.Mutex
+	limit int64
+	usage int64
+}
+
+func (s *quotaEntitlementService) CheckLimit(ctx context.Context, orgId string, limit models.Limit, delta int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.usage+delta > s.limit {
+		return constants.ErrLimitExceeded
+	}
+	return nil
+}
+
+func (s *quotaEntitlementService) AddStorageUsage(ctx context.Context, orgId string, delta int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.usage = max(s.usage+delta, 0)
+	return nil
+}
+
+func (s *quotaEntitlementService) ReserveStorage(ctx context.Context, orgId string, delta int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.usage+delta > s.limit {
+		return constants.ErrLimitExceeded
+	}
+	s.usage += delta
+	return nil
+}
+
+func TestUploadHandler_DirectUploads(t *testing.T) {
+	ctx := context.Background()
+
+	signer := storage.NewUrlSigner("http://localhost/v1/objects", []byte("object-key"))
+	objService := services.NewObjectServiceMemoryImpl(signer)
+	uploadService := &memoryUploadService{uploads: map[string]models.Upload{}}
+	entitlementService := &quotaEntitlementService{limit: 1024}
+	handler := NewUploadHandler(uploadService, objService, entitlementService)
+	objectHandler := NewObjectHandler(objService, signer)
+
+	orgId := "ORG01"
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	v1 := router.Group("/v1")
+	objectHandler.RegisterRoutes(v1, nil)
+	uploads := v1.Group("/uploads", func(ctx *gin.Context) {
+		ctx.Set(constants.GinCtxJwtClaimKeyName, models.JwtClaims{UserId: 1, OrganizationId: &orgId})
+	})
+	uploads.POST("/direct", handler.CreateDirectUpload)
+	uploads.POST("/:uploadId/confirm", handler.ConfirmDirectUpload)
+
+	do := func(method string, target string, contentType string, body []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	// create starts a direct upload, returning it and its upload URL
+	create := func(contentType string, size int, purpose models.UploadPurpose) (models.Upload, string) {
+		t.Helper()
+		body, _ := json.Marshal(dto.CreateDirectUpload{FileName: "file", ContentType: contentType, Size: int64(size), Purpose: purpose})
+		w := do(http.MethodPost, "/v1/uploads/direct", "application/json", body)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("CreateDirectUpload() = %d %s", w.Code, w.Body.String())
+		}
+		var created dto.DirectUploadCreated
+		if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+			t.Fatal(err)
+		}
+		upload, err := uploadService.GetUpload(ctx, 1, created.UploadId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := url.Parse(created.Url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return upload, u.RequestURI()
+	}
+	confirm := func(upload models.Upload) *httptest.ResponseRecorder {
+		t.Helper()
+		return do(http.MethodPost, "/v1/uploads/"+upload.UploadId+"/confirm", "", nil)
+	}
+	stored := func(upload models.Upload) bool {
+		t.Helper()
+		_, err := objService.Stat(ctx, constants.S3Bucket, upload.ObjectPath)
+		if err != nil && !errors.Is(err, constants.ErrObjectNotFound) {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+
+	pdf := []byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n1 0 obj\n<<>>\nendobj\n")
+
+	upload, uploadUrl := create("application/pdf", len(pdf), models.DocumentPurpose)
+	if w := confirm(upload); w.Code != http.StatusConflict || w.Body.String() != "NotUploaded" {
+		t.Errorf("confirming before uploading should conflict, got %d %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPut, uploadUrl, "application/pdf", pdf); w.Code != http.StatusOK {
+		t.Fatalf("uploading to the url should succeed, got %d %s", w.Code, w.Body.String())
+	}
+	w := confirm(upload)
+	if w.Code != http.StatusOK || entitlementService.usage != int64(len(pdf)) {
+		t.Fatalf("ConfirmDirectUpload() = %d %s, usage %d", w.Code, w.Body.String(), entitlementService.usage)
+	}
+	if w := confirm(upload); w.Code != http.StatusConflict || entitlementService.usage != int64(len(pdf)) {
+		t.Errorf("confirming twice should conflict without counting it again, got %d, usage %d", w.Code, entitlementService.usage)
+	}
+
+	// the url is still valid once confirmed, but cannot replace the confirmed file
+	unchecked := bytes.Repeat([]byte("a"), len(pdf))
+	if w := do(http.MethodPut, uploadUrl, "application/pdf", unchecked); w.Code != http.StatusOK {
+		t.Fatalf("uploading to the url again should succeed, got %d", w.Code)
+	}
+	r, err := objService.Download(ctx, constants.S3Bucket, upload.ObjectPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(content, pdf) {
+		t.Errorf("the confirmed file should be kept, got %q, %v", content, err)
+	}
+
+	// files that do not match what was declared are deleted, and can be uploaded again
+	for name, tt := range map[string]struct {
+		contentType string
+		body        []byte
+		want        string
+	}{
+		"size":  {contentType: "application/pdf", body: append(pdf, '\n'), want: "SizeMismatch"},
+		"type":  {contentType: "text/plain", body: pdf, want: "ContentTypeMismatch"},
+		"magic": {contentType: "application/pdf", body: bytes.Repeat([]byte("a"), len(pdf)), want: "MagicBytesMismatch"},
+	} {
+		upload, _ := create("application/pdf", len(pdf), models.DocumentPurpose)
+		// the signed url only takes what was declared, the storage of presigned urls may not check it
+		err := objService.Upload(ctx, constants.S3Bucket, directUploadPath(upload), int64(len(tt.body)), bytes.NewReader(tt.body), models.ObjectOptions{ContentType: tt.contentType})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w := confirm(upload); w.Code != http.StatusUnprocessableEntity || w.Body.String() != tt.want {
+			t.Errorf("%s: expected %s, got %d %s", name, tt.want, w.Code, w.Body.String())
+		}
+		if stored(upload) {
+			t.Errorf("%s: the mismatched file should be deleted", name)
+		}
+	}
+	if entitlementService.usage != int64(len(pdf)) {
+		t.Errorf("mismatched files should not count, usage %d", entitlementService.usage)
+	}
+
+	// files past the limit are kept unconfirmed until there is room for them
+	upload, uploadUrl = create("application/pdf", len(pdf), models.DocumentPurpose)
+	if w := do(http.MethodPut, uploadUrl, "application/pdf", pdf); w.Code != http.StatusOK {
+		t.Fatalf("uploading to the url should succeed, got %d", w.Code)
+	}
+	entitlementService.limit = int64(len(pdf)) + 10
+	if w := confirm(upload); w.Code != http.StatusPaymentRequired || !stored(upload) || entitlementService.usage != int64(len(pdf)) {
+		t.Errorf("confirming past the limit should require payment, got %d, usage %d", w.Code, entitlementService.usage)
+	}
+	entitlementService.limit = 1024
+	if w := confirm(upload); w.Code != http.StatusOK || entitlementService.usage != 2*int64(len(pdf)) {
+		t.Errorf("confirming once there is room should succeed, got %d, usage %d", w.Code, entitlementService.usage)
+	}
+}
+
+func TestUploadHandler_DeleteExpiredUploads(t *testing.T) {
+	ctx := context.Background()
+
+	objService := services.NewObjectServiceMemoryImpl(storage.NewUrlSigner("http://localhost/v1/objects", []byte("object-key")))
+	uploadService := &memoryUploadService{uploads: map[string]models.Upload{}}
+	handler := NewUploadHandler(uploadService, objService, &quotaEntitlementService{limit: 1024})
+
+	multipartId, err := objService.CreateMultipartUpload(ctx, constants.S3Bucket, "private/uploads/1/resumable", models.ObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := objService.Upload(ctx, constants.S3Bucket, "private/uploads/1/direct.incoming", 1, strings.NewReader("x"), models.ObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := objService.Upload(ctx, constants.S3Bucket, "private/uploads/1/pending", 1, strings.NewReader("x"), models.ObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	size := int64(1)
+	for _, upload := range []models.Upload{
+		{UploadId: "resumable", UserId: 1, ObjectPath: "private/uploads/1/resumable", MultipartId: &multipartId, ExpiresAt: time.Now().Add(-time.Minute)},
+		{UploadId: "direct", UserId: 1, ObjectPath: "private/uploads/1/direct", DeclaredSize: &size, ExpiresAt: time.Now().Add(-time.Minute)},
+		{UploadId: "pending", UserId: 1, ObjectPath: "private/uploads/1/pending", DeclaredSize: &size, ExpiresAt: time.Now().Add(time.Hour)},
+	} {
+		if err := uploadService.CreateUpload(ctx, upload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := handler.DeleteExpiredUploads(); err != nil {
+		t.Fatalf("UploadHandler.DeleteExpiredUploads() error = %v", err)
+	}
+
+	if _, err := objService.ListParts(ctx, constants.S3Bucket, "private/uploads/1/resumable", multipartId); !errors.Is(err, constants.ErrUploadNotFound) {
+		t.Errorf("the expired upload in parts should be aborted, got %v", err)
+	}
+	if _, err := objService.Stat(ctx, constants.S3Bucket, "private/uploads/1/direct.incoming"); !errors.Is(err, constants.ErrObjectNotFound) {
+		t.Errorf("the file of the expired direct upload should be deleted, got %v", err)
+	}
+	if _, err := objService.Stat(ctx, constants.S3Bucket, "private/uploads/1/pending"); err != nil {
+		t.Errorf("the file of the pending upload should be kept, got %v", err)
+	}
+	if len(uploadService.uploads) != 1 || uploadService.uploads["pending"].UploadId == "" {
+		t.Errorf("only the pending upload should be left, got %+v", uploadService.uploads)
+	}
+
+	// uploads already discarded by the storage are deleted all the same
+	if err := handler.DeleteExpiredUploads(); err != nil {
+		t.Errorf("UploadHandler.DeleteExpiredUploads() error = %v", err)
+	}
+}
@@ -2,12 +2,18 @@ package handlers
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"image"
+	"io"
 	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/LombardiDaniel/goliath/src/internal/dto"
 	"github.com/LombardiDaniel/goliath/src/internal/middlewares"
@@ -18,13 +24,15 @@ import (
 	"github.com/LombardiDaniel/goliath/src/pkg/storage"
 	"github.com/LombardiDaniel/goliath/src/pkg/token"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 type UserHandler struct {
-	authService  services.AuthService
-	userService  services.UserService
-	emailService services.EmailService
-	objService   services.ObjectService
+	authService        services.AuthService
+	userService        services.UserService
+	emailService       services.EmailService
+	objService         services.ObjectService
+	entitlementService services.EntitlementService
 }
 
 func NewUserHandler(
@@ -32,12 +40,14 @@ func NewUserHandler(
 	userService services.UserService,
 	emailService services.EmailService,
 	objService services.ObjectService,
+	entitlementService services.EntitlementService,
 ) UserHandler {
 	return UserHandler{
-		authService:  authService,
-		userService:  userService,
-		emailService: emailService,
-		objService:   objService,
+		authService:        authService,
+		userService:        userService,
+		emailService:       emailService,
+		objService:         objService,
+		entitlementService: entitlementService,
 	}
 }
 
@@ -62,7 +72,14 @@ func (c *UserHandler) CreateUser(ctx *gin.Context) {
 		return
 	}
 
-	unconfirmedUser, err := models.NewUnconfirmedUser(createUser.Email, createUser.Password, createUser.FirstName, createUser.LastName, createUser.DateOfBirth)
+	// clients that negotiated a locale but did not pick one get it
+	locale := createUser.Locale
+	if locale == nil && middlewares.GetLocale(ctx) != "" {
+		l := middlewares.GetLocale(ctx)
+		locale = &l
+	}
+
+	unconfirmedUser, err := models.NewUnconfirmedUser(createUser.Email, createUser.Password, createUser.FirstName, createUser.LastName, createUser.DateOfBirth, locale)
 	if err != nil {
 		slog.Error(fmt.Sprintf("Error while generating unconfirmed user '%s': '%s'", createUser.Email, err.Error()))
 		ctx.String(http.StatusBadRequest, "BadRequest")
@@ -80,13 +97,6 @@ func (c *UserHandler) CreateUser(ctx *gin.Context) {
 		return
 	}
 
-	err = c.emailService.SendEmailConfirmation(unconfirmedUser.Email, unconfirmedUser.FirstName, unconfirmedUser.Otp)
-	if err != nil {
-		slog.Error(fmt.Sprintf("Error while sending email '%s': '%s'", unconfirmedUser.Email, err.Error()))
-		ctx.String(http.StatusBadGateway, "BadGateway")
-		return
-	}
-
 	ctx.String(http.StatusOK, "OK")
 }
 
@@ -184,13 +194,6 @@ func (c *UserHandler) InitResetPassword(ctx *gin.Context) {
 		return
 	}
 
-	err = c.emailService.SendPasswordReset(email.Email, user.FirstName, otp)
-	if err != nil {
-		slog.Error(err.Error())
-		ctx.String(http.StatusBadRequest, err.Error())
-		return
-	}
-
 	ctx.String(http.StatusOK, "OK")
 }
 
@@ -309,80 +312,211 @@ func (c *UserHandler) EditUser(ctx *gin.Context) {
 	ctx.String(http.StatusOK, "OK")
 }
 
+// readPicture reads the picture of a SetPicture request: base64 in a json body, the picture
+// file of a multipart form or the raw body, up to one byte past constants.MaxAvatarSize.
+func readPicture(ctx *gin.Context) ([]byte, error) {
+	limit := constants.MaxAvatarSize + 1
+
+	switch ct := ctx.ContentType(); {
+	case ct == binding.MIMEJSON:
+		var pic dto.UloadPicture
+		if err := ctx.ShouldBindJSON(&pic); err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(pic.Content)
+	case ct == binding.MIMEMultipartPOSTForm:
+		fh, err := ctx.FormFile("picture")
+		if err != nil {
+			return nil, err
+		}
+		f, err := fh.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(io.LimitReader(f, limit))
+	case strings.HasPrefix(ct, "image/"):
+		return io.ReadAll(io.LimitReader(ctx.Request.Body, limit))
+	default:
+		return nil, fmt.Errorf("unsupported picture content type '%s'", ct)
+	}
+}
+
+// avatarVariant is a size of an avatar, encoded in a format.
+type avatarVariant struct {
+	dto.AvatarVariant
+	path string
+	data []byte
+}
+
+// avatarPrefix is the prefix of the keys of all avatars of a user.
+func avatarPrefix(userId uint32) string {
+	return storage.GetPublicPath(storage.UserAvatars, fmt.Sprintf("%d-", userId))
+}
+
+// encodeAvatar crops the center of a picture and scales it to each of constants.AvatarSizes, in
+// JPEG, or in PNG if it has transparent pixels. The keys are named after the hash of the
+// picture, so their URLs change with it and can be cached for good.
+func encodeAvatar(userId uint32, picBytes []byte, img image.Image) ([]avatarVariant, error) {
+	sum := sha256.Sum256(picBytes)
+	hash := hex.EncodeToString(sum[:8])
+
+	// the sizes are scaled down from the largest, not all from the picture
+	sizes := slices.Sorted(slices.Values(constants.AvatarSizes))
+	img = common.Thumbnail(img, sizes[len(sizes)-1])
+
+	format := common.JPEG
+	if o, ok := img.(interface{ Opaque() bool }); !ok || !o.Opaque() {
+		format = common.PNG
+	}
+
+	variants := []avatarVariant{}
+	for _, size := range slices.Backward(sizes) {
+		var buf bytes.Buffer
+		err := common.EncodeImage(&buf, common.Thumbnail(img, size), format)
+		if err != nil {
+			return nil, err
+		}
+
+		path := fmt.Sprintf("%s%s-%d.%s", avatarPrefix(userId), hash, size, format)
+		url, err := storage.GetFullObjUrl(path)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, avatarVariant{
+			AvatarVariant: dto.AvatarVariant{Size: size, Format: string(format), Url: url},
+			path:          path,
+			data:          buf.Bytes(),
+		})
+	}
+	return variants, nil
+}
+
+// deleteOldAvatars deletes the avatars of a user but those of the paths, and the one kept
+// as-is before avatars were processed.
+func (c *UserHandler) deleteOldAvatars(ctx *gin.Context, userId uint32, keep []string) error {
+	old := []string{storage.GetPublicPath(storage.UserAvatars, strconv.Itoa(int(userId)))}
+
+	after := ""
+	for {
+		objs, next, err := c.objService.List(ctx, constants.S3Bucket, avatarPrefix(userId), after, 1000)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objs {
+			if !slices.Contains(keep, obj.Path) {
+				old = append(old, obj.Path)
+			}
+		}
+		if next == "" {
+			break
+		}
+		after = next
+	}
+
+	return c.objService.DeleteMany(ctx, constants.S3Bucket, old)
+}
+
 // @Summary SetPicture
 // @Tags User
-// @Description Sets User Picture
-// @Consume application/json
-// @Accept json
-// @Produce plain
-// @Param   payload 	body 		dto.UloadPicture true "picture json"
-// @Success 200 		{string} 	OKResponse "OK"
+// @Description Sets User Picture from a JPEG, PNG, GIF or WebP, sent as base64 json, a multipart form or the raw image. It is cropped to a square and scaled to several sizes, in JPEG, or in PNG if it has transparent pixels
+// @Accept json,mpfd,jpeg,png,gif,webp
+// @Produce json
+// @Param   payload 	body 		dto.UloadPicture false "picture json"
+// @Param   picture 	formData 	file false "picture file"
+// @Success 200 		{object} 	dto.Avatar
 // @Failure 400 		{string} 	ErrorResponse "Bad Request"
+// @Failure 402 		{string} 	ErrorResponse "Storage Limit Exceeded"
 // @Failure 409 		{string} 	ErrorResponse "Conflict"
+// @Failure 413 		{string} 	ErrorResponse "Image Too Large"
+// @Failure 415 		{string} 	ErrorResponse "Unsupported Media Type"
 // @Failure 502 		{string} 	ErrorResponse "Bad Gateway"
 // @Router /v1/users/profile-picture [POST]
 func (c *UserHandler) SetPicture(ctx *gin.Context) {
-	var pic dto.UloadPicture
-
-	if err := ctx.ShouldBind(&pic); err != nil {
-		slog.Error(err.Error())
-		ctx.String(http.StatusBadRequest, err.Error())
-		return
-	}
-
 	claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](ctx)
 	if err != nil {
 		ctx.String(http.StatusBadGateway, "BadGateway")
 		return
 	}
 
-	picBytes, err := base64.StdEncoding.DecodeString(pic.Content)
+	picBytes, err := readPicture(ctx)
 	if err != nil {
 		slog.Error(err.Error())
 		ctx.String(http.StatusBadRequest, err.Error())
 		return
 	}
 
-	if len(picBytes) > 100*1024 { // if > 100k
-		ctx.String(http.StatusBadGateway, "ImgTooLarge")
+	if int64(len(picBytes)) > constants.MaxAvatarSize {
+		ctx.String(http.StatusRequestEntityTooLarge, "ImgTooLarge")
 		return
 	}
 
-	imgFmt, err := common.ImageFormat(picBytes)
+	img, _, err := common.DecodeImage(picBytes, constants.MaxAvatarPixels)
 	if err != nil {
 		slog.Error(err.Error())
-		ctx.String(http.StatusBadGateway, "BadGateway")
-		return
-	}
-
-	if imgFmt != common.JPEG && imgFmt != common.PNG {
 		ctx.String(http.StatusUnsupportedMediaType, "UnsupportedMediaType")
 		return
 	}
 
-	objPath := storage.GetPublicPath(storage.UserAvatars, strconv.Itoa(int(claims.UserId)))
-	err = c.objService.Upload(ctx, constants.S3Bucket, objPath, int64(len(picBytes)), bytes.NewReader(picBytes))
+	variants, err := encodeAvatar(claims.UserId, picBytes, img)
 	if err != nil {
 		slog.Error(err.Error())
 		ctx.String(http.StatusBadGateway, "BadGateway")
 		return
 	}
 
-	objUrl, err := storage.GetFullObjUrl(objPath)
+	// avatars replace the previous ones, so they are checked against
+	// the organization's storage but never added to its usage
+	if claims.OrganizationId != nil {
+		var size int64
+		for _, v := range variants {
+			size += int64(len(v.data))
+		}
+		err = c.entitlementService.CheckLimit(ctx, *claims.OrganizationId, models.StorageBytesLimit, size)
+		if err != nil {
+			if errors.Is(err, constants.ErrLimitExceeded) {
+				ctx.String(http.StatusPaymentRequired, "StorageLimitExceeded")
+				return
+			}
+			slog.Error(err.Error())
+			ctx.String(http.StatusBadGateway, "BadGateway")
+			return
+		}
+	}
+
+	avatar := dto.Avatar{Variants: []dto.AvatarVariant{}}
+	paths := []string{}
+	for _, v := range variants {
+		err = c.objService.Upload(ctx, constants.S3Bucket, v.path, int64(len(v.data)), bytes.NewReader(v.data), models.ObjectOptions{
+			ContentType: common.ImageFmt(v.Format).ContentType(),
+		})
+		if err != nil {
+			slog.Error(err.Error())
+			ctx.String(http.StatusBadGateway, "BadGateway")
+			return
+		}
+
+		avatar.Variants = append(avatar.Variants, v.AvatarVariant)
+		paths = append(paths, v.path)
+		if v.Size == constants.DefaultAvatarSize {
+			avatar.Url = v.Url
+		}
+	}
+
+	err = c.userService.SetAvatarUrl(ctx, claims.UserId, avatar.Url)
 	if err != nil {
 		slog.Error(err.Error())
 		ctx.String(http.StatusBadGateway, "BadGateway")
 		return
 	}
 
-	err = c.userService.SetAvatarUrl(ctx, claims.UserId, objUrl)
+	// the new avatar is set, old ones left behind are only wasted space
+	err = c.deleteOldAvatars(ctx, claims.UserId, paths)
 	if err != nil {
 		slog.Error(err.Error())
-		ctx.String(http.StatusBadGateway, "BadGateway")
-		return
 	}
 
-	ctx.String(http.StatusOK, "OK")
+	ctx.JSON(http.StatusOK, avatar)
 }
 
 func (c *UserHandler) RegisterRoutes(rg *gin.RouterGroup, authMiddleware middlewares.AuthMiddleware) {
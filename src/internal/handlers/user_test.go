@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/LombardiDaniel/goliath/src/internal/dto"
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/services"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/storage"
+	"github.com/gin-gonic/gin"
+)
+
+type avatarUserService struct {
+	services.UserService
+	avatarUrl string
+}
+
+func (s *avatarUserService) SetAvatarUrl(ctx context.Context, userId uint32, url string) error {
+	s.avatarUrl = url
+	return nil
+}
+
+// picture is a w by h PNG, transparent or not.
+func picture(t *testing.T, w int, h int, transparent bool) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			c := color.NRGBA{R: 255, A: 255}
+			if transparent && x < w/2 {
+				c.A = 0
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUserHandler_SetPicture(t *testing.T) {
+	ctx := context.Background()
+
+	objService := services.NewObjectServiceMemoryImpl(storage.NewUrlSigner("http://localhost/v1/objects", []byte("object-key")))
+	userService := &avatarUserService{}
+	handler := NewUserHandler(nil, userService, nil, objService, nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/users/profile-picture", func(ctx *gin.Context) {
+		ctx.Set(constants.GinCtxJwtClaimKeyName, models.JwtClaims{UserId: 1})
+	}, handler.SetPicture)
+
+	for _, p := range []string{
+		storage.GetPublicPath(storage.UserAvatars, "1"),                            // kept as-is before avatars were processed
+		storage.GetPublicPath(storage.UserAvatars, "1-0123456789abcdef-256.jpeg"),  // of an older picture
+		storage.GetPublicPath(storage.UserAvatars, "10-0123456789abcdef-256.jpeg"), // of another user
+	} {
+		err := objService.Upload(ctx, constants.S3Bucket, p, 3, strings.NewReader("old"), models.ObjectOptions{ContentType: "image/jpeg"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	setPicture := func(pic []byte) dto.Avatar {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/v1/users/profile-picture", bytes.NewReader(pic))
+		req.Header.Set("Content-Type", "image/png")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("SetPicture() = %d %s", w.Code, w.Body.String())
+		}
+
+		var avatar dto.Avatar
+		if err := json.Unmarshal(w.Body.Bytes(), &avatar); err != nil {
+			t.Fatal(err)
+		}
+		return avatar
+	}
+	avatarPaths := func() []string {
+		t.Helper()
+		objs, _, err := objService.List(ctx, constants.S3Bucket, storage.GetPublicPath(storage.UserAvatars, "1"), "", 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths := []string{}
+		for _, obj := range objs {
+			paths = append(paths, obj.Path)
+		}
+		return paths
+	}
+
+	// opaque pictures are scaled to each size in jpeg, under keys named after their hash
+	pic := picture(t, 600, 400, false)
+	sum := sha256.Sum256(pic)
+	hash := hex.EncodeToString(sum[:8])
+
+	avatar := setPicture(pic)
+	if len(avatar.Variants) != len(constants.AvatarSizes) {
+		t.Fatalf("expected a variant of each size, got %+v", avatar.Variants)
+	}
+	wantPaths := []string{storage.GetPublicPath(storage.UserAvatars, "10-0123456789abcdef-256.jpeg")}
+	for _, v := range avatar.Variants {
+		if v.Format != "jpeg" || !strings.Contains(v.Url, "/1-"+hash+"-") {
+			t.Errorf("unexpected variant %+v", v)
+		}
+		if v.Size == constants.DefaultAvatarSize && v.Url != avatar.Url {
+			t.Errorf("the avatar url should be of the default size, got %s", avatar.Url)
+		}
+
+		p := storage.GetPublicPath(storage.UserAvatars, path.Base(v.Url))
+		info, err := objService.Stat(ctx, constants.S3Bucket, p)
+		if err != nil || info.ContentType != "image/jpeg" {
+			t.Errorf("variant %s not uploaded as a jpeg: %+v, %v", p, info, err)
+		}
+		wantPaths = append(wantPaths, p)
+	}
+	if avatar.Url == "" || userService.avatarUrl != avatar.Url {
+		t.Errorf("the user avatar should be set to %q, got %q", avatar.Url, userService.avatarUrl)
+	}
+
+	// the older avatars of the user are deleted, those of other users are not
+	slices.Sort(wantPaths)
+	if paths := avatarPaths(); !slices.Equal(paths, wantPaths) {
+		t.Errorf("unexpected avatars after setting the picture\n got %v\nwant %v", paths, wantPaths)
+	}
+
+	// transparent pictures are kept transparent, and replace the previous ones
+	avatar = setPicture(picture(t, 300, 300, true))
+	wantPaths = []string{storage.GetPublicPath(storage.UserAvatars, "10-0123456789abcdef-256.jpeg")}
+	for _, v := range avatar.Variants {
+		if v.Format != "png" || strings.Contains(v.Url, hash) {
+			t.Errorf("unexpected variant %+v", v)
+		}
+		wantPaths = append(wantPaths, storage.GetPublicPath(storage.UserAvatars, path.Base(v.Url)))
+	}
+	slices.Sort(wantPaths)
+	if paths := avatarPaths(); !slices.Equal(paths, wantPaths) {
+		t.Errorf("unexpected avatars after replacing the picture\n got %v\nwant %v", paths, wantPaths)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/users/profile-picture", strings.NewReader("not a picture"))
+	req.Header.Set("Content-Type", "image/png")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("a body that is not a picture should be unsupported, got %d", w.Code)
+	}
+}
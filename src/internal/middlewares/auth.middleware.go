@@ -17,6 +17,10 @@ type AuthMiddleware interface {
 	// the user is authorized to access organization-specific resources.
 	AuthorizeOrganization(need map[string]models.Permission) gin.HandlerFunc
 
+	// AuthorizeAdmin returns a middleware handler function that ensures
+	// the user is a system administrator, for back-office endpoints.
+	AuthorizeAdmin() gin.HandlerFunc
+
 	// Reauthorize returns a middleware handler function that handles
 	// reauthorization logic, such as refreshing tokens or revalidating
 	// user sessions.
@@ -136,6 +136,36 @@ func (m *AuthMiddlewareJwt) AuthorizeOrganization(need map[string]models.Permiss
 	}
 }
 
+func (m *AuthMiddlewareJwt) AuthorizeAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenStr, err := c.Cookie(constants.JwtCookieName)
+		if err != nil && err != http.ErrNoCookie {
+			c.String(http.StatusUnauthorized, "Unauthorized")
+			token.ClearAuthCookie(c)
+			c.Abort()
+			return
+		}
+
+		jwtClaims, err := m.authService.ParseToken(tokenStr)
+		if err != nil {
+			slog.Info(err.Error())
+			c.String(http.StatusUnauthorized, "Unauthorized")
+			token.ClearAuthCookie(c)
+			c.Abort()
+			return
+		}
+
+		if !jwtClaims.IsAdmin {
+			c.String(http.StatusForbidden, "Forbidden")
+			c.Abort()
+			return
+		}
+
+		c.Set(constants.GinCtxJwtClaimKeyName, jwtClaims)
+		c.Next()
+	}
+}
+
 func (m *AuthMiddlewareJwt) Reauthorize() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		jwtClaims, err := token.GetClaimsFromGinCtx[models.JwtClaims](c)
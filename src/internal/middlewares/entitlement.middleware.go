@@ -0,0 +1,20 @@
+package middlewares
+
+import "github.com/gin-gonic/gin"
+
+// EntitlementMiddleware defines an interface for gating routes by the
+// organization's plan. It must run after AuthMiddleware.AuthorizeOrganization,
+// which guarantees the `orgId` path param belongs to the caller.
+type EntitlementMiddleware interface {
+	// RequireFeature returns a middleware handler function that blocks
+	// the request with 402 if the organization's plan does not include
+	// the feature.
+	RequireFeature(feature string) gin.HandlerFunc
+
+	// LimitApiCalls returns a middleware handler function that blocks the
+	// requests of a user signed in to an organization with 402 once the
+	// organization used up the API calls of its plan. It reads the JWT
+	// itself, so it can run on a whole group before the auth middlewares of
+	// its routes; requests without a valid JWT are left for them to reject.
+	LimitApiCalls() gin.HandlerFunc
+}
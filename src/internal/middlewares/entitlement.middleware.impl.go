@@ -0,0 +1,137 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/services"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/gin-gonic/gin"
+)
+
+type EntitlementMiddlewareImpl struct {
+	entitlementService services.EntitlementService
+	authService        services.AuthService
+
+	mu           sync.Mutex
+	entitlements map[string]entitlementsEntry
+}
+
+// entitlementsEntry spares LimitApiCalls from resolving the plan of an organization on every request.
+type entitlementsEntry struct {
+	ent models.Entitlements
+	exp time.Time
+}
+
+func NewEntitlementMiddleware(entitlementService services.EntitlementService, authService services.AuthService) EntitlementMiddleware {
+	return &EntitlementMiddlewareImpl{
+		entitlementService: entitlementService,
+		authService:        authService,
+		entitlements:       make(map[string]entitlementsEntry),
+	}
+}
+
+func (m *EntitlementMiddlewareImpl) RequireFeature(feature string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ent, err := m.entitlementService.GetEntitlements(c, c.Param("orgId"))
+		if err != nil {
+			slog.Error(err.Error())
+			c.String(http.StatusBadGateway, "BadGateway")
+			c.Abort()
+			return
+		}
+
+		if !ent.HasFeature(feature) {
+			c.String(http.StatusPaymentRequired, "FeatureNotIncluded")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func (m *EntitlementMiddlewareImpl) LimitApiCalls() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenStr, err := c.Cookie(constants.JwtCookieName)
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		claims, err := m.authService.ParseToken(tokenStr)
+		if err != nil || claims.OrganizationId == nil {
+			c.Next()
+			return
+		}
+
+		// organizations that are gone have no limit to enforce, the handlers deal with them
+		ent, err := m.cachedEntitlements(c, *claims.OrganizationId)
+		if errors.Is(err, constants.ErrNoRows) {
+			c.Next()
+			return
+		}
+		if err != nil {
+			slog.Error(err.Error())
+			c.String(http.StatusBadGateway, "BadGateway")
+			c.Abort()
+			return
+		}
+
+		if _, ok := ent.Limits[models.ApiCallsLimit]; !ok {
+			c.Next()
+			return
+		}
+
+		// usage is flushed periodically, so a burst may run slightly past the limit
+		usage, err := m.entitlementService.Usage(c, *claims.OrganizationId, models.ApiCallsLimit)
+		if err != nil {
+			slog.Error(err.Error())
+			c.String(http.StatusBadGateway, "BadGateway")
+			c.Abort()
+			return
+		}
+
+		if !ent.Allows(models.ApiCallsLimit, usage+1) {
+			c.String(http.StatusPaymentRequired, "ApiCallsLimitExceeded")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// cachedEntitlements returns the entitlements of the organization, resolved at most
+// constants.EntitlementCacheTtl ago, so plan changes take up to that long to apply.
+func (m *EntitlementMiddlewareImpl) cachedEntitlements(ctx context.Context, orgId string) (models.Entitlements, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	cached, ok := m.entitlements[orgId]
+	m.mu.Unlock()
+	if ok && now.Before(cached.exp) {
+		return cached.ent, nil
+	}
+
+	ent, err := m.entitlementService.GetEntitlements(ctx, orgId)
+	if err != nil {
+		return ent, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, c := range m.entitlements {
+		if now.After(c.exp) {
+			delete(m.entitlements, id)
+		}
+	}
+	m.entitlements[orgId] = entitlementsEntry{ent: ent, exp: now.Add(constants.EntitlementCacheTtl)}
+
+	return ent, nil
+}
@@ -0,0 +1,113 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/services"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/helpers"
+	"github.com/gin-gonic/gin"
+)
+
+func TestEntitlementMiddlewareImpl_LimitApiCalls(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := helpers.NewPostgresContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %s", err)
+		}
+	})
+
+	db := pgContainer.DB
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO users (user_id, email, password_hash, first_name, last_name)
+		VALUES (1, 'owner@email.com', 'hashtest', 'Test', 'Owner');
+
+		INSERT INTO organizations (organization_id, organization_name, owner_user_id)
+		VALUES ('ORG01', 'org', 1), ('ORG02', 'deleted org', 1);
+
+		INSERT INTO organizations_users (organization_id, user_id)
+		VALUES ('ORG01', 1), ('ORG02', 1);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authService := services.NewAuthServiceJwtImpl("secret", db)
+	entitlementService := services.NewEntitlementServicePgImpl(db, map[models.Limit]int64{
+		models.ApiCallsLimit: 10,
+	})
+	authMiddleware := NewAuthMiddlewareJwt(authService)
+	entitlementMiddleware := NewEntitlementMiddleware(entitlementService, authService)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Group("/v1", entitlementMiddleware.LimitApiCalls()).GET("/me", authMiddleware.AuthorizeUser(), func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, "OK")
+	})
+
+	orgId := "ORG01"
+	orgToken, err := authService.InitToken(ctx, 1, "owner@email.com", &orgId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userToken, err := authService.InitToken(ctx, 1, "owner@email.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deletedOrgId := "ORG02"
+	deletedOrgToken, err := authService.InitToken(ctx, 1, "owner@email.com", &deletedOrgId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.ExecContext(ctx, `UPDATE organizations SET deleted_at = NOW() WHERE organization_id = 'ORG02';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/v1/me", nil)
+		if token != "" {
+			req.AddCookie(&http.Cookie{Name: constants.JwtCookieName, Value: token})
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := get(orgToken); code != http.StatusOK {
+		t.Fatalf("expected %d under the limit, got %d", http.StatusOK, code)
+	}
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO usage_records (organization_id, meter, period_start, quantity)
+		VALUES ('ORG01', 'api_calls', $1, 10);
+		`,
+		time.Now().Truncate(time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code := get(orgToken); code != http.StatusPaymentRequired {
+		t.Errorf("expected %d once the limit is reached, got %d", http.StatusPaymentRequired, code)
+	}
+	if code := get(userToken); code != http.StatusOK {
+		t.Errorf("expected requests outside the organization not to be limited, got %d", code)
+	}
+	if code := get(deletedOrgToken); code != http.StatusOK {
+		t.Errorf("expected requests of a deleted organization not to be limited, got %d", code)
+	}
+	if code := get(""); code != http.StatusUnauthorized {
+		t.Errorf("expected requests without a JWT to be left for the auth middleware, got %d", code)
+	}
+}
@@ -0,0 +1,21 @@
+package middlewares
+
+import (
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/gin-gonic/gin"
+)
+
+// LocaleMiddleware defines an interface for the localization middleware.
+type LocaleMiddleware interface {
+	// NegotiateLocale returns a middleware handler function that negotiates
+	// the locale of the request from its Accept-Language header, sets it at
+	// `constants.GinCtxLocaleKeyName` and translates the error messages
+	// written by the handlers to it.
+	NegotiateLocale() gin.HandlerFunc
+}
+
+// GetLocale returns the locale negotiated for the request, empty if the
+// client did not send an Accept-Language header.
+func GetLocale(c *gin.Context) string {
+	return c.GetString(constants.GinCtxLocaleKeyName)
+}
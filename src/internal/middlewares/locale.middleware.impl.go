@@ -0,0 +1,88 @@
+package middlewares
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/i18n"
+	"github.com/gin-gonic/gin"
+)
+
+type LocaleMiddlewareImpl struct {
+	catalog *i18n.Catalog
+}
+
+func NewLocaleMiddleware(catalog *i18n.Catalog) LocaleMiddleware {
+	return &LocaleMiddlewareImpl{
+		catalog: catalog,
+	}
+}
+
+// Error responses are plain text tokens, e.g. "NotFound", clients without an Accept-Language
+// header keep getting them as is. The others get the message of the token in their locale,
+// with the token at the X-Error-Code header.
+func (m *LocaleMiddlewareImpl) NegotiateLocale() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		acceptLanguage := c.GetHeader("Accept-Language")
+		if acceptLanguage == "" {
+			c.Next()
+			return
+		}
+
+		locale := m.catalog.Match(acceptLanguage)
+		c.Set(constants.GinCtxLocaleKeyName, locale)
+		c.Header("Content-Language", locale)
+		c.Header("Vary", "Accept-Language")
+
+		c.Writer = &localeWriter{ResponseWriter: c.Writer, catalog: m.catalog, locale: locale}
+		c.Next()
+	}
+}
+
+// localeWriter translates the plain text error bodies that are catalog keys.
+type localeWriter struct {
+	gin.ResponseWriter
+	catalog *i18n.Catalog
+	locale  string
+}
+
+func (w *localeWriter) Write(b []byte) (int, error) {
+	msg, ok := w.translate(string(b))
+	if !ok {
+		return w.ResponseWriter.Write(b)
+	}
+
+	_, err := w.ResponseWriter.Write([]byte(msg))
+	// callers expect the length of what they wrote
+	return len(b), err
+}
+
+func (w *localeWriter) WriteString(s string) (int, error) {
+	msg, ok := w.translate(s)
+	if !ok {
+		return w.ResponseWriter.WriteString(s)
+	}
+
+	_, err := w.ResponseWriter.WriteString(msg)
+	return len(s), err
+}
+
+// translate returns the message of an error body in the locale, the headers are only
+// flushed on the first write so the error code can still be set.
+func (w *localeWriter) translate(body string) (string, bool) {
+	if w.Written() || w.Status() < http.StatusBadRequest {
+		return "", false
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		return "", false
+	}
+
+	msg, ok := w.catalog.Message(w.locale, body)
+	if !ok {
+		return "", false
+	}
+
+	w.Header().Set("X-Error-Code", body)
+	return msg, true
+}
@@ -6,6 +6,7 @@ import "github.com/gin-gonic/gin"
 // It includes methods for collecting and processing telemetry data.
 type TelemetryMiddleware interface {
 	// CollectApiCall returns a middleware handler function that collects
-	// telemetry data for API calls, such as request metrics or logging.
+	// telemetry data for API calls, such as request metrics or logging, and
+	// meters the calls made on behalf of an organization.
 	CollectApiCalls() gin.HandlerFunc
 }
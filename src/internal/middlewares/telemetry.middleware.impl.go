@@ -4,17 +4,21 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/LombardiDaniel/goliath/src/internal/models"
 	"github.com/LombardiDaniel/goliath/src/internal/services"
+	"github.com/LombardiDaniel/goliath/src/pkg/token"
 	"github.com/gin-gonic/gin"
 )
 
 type TelemetryMiddlewareImpl struct {
 	telemetryService services.TelemetryService
+	usageService     services.UsageService
 }
 
-func NewTelemetryMiddleware(telemetryService services.TelemetryService) TelemetryMiddleware {
+func NewTelemetryMiddleware(telemetryService services.TelemetryService, usageService services.UsageService) TelemetryMiddleware {
 	return &TelemetryMiddlewareImpl{
 		telemetryService: telemetryService,
+		usageService:     usageService,
 	}
 }
 
@@ -34,5 +38,11 @@ func (m *TelemetryMiddlewareImpl) CollectApiCalls() gin.HandlerFunc {
 				"status": fmt.Sprintf("%d", c.Writer.Status()),
 			},
 		)
+
+		// claims are only set if an auth middleware ran for the route
+		claims, err := token.GetClaimsFromGinCtx[models.JwtClaims](c)
+		if err == nil && claims.OrganizationId != nil {
+			m.usageService.Record(c.Request.Context(), *claims.OrganizationId, models.ApiCallsMeter, 1)
+		}
 	}
 }
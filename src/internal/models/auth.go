@@ -33,6 +33,7 @@ type JwtClaims struct {
 	Email          string                `json:"email" binding:"required"`
 	OrganizationId *string               `json:"organizationId" binding:"required"`
 	Perms          map[string]Permission `json:"perms" binding:"required"`
+	IsAdmin        bool                  `json:"isAdmin,omitempty"`
 
 	jwt.StandardClaims
 }
@@ -43,6 +44,7 @@ type JwtClaimsOutput struct {
 	Email          string                `json:"email" binding:"required"`
 	OrganizationId *string               `json:"organizationId" binding:"required"`
 	Perms          map[string]Permission `json:"perms" binding:"required"`
+	IsAdmin        bool                  `json:"isAdmin,omitempty"`
 
 	Audience  string `json:"aud"`
 	ExpiresAt int64  `json:"exp"`
@@ -2,14 +2,166 @@ package models
 
 import "time"
 
+// PaymentStatus is the lifecycle of a one-time payment.
+type PaymentStatus string
+
+const (
+	PaymentPending           PaymentStatus = "pending"
+	PaymentComplete          PaymentStatus = "complete"
+	PaymentCanceled          PaymentStatus = "canceled"
+	PaymentExpired           PaymentStatus = "expired"
+	PaymentPartiallyRefunded PaymentStatus = "partially_refunded"
+	PaymentRefunded          PaymentStatus = "refunded"
+	PaymentDisputed          PaymentStatus = "disputed"
+	PaymentDisputeLost       PaymentStatus = "dispute_lost"
+)
+
+// SubscriptionPaymentStatus is the outcome of a charge of a subscription.
+type SubscriptionPaymentStatus string
+
+const (
+	SubscriptionPaymentPaid   SubscriptionPaymentStatus = "paid"
+	SubscriptionPaymentFailed SubscriptionPaymentStatus = "failed"
+)
+
+// SubscriptionPayment is a charge of the subscription of an organization, one per gateway invoice.
+type SubscriptionPayment struct {
+	ProviderInvoiceId string                    `json:"providerInvoiceId"`
+	OrganizationId    string                    `json:"organizationId"`
+	SubscriptionId    string                    `json:"subscriptionId"`
+	Ammount           int64                     `json:"ammount"` // paid, or due if failed
+	Currency          string                    `json:"currency"`
+	PaymentStatus     SubscriptionPaymentStatus `json:"paymentStatus"`
+	PeriodStart       *time.Time                `json:"periodStart"`
+	PeriodEnd         *time.Time                `json:"periodEnd"`
+	CreatedAt         time.Time                 `json:"createdAt"`
+	PaidAt            *time.Time                `json:"paidAt"`
+}
+
 // Payment represents a payment in the system.
 type Payment struct {
-	PaymentId               string     `json:"paymentId"`
-	UserId                  uint32     `json:"userId"`
-	UnitAmmount             uint32     `json:"unitAmmount"`
-	UnitCurrency            string     `json:"unitCurrency"`
-	PaymentStatus           string     `json:"paymentStatus"`
-	StripeCheckoutSessionId string     `json:"stripeCheckoutSessionId"`
-	CreatedAt               time.Time  `json:"createdAt"`
-	CompletedAt             *time.Time `json:"completedAt"`
+	PaymentId                 string        `json:"paymentId"`
+	UserId                    uint32        `json:"userId"`
+	PriceId                   *uint32       `json:"priceId"`
+	UnitAmmount               uint32        `json:"unitAmmount"`
+	UnitCurrency              string        `json:"unitCurrency"`
+	PaymentStatus             PaymentStatus `json:"paymentStatus"`
+	RefundedAmmount           int64         `json:"refundedAmmount"`
+	CouponId                  *uint32       `json:"couponId"`
+	DiscountAmmount           int64         `json:"discountAmmount"` // already taken off UnitAmmount
+	ProviderCheckoutSessionId string        `json:"providerCheckoutSessionId"`
+	ProviderPaymentIntentId   *string       `json:"providerPaymentIntentId"`
+	CreatedAt                 time.Time     `json:"createdAt"`
+	CompletedAt               *time.Time    `json:"completedAt"`
+}
+
+// Refundable is how much of the payment can still be refunded.
+func (p Payment) Refundable() int64 {
+	if p.PaymentStatus != PaymentComplete && p.PaymentStatus != PaymentPartiallyRefunded {
+		return 0
+	}
+	return int64(p.UnitAmmount) - p.RefundedAmmount
+}
+
+// Payment event types that are not a status the payment moved to.
+const (
+	CreatedPaymentEvent         string = "created"
+	RefundRequestedPaymentEvent string = "refund_requested"
+	DisputeWonPaymentEvent      string = "dispute_won"
+)
+
+// PaymentEvent is an entry in the history of a payment.
+type PaymentEvent struct {
+	PaymentEventId uint64    `json:"paymentEventId"`
+	PaymentId      string    `json:"paymentId"`
+	EventType      string    `json:"eventType"`
+	Ammount        *int64    `json:"ammount"`
+	Details        *string   `json:"details"`
+	ActorUserId    *uint32   `json:"actorUserId"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// BillingCustomer links a user or an organization to its customer at the payment provider.
+type BillingCustomer struct {
+	BillingCustomerId  uint32    `json:"billingCustomerId"`
+	UserId             *uint32   `json:"userId"`
+	OrganizationId     *string   `json:"organizationId"`
+	ProviderCustomerId string    `json:"providerCustomerId"`
+	CreatedAt          time.Time `json:"createdAt"`
+}
+
+// BillingInterval is how often a Price is charged.
+type BillingInterval string
+
+const (
+	OneTimeInterval BillingInterval = "one_time"
+	DayInterval     BillingInterval = "day"
+	WeekInterval    BillingInterval = "week"
+	MonthInterval   BillingInterval = "month"
+	YearInterval    BillingInterval = "year"
+)
+
+// Plan represents a product of the billing catalog, organizations subscribe to plans.
+type Plan struct {
+	PlanId            uint32          `json:"planId"`
+	PlanName          string          `json:"planName"`
+	Features          []string        `json:"features"`
+	Limits            map[Limit]int64 `json:"limits"`
+	IsActive          bool            `json:"isActive"`
+	ProviderProductId *string         `json:"providerProductId"`
+	CreatedAt         time.Time       `json:"createdAt"`
+	Prices            []Price         `json:"prices"`
+}
+
+// Price represents how much and how often a Plan is charged.
+type Price struct {
+	PriceId         uint32          `json:"priceId"`
+	PlanId          uint32          `json:"planId"`
+	UnitAmmount     int64           `json:"unitAmmount"`
+	UnitCurrency    string          `json:"unitCurrency"`
+	Interval        BillingInterval `json:"interval"`
+	Meter           *Meter          `json:"meter"`
+	TrialDays       int64           `json:"trialDays"`
+	IsActive        bool            `json:"isActive"`
+	ProviderPriceId *string         `json:"providerPriceId"`
+	CreatedAt       time.Time       `json:"createdAt"`
+}
+
+// SubscriptionStatus mirrors the lifecycle of a subscription at the payment provider.
+type SubscriptionStatus string
+
+const (
+	SubscriptionIncomplete        SubscriptionStatus = "incomplete"
+	SubscriptionIncompleteExpired SubscriptionStatus = "incomplete_expired"
+	SubscriptionTrialing          SubscriptionStatus = "trialing"
+	SubscriptionActive            SubscriptionStatus = "active"
+	SubscriptionPastDue           SubscriptionStatus = "past_due"
+	SubscriptionCanceled          SubscriptionStatus = "canceled"
+	SubscriptionUnpaid            SubscriptionStatus = "unpaid"
+	SubscriptionPaused            SubscriptionStatus = "paused"
+)
+
+// GrantsPlan reports whether an organization in this status keeps the benefits of its plan.
+func (s SubscriptionStatus) GrantsPlan() bool {
+	return s == SubscriptionTrialing || s == SubscriptionActive || s == SubscriptionPastDue
+}
+
+// Subscription represents the recurring billing of an organization to a plan.
+type Subscription struct {
+	SubscriptionId             string             `json:"subscriptionId"`
+	OrganizationId             string             `json:"organizationId"`
+	PlanId                     uint32             `json:"planId"`
+	PriceId                    uint32             `json:"priceId"`
+	Seats                      int64              `json:"seats"`
+	Status                     SubscriptionStatus `json:"status"`
+	ProviderSubscriptionId     *string            `json:"providerSubscriptionId"`
+	ProviderSubscriptionItemId *string            `json:"providerSubscriptionItemId"`
+	ProviderCustomerId         *string            `json:"providerCustomerId"`
+	CurrentPeriodEnd           *time.Time         `json:"currentPeriodEnd"`
+	TrialEnd                   *time.Time         `json:"trialEnd"`
+	CancelAtPeriodEnd          bool               `json:"cancelAtPeriodEnd"`
+	CouponId                   *uint32            `json:"couponId"`
+	DiscountAmmount            int64              `json:"discountAmmount"` // off the first charge
+	CreatedAt                  time.Time          `json:"createdAt"`
+	UpdatedAt                  time.Time          `json:"updatedAt"`
 }
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// CouponDuration is how many charges of a subscription a coupon discounts,
+// one-time payments are always discounted once.
+type CouponDuration string
+
+const (
+	OnceCouponDuration    CouponDuration = "once"
+	ForeverCouponDuration CouponDuration = "forever"
+)
+
+// Coupon is a discount redeemed by its code at checkout, either PercentOff or
+// AmmountOff (in Currency) is set. Coupons without PlanIds apply to all plans.
+type Coupon struct {
+	CouponId         uint32         `json:"couponId"`
+	Code             string         `json:"code"`
+	PercentOff       *int64         `json:"percentOff"`
+	AmmountOff       *int64         `json:"ammountOff"`
+	Currency         *string        `json:"currency"`
+	Duration         CouponDuration `json:"duration"`
+	PlanIds          []uint32       `json:"planIds"`
+	MaxRedemptions   *int64         `json:"maxRedemptions"`
+	TimesRedeemed    int64          `json:"timesRedeemed"`
+	ExpiresAt        *time.Time     `json:"expiresAt"`
+	IsActive         bool           `json:"isActive"`
+	ProviderCouponId *string        `json:"providerCouponId"`
+	CreatedAt        time.Time      `json:"createdAt"`
+}
+
+// Discount is how much the coupon takes off ammount, percentages are rounded half up
+// as the payment gateway does.
+func (c Coupon) Discount(ammount int64) int64 {
+	if c.PercentOff != nil {
+		return (ammount**c.PercentOff + 50) / 100
+	}
+	if c.AmmountOff != nil {
+		return min(*c.AmmountOff, ammount)
+	}
+	return 0
+}
+
+// AppliesTo reports whether the coupon can be redeemed on price at the time now.
+func (c Coupon) AppliesTo(price Price, now time.Time) bool {
+	if !c.IsActive {
+		return false
+	}
+	if c.ExpiresAt != nil && !now.Before(*c.ExpiresAt) {
+		return false
+	}
+	if c.MaxRedemptions != nil && c.TimesRedeemed >= *c.MaxRedemptions {
+		return false
+	}
+	if len(c.PlanIds) > 0 && !slices.Contains(c.PlanIds, price.PlanId) {
+		return false
+	}
+	return c.Currency == nil || *c.Currency == price.UnitCurrency
+}
+
+// CouponRedemption is a coupon redeemed by a payment of a user or a subscription of an organization.
+type CouponRedemption struct {
+	CouponRedemptionId uint64    `json:"couponRedemptionId"`
+	CouponId           uint32    `json:"couponId"`
+	UserId             *uint32   `json:"userId"`
+	OrganizationId     *string   `json:"organizationId"`
+	PaymentId          *string   `json:"paymentId"`
+	SubscriptionId     *string   `json:"subscriptionId"`
+	DiscountAmmount    int64     `json:"discountAmmount"`
+	Currency           string    `json:"currency"`
+	RedeemedAt         time.Time `json:"redeemedAt"`
+}
+
+// CouponReport totals the redemptions of a coupon in a currency.
+type CouponReport struct {
+	CouponId        uint32 `json:"couponId"`
+	Code            string `json:"code"`
+	Currency        string `json:"currency"`
+	Redemptions     int64  `json:"redemptions"`
+	DiscountAmmount int64  `json:"discountAmmount"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// DeliverabilityStatus is the outcome of the last email sent to an address, as its provider reported it.
+type DeliverabilityStatus string
+
+const (
+	DeliverabilityUnknown     DeliverabilityStatus = "unknown" // nothing was reported on the address
+	DeliverabilityDelivered   DeliverabilityStatus = "delivered"
+	DeliverabilitySoftBounced DeliverabilityStatus = "soft_bounced"
+	DeliverabilityBounced     DeliverabilityStatus = "bounced"
+	DeliverabilityComplained  DeliverabilityStatus = "complained"
+)
+
+// EmailDeliverability is what is known about sending emails to an address. Addresses that bounced
+// for good, complained or bounced too many times in a row are suppressed: emails are not sent to
+// them until an admin removes the suppression.
+type EmailDeliverability struct {
+	Email        string               `json:"email"`
+	Status       DeliverabilityStatus `json:"status"`
+	SoftBounces  int64                `json:"softBounces"`
+	LastReason   *string              `json:"lastReason"`
+	LastEventAt  *time.Time           `json:"lastEventAt"`
+	SuppressedAt *time.Time           `json:"suppressedAt"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// DunningStatus is the step a failed subscription charge is in. Cases start retrying, move to
+// grace once the retries run out, and end recovered (paid), suspended or canceled.
+type DunningStatus string
+
+const (
+	DunningRetrying  DunningStatus = "retrying"
+	DunningGrace     DunningStatus = "grace"
+	DunningRecovered DunningStatus = "recovered"
+	DunningSuspended DunningStatus = "suspended"
+	DunningCanceled  DunningStatus = "canceled" // the subscription was canceled by other means
+)
+
+// IsOpen reports whether the case still has a step ahead.
+func (s DunningStatus) IsOpen() bool {
+	return s == DunningRetrying || s == DunningGrace
+}
+
+// DunningCase tracks the recovery of a failed renewal charge of a subscription.
+type DunningCase struct {
+	DunningCaseId     string        `json:"dunningCaseId"`
+	SubscriptionId    string        `json:"subscriptionId"`
+	OrganizationId    string        `json:"organizationId"`
+	ProviderInvoiceId string        `json:"providerInvoiceId"`
+	AmmountDue        int64         `json:"ammountDue"`
+	Currency          string        `json:"currency"`
+	Status            DunningStatus `json:"status"`
+	AttemptCount      int64         `json:"attemptCount"`  // charges tried so far, the failed renewal included
+	NextAttemptAt     *time.Time    `json:"nextAttemptAt"` // while retrying
+	GraceEndsAt       *time.Time    `json:"graceEndsAt"`   // once in grace
+	WarnedAt          *time.Time    `json:"warnedAt"`      // once the owner is warned of the suspension
+	LastError         *string       `json:"lastError"`
+	CreatedAt         time.Time     `json:"createdAt"`
+	UpdatedAt         time.Time     `json:"updatedAt"`
+	ResolvedAt        *time.Time    `json:"resolvedAt"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "slices"
+
+// Limit is the name of a numeric quota granted by a plan.
+type Limit string
+
+const (
+	SeatsLimit        Limit = "seats"
+	StorageBytesLimit Limit = "storage_bytes"
+	ApiCallsLimit     Limit = "api_calls"
+)
+
+// Entitlements represents the features and limits an organization is entitled to by its plan.
+type Entitlements struct {
+	OrganizationId string          `json:"organizationId"`
+	PlanId         *uint32         `json:"planId"`
+	Features       []string        `json:"features"`
+	Limits         map[Limit]int64 `json:"limits"`
+}
+
+// HasFeature reports whether the feature flag is included.
+func (e Entitlements) HasFeature(feature string) bool {
+	return slices.Contains(e.Features, feature)
+}
+
+// Allows reports whether the total usage fits in the limit, absent limits are unlimited.
+func (e Entitlements) Allows(limit Limit, total int64) bool {
+	maximum, ok := e.Limits[limit]
+	return !ok || total <= maximum
+}
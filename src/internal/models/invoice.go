@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// BillingDetails is who an invoice is billed to.
+type BillingDetails struct {
+	CompanyName  string  `json:"companyName"`
+	TaxId        *string `json:"taxId"`
+	AddressLine1 string  `json:"addressLine1"`
+	AddressLine2 *string `json:"addressLine2"`
+	City         string  `json:"city"`
+	State        *string `json:"state"`
+	PostalCode   *string `json:"postalCode"`
+	Country      string  `json:"country"`
+	Email        *string `json:"email,omitempty"`
+}
+
+// Invoice is issued for a completed payment of a user or a paid period of an organization subscription.
+type Invoice struct {
+	InvoiceId         string         `json:"invoiceId"`
+	InvoiceNumber     string         `json:"invoiceNumber"`
+	UserId            *uint32        `json:"userId"`
+	OrganizationId    *string        `json:"organizationId"`
+	PaymentId         *string        `json:"paymentId"`
+	SubscriptionId    *string        `json:"subscriptionId"`
+	ProviderInvoiceId *string        `json:"providerInvoiceId"`
+	Description       string         `json:"description"`
+	Ammount           int64          `json:"ammount"`
+	Currency          string         `json:"currency"`
+	BillingDetails    BillingDetails `json:"billingDetails"`
+	PeriodStart       *time.Time     `json:"periodStart"`
+	PeriodEnd         *time.Time     `json:"periodEnd"`
+	ReceiptPath       *string        `json:"-"`
+	IssuedAt          time.Time      `json:"issuedAt"`
+}
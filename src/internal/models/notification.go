@@ -0,0 +1,85 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// NotificationCategory groups the notifications users get, so they can opt out of whole
+// categories. Security notifications are mandatory.
+type NotificationCategory string
+
+const (
+	SecurityNotifications    NotificationCategory = "security"
+	BillingNotifications     NotificationCategory = "billing"
+	OrgActivityNotifications NotificationCategory = "org_activity"
+	ProductNewsNotifications NotificationCategory = "product_news"
+)
+
+// NotificationCategories are all the categories, in the order they are shown to users.
+var NotificationCategories = []NotificationCategory{
+	SecurityNotifications,
+	BillingNotifications,
+	OrgActivityNotifications,
+	ProductNewsNotifications,
+}
+
+// Mandatory reports whether users cannot opt out of the category.
+func (c NotificationCategory) Mandatory() bool {
+	return c == SecurityNotifications
+}
+
+// OptOutable reports whether the category is one users can opt out of.
+func (c NotificationCategory) OptOutable() bool {
+	return slices.Contains(NotificationCategories, c) && !c.Mandatory()
+}
+
+// EmailByDefault reports whether users get the emails of the category until they opt out,
+// product news are opt-in.
+func (c NotificationCategory) EmailByDefault() bool {
+	return c != ProductNewsNotifications
+}
+
+// NotificationPreference is whether a user gets the emails of a category.
+type NotificationPreference struct {
+	Category  NotificationCategory `json:"category"`
+	Email     bool                 `json:"email"`
+	Mandatory bool                 `json:"mandatory"`
+}
+
+// NotificationKind is what an in-app notification is about, the app renders each kind from its data.
+type NotificationKind string
+
+const (
+	OrganizationInviteNotification    NotificationKind = "organization_invite"    // OrganizationId, OrganizationName
+	OrganizationPermsNotification     NotificationKind = "organization_perms"     // OrganizationId, OrganizationName, Action, Permission
+	OrganizationOwnerNotification     NotificationKind = "organization_owner"     // OrganizationId, OrganizationName
+	OrganizationRemovalNotification   NotificationKind = "organization_removal"   // OrganizationId, OrganizationName
+	PaymentAcceptedNotification       NotificationKind = "payment_accepted"       // PaymentId, Ammount, Currency
+	PaymentRefundedNotification       NotificationKind = "payment_refunded"       // PaymentId, Ammount, RefundedAmmount, Currency
+	PaymentCanceledNotification       NotificationKind = "payment_canceled"       // PaymentId
+	PaymentFailedNotification         NotificationKind = "payment_failed"         // OrganizationId, AmmountDue, Currency, NextAttemptAt, GraceEndsAt
+	SubscriptionSuspendedNotification NotificationKind = "subscription_suspended" // OrganizationId, AmmountDue, Currency
+	SuspensionWarningNotification     NotificationKind = "suspension_warning"     // OrganizationId, AmmountDue, Currency, GraceEndsAt
+)
+
+// Category returns the category of the notifications of the kind.
+func (k NotificationKind) Category() NotificationCategory {
+	switch k {
+	case OrganizationInviteNotification, OrganizationPermsNotification, OrganizationOwnerNotification, OrganizationRemovalNotification:
+		return OrgActivityNotifications
+	default:
+		return BillingNotifications
+	}
+}
+
+// Notification is an in-app notification of a user.
+type Notification struct {
+	NotificationId int64                `json:"notificationId"`
+	UserId         uint32               `json:"userId"`
+	Kind           NotificationKind     `json:"kind"`
+	Category       NotificationCategory `json:"category"`
+	Data           map[string]any       `json:"data"`
+	ReadAt         *time.Time           `json:"readAt"`
+	CreatedAt      time.Time            `json:"createdAt"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// ObjectOptions are the optional attributes of an uploaded object.
+type ObjectOptions struct {
+	ContentType string            // application/octet-stream if empty
+	Metadata    map[string]string // user metadata, returned by Stat
+	Tags        map[string]string
+	SHA256      string // hex checksum the content must match, kept with the object
+}
+
+// ObjectInfo describes a stored object. Listings only fill the path, size, etag and modification time.
+type ObjectInfo struct {
+	Path         string            `json:"path"`
+	Size         int64             `json:"size"`
+	ContentType  string            `json:"contentType,omitempty"`
+	ETag         string            `json:"etag"`
+	LastModified time.Time         `json:"lastModified"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	SHA256       string            `json:"sha256,omitempty"` // only of objects uploaded with one
+}
+
+// ByteRange is the inclusive range of bytes [Start, End] of an object.
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// Len is the number of bytes in the range.
+func (r ByteRange) Len() int64 {
+	return r.End - r.Start + 1
+}
+
+// ObjectPart is an uploaded part of a multipart upload.
+type ObjectPart struct {
+	PartNumber int    `json:"partNumber" binding:"required"`
+	ETag       string `json:"etag" binding:"required"`
+	Size       int64  `json:"size"`
+}
@@ -15,6 +15,7 @@ type Organization struct {
 	CreatedAt        time.Time  `json:"createdAt" binding:"required"`
 	DeletedAt        *time.Time `json:"deletedAt,omitempty"`
 	OwnerUserId      uint32     `json:"ownerUserId,omitempty"`
+	DefaultLocale    *string    `json:"defaultLocale"`
 }
 
 // FrontendConfig represents the frontend configuration for an organization.
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// OutboxStatus is the delivery state of an enqueued email. Pending emails are retried
+// with backoff until they are sent or run out of attempts, then they are dead. Emails
+// to suppressed addresses are not sent at all.
+type OutboxStatus string
+
+const (
+	OutboxPending    OutboxStatus = "pending"
+	OutboxSent       OutboxStatus = "sent"
+	OutboxDead       OutboxStatus = "dead"
+	OutboxSuppressed OutboxStatus = "suppressed"
+)
+
+// OutboxEmail is an email of the outbox, its payload is kept out as it may hold one-time passwords.
+type OutboxEmail struct {
+	EmailId       int64        `json:"emailId"`
+	TemplateName  string       `json:"templateName"`
+	Recipient     string       `json:"recipient"`
+	Locale        string       `json:"locale"`
+	Status        OutboxStatus `json:"status"`
+	Attempts      int64        `json:"attempts"`
+	NextAttemptAt time.Time    `json:"nextAttemptAt"`
+	LastError     *string      `json:"lastError"`
+	CreatedAt     time.Time    `json:"createdAt"`
+	SentAt        *time.Time   `json:"sentAt"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// UploadPurpose is what an uploaded file is for, which limits its size and content types.
+type UploadPurpose string
+
+const (
+	FilePurpose     UploadPurpose = "file" // of any content type, which is not verified
+	ImagePurpose    UploadPurpose = "image"
+	DocumentPurpose UploadPurpose = "document"
+)
+
+// Upload is a file a user uploads, either in parts through the API, resumable until it is
+// completed, or directly to the storage through a presigned URL, then confirmed. Its object
+// only counts in the storage of the organization once completed, and incomplete uploads are
+// deleted once expired.
+type Upload struct {
+	UploadId       string        `json:"uploadId"`
+	UserId         uint32        `json:"userId"`
+	OrganizationId *string       `json:"organizationId,omitempty"`
+	ObjectPath     string        `json:"-"`
+	MultipartId    *string       `json:"-"` // of the storage, nil for direct uploads
+	Purpose        UploadPurpose `json:"purpose"`
+	FileName       string        `json:"fileName"`
+	ContentType    string        `json:"contentType"`
+	DeclaredSize   *int64        `json:"declaredSize,omitempty"` // of direct uploads
+	Size           *int64        `json:"size,omitempty"`         // once completed
+	CreatedAt      time.Time     `json:"createdAt"`
+	ExpiresAt      time.Time     `json:"expiresAt"` // unless completed
+	CompletedAt    *time.Time    `json:"completedAt,omitempty"`
+}
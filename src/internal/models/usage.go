@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// Meter is the name of something organizations are charged by usage of,
+// besides the builtin ones any name reported through UsageService is valid.
+type Meter string
+
+const (
+	ApiCallsMeter     Meter = "api_calls"
+	StorageBytesMeter Meter = "storage_bytes"
+)
+
+// IsGauge reports whether the meter measures a level instead of counting events,
+// gauges are aggregated with max instead of sum.
+func (m Meter) IsGauge() bool {
+	return m == StorageBytesMeter
+}
+
+// UsageRecord is the usage of a meter by an organization during one hour.
+type UsageRecord struct {
+	OrganizationId string     `json:"organizationId"`
+	Meter          Meter      `json:"meter"`
+	PeriodStart    time.Time  `json:"periodStart"`
+	Quantity       int64      `json:"quantity"`
+	ReportedAt     *time.Time `json:"reportedAt"`
+}
+
+// UsageSummary is the usage of an organization over a time range.
+type UsageSummary struct {
+	OrganizationId string          `json:"organizationId"`
+	From           time.Time       `json:"from"`
+	To             time.Time       `json:"to"`
+	Totals         map[Meter]int64 `json:"totals"`
+	Records        []UsageRecord   `json:"records"`
+}
@@ -17,6 +17,7 @@ type User struct {
 	FirstName    string
 	LastName     string
 	AvatarUrl    *string
+	Locale       *string
 	DateOfBirth  *time.Time
 	// LastLogin    *time.Time
 	CreatedAt *time.Time
@@ -32,9 +33,10 @@ type UnconfirmedUser struct {
 	FirstName    string
 	LastName     string
 	DateOfBirth  *time.Time
+	Locale       *string
 }
 
-func NewUnconfirmedUser(email string, password string, firstName string, lastName string, dateOfBirth *time.Time) (*UnconfirmedUser, error) {
+func NewUnconfirmedUser(email string, password string, firstName string, lastName string, dateOfBirth *time.Time, locale *string) (*UnconfirmedUser, error) {
 	hash, err := token.HashPassword(password)
 	if err != nil {
 		return nil, err
@@ -52,5 +54,6 @@ func NewUnconfirmedUser(email string, password string, firstName string, lastNam
 		FirstName:    firstName,
 		LastName:     lastName,
 		DateOfBirth:  dateOfBirth,
+		Locale:       locale,
 	}, nil
 }
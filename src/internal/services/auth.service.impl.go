@@ -31,11 +31,22 @@ func (s *AuthServiceJwtImpl) InitToken(ctx context.Context, userId uint32, email
 		return "", err
 	}
 
+	var isAdmin bool
+	err = s.db.QueryRowContext(ctx, `
+		SELECT is_admin
+		FROM users
+		WHERE user_id = $1;
+	`, userId).Scan(&isAdmin)
+	if err != nil {
+		return "", errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
 	claims := models.JwtClaims{
 		UserId:         userId,
 		Email:          email,
 		OrganizationId: organizationId,
 		Perms:          perms,
+		IsAdmin:        isAdmin,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Add(time.Second * time.Duration(constants.JwtTimeoutSecs)).Unix(),
 			Issuer:    constants.ProjectName + "-auth",
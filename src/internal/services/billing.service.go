@@ -2,24 +2,141 @@ package services
 
 import (
 	"context"
+	"net/http"
+	"time"
 
 	"github.com/LombardiDaniel/goliath/src/internal/models"
-	"github.com/stripe/stripe-go/v81"
+	"github.com/LombardiDaniel/goliath/src/pkg/payments"
 )
 
 // BillingService defines the interface for all billing-related operations.
+// Payments are delegated to a payments.Gateway, the service keeps their state.
 type BillingService interface {
-	// CheckoutURL gets the Stripe Checkout URL to be redirected to in the frontend
-	CheckoutURL(ctx context.Context, currencyUnit stripe.Currency, unitAmmount int64, planName string, userId uint32) (string, error)
+	// CheckoutURL gets the gateway Checkout URL to be redirected to in the frontend,
+	// the coupon, if not nil, must exist at the gateway.
+	CheckoutURL(ctx context.Context, plan models.Plan, price models.Price, userId uint32, coupon *models.Coupon) (string, error)
+
+	// SubscriptionCheckoutURL gets the gateway Checkout URL for an organization to subscribe to a recurring price,
+	// starting with the trial of the price if the organization never had one.
+	SubscriptionCheckoutURL(ctx context.Context, plan models.Plan, price models.Price, orgId string, seats int64, coupon *models.Coupon) (string, error)
+
+	// GetUserCustomer retrieves the gateway customer of a user, creating it on first use.
+	GetUserCustomer(ctx context.Context, userId uint32) (models.BillingCustomer, error)
+
+	// GetOrganizationCustomer retrieves the gateway customer of an organization, creating it on first use
+	// unless a previous subscription of the organization already has one.
+	GetOrganizationCustomer(ctx context.Context, orgId string) (models.BillingCustomer, error)
+
+	// FindOrganizationCustomer retrieves the gateway customer of an organization without creating one,
+	// returns constants.ErrNoRows if the organization never had one.
+	FindOrganizationCustomer(ctx context.Context, orgId string) (models.BillingCustomer, error)
+
+	// PortalURL gets the gateway portal URL where a customer manages its payment methods and subscriptions.
+	PortalURL(ctx context.Context, customer models.BillingCustomer) (string, error)
+
+	// GetPaymentMethods retrieves the payment methods stored for a customer.
+	GetPaymentMethods(ctx context.Context, customer models.BillingCustomer) ([]payments.PaymentMethod, error)
+
+	// SetDefaultPaymentMethod makes a payment method the default of a customer and of the live subscription
+	// of its organization, returns constants.ErrNoRows if it is not one of the customer.
+	SetDefaultPaymentMethod(ctx context.Context, customer models.BillingCustomer, paymentMethodId string) error
+
+	// GetOrganizationSubscription retrieves the live (not canceled) subscription of an organization.
+	GetOrganizationSubscription(ctx context.Context, orgId string) (models.Subscription, error)
+
+	// SyncSubscription fetches a subscription from the gateway and applies its state, and the plan it
+	// grants, to the subscription and its organization.
+	SyncSubscription(ctx context.Context, providerSubscriptionId string) (models.Subscription, error)
+
+	// ChangeSubscription moves a subscription to another price and/or number of seats, prorating the difference.
+	ChangeSubscription(ctx context.Context, sub models.Subscription, price models.Price, seats int64) (models.Subscription, error)
+
+	// CancelSubscription cancels a subscription at the end of the current period.
+	CancelSubscription(ctx context.Context, sub models.Subscription) (models.Subscription, error)
 
 	// CheckoutSession is the webhook to be used in a daemon
-	CheckoutSession(ctx context.Context, sessionId string) (*stripe.CheckoutSession, error)
+	CheckoutSession(ctx context.Context, sessionId string) (payments.CheckoutSession, error)
+
+	// SetCheckoutSessionAsComplete marks the payment of a paid checkout session as complete.
+	// Like the other payment transitions, the returned bool reports whether the payment changed,
+	// payments that already moved past the transition are returned as they are.
+	SetCheckoutSessionAsComplete(ctx context.Context, checkoutSession payments.CheckoutSession) (models.Payment, bool, error)
+
+	// GetUserPayments retrieves a page of the payments of a user, newest first, and their total count.
+	GetUserPayments(ctx context.Context, userId uint32, offset int, limit int) ([]models.Payment, int64, error)
+
+	// SetCheckoutSessionAsCanceled marks the pending payment of a checkout session whose payment failed as canceled.
+	SetCheckoutSessionAsCanceled(ctx context.Context, sessionId string) (models.Payment, bool, error)
+
+	// SetCheckoutSessionAsExpired marks the pending payment of an expired checkout session as expired.
+	SetCheckoutSessionAsExpired(ctx context.Context, sessionId string) (models.Payment, bool, error)
+
+	// GetPayment retrieves a payment by its id.
+	GetPayment(ctx context.Context, paymentId string) (models.Payment, error)
+
+	// GetPaymentEvents retrieves the history of a payment, oldest first.
+	GetPaymentEvents(ctx context.Context, paymentId string) ([]models.PaymentEvent, error)
+
+	// CancelPayment expires the checkout session of a pending payment and cancels it,
+	// returns constants.ErrDbConflict if the payment is not pending.
+	CancelPayment(ctx context.Context, payment models.Payment, actorUserId uint32) (models.Payment, bool, error)
+
+	// ExpirePayment expires the checkout session of a pending payment that was never paid.
+	ExpirePayment(ctx context.Context, payment models.Payment) (models.Payment, bool, error)
+
+	// RecordSubscriptionPayment records a charge of the subscription by its gateway invoice, paid or
+	// failed. Recording it again updates it, but a paid charge is never recorded as failed.
+	RecordSubscriptionPayment(ctx context.Context, sub models.Subscription, invoice payments.Invoice, paid bool) error
+
+	// GetOrganizationPayments retrieves a page of the subscription charges of an organization,
+	// newest first, and their total count.
+	GetOrganizationPayments(ctx context.Context, orgId string, offset int, limit int) ([]models.SubscriptionPayment, int64, error)
+
+	// GetPendingPayments retrieves up to limit pending payments created before createdBefore that are due
+	// a check against the gateway, the ones checked least recently first.
+	GetPendingPayments(ctx context.Context, createdBefore time.Time, limit int) ([]models.Payment, error)
+
+	// RecordPaymentReconciliation records a check of a pending payment against the gateway, backing off
+	// its next check if it failed. Returns how many checks of the payment have failed.
+	RecordPaymentReconciliation(ctx context.Context, paymentId string, failed bool) (int64, error)
+
+	// RefundPayment refunds ammount of a payment at the gateway, returns constants.ErrDbConflict
+	// if it is more than what can still be refunded.
+	RefundPayment(ctx context.Context, payment models.Payment, ammount int64, reason payments.RefundReason, actorUserId uint32) (models.Payment, bool, error)
+
+	// SetPaymentRefunded applies the total refunded at the gateway to its payment.
+	SetPaymentRefunded(ctx context.Context, refund payments.Refund) (models.Payment, bool, error)
+
+	// SetPaymentDispute applies the state of a gateway dispute to its payment.
+	SetPaymentDispute(ctx context.Context, dispute payments.Dispute) (models.Payment, bool, error)
+
+	// ParseWebhookEvent verifies the gateway signature in the headers and parses the payload,
+	// returns constants.ErrAuth if the signature does not match.
+	ParseWebhookEvent(payload []byte, header http.Header) (payments.Event, error)
+
+	// ClaimWebhookEvent claims the event to be handled, returns false if it already was processed or
+	// is being handled. A claim not completed within constants.WebhookClaimTimeout can be taken over.
+	ClaimWebhookEvent(ctx context.Context, eventId string, eventType string) (bool, error)
+
+	// CompleteWebhookEvent marks a claimed event as processed, once its handlers succeeded.
+	CompleteWebhookEvent(ctx context.Context, eventId string) error
+
+	// SyncProduct creates or updates the gateway Product of a plan, returns its gateway ID.
+	SyncProduct(ctx context.Context, plan models.Plan) (string, error)
+
+	// SyncPrice creates the gateway Price of a price (or updates its active flag,
+	// as gateway Prices are immutable), returns its gateway ID.
+	SyncPrice(ctx context.Context, price models.Price, providerProductId string) (string, error)
+
+	// SyncCoupon creates the gateway Coupon of a coupon (or deletes it once inactive,
+	// as gateway Coupons are immutable), returns its gateway ID.
+	SyncCoupon(ctx context.Context, coupon models.Coupon) (string, error)
 
-	// SetCheckoutSessionAsComplete is the webhook to be used in a daemon
-	SetCheckoutSessionAsComplete(ctx context.Context, sessionId string) (models.Payment, error)
+	// ReleaseWebhookEvent removes the processed mark of an event, so a retry can process it again.
+	ReleaseWebhookEvent(ctx context.Context, eventId string) error
 
-	// // Gets the Stripe Client Secret to be used in Embedded Checkout Form
-	// 	//
-	// 	// Take a look at: https://docs.stripe.com/payments/accept-a-payment?platform=web&ui=stripe-hosted
-	// 	GetClientSecret(ctx context.Context, currencyUnit stripe.Currency, unitAmmount int64, planName string) (string, error)
+	// ReportMeteredUsage reports the closed hourly usage buckets of organizations
+	// subscribed to metered prices, and marks those of past periods as skipped.
+	// This method should be called periodically.
+	ReportMeteredUsage() error
 }
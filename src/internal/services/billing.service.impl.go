@@ -4,19 +4,27 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/LombardiDaniel/goliath/src/internal/models"
 	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/payments"
 	"github.com/LombardiDaniel/goliath/src/pkg/validators"
-	"github.com/stripe/stripe-go/v81"
-	"github.com/stripe/stripe-go/v81/checkout/session"
+	"github.com/lib/pq"
 )
 
-type BillingServiceStripeImpl struct {
+type BillingServicePgImpl struct {
 	db            *sql.DB
+	gateway       payments.Gateway
 	appSuccessUrl string
 	appCancelUrl  string
+	appBillingUrl string
 }
 
 // https://www.youtube.com/watch?v=M4aCgy67f243
@@ -24,7 +32,7 @@ type BillingServiceStripeImpl struct {
 // https://docs.stripe.com/payments/accept-a-payment?platform=web&ui=stripe-hosted
 // https://www.youtube.com/watch?v=ePmEVBu8w6Y
 
-func NewBillingService(db *sql.DB, stripeApiKey string) BillingService {
+func NewBillingServicePgImpl(db *sql.DB, gateway payments.Gateway) BillingService {
 	successUrl, err := url.JoinPath(constants.AppHostUrl, "/billing/success")
 	if err != nil {
 		panic(err)
@@ -35,16 +43,38 @@ func NewBillingService(db *sql.DB, stripeApiKey string) BillingService {
 		panic(err)
 	}
 
-	stripe.Key = stripeApiKey
+	billingUrl, err := url.JoinPath(constants.AppHostUrl, "/billing")
+	if err != nil {
+		panic(err)
+	}
 
-	return &BillingServiceStripeImpl{
+	return &BillingServicePgImpl{
 		db:            db,
+		gateway:       gateway,
 		appSuccessUrl: successUrl,
 		appCancelUrl:  cancelUrl,
+		appBillingUrl: billingUrl,
 	}
 }
 
-func (s *BillingServiceStripeImpl) CheckoutURL(ctx context.Context, currencyUnit stripe.Currency, unitAmmount int64, planName string, userId uint32) (string, error) {
+func (s *BillingServicePgImpl) CheckoutURL(ctx context.Context, plan models.Plan, price models.Price, userId uint32, coupon *models.Coupon) (string, error) {
+	couponId, providerCouponId, err := checkoutCoupon(coupon)
+	if err != nil {
+		return "", err
+	}
+
+	var discount int64
+	var couponCode *string
+	if coupon != nil {
+		discount = coupon.Discount(price.UnitAmmount)
+		couponCode = &coupon.Code
+	}
+
+	customer, err := s.GetUserCustomer(ctx, userId)
+	if err != nil {
+		return "", err
+	}
+
 	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{})
 	if err != nil {
 		return "", errors.Join(err, constants.ErrDbTransactionCreate)
@@ -54,111 +84,1325 @@ func (s *BillingServiceStripeImpl) CheckoutURL(ctx context.Context, currencyUnit
 	var paymentId string
 	err = tx.QueryRowContext(ctx, `
 		INSERT INTO payments
-			(user_id, unit_ammount, unit_currency)
+			(user_id, price_id, unit_ammount, unit_currency, coupon_id, discount_ammount)
 		VALUES
-			($1, $2, LOWER($3))
+			($1, $2, $3, LOWER($4), $5, $6)
 		RETURNING payment_id;
 		`,
 		userId,
-		unitAmmount,
-		string(currencyUnit),
+		price.PriceId,
+		price.UnitAmmount-discount,
+		price.UnitCurrency,
+		couponId,
+		discount,
 	).Scan(&paymentId)
 	if err != nil {
 		return "", errors.Join(err, validators.FilterSqlPgError(err))
 	}
 
-	params := &stripe.CheckoutSessionParams{
-		ClientReferenceID: stripe.String(paymentId),
-		Mode:              stripe.String(string(stripe.CheckoutSessionModePayment)),
-		LineItems: []*stripe.CheckoutSessionLineItemParams{
-			{
-				PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
-					Currency: stripe.String(string(currencyUnit)),
-					ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
-						Name: stripe.String(planName),
-					},
-					UnitAmount: stripe.Int64(unitAmmount),
-				},
-				Quantity: stripe.Int64(1),
-			},
-		},
-		SuccessURL: &s.appSuccessUrl,
-		CancelURL:  &s.appCancelUrl,
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO payment_events
+			(payment_id, event_type, ammount, details, actor_user_id)
+		VALUES
+			($1, $2, $3, $4, $5);
+		`,
+		paymentId,
+		models.CreatedPaymentEvent,
+		price.UnitAmmount-discount,
+		couponCode,
+		userId,
+	)
+	if err != nil {
+		return "", errors.Join(err, validators.FilterSqlPgError(err))
 	}
 
-	checkout, err := session.New(params)
+	checkout, err := s.gateway.CreateCheckoutSession(ctx, payments.CheckoutParams{
+		Mode:             payments.PaymentMode,
+		ReferenceId:      paymentId,
+		CustomerId:       &customer.ProviderCustomerId,
+		LineItem:         lineItem(plan, price, 1),
+		SuccessUrl:       s.appSuccessUrl,
+		CancelUrl:        s.appCancelUrl,
+		ProviderCouponId: providerCouponId,
+	})
 	if err != nil {
-		return "", errors.Join(err, errors.New("could not create stripe session"))
+		return "", err
 	}
 
 	_, err = tx.ExecContext(ctx, `
 		UPDATE payments
-		SET
-			stripe_checkout_session_id = $1,
-			completed_at = NOW()
+		SET provider_checkout_session_id = $1
 		WHERE payment_id = $2;
 		`,
-		checkout.ID,
+		checkout.Id,
 		paymentId,
 	)
 	if err != nil {
 		return "", errors.Join(err, validators.FilterSqlPgError(err))
 	}
 
-	return checkout.URL, tx.Commit()
+	return checkout.Url, tx.Commit()
+}
+
+func (s *BillingServicePgImpl) SubscriptionCheckoutURL(ctx context.Context, plan models.Plan, price models.Price, orgId string, seats int64, coupon *models.Coupon) (string, error) {
+	couponId, providerCouponId, err := checkoutCoupon(coupon)
+	if err != nil {
+		return "", err
+	}
+
+	// metered charges are only known at the end of the period, the discount is of the licensed ones
+	var discount int64
+	if coupon != nil && price.Meter == nil {
+		discount = coupon.Discount(price.UnitAmmount * seats)
+	}
+
+	customer, err := s.GetOrganizationCustomer(ctx, orgId)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return "", errors.Join(err, constants.ErrDbTransactionCreate)
+	}
+	defer tx.Rollback()
+
+	// checkouts that were abandoned never got a gateway subscription
+	_, err = tx.ExecContext(ctx, `
+		UPDATE subscriptions
+		SET subscription_status = 'incomplete_expired'
+		WHERE
+			organization_id = $1 AND
+			subscription_status = 'incomplete' AND
+			provider_subscription_id IS NULL;
+		`,
+		orgId,
+	)
+	if err != nil {
+		return "", errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	// trials are only offered to organizations that never had one
+	var trialDays int64
+	if price.TrialDays > 0 {
+		var trialed bool
+		err = tx.QueryRowContext(ctx, `
+			SELECT EXISTS (
+				SELECT 1
+				FROM subscriptions
+				WHERE
+					organization_id = $1 AND
+					trial_end IS NOT NULL
+			);
+			`,
+			orgId,
+		).Scan(&trialed)
+		if err != nil {
+			return "", errors.Join(err, validators.FilterSqlPgError(err))
+		}
+		if !trialed {
+			trialDays = price.TrialDays
+		}
+	}
+
+	var subscriptionId string
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO subscriptions
+			(organization_id, plan_id, price_id, seats, coupon_id, discount_ammount)
+		VALUES
+			($1, $2, $3, $4, $5, $6)
+		RETURNING subscription_id;
+		`,
+		orgId,
+		plan.PlanId,
+		price.PriceId,
+		seats,
+		couponId,
+		discount,
+	).Scan(&subscriptionId)
+	if err != nil {
+		return "", errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	checkout, err := s.gateway.CreateCheckoutSession(ctx, payments.CheckoutParams{
+		Mode:        payments.SubscriptionMode,
+		ReferenceId: subscriptionId,
+		CustomerId:  &customer.ProviderCustomerId,
+		LineItem:    lineItem(plan, price, seats),
+		Metadata: map[string]string{
+			"subscription_id": subscriptionId,
+			"organization_id": orgId,
+		},
+		SuccessUrl:       s.appSuccessUrl,
+		CancelUrl:        s.appCancelUrl,
+		ProviderCouponId: providerCouponId,
+		TrialDays:        trialDays,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE subscriptions
+		SET provider_checkout_session_id = $1
+		WHERE subscription_id = $2;
+		`,
+		checkout.Id,
+		subscriptionId,
+	)
+	if err != nil {
+		return "", errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return checkout.Url, tx.Commit()
+}
+
+const billingCustomerColumns = `
+	billing_customer_id,
+	user_id,
+	organization_id,
+	provider_customer_id,
+	created_at
+`
+
+func scanBillingCustomer(row rowScanner) (models.BillingCustomer, error) {
+	c := models.BillingCustomer{}
+	err := row.Scan(
+		&c.BillingCustomerId,
+		&c.UserId,
+		&c.OrganizationId,
+		&c.ProviderCustomerId,
+		&c.CreatedAt,
+	)
+	return c, errors.Join(err, validators.FilterSqlPgError(err))
 }
 
-func (s *BillingServiceStripeImpl) CheckoutSession(ctx context.Context, sessionId string) (*stripe.CheckoutSession, error) {
-	checkout, err := session.Get(sessionId, nil)
-	return checkout, errors.Join(err, errors.New("could not get session from stripe"))
+// getCustomerBy retrieves the customer where keyColumn = key, keyColumn is never user input.
+func (s *BillingServicePgImpl) getCustomerBy(ctx context.Context, keyColumn string, key any) (models.BillingCustomer, error) {
+	return scanBillingCustomer(s.db.QueryRowContext(ctx, `
+		SELECT `+billingCustomerColumns+`
+		FROM billing_customers
+		WHERE `+keyColumn+` = $1;
+		`,
+		key,
+	))
 }
 
-func (s *BillingServiceStripeImpl) SetCheckoutSessionAsComplete(ctx context.Context, sessionId string) (models.Payment, error) {
-	var p models.Payment
-	err := s.db.QueryRowContext(ctx, `
-		UPDATE payments
-		SET payment_status = 'complete'
-		WHERE stripe_checkout_session_id = $1
-		RETURNING *;
+// saveCustomer links the gateway customer to the owner where keyColumn = key, keeping
+// the customer of a concurrent checkout that linked one first.
+func (s *BillingServicePgImpl) saveCustomer(ctx context.Context, keyColumn string, key any, providerCustomerId string) (models.BillingCustomer, error) {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO billing_customers
+			(`+keyColumn+`, provider_customer_id)
+		VALUES
+			($1, $2)
+		ON CONFLICT DO NOTHING;
+		`,
+		key,
+		providerCustomerId,
+	)
+	if err != nil {
+		return models.BillingCustomer{}, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return s.getCustomerBy(ctx, keyColumn, key)
+}
+
+func (s *BillingServicePgImpl) GetUserCustomer(ctx context.Context, userId uint32) (models.BillingCustomer, error) {
+	customer, err := s.getCustomerBy(ctx, "user_id", userId)
+	if !errors.Is(err, constants.ErrNoRows) {
+		return customer, err
+	}
+
+	var email, firstName, lastName string
+	err = s.db.QueryRowContext(ctx, `
+		SELECT email, first_name, last_name
+		FROM users
+		WHERE user_id = $1;
+		`,
+		userId,
+	).Scan(&email, &firstName, &lastName)
+	if err != nil {
+		return customer, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	// the gateway returns the same customer to retries of the same key
+	providerCustomerId, err := s.gateway.CreateCustomer(ctx, payments.CustomerParams{
+		ReferenceId:    fmt.Sprintf("user-%d", userId),
+		Email:          email,
+		Name:           firstName + " " + lastName,
+		IdempotencyKey: fmt.Sprintf("customer-user-%d", userId),
+	})
+	if err != nil {
+		return customer, err
+	}
+
+	return s.saveCustomer(ctx, "user_id", userId, providerCustomerId)
+}
+
+func (s *BillingServicePgImpl) GetOrganizationCustomer(ctx context.Context, orgId string) (models.BillingCustomer, error) {
+	customer, err := s.FindOrganizationCustomer(ctx, orgId)
+	if !errors.Is(err, constants.ErrNoRows) {
+		return customer, err
+	}
+
+	var orgName, ownerEmail string
+	err = s.db.QueryRowContext(ctx, `
+		SELECT o.organization_name, u.email
+		FROM organizations o
+		INNER JOIN users u ON u.user_id = o.owner_user_id
+		WHERE o.organization_id = $1;
+		`,
+		orgId,
+	).Scan(&orgName, &ownerEmail)
+	if err != nil {
+		return customer, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	providerCustomerId, err := s.gateway.CreateCustomer(ctx, payments.CustomerParams{
+		ReferenceId:    "organization-" + orgId,
+		Email:          ownerEmail,
+		Name:           orgName,
+		IdempotencyKey: "customer-organization-" + orgId,
+	})
+	if err != nil {
+		return customer, err
+	}
+
+	return s.saveCustomer(ctx, "organization_id", orgId, providerCustomerId)
+}
+
+func (s *BillingServicePgImpl) FindOrganizationCustomer(ctx context.Context, orgId string) (models.BillingCustomer, error) {
+	customer, err := s.getCustomerBy(ctx, "organization_id", orgId)
+	if !errors.Is(err, constants.ErrNoRows) {
+		return customer, err
+	}
+
+	// organizations that subscribed before customers were kept reuse the one of their subscription
+	var providerCustomerId string
+	err = s.db.QueryRowContext(ctx, `
+		SELECT provider_customer_id
+		FROM subscriptions
+		WHERE
+			organization_id = $1 AND
+			provider_customer_id IS NOT NULL
+		ORDER BY created_at DESC
+		LIMIT 1;
+		`,
+		orgId,
+	).Scan(&providerCustomerId)
+	if err != nil {
+		return customer, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return s.saveCustomer(ctx, "organization_id", orgId, providerCustomerId)
+}
+
+func (s *BillingServicePgImpl) PortalURL(ctx context.Context, customer models.BillingCustomer) (string, error) {
+	return s.gateway.CreatePortalSession(ctx, customer.ProviderCustomerId, s.appBillingUrl)
+}
+
+func (s *BillingServicePgImpl) GetPaymentMethods(ctx context.Context, customer models.BillingCustomer) ([]payments.PaymentMethod, error) {
+	return s.gateway.ListPaymentMethods(ctx, customer.ProviderCustomerId)
+}
+
+func (s *BillingServicePgImpl) SetDefaultPaymentMethod(ctx context.Context, customer models.BillingCustomer, paymentMethodId string) error {
+	methods, err := s.gateway.ListPaymentMethods(ctx, customer.ProviderCustomerId)
+	if err != nil {
+		return err
+	}
+
+	found := slices.ContainsFunc(methods, func(pm payments.PaymentMethod) bool {
+		return pm.Id == paymentMethodId
+	})
+	if !found {
+		return errors.Join(constants.ErrNoRows, fmt.Errorf("payment method '%s' is not of customer '%s'", paymentMethodId, customer.ProviderCustomerId))
+	}
+
+	// only the subscription of the organization is changed, the customer may have older ones
+	var subscriptionId *string
+	if customer.OrganizationId != nil {
+		sub, err := s.GetOrganizationSubscription(ctx, *customer.OrganizationId)
+		if err != nil && !errors.Is(err, constants.ErrNoRows) {
+			return err
+		}
+		if err == nil && sub.ProviderCustomerId != nil && *sub.ProviderCustomerId == customer.ProviderCustomerId {
+			subscriptionId = sub.ProviderSubscriptionId
+		}
+	}
+
+	return s.gateway.SetDefaultPaymentMethod(ctx, customer.ProviderCustomerId, paymentMethodId, subscriptionId)
+}
+
+// checkoutCoupon returns the ids of the coupon applied to a checkout, if any,
+// coupons are applied by the gateway so they must exist there.
+func checkoutCoupon(coupon *models.Coupon) (*uint32, *string, error) {
+	if coupon == nil {
+		return nil, nil, nil
+	}
+	if coupon.ProviderCouponId == nil {
+		return nil, nil, errors.Join(constants.ErrDbConflict, errors.New("coupon must exist at the payment gateway"))
+	}
+	return &coupon.CouponId, coupon.ProviderCouponId, nil
+}
+
+// lineItem builds the checkout item of a price, using the provider price once it is synced.
+func lineItem(plan models.Plan, price models.Price, quantity int64) payments.LineItem {
+	item := payments.LineItem{
+		ProviderPriceId: price.ProviderPriceId,
+		Name:            plan.PlanName,
+		Currency:        price.UnitCurrency,
+		UnitAmount:      price.UnitAmmount,
+	}
+	if price.Interval != models.OneTimeInterval {
+		item.Interval = string(price.Interval)
+	}
+	if price.Meter == nil {
+		// metered prices are charged by usage, not by quantity
+		item.Quantity = &quantity
+	}
+	return item
+}
+
+const subscriptionColumns = `
+	subscription_id,
+	organization_id,
+	plan_id,
+	price_id,
+	seats,
+	subscription_status,
+	provider_subscription_id,
+	provider_subscription_item_id,
+	provider_customer_id,
+	current_period_end,
+	trial_end,
+	cancel_at_period_end,
+	coupon_id,
+	discount_ammount,
+	created_at,
+	updated_at
+`
+
+func scanSubscription(row *sql.Row) (models.Subscription, error) {
+	sub := models.Subscription{}
+	err := row.Scan(
+		&sub.SubscriptionId,
+		&sub.OrganizationId,
+		&sub.PlanId,
+		&sub.PriceId,
+		&sub.Seats,
+		&sub.Status,
+		&sub.ProviderSubscriptionId,
+		&sub.ProviderSubscriptionItemId,
+		&sub.ProviderCustomerId,
+		&sub.CurrentPeriodEnd,
+		&sub.TrialEnd,
+		&sub.CancelAtPeriodEnd,
+		&sub.CouponId,
+		&sub.DiscountAmmount,
+		&sub.CreatedAt,
+		&sub.UpdatedAt,
+	)
+	return sub, errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+func (s *BillingServicePgImpl) GetOrganizationSubscription(ctx context.Context, orgId string) (models.Subscription, error) {
+	return scanSubscription(s.db.QueryRowContext(ctx, `
+		SELECT `+subscriptionColumns+`
+		FROM subscriptions
+		WHERE
+			organization_id = $1 AND
+			subscription_status NOT IN ('canceled', 'incomplete_expired');
+		`,
+		orgId,
+	))
+}
+
+func (s *BillingServicePgImpl) SyncSubscription(ctx context.Context, providerSubscriptionId string) (models.Subscription, error) {
+	providerSub, err := s.gateway.GetSubscription(ctx, providerSubscriptionId)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+
+	subscriptionId, ok := providerSub.Metadata["subscription_id"]
+	if !ok {
+		return models.Subscription{}, fmt.Errorf("subscription '%s' has no subscription_id metadata", providerSubscriptionId)
+	}
+
+	var seats int64 = 1
+	if providerSub.Quantity > 0 { // metered items have no quantity
+		seats = providerSub.Quantity
+	}
+
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return models.Subscription{}, errors.Join(err, constants.ErrDbTransactionCreate)
+	}
+	defer tx.Rollback()
+
+	// plan changes made at the gateway are only tracked if the new price is in the catalog
+	var priceId, planId *uint32
+	if providerSub.ProviderPriceId != nil {
+		err = tx.QueryRowContext(ctx, `
+			SELECT price_id, plan_id
+			FROM prices
+			WHERE provider_price_id = $1;
+			`,
+			*providerSub.ProviderPriceId,
+		).Scan(&priceId, &planId)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return models.Subscription{}, errors.Join(err, validators.FilterSqlPgError(err))
+		}
+	}
+
+	sub, err := scanSubscription(tx.QueryRowContext(ctx, `
+		UPDATE subscriptions
+		SET
+			provider_subscription_id = $1,
+			provider_subscription_item_id = $2,
+			provider_customer_id = $3,
+			subscription_status = $4,
+			seats = $5,
+			current_period_end = $6,
+			cancel_at_period_end = $7,
+			price_id = COALESCE($8, price_id),
+			plan_id = COALESCE($9, plan_id),
+			trial_end = $11
+		WHERE subscription_id = $10
+		RETURNING `+subscriptionColumns+`;
+		`,
+		providerSub.Id,
+		providerSub.ItemId,
+		providerSub.CustomerId,
+		providerSub.Status,
+		seats,
+		providerSub.CurrentPeriodEnd,
+		providerSub.CancelAtPeriodEnd,
+		priceId,
+		planId,
+		subscriptionId,
+		providerSub.TrialEnd,
+	))
+	if err != nil {
+		return sub, err
+	}
+
+	var billingPlanId *uint32
+	if sub.Status.GrantsPlan() {
+		billingPlanId = &sub.PlanId
+	}
+
+	// a canceled subscription must not take the plan of the one that replaced it
+	_, err = tx.ExecContext(ctx, `
+		UPDATE organizations
+		SET billing_plan_id = $1
+		WHERE organization_id = $2
+		AND NOT EXISTS (
+			SELECT 1 FROM subscriptions
+			WHERE organization_id = $2
+			AND subscription_id <> $3
+			AND subscription_status NOT IN ('canceled', 'incomplete_expired')
+		);
 		`,
-		sessionId,
-	).Scan(
+		billingPlanId,
+		sub.OrganizationId,
+		sub.SubscriptionId,
+	)
+	if err != nil {
+		return sub, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return sub, tx.Commit()
+}
+
+func (s *BillingServicePgImpl) ChangeSubscription(ctx context.Context, sub models.Subscription, price models.Price, seats int64) (models.Subscription, error) {
+	if sub.ProviderSubscriptionId == nil || price.ProviderPriceId == nil {
+		return sub, errors.Join(constants.ErrDbConflict, errors.New("subscription and price must exist at the payment gateway"))
+	}
+
+	_, err := s.gateway.ChangeSubscription(ctx, *sub.ProviderSubscriptionId, *price.ProviderPriceId, seats)
+	if err != nil {
+		return sub, err
+	}
+
+	return s.SyncSubscription(ctx, *sub.ProviderSubscriptionId)
+}
+
+func (s *BillingServicePgImpl) CancelSubscription(ctx context.Context, sub models.Subscription) (models.Subscription, error) {
+	if sub.ProviderSubscriptionId == nil {
+		return sub, errors.Join(constants.ErrDbConflict, errors.New("subscription must exist at the payment gateway"))
+	}
+
+	_, err := s.gateway.CancelSubscriptionAtPeriodEnd(ctx, *sub.ProviderSubscriptionId)
+	if err != nil {
+		return sub, err
+	}
+
+	return s.SyncSubscription(ctx, *sub.ProviderSubscriptionId)
+}
+
+func (s *BillingServicePgImpl) CheckoutSession(ctx context.Context, sessionId string) (payments.CheckoutSession, error) {
+	return s.gateway.GetCheckoutSession(ctx, sessionId)
+}
+
+const paymentColumns = `
+	payment_id,
+	user_id,
+	price_id,
+	unit_ammount,
+	unit_currency,
+	payment_status,
+	refunded_ammount,
+	coupon_id,
+	discount_ammount,
+	provider_checkout_session_id,
+	provider_payment_intent_id,
+	created_at,
+	completed_at
+`
+
+func scanPayment(row rowScanner) (models.Payment, error) {
+	p := models.Payment{}
+	err := row.Scan(
 		&p.PaymentId,
 		&p.UserId,
+		&p.PriceId,
 		&p.UnitAmmount,
 		&p.UnitCurrency,
 		&p.PaymentStatus,
-		&p.StripeCheckoutSessionId,
+		&p.RefundedAmmount,
+		&p.CouponId,
+		&p.DiscountAmmount,
+		&p.ProviderCheckoutSessionId,
+		&p.ProviderPaymentIntentId,
 		&p.CreatedAt,
 		&p.CompletedAt,
 	)
-
 	return p, errors.Join(err, validators.FilterSqlPgError(err))
 }
 
-// func (s *BillingServiceStripeImpl) GetClientSecret(ctx context.Context, currencyUnit stripe.Currency, unitAmmount int64, planName string) (string, error) {
-// 	panic("not impl")
-// 	params := &stripe.CheckoutSessionParams{
-// 		Mode:   stripe.String(string(stripe.CheckoutSessionModePayment)),
-// 		UIMode: stripe.String("embedded"),
-// 		LineItems: []*stripe.CheckoutSessionLineItemParams{
-// 			{
-// 				PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
-// 					Currency: stripe.String(string(currencyUnit)),
-// 					ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
-// 						Name: stripe.String(planName),
-// 					},
-// 					UnitAmount: stripe.Int64(unitAmmount),
-// 				},
-// 				Quantity: stripe.Int64(1),
-// 			},
-// 		},
-// 		ReturnURL: stripe.String("https://example.com/checkout/return?session_id={CHECKOUT_SESSION_ID}"),
-// 	}
-
-// 	checkout, err := session.New(params)
-// 	if err != nil {
-// 		return "", err
-// 	}
-// 	return checkout.URL, nil
-// }
+func (s *BillingServicePgImpl) GetUserPayments(ctx context.Context, userId uint32, offset int, limit int) ([]models.Payment, int64, error) {
+	userPayments := []models.Payment{}
+
+	var total int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM payments
+		WHERE
+			user_id = $1 AND
+			provider_checkout_session_id IS NOT NULL;
+		`,
+		userId,
+	).Scan(&total)
+	if err != nil {
+		return userPayments, 0, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+paymentColumns+`
+		FROM payments
+		WHERE
+			user_id = $1 AND
+			provider_checkout_session_id IS NOT NULL
+		ORDER BY created_at DESC
+		OFFSET $2
+		LIMIT $3;
+		`,
+		userId,
+		offset,
+		limit,
+	)
+	if err != nil {
+		return userPayments, 0, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return userPayments, 0, err
+		}
+		userPayments = append(userPayments, p)
+	}
+
+	return userPayments, total, rows.Err()
+}
+
+func (s *BillingServicePgImpl) RecordSubscriptionPayment(ctx context.Context, sub models.Subscription, invoice payments.Invoice, paid bool) error {
+	status := models.SubscriptionPaymentFailed
+	ammount := invoice.AmountDue
+	var paidAt *time.Time
+	if paid {
+		now := time.Now()
+		status = models.SubscriptionPaymentPaid
+		ammount = invoice.AmountPaid
+		paidAt = &now
+	}
+
+	// events come out of order, a late failure does not undo a payment
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO subscription_payments
+			(provider_invoice_id, organization_id, subscription_id, ammount, currency, payment_status, period_start, period_end, paid_at)
+		VALUES
+			($1, $2, $3, $4, LOWER($5), $6, $7, $8, $9)
+		ON CONFLICT (provider_invoice_id) DO UPDATE
+		SET
+			ammount = EXCLUDED.ammount,
+			payment_status = EXCLUDED.payment_status,
+			paid_at = EXCLUDED.paid_at
+		WHERE subscription_payments.payment_status <> 'paid';
+		`,
+		invoice.Id,
+		sub.OrganizationId,
+		sub.SubscriptionId,
+		ammount,
+		invoice.Currency,
+		status,
+		invoice.PeriodStart,
+		invoice.PeriodEnd,
+		paidAt,
+	)
+	return errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+func (s *BillingServicePgImpl) GetOrganizationPayments(ctx context.Context, orgId string, offset int, limit int) ([]models.SubscriptionPayment, int64, error) {
+	orgPayments := []models.SubscriptionPayment{}
+
+	var total int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM subscription_payments
+		WHERE organization_id = $1;
+		`,
+		orgId,
+	).Scan(&total)
+	if err != nil {
+		return orgPayments, 0, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			provider_invoice_id,
+			organization_id,
+			subscription_id,
+			ammount,
+			currency,
+			payment_status,
+			period_start,
+			period_end,
+			created_at,
+			paid_at
+		FROM subscription_payments
+		WHERE organization_id = $1
+		ORDER BY created_at DESC
+		OFFSET $2
+		LIMIT $3;
+		`,
+		orgId,
+		offset,
+		limit,
+	)
+	if err != nil {
+		return orgPayments, 0, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p := models.SubscriptionPayment{}
+		err := rows.Scan(
+			&p.ProviderInvoiceId,
+			&p.OrganizationId,
+			&p.SubscriptionId,
+			&p.Ammount,
+			&p.Currency,
+			&p.PaymentStatus,
+			&p.PeriodStart,
+			&p.PeriodEnd,
+			&p.CreatedAt,
+			&p.PaidAt,
+		)
+		if err != nil {
+			return orgPayments, 0, errors.Join(err, validators.FilterSqlPgError(err))
+		}
+		orgPayments = append(orgPayments, p)
+	}
+
+	return orgPayments, total, rows.Err()
+}
+
+func (s *BillingServicePgImpl) GetPayment(ctx context.Context, paymentId string) (models.Payment, error) {
+	return s.getPaymentBy(ctx, "payment_id", paymentId)
+}
+
+// getPaymentBy retrieves the payment where keyColumn = key, keyColumn is never user input.
+func (s *BillingServicePgImpl) getPaymentBy(ctx context.Context, keyColumn string, key any) (models.Payment, error) {
+	return scanPayment(s.db.QueryRowContext(ctx, `
+		SELECT `+paymentColumns+`
+		FROM payments
+		WHERE `+keyColumn+` = $1;
+		`,
+		key,
+	))
+}
+
+func (s *BillingServicePgImpl) GetPaymentEvents(ctx context.Context, paymentId string) ([]models.PaymentEvent, error) {
+	paymentEvents := []models.PaymentEvent{}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			payment_event_id,
+			payment_id,
+			event_type,
+			ammount,
+			details,
+			actor_user_id,
+			created_at
+		FROM payment_events
+		WHERE payment_id = $1
+		ORDER BY created_at, payment_event_id;
+		`,
+		paymentId,
+	)
+	if err != nil {
+		return paymentEvents, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e := models.PaymentEvent{}
+		err := rows.Scan(
+			&e.PaymentEventId,
+			&e.PaymentId,
+			&e.EventType,
+			&e.Ammount,
+			&e.Details,
+			&e.ActorUserId,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return paymentEvents, err
+		}
+		paymentEvents = append(paymentEvents, e)
+	}
+
+	return paymentEvents, rows.Err()
+}
+
+// paymentEvent is the history entry recorded by a payment transition, its type defaults to the new status.
+type paymentEvent struct {
+	eventType   string
+	ammount     *int64
+	details     *string
+	actorUserId *uint32
+}
+
+// transitionPayment moves the payment where keyColumn = key to status if it is in one of
+// the from statuses, recording the event. A nil status moves the payment back to the paid
+// status it had. Payments in other statuses are returned unchanged.
+func (s *BillingServicePgImpl) transitionPayment(
+	ctx context.Context,
+	keyColumn string,
+	key any,
+	from []models.PaymentStatus,
+	status *models.PaymentStatus,
+	providerPaymentId *string,
+	event paymentEvent,
+) (models.Payment, bool, error) {
+	var eventType *string
+	if event.eventType != "" {
+		eventType = &event.eventType
+	}
+
+	fromStatuses := make([]string, len(from))
+	for i, f := range from {
+		fromStatuses[i] = string(f)
+	}
+
+	p, err := scanPayment(s.db.QueryRowContext(ctx, `
+		WITH updated AS (
+			UPDATE payments
+			SET
+				payment_status = COALESCE($1::TEXT, CASE WHEN refunded_ammount > 0 THEN 'partially_refunded' ELSE 'complete' END),
+				completed_at = CASE WHEN $1::TEXT = 'complete' THEN NOW() ELSE completed_at END,
+				provider_payment_intent_id = COALESCE($2, provider_payment_intent_id)
+			WHERE
+				`+keyColumn+` = $3 AND
+				payment_status = ANY($4)
+			RETURNING `+paymentColumns+`
+		), recorded AS (
+			INSERT INTO payment_events
+				(payment_id, event_type, ammount, details, actor_user_id)
+			SELECT payment_id, COALESCE($5::TEXT, payment_status), $6::BIGINT, $7::TEXT, $8::INT
+			FROM updated
+		)
+		SELECT `+paymentColumns+`
+		FROM updated;
+		`,
+		status,
+		providerPaymentId,
+		key,
+		pq.Array(fromStatuses),
+		eventType,
+		event.ammount,
+		event.details,
+		event.actorUserId,
+	))
+	if errors.Is(err, constants.ErrNoRows) {
+		p, err = s.getPaymentBy(ctx, keyColumn, key)
+		return p, false, err
+	}
+
+	return p, err == nil, err
+}
+
+func (s *BillingServicePgImpl) SetCheckoutSessionAsComplete(ctx context.Context, checkoutSession payments.CheckoutSession) (models.Payment, bool, error) {
+	status := models.PaymentComplete
+	return s.transitionPayment(
+		ctx,
+		"provider_checkout_session_id",
+		checkoutSession.Id,
+		// the gateway charged, even if the payment was given up on meanwhile
+		[]models.PaymentStatus{models.PaymentPending, models.PaymentCanceled, models.PaymentExpired},
+		&status,
+		checkoutSession.PaymentId,
+		paymentEvent{},
+	)
+}
+
+func (s *BillingServicePgImpl) SetCheckoutSessionAsCanceled(ctx context.Context, sessionId string) (models.Payment, bool, error) {
+	status := models.PaymentCanceled
+	return s.transitionPayment(ctx, "provider_checkout_session_id", sessionId, []models.PaymentStatus{models.PaymentPending}, &status, nil, paymentEvent{})
+}
+
+func (s *BillingServicePgImpl) SetCheckoutSessionAsExpired(ctx context.Context, sessionId string) (models.Payment, bool, error) {
+	status := models.PaymentExpired
+	return s.transitionPayment(ctx, "provider_checkout_session_id", sessionId, []models.PaymentStatus{models.PaymentPending}, &status, nil, paymentEvent{})
+}
+
+func (s *BillingServicePgImpl) CancelPayment(ctx context.Context, payment models.Payment, actorUserId uint32) (models.Payment, bool, error) {
+	if payment.PaymentStatus != models.PaymentPending {
+		return payment, false, errors.Join(constants.ErrDbConflict, fmt.Errorf("payment is %s", payment.PaymentStatus))
+	}
+
+	if payment.ProviderCheckoutSessionId != "" {
+		_, err := s.gateway.ExpireCheckoutSession(ctx, payment.ProviderCheckoutSessionId)
+		if err != nil {
+			return payment, false, err
+		}
+	}
+
+	status := models.PaymentCanceled
+	return s.transitionPayment(ctx, "payment_id", payment.PaymentId, []models.PaymentStatus{models.PaymentPending}, &status, nil, paymentEvent{
+		actorUserId: &actorUserId,
+	})
+}
+
+func (s *BillingServicePgImpl) ExpirePayment(ctx context.Context, payment models.Payment) (models.Payment, bool, error) {
+	_, err := s.gateway.ExpireCheckoutSession(ctx, payment.ProviderCheckoutSessionId)
+	if err != nil {
+		return payment, false, err
+	}
+
+	status := models.PaymentExpired
+	return s.transitionPayment(ctx, "payment_id", payment.PaymentId, []models.PaymentStatus{models.PaymentPending}, &status, nil, paymentEvent{})
+}
+
+func (s *BillingServicePgImpl) GetPendingPayments(ctx context.Context, createdBefore time.Time, limit int) ([]models.Payment, error) {
+	pending := []models.Payment{}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+paymentColumns+`
+		FROM payments
+		WHERE
+			payment_status = 'pending' AND
+			provider_checkout_session_id IS NOT NULL AND
+			created_at < $1 AND
+			reconcile_attempts < $3 AND
+			(next_reconcile_at IS NULL OR next_reconcile_at <= NOW())
+		ORDER BY last_reconciled_at NULLS FIRST, created_at
+		LIMIT $2;
+		`,
+		createdBefore,
+		limit,
+		constants.PaymentReconcileMaxAttempts,
+	)
+	if err != nil {
+		return pending, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return pending, err
+		}
+		pending = append(pending, p)
+	}
+
+	return pending, rows.Err()
+}
+
+func (s *BillingServicePgImpl) RecordPaymentReconciliation(ctx context.Context, paymentId string, failed bool) (int64, error) {
+	var attempts int64
+
+	// the backoff doubles from PaymentReconcileGrace with every failed check
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE payments
+		SET
+			last_reconciled_at = NOW(),
+			reconcile_attempts = reconcile_attempts + CASE WHEN $2 THEN 1 ELSE 0 END,
+			next_reconcile_at = CASE
+				WHEN $2 THEN NOW() + make_interval(secs => $3 * POWER(2, LEAST(reconcile_attempts, 16)))
+				ELSE NULL
+			END
+		WHERE payment_id = $1
+		RETURNING reconcile_attempts;
+		`,
+		paymentId,
+		failed,
+		constants.PaymentReconcileGrace.Seconds(),
+	).Scan(&attempts)
+	if err != nil {
+		return 0, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return attempts, nil
+}
+
+func (s *BillingServicePgImpl) RefundPayment(ctx context.Context, payment models.Payment, ammount int64, reason payments.RefundReason, actorUserId uint32) (models.Payment, bool, error) {
+	if ammount <= 0 || ammount > payment.Refundable() {
+		return payment, false, errors.Join(constants.ErrDbConflict, fmt.Errorf("only %d of the payment can be refunded", payment.Refundable()))
+	}
+	if payment.ProviderPaymentIntentId == nil {
+		return payment, false, errors.Join(constants.ErrDbConflict, errors.New("payment has no gateway payment to refund"))
+	}
+
+	// a retry of the same refund from the same state reuses the key, so it is not refunded twice
+	_, err := s.gateway.Refund(ctx, payments.RefundParams{
+		ProviderPaymentId: *payment.ProviderPaymentIntentId,
+		Amount:            ammount,
+		Reason:            reason,
+		IdempotencyKey:    fmt.Sprintf("refund-%s-%d-%d", payment.PaymentId, payment.RefundedAmmount, ammount),
+	})
+	if err != nil {
+		return payment, false, err
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO payment_events
+			(payment_id, event_type, ammount, details, actor_user_id)
+		VALUES
+			($1, $2, $3, $4, $5);
+		`,
+		payment.PaymentId,
+		models.RefundRequestedPaymentEvent,
+		ammount,
+		reason,
+		actorUserId,
+	)
+	if err != nil {
+		return payment, false, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return s.applyRefundedAmmount(ctx, *payment.ProviderPaymentIntentId, payment.RefundedAmmount+ammount)
+}
+
+func (s *BillingServicePgImpl) SetPaymentRefunded(ctx context.Context, refund payments.Refund) (models.Payment, bool, error) {
+	return s.applyRefundedAmmount(ctx, refund.ProviderPaymentId, refund.AmountRefunded)
+}
+
+// applyRefundedAmmount raises the refunded ammount of a payment to total, both the refund
+// request and its webhook apply it, whichever comes first changes the payment.
+func (s *BillingServicePgImpl) applyRefundedAmmount(ctx context.Context, providerPaymentId string, total int64) (models.Payment, bool, error) {
+	p, err := scanPayment(s.db.QueryRowContext(ctx, `
+		WITH previous AS (
+			SELECT payment_id, refunded_ammount
+			FROM payments
+			WHERE provider_payment_intent_id = $1
+		), updated AS (
+			UPDATE payments
+			SET
+				refunded_ammount = $2,
+				payment_status = CASE WHEN $2 >= unit_ammount THEN 'refunded' ELSE 'partially_refunded' END
+			WHERE
+				provider_payment_intent_id = $1 AND
+				refunded_ammount < $2 AND
+				payment_status IN ('complete', 'partially_refunded')
+			RETURNING `+paymentColumns+`
+		), recorded AS (
+			INSERT INTO payment_events
+				(payment_id, event_type, ammount)
+			SELECT u.payment_id, u.payment_status, u.refunded_ammount - p.refunded_ammount
+			FROM updated u
+			INNER JOIN previous p ON p.payment_id = u.payment_id
+		)
+		SELECT `+paymentColumns+`
+		FROM updated;
+		`,
+		providerPaymentId,
+		total,
+	))
+	if errors.Is(err, constants.ErrNoRows) {
+		p, err = s.getPaymentBy(ctx, "provider_payment_intent_id", providerPaymentId)
+		return p, false, err
+	}
+
+	return p, err == nil, err
+}
+
+func (s *BillingServicePgImpl) SetPaymentDispute(ctx context.Context, dispute payments.Dispute) (models.Payment, bool, error) {
+	event := paymentEvent{
+		ammount: &dispute.Amount,
+		details: &dispute.Reason,
+	}
+
+	switch dispute.Status {
+	case payments.DisputeOpen:
+		status := models.PaymentDisputed
+		return s.transitionPayment(ctx, "provider_payment_intent_id", dispute.ProviderPaymentId, []models.PaymentStatus{models.PaymentComplete, models.PaymentPartiallyRefunded}, &status, nil, event)
+	case payments.DisputeLost:
+		status := models.PaymentDisputeLost
+		return s.transitionPayment(ctx, "provider_payment_intent_id", dispute.ProviderPaymentId, []models.PaymentStatus{models.PaymentDisputed}, &status, nil, event)
+	default:
+		event.eventType = models.DisputeWonPaymentEvent
+		return s.transitionPayment(ctx, "provider_payment_intent_id", dispute.ProviderPaymentId, []models.PaymentStatus{models.PaymentDisputed}, nil, nil, event)
+	}
+}
+
+func (s *BillingServicePgImpl) ParseWebhookEvent(payload []byte, header http.Header) (payments.Event, error) {
+	event, err := s.gateway.ParseWebhookEvent(payload, header)
+	if err != nil {
+		return event, errors.Join(err, constants.ErrAuth)
+	}
+
+	return event, nil
+}
+
+func (s *BillingServicePgImpl) ClaimWebhookEvent(ctx context.Context, eventId string, eventType string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO processed_webhook_events (event_id, event_type)
+		VALUES ($1, $2)
+		ON CONFLICT (event_id) DO UPDATE
+		SET claimed_at = NOW()
+		WHERE processed_webhook_events.processed_at IS NULL
+			AND processed_webhook_events.claimed_at < NOW() - make_interval(secs => $3);
+		`,
+		eventId,
+		eventType,
+		constants.WebhookClaimTimeout.Seconds(),
+	)
+	if err != nil {
+		return false, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+func (s *BillingServicePgImpl) CompleteWebhookEvent(ctx context.Context, eventId string) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE processed_webhook_events
+		SET processed_at = NOW()
+		WHERE event_id = $1;
+		`,
+		eventId,
+	)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return errIfNoRowsAffected(res)
+}
+
+func (s *BillingServicePgImpl) SyncProduct(ctx context.Context, plan models.Plan) (string, error) {
+	return s.gateway.SyncProduct(ctx, payments.Product{
+		ReferenceId: strconv.Itoa(int(plan.PlanId)),
+		ProviderId:  plan.ProviderProductId,
+		Name:        plan.PlanName,
+		Active:      plan.IsActive,
+	})
+}
+
+func (s *BillingServicePgImpl) SyncPrice(ctx context.Context, p models.Price, providerProductId string) (string, error) {
+	gatewayPrice := payments.Price{
+		ReferenceId: strconv.Itoa(int(p.PriceId)),
+		ProviderId:  p.ProviderPriceId,
+		Currency:    p.UnitCurrency,
+		UnitAmount:  p.UnitAmmount,
+		Active:      p.IsActive,
+	}
+	if p.Interval != models.OneTimeInterval {
+		gatewayPrice.Interval = string(p.Interval)
+	}
+	if p.Meter != nil {
+		meter := string(*p.Meter)
+		gatewayPrice.Meter = &meter
+		gatewayPrice.MeterGauge = p.Meter.IsGauge()
+	}
+
+	return s.gateway.SyncPrice(ctx, gatewayPrice, providerProductId)
+}
+
+func (s *BillingServicePgImpl) SyncCoupon(ctx context.Context, c models.Coupon) (string, error) {
+	gatewayCoupon := payments.Coupon{
+		ReferenceId:    strconv.Itoa(int(c.CouponId)),
+		ProviderId:     c.ProviderCouponId,
+		Name:           c.Code,
+		PercentOff:     c.PercentOff,
+		AmountOff:      c.AmmountOff,
+		Duration:       payments.CouponDuration(c.Duration),
+		MaxRedemptions: c.MaxRedemptions,
+		RedeemBy:       c.ExpiresAt,
+		Active:         c.IsActive,
+	}
+	if c.Currency != nil {
+		gatewayCoupon.Currency = *c.Currency
+	}
+
+	return s.gateway.SyncCoupon(ctx, gatewayCoupon)
+}
+
+func (s *BillingServicePgImpl) ReportMeteredUsage() error {
+	ctx := context.Background()
+
+	// the gateway only takes usage of the current period, buckets of past periods are lost
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE usage_records u
+		SET skipped_at = NOW()
+		FROM subscriptions s
+		INNER JOIN prices p ON p.price_id = s.price_id
+		WHERE
+			s.organization_id = u.organization_id AND
+			s.subscription_status IN ('trialing', 'active', 'past_due') AND
+			s.provider_subscription_item_id IS NOT NULL AND
+			p.meter = u.meter AND
+			u.quantity > u.reported_quantity AND
+			u.skipped_at IS NULL AND
+			u.period_start < s.current_period_end - ('1 ' || p.billing_interval)::INTERVAL;
+		`,
+	)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	// buckets are only reported once closed, leaving time for the last flush of usage,
+	// and usage flushed into a bucket after it was reported is reported on its own
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			u.organization_id,
+			u.meter,
+			u.period_start,
+			u.quantity,
+			u.reported_quantity,
+			s.provider_subscription_item_id
+		FROM usage_records u
+		INNER JOIN subscriptions s ON
+			s.organization_id = u.organization_id AND
+			s.subscription_status IN ('trialing', 'active', 'past_due') AND
+			s.provider_subscription_item_id IS NOT NULL
+		INNER JOIN prices p ON
+			p.price_id = s.price_id AND
+			p.meter = u.meter
+		WHERE
+			u.quantity > u.reported_quantity AND
+			u.skipped_at IS NULL AND
+			u.period_start < NOW() - INTERVAL '65 minutes' AND
+			(
+				s.current_period_end IS NULL OR (
+					u.period_start >= s.current_period_end - ('1 ' || p.billing_interval)::INTERVAL AND
+					u.period_start < s.current_period_end
+				)
+			)
+		ORDER BY u.period_start;
+		`,
+	)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	type pendingRecord struct {
+		record           models.UsageRecord
+		reportedQuantity int64
+		itemId           string
+	}
+	pending := []pendingRecord{}
+	for rows.Next() {
+		p := pendingRecord{}
+		err := rows.Scan(
+			&p.record.OrganizationId,
+			&p.record.Meter,
+			&p.record.PeriodStart,
+			&p.record.Quantity,
+			&p.reportedQuantity,
+			&p.itemId,
+		)
+		if err != nil {
+			rows.Close()
+			return errors.Join(err, validators.FilterSqlPgError(err))
+		}
+		pending = append(pending, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	// a bucket that fails is left for the next run, it must not hold back the others
+	for _, p := range pending {
+		err := s.gateway.ReportUsage(ctx, payments.UsageReport{
+			SubscriptionItemId: p.itemId,
+			Quantity:           p.record.Quantity - p.reportedQuantity,
+			Ts:                 p.record.PeriodStart,
+			// retries after a failed update must not be counted twice by the gateway
+			IdempotencyKey: fmt.Sprintf(
+				"usage-%s-%s-%d-%d-%d",
+				p.record.OrganizationId,
+				p.record.Meter,
+				p.record.PeriodStart.Unix(),
+				p.reportedQuantity,
+				p.record.Quantity,
+			),
+		})
+		if err != nil {
+			slog.Error(fmt.Sprintf("could not report %s usage of organization %s at %s: %s", p.record.Meter, p.record.OrganizationId, p.record.PeriodStart.Format(constants.TimestampStrFormat), err.Error()))
+			continue
+		}
+
+		// flushes since the bucket was read are left for the next run
+		_, err = s.db.ExecContext(ctx, `
+			UPDATE usage_records
+			SET
+				reported_quantity = $4,
+				reported_at = NOW()
+			WHERE
+				organization_id = $1 AND
+				meter = $2 AND
+				period_start = $3;
+			`,
+			p.record.OrganizationId,
+			p.record.Meter,
+			p.record.PeriodStart,
+			p.record.Quantity,
+		)
+		if err != nil {
+			err = errors.Join(err, validators.FilterSqlPgError(err))
+			slog.Error(fmt.Sprintf("could not mark %s usage of organization %s at %s as reported: %s", p.record.Meter, p.record.OrganizationId, p.record.PeriodStart.Format(constants.TimestampStrFormat), err.Error()))
+		}
+	}
+
+	return nil
+}
+
+func (s *BillingServicePgImpl) ReleaseWebhookEvent(ctx context.Context, eventId string) error {
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM processed_webhook_events
+		WHERE event_id = $1;
+		`,
+		eventId,
+	)
+	return errors.Join(err, validators.FilterSqlPgError(err))
+}
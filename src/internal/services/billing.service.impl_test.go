@@ -0,0 +1,369 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/helpers"
+	"github.com/LombardiDaniel/goliath/src/pkg/payments"
+)
+
+func TestBillingServicePgImpl_ReportMeteredUsage(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := helpers.NewPostgresContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %s", err)
+		}
+	})
+
+	stripeServer := helpers.NewFakeStripeServer()
+	t.Cleanup(stripeServer.Close)
+	stripeServer.Respond("POST", "/v1/subscription_items/si_test/usage_records", `{"id": "mbur_test", "object": "usage_record"}`)
+
+	db := pgContainer.DB
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO users (user_id, email, password_hash, first_name, last_name)
+		VALUES (1, 'owner@email.com', 'hashtest', 'Test', 'Owner');
+
+		INSERT INTO plans (plan_id, plan_name) VALUES (1, 'metered');
+		INSERT INTO prices (price_id, plan_id, unit_ammount, unit_currency, billing_interval, meter, provider_price_id)
+		VALUES (1, 1, 1, 'usd', 'month', 'api_calls', 'price_test');
+
+		INSERT INTO organizations (organization_id, organization_name, owner_user_id, billing_plan_id)
+		VALUES ('ORG01', 'org', 1, 1);
+
+		INSERT INTO subscriptions (organization_id, plan_id, price_id, subscription_status, provider_subscription_id, provider_subscription_item_id, current_period_end)
+		VALUES ('ORG01', 1, 1, 'active', 'sub_test', 'si_test', NOW() + INTERVAL '10 days');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closedHour := time.Now().Add(-2 * time.Hour).Truncate(time.Hour)
+	currHour := time.Now().Truncate(time.Hour)
+	pastPeriodHour := time.Now().Add(-40 * 24 * time.Hour).Truncate(time.Hour)
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO usage_records (organization_id, meter, period_start, quantity)
+		VALUES
+			('ORG01', 'api_calls', $1, 42),
+			('ORG01', 'api_calls', $2, 7),
+			('ORG01', 'storage_bytes', $1, 1024),
+			('ORG01', 'api_calls', $3, 5);
+		`,
+		closedHour,
+		currHour,
+		pastPeriodHour,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &BillingServicePgImpl{
+		db:      db,
+		gateway: payments.NewStripeGateway("sk_test_fake", "", stripeServer.Backends()),
+	}
+
+	if err := s.ReportMeteredUsage(); err != nil {
+		t.Fatalf("BillingServicePgImpl.ReportMeteredUsage() error = %v", err)
+	}
+
+	reqs := stripeServer.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("expected 1 usage record reported, got %d", len(reqs))
+	}
+	if reqs[0].Form.Get("quantity") != "42" {
+		t.Errorf("expected quantity 42, got %s", reqs[0].Form.Get("quantity"))
+	}
+	if reqs[0].IdempotencyKey == "" {
+		t.Errorf("expected usage record to be reported with an idempotency key")
+	}
+
+	var unreported int
+	err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM usage_records WHERE reported_at IS NULL AND skipped_at IS NULL;`).Scan(&unreported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unreported != 2 {
+		t.Errorf("expected the open bucket and the unmetered meter to stay unreported, got %d", unreported)
+	}
+
+	// the gateway no longer takes usage of past periods
+	var skipped bool
+	err = db.QueryRowContext(ctx, `SELECT skipped_at IS NOT NULL FROM usage_records WHERE period_start = $1;`, pastPeriodHour).Scan(&skipped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !skipped {
+		t.Errorf("expected the bucket of a past period to be skipped")
+	}
+
+	if err := s.ReportMeteredUsage(); err != nil {
+		t.Fatalf("BillingServicePgImpl.ReportMeteredUsage() error = %v", err)
+	}
+	if len(stripeServer.Requests()) != 1 {
+		t.Errorf("expected reported buckets not to be reported again")
+	}
+
+	// usage flushed into a bucket once reported is reported on its own
+	_, err = db.ExecContext(ctx, `
+		UPDATE usage_records
+		SET quantity = quantity + 8
+		WHERE meter = 'api_calls' AND period_start = $1;
+		`,
+		closedHour,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReportMeteredUsage(); err != nil {
+		t.Fatalf("BillingServicePgImpl.ReportMeteredUsage() error = %v", err)
+	}
+	reqs = stripeServer.Requests()
+	if len(reqs) != 2 || reqs[1].Form.Get("quantity") != "8" {
+		t.Fatalf("expected the late usage reported as an increment of 8, got %+v", reqs)
+	}
+	if reqs[1].IdempotencyKey == reqs[0].IdempotencyKey {
+		t.Errorf("expected the late usage to be reported with its own idempotency key")
+	}
+}
+
+func TestBillingServicePgImpl_ClaimWebhookEvent(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := helpers.NewPostgresContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %s", err)
+		}
+	})
+
+	s := &BillingServicePgImpl{db: pgContainer.DB}
+
+	claim := func(eventId string, want bool) {
+		t.Helper()
+		claimed, err := s.ClaimWebhookEvent(ctx, eventId, "checkout.session.completed")
+		if err != nil {
+			t.Fatalf("BillingServicePgImpl.ClaimWebhookEvent() error = %v", err)
+		}
+		if claimed != want {
+			t.Errorf("BillingServicePgImpl.ClaimWebhookEvent(%s) = %v, want %v", eventId, claimed, want)
+		}
+	}
+
+	claim("evt_done", true)
+	claim("evt_done", false)
+	if err := s.CompleteWebhookEvent(ctx, "evt_done"); err != nil {
+		t.Fatalf("BillingServicePgImpl.CompleteWebhookEvent() error = %v", err)
+	}
+	claim("evt_done", false)
+
+	// a claim abandoned past its timeout, as by a crash while handling it, is taken over
+	claim("evt_crashed", true)
+	_, err = pgContainer.DB.ExecContext(ctx, `
+		UPDATE processed_webhook_events
+		SET claimed_at = NOW() - make_interval(secs => $1)
+		WHERE event_id = 'evt_crashed';
+		`,
+		(constants.WebhookClaimTimeout + time.Minute).Seconds(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claim("evt_crashed", true)
+	claim("evt_crashed", false)
+
+	if err := s.CompleteWebhookEvent(ctx, "evt_unknown"); !errors.Is(err, constants.ErrNoRows) {
+		t.Errorf("expected completing an unclaimed event to fail with ErrNoRows, got %v", err)
+	}
+}
+
+func TestBillingServicePgImpl_GetPendingPayments(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := helpers.NewPostgresContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %s", err)
+		}
+	})
+
+	const (
+		failing = "00000000-0000-0000-0000-000000000001"
+		checked = "00000000-0000-0000-0000-000000000002"
+		fresh   = "00000000-0000-0000-0000-000000000003"
+	)
+
+	db := pgContainer.DB
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO users (user_id, email, password_hash, first_name, last_name)
+		VALUES (1, 'user@email.com', 'hashtest', 'Test', 'User');
+
+		INSERT INTO payments (payment_id, user_id, unit_ammount, unit_currency, provider_checkout_session_id, created_at)
+		VALUES
+			($1, 1, 100, 'usd', 'cs_1', NOW() - INTERVAL '3 hours'),
+			($2, 1, 100, 'usd', 'cs_2', NOW() - INTERVAL '2 hours'),
+			($3, 1, 100, 'usd', 'cs_3', NOW() - INTERVAL '1 hour');
+		`,
+		failing,
+		checked,
+		fresh,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &BillingServicePgImpl{db: db}
+
+	pending := func(want ...string) {
+		t.Helper()
+		got, err := s.GetPendingPayments(ctx, time.Now(), 100)
+		if err != nil {
+			t.Fatalf("BillingServicePgImpl.GetPendingPayments() error = %v", err)
+		}
+		ids := []string{}
+		for _, p := range got {
+			ids = append(ids, p.PaymentId)
+		}
+		if len(ids) != len(want) {
+			t.Fatalf("BillingServicePgImpl.GetPendingPayments() = %v, want %v", ids, want)
+		}
+		for i := range want {
+			if ids[i] != want[i] {
+				t.Fatalf("BillingServicePgImpl.GetPendingPayments() = %v, want %v", ids, want)
+			}
+		}
+	}
+
+	record := func(paymentId string, failed bool, want int64) {
+		t.Helper()
+		attempts, err := s.RecordPaymentReconciliation(ctx, paymentId, failed)
+		if err != nil {
+			t.Fatalf("BillingServicePgImpl.RecordPaymentReconciliation() error = %v", err)
+		}
+		if attempts != want {
+			t.Errorf("BillingServicePgImpl.RecordPaymentReconciliation(%s) = %d, want %d", paymentId, attempts, want)
+		}
+	}
+
+	pending(failing, checked, fresh)
+
+	// a failed check backs the payment off, a successful one sends it behind the unchecked ones
+	record(failing, true, 1)
+	record(checked, false, 0)
+	pending(fresh, checked)
+
+	// once the backoff passes it is due again, until it runs out of attempts
+	_, err = db.ExecContext(ctx, `UPDATE payments SET next_reconcile_at = NOW() - INTERVAL '1 minute' WHERE payment_id = $1;`, failing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending(fresh, failing, checked)
+
+	_, err = db.ExecContext(ctx, `UPDATE payments SET reconcile_attempts = $2 WHERE payment_id = $1;`, failing, constants.PaymentReconcileMaxAttempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending(fresh, checked)
+}
+
+func TestBillingServicePgImpl_SyncSubscription(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := helpers.NewPostgresContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %s", err)
+		}
+	})
+
+	oldSubscriptionId := "00000000-0000-0000-0000-000000000001"
+	newSubscriptionId := "00000000-0000-0000-0000-000000000002"
+
+	stripeServer := helpers.NewFakeStripeServer()
+	t.Cleanup(stripeServer.Close)
+	stripeServer.Respond("GET", "/v1/subscriptions/sub_old", `{
+		"id": "sub_old", "object": "subscription", "status": "canceled",
+		"metadata": {"subscription_id": "`+oldSubscriptionId+`"},
+		"items": {"object": "list", "data": [{"id": "si_old", "quantity": 1, "price": {"id": "price_basic"}}]}
+	}`)
+	stripeServer.Respond("GET", "/v1/subscriptions/sub_new", `{
+		"id": "sub_new", "object": "subscription", "status": "canceled",
+		"metadata": {"subscription_id": "`+newSubscriptionId+`"},
+		"items": {"object": "list", "data": [{"id": "si_new", "quantity": 1, "price": {"id": "price_pro"}}]}
+	}`)
+
+	db := pgContainer.DB
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO users (user_id, email, password_hash, first_name, last_name)
+		VALUES (1, 'owner@email.com', 'hashtest', 'Test', 'Owner');
+
+		INSERT INTO plans (plan_id, plan_name) VALUES (1, 'basic'), (2, 'pro');
+		INSERT INTO prices (price_id, plan_id, unit_ammount, unit_currency, billing_interval, provider_price_id)
+		VALUES (1, 1, 100, 'usd', 'month', 'price_basic'), (2, 2, 200, 'usd', 'month', 'price_pro');
+
+		INSERT INTO organizations (organization_id, organization_name, owner_user_id, billing_plan_id)
+		VALUES ('ORG01', 'org', 1, 2);
+
+		INSERT INTO subscriptions (subscription_id, organization_id, plan_id, price_id, subscription_status, provider_subscription_id)
+		VALUES
+			($1, 'ORG01', 1, 1, 'canceled', 'sub_old'),
+			($2, 'ORG01', 2, 2, 'active', 'sub_new');
+		`,
+		oldSubscriptionId,
+		newSubscriptionId,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &BillingServicePgImpl{
+		db:      db,
+		gateway: payments.NewStripeGateway("sk_test_fake", "", stripeServer.Backends()),
+	}
+
+	billingPlanId := func() *uint32 {
+		t.Helper()
+		var planId *uint32
+		err := db.QueryRowContext(ctx, `SELECT billing_plan_id FROM organizations WHERE organization_id = 'ORG01';`).Scan(&planId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return planId
+	}
+
+	// a late sync of the replaced subscription leaves the plan of the live one
+	sub, err := s.SyncSubscription(ctx, "sub_old")
+	if err != nil || sub.SubscriptionId != oldSubscriptionId {
+		t.Fatalf("BillingServicePgImpl.SyncSubscription() = %+v, %v", sub, err)
+	}
+	if planId := billingPlanId(); planId == nil || *planId != 2 {
+		t.Errorf("expected the organization to keep the plan of its live subscription, got %v", planId)
+	}
+
+	// canceling the live subscription drops the plan
+	sub, err = s.SyncSubscription(ctx, "sub_new")
+	if err != nil || sub.Status != models.SubscriptionCanceled {
+		t.Fatalf("BillingServicePgImpl.SyncSubscription() = %+v, %v", sub, err)
+	}
+	if planId := billingPlanId(); planId != nil {
+		t.Errorf("expected the organization without a plan, got %d", *planId)
+	}
+}
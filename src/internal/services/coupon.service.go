@@ -0,0 +1,42 @@
+package services
+
+import (
+	"context"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+)
+
+// CouponService defines the interface for discount coupons, from their
+// catalog to the redemptions made at checkout.
+type CouponService interface {
+	// CreateCoupon creates a coupon, its code is stored uppercase, and returns its ID.
+	CreateCoupon(ctx context.Context, coupon models.Coupon) (uint32, error)
+
+	// GetCoupon retrieves a coupon by its ID.
+	GetCoupon(ctx context.Context, couponId uint32) (models.Coupon, error)
+
+	// GetCoupons retrieves a page of the coupons, newest first, and their total count.
+	GetCoupons(ctx context.Context, offset int, limit int) ([]models.Coupon, int64, error)
+
+	// SetCouponActive activates or deactivates a coupon, coupons are otherwise immutable.
+	SetCouponActive(ctx context.Context, couponId uint32, isActive bool) error
+
+	// SetCouponProviderCouponId links a coupon to its gateway Coupon, nil once it is deleted at the gateway.
+	SetCouponProviderCouponId(ctx context.Context, couponId uint32, providerCouponId *string) error
+
+	// GetRedeemableCoupon retrieves the coupon of a code, case insensitive, returns
+	// constants.ErrCouponNotApplicable if it cannot be redeemed on price now.
+	GetRedeemableCoupon(ctx context.Context, code string, price models.Price) (models.Coupon, error)
+
+	// RedeemForPayment records the redemption of the coupon of a completed payment, once.
+	RedeemForPayment(ctx context.Context, payment models.Payment) error
+
+	// RedeemForSubscription records the redemption of the coupon of a subscription checkout, once.
+	RedeemForSubscription(ctx context.Context, sub models.Subscription) error
+
+	// GetCouponRedemptions retrieves a page of the redemptions of a coupon, newest first, and their total count.
+	GetCouponRedemptions(ctx context.Context, couponId uint32, offset int, limit int) ([]models.CouponRedemption, int64, error)
+
+	// GetCouponsReport totals the redemptions of every redeemed coupon, per currency.
+	GetCouponsReport(ctx context.Context) ([]models.CouponReport, error)
+}
@@ -0,0 +1,350 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/validators"
+)
+
+type CouponServicePgImpl struct {
+	db *sql.DB
+}
+
+func NewCouponServicePgImpl(db *sql.DB) CouponService {
+	return &CouponServicePgImpl{
+		db: db,
+	}
+}
+
+func (s *CouponServicePgImpl) CreateCoupon(ctx context.Context, coupon models.Coupon) (uint32, error) {
+	planIds, err := json.Marshal(coupon.PlanIds)
+	if err != nil {
+		return 0, errors.Join(err, errors.New("could not marshal coupon plan ids"))
+	}
+
+	var couponId uint32
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO coupons
+			(code, percent_off, ammount_off, currency, duration, plan_ids, max_redemptions, expires_at)
+		VALUES
+			(UPPER($1), $2, $3, LOWER($4), $5, $6, $7, $8)
+		RETURNING coupon_id;
+		`,
+		coupon.Code,
+		coupon.PercentOff,
+		coupon.AmmountOff,
+		coupon.Currency,
+		coupon.Duration,
+		planIds,
+		coupon.MaxRedemptions,
+		coupon.ExpiresAt,
+	).Scan(&couponId)
+
+	return couponId, errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+const couponColumns = `
+	coupon_id,
+	code,
+	percent_off,
+	ammount_off,
+	LOWER(currency),
+	duration,
+	plan_ids,
+	max_redemptions,
+	times_redeemed,
+	expires_at,
+	is_active,
+	provider_coupon_id,
+	created_at
+`
+
+func scanCoupon(row rowScanner) (models.Coupon, error) {
+	c := models.Coupon{}
+	var planIds []byte
+	err := row.Scan(
+		&c.CouponId,
+		&c.Code,
+		&c.PercentOff,
+		&c.AmmountOff,
+		&c.Currency,
+		&c.Duration,
+		&planIds,
+		&c.MaxRedemptions,
+		&c.TimesRedeemed,
+		&c.ExpiresAt,
+		&c.IsActive,
+		&c.ProviderCouponId,
+		&c.CreatedAt,
+	)
+	if err != nil {
+		return c, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	err = json.Unmarshal(planIds, &c.PlanIds)
+	if err != nil {
+		return c, errors.Join(err, errors.New("could not unmarshal coupon plan ids"))
+	}
+
+	return c, nil
+}
+
+func (s *CouponServicePgImpl) GetCoupon(ctx context.Context, couponId uint32) (models.Coupon, error) {
+	return scanCoupon(s.db.QueryRowContext(ctx, `
+		SELECT `+couponColumns+`
+		FROM coupons
+		WHERE coupon_id = $1;
+		`,
+		couponId,
+	))
+}
+
+func (s *CouponServicePgImpl) GetCoupons(ctx context.Context, offset int, limit int) ([]models.Coupon, int64, error) {
+	coupons := []models.Coupon{}
+
+	var total int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM coupons;
+		`,
+	).Scan(&total)
+	if err != nil {
+		return coupons, 0, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+couponColumns+`
+		FROM coupons
+		ORDER BY created_at DESC
+		OFFSET $1
+		LIMIT $2;
+		`,
+		offset,
+		limit,
+	)
+	if err != nil {
+		return coupons, 0, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanCoupon(rows)
+		if err != nil {
+			return coupons, 0, err
+		}
+		coupons = append(coupons, c)
+	}
+
+	return coupons, total, rows.Err()
+}
+
+func (s *CouponServicePgImpl) SetCouponActive(ctx context.Context, couponId uint32, isActive bool) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE coupons
+		SET is_active = $1
+		WHERE coupon_id = $2;
+		`,
+		isActive,
+		couponId,
+	)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return errIfNoRowsAffected(res)
+}
+
+func (s *CouponServicePgImpl) SetCouponProviderCouponId(ctx context.Context, couponId uint32, providerCouponId *string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE coupons
+		SET provider_coupon_id = $1
+		WHERE coupon_id = $2;
+		`,
+		providerCouponId,
+		couponId,
+	)
+	return errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+func (s *CouponServicePgImpl) GetRedeemableCoupon(ctx context.Context, code string, price models.Price) (models.Coupon, error) {
+	coupon, err := scanCoupon(s.db.QueryRowContext(ctx, `
+		SELECT `+couponColumns+`
+		FROM coupons
+		WHERE code = UPPER($1);
+		`,
+		code,
+	))
+	if err != nil {
+		return coupon, err
+	}
+
+	if !coupon.AppliesTo(price, time.Now()) {
+		return coupon, errors.Join(constants.ErrCouponNotApplicable, fmt.Errorf("coupon '%s' does not apply to price %d", coupon.Code, price.PriceId))
+	}
+
+	return coupon, nil
+}
+
+func (s *CouponServicePgImpl) RedeemForPayment(ctx context.Context, payment models.Payment) error {
+	if payment.CouponId == nil {
+		return nil
+	}
+
+	// the gateway already applied the discount, so it is recorded even past max_redemptions
+	_, err := s.db.ExecContext(ctx, `
+		WITH redeemed AS (
+			INSERT INTO coupon_redemptions
+				(coupon_id, user_id, payment_id, discount_ammount, currency)
+			VALUES
+				($1, $2, $3, $4, LOWER($5))
+			ON CONFLICT (payment_id) DO NOTHING
+			RETURNING coupon_id
+		)
+		UPDATE coupons
+		SET times_redeemed = times_redeemed + 1
+		WHERE coupon_id IN (SELECT coupon_id FROM redeemed);
+		`,
+		*payment.CouponId,
+		payment.UserId,
+		payment.PaymentId,
+		payment.DiscountAmmount,
+		payment.UnitCurrency,
+	)
+	return errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+func (s *CouponServicePgImpl) RedeemForSubscription(ctx context.Context, sub models.Subscription) error {
+	if sub.CouponId == nil {
+		return nil
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		WITH redeemed AS (
+			INSERT INTO coupon_redemptions
+				(coupon_id, organization_id, subscription_id, discount_ammount, currency)
+			SELECT s.coupon_id, s.organization_id, s.subscription_id, s.discount_ammount, LOWER(p.unit_currency)
+			FROM subscriptions s
+			INNER JOIN prices p ON p.price_id = s.price_id
+			WHERE
+				s.subscription_id = $1 AND
+				s.coupon_id IS NOT NULL
+			ON CONFLICT (subscription_id) DO NOTHING
+			RETURNING coupon_id
+		)
+		UPDATE coupons
+		SET times_redeemed = times_redeemed + 1
+		WHERE coupon_id IN (SELECT coupon_id FROM redeemed);
+		`,
+		sub.SubscriptionId,
+	)
+	return errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+func (s *CouponServicePgImpl) GetCouponRedemptions(ctx context.Context, couponId uint32, offset int, limit int) ([]models.CouponRedemption, int64, error) {
+	redemptions := []models.CouponRedemption{}
+
+	var total int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM coupon_redemptions
+		WHERE coupon_id = $1;
+		`,
+		couponId,
+	).Scan(&total)
+	if err != nil {
+		return redemptions, 0, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			coupon_redemption_id,
+			coupon_id,
+			user_id,
+			organization_id,
+			payment_id,
+			subscription_id,
+			discount_ammount,
+			LOWER(currency),
+			redeemed_at
+		FROM coupon_redemptions
+		WHERE coupon_id = $1
+		ORDER BY redeemed_at DESC
+		OFFSET $2
+		LIMIT $3;
+		`,
+		couponId,
+		offset,
+		limit,
+	)
+	if err != nil {
+		return redemptions, 0, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := models.CouponRedemption{}
+		err := rows.Scan(
+			&r.CouponRedemptionId,
+			&r.CouponId,
+			&r.UserId,
+			&r.OrganizationId,
+			&r.PaymentId,
+			&r.SubscriptionId,
+			&r.DiscountAmmount,
+			&r.Currency,
+			&r.RedeemedAt,
+		)
+		if err != nil {
+			return redemptions, 0, errors.Join(err, validators.FilterSqlPgError(err))
+		}
+		redemptions = append(redemptions, r)
+	}
+
+	return redemptions, total, rows.Err()
+}
+
+func (s *CouponServicePgImpl) GetCouponsReport(ctx context.Context) ([]models.CouponReport, error) {
+	report := []models.CouponReport{}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			c.coupon_id,
+			c.code,
+			LOWER(r.currency),
+			COUNT(*),
+			SUM(r.discount_ammount)
+		FROM coupons c
+		INNER JOIN coupon_redemptions r ON r.coupon_id = c.coupon_id
+		GROUP BY c.coupon_id, c.code, LOWER(r.currency)
+		ORDER BY c.coupon_id, LOWER(r.currency);
+		`,
+	)
+	if err != nil {
+		return report, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := models.CouponReport{}
+		err := rows.Scan(
+			&r.CouponId,
+			&r.Code,
+			&r.Currency,
+			&r.Redemptions,
+			&r.DiscountAmmount,
+		)
+		if err != nil {
+			return report, errors.Join(err, validators.FilterSqlPgError(err))
+		}
+		report = append(report, r)
+	}
+
+	return report, rows.Err()
+}
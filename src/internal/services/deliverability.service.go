@@ -0,0 +1,31 @@
+package services
+
+import (
+	"context"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/mail"
+)
+
+// DeliverabilityService defines the interface for tracking what email providers report about
+// the addresses emails are sent to, and for the suppression list built from it.
+type DeliverabilityService interface {
+	// RecordEvent applies a delivery event to its recipients, suppressing the addresses that
+	// bounced for good, complained or bounced too many times in a row. Each event is applied
+	// once, by ID, and events older than the last one applied to an address are ignored.
+	RecordEvent(ctx context.Context, event mail.DeliveryEvent) error
+
+	// IsSuppressed reports whether emails to the address are not sent.
+	IsSuppressed(ctx context.Context, email string) (bool, error)
+
+	// GetDeliverability retrieves the deliverability of an address, models.DeliverabilityUnknown
+	// if nothing was reported on it.
+	GetDeliverability(ctx context.Context, email string) (models.EmailDeliverability, error)
+
+	// GetSuppressions retrieves a page of the suppressed addresses, most recent first, and their total count.
+	GetSuppressions(ctx context.Context, offset int, limit int) ([]models.EmailDeliverability, int64, error)
+
+	// RemoveSuppression lets emails be sent to the address again, returns constants.ErrNoRows
+	// if it is not suppressed.
+	RemoveSuppression(ctx context.Context, email string) error
+}
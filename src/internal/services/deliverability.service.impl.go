@@ -0,0 +1,264 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/mail"
+	"github.com/LombardiDaniel/goliath/src/pkg/validators"
+)
+
+type DeliverabilityServicePgImpl struct {
+	db *sql.DB
+}
+
+func NewDeliverabilityServicePgImpl(db *sql.DB) DeliverabilityService {
+	return &DeliverabilityServicePgImpl{
+		db: db,
+	}
+}
+
+const deliverabilityColumns = `
+	email,
+	email_status,
+	soft_bounces,
+	last_reason,
+	last_event_at,
+	suppressed_at
+`
+
+func scanDeliverability(row rowScanner) (models.EmailDeliverability, error) {
+	d := models.EmailDeliverability{}
+	err := row.Scan(
+		&d.Email,
+		&d.Status,
+		&d.SoftBounces,
+		&d.LastReason,
+		&d.LastEventAt,
+		&d.SuppressedAt,
+	)
+	return d, errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+// normalizeEmail is how addresses are keyed, providers do not keep the case they were sent with.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func (s *DeliverabilityServicePgImpl) RecordEvent(ctx context.Context, event mail.DeliveryEvent) error {
+	switch event.Type {
+	case mail.DeliveredEvent, mail.BouncedEvent, mail.ComplainedEvent:
+	default:
+		return nil
+	}
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return errors.Join(err, constants.ErrDbTransactionCreate)
+	}
+	defer tx.Rollback()
+
+	// providers retry deliveries, the event is claimed along its changes; the prefix keeps
+	// email provider IDs apart from the payment gateway ones
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO processed_webhook_events (event_id, event_type, processed_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (event_id) DO NOTHING;
+		`,
+		"email:"+event.Id,
+		"email."+string(event.Type),
+	)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+	err = errIfNoRowsAffected(res)
+	if errors.Is(err, constants.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, to := range event.Recipients {
+		email := normalizeEmail(to)
+
+		d, err := scanDeliverability(tx.QueryRowContext(ctx, `
+			SELECT `+deliverabilityColumns+`
+			FROM email_deliverability
+			WHERE email = $1
+			FOR UPDATE;
+			`,
+			email,
+		))
+		if errors.Is(err, constants.ErrNoRows) {
+			d = models.EmailDeliverability{Email: email}
+		} else if err != nil {
+			return err
+		}
+
+		d, ok := applyDeliveryEvent(d, event)
+		if !ok {
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO email_deliverability (email, email_status, soft_bounces, last_reason, last_event_at, suppressed_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (email) DO UPDATE
+			SET
+				email_status = EXCLUDED.email_status,
+				soft_bounces = EXCLUDED.soft_bounces,
+				last_reason = EXCLUDED.last_reason,
+				last_event_at = EXCLUDED.last_event_at,
+				suppressed_at = EXCLUDED.suppressed_at;
+			`,
+			d.Email,
+			d.Status,
+			d.SoftBounces,
+			d.LastReason,
+			d.LastEventAt,
+			d.SuppressedAt,
+		)
+		if err != nil {
+			return errors.Join(err, validators.FilterSqlPgError(err))
+		}
+	}
+
+	return tx.Commit()
+}
+
+// applyDeliveryEvent returns the deliverability after the event, or false if the event is older
+// than the last one applied, as providers do not deliver events in order. Deliveries do not lift
+// suppressions, complaints in particular must stick until an admin removes them.
+func applyDeliveryEvent(d models.EmailDeliverability, event mail.DeliveryEvent) (models.EmailDeliverability, bool) {
+	if d.LastEventAt != nil && event.OccurredAt.Before(*d.LastEventAt) {
+		return d, false
+	}
+
+	switch event.Type {
+	case mail.DeliveredEvent:
+		d.Status = models.DeliverabilityDelivered
+		d.SoftBounces = 0
+	case mail.BouncedEvent:
+		if event.Permanent {
+			d.Status = models.DeliverabilityBounced
+		} else {
+			d.Status = models.DeliverabilitySoftBounced
+			d.SoftBounces++
+		}
+	case mail.ComplainedEvent:
+		d.Status = models.DeliverabilityComplained
+	}
+
+	d.LastEventAt = &event.OccurredAt
+	if event.Reason != "" {
+		d.LastReason = &event.Reason
+	}
+
+	suppress := d.Status == models.DeliverabilityBounced ||
+		d.Status == models.DeliverabilityComplained ||
+		d.SoftBounces >= constants.EmailSoftBounceLimit
+	if suppress && d.SuppressedAt == nil {
+		now := time.Now()
+		d.SuppressedAt = &now
+	}
+
+	return d, true
+}
+
+func (s *DeliverabilityServicePgImpl) IsSuppressed(ctx context.Context, email string) (bool, error) {
+	var suppressed bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM email_deliverability
+			WHERE
+				email = $1 AND
+				suppressed_at IS NOT NULL
+		);
+		`,
+		normalizeEmail(email),
+	).Scan(&suppressed)
+	return suppressed, errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+func (s *DeliverabilityServicePgImpl) GetDeliverability(ctx context.Context, email string) (models.EmailDeliverability, error) {
+	d, err := scanDeliverability(s.db.QueryRowContext(ctx, `
+		SELECT `+deliverabilityColumns+`
+		FROM email_deliverability
+		WHERE email = $1;
+		`,
+		normalizeEmail(email),
+	))
+	if errors.Is(err, constants.ErrNoRows) {
+		return models.EmailDeliverability{Email: normalizeEmail(email), Status: models.DeliverabilityUnknown}, nil
+	}
+	return d, err
+}
+
+func (s *DeliverabilityServicePgImpl) GetSuppressions(ctx context.Context, offset int, limit int) ([]models.EmailDeliverability, int64, error) {
+	suppressions := []models.EmailDeliverability{}
+
+	var total int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM email_deliverability
+		WHERE suppressed_at IS NOT NULL;
+	`).Scan(&total)
+	if err != nil {
+		return suppressions, 0, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+deliverabilityColumns+`
+		FROM email_deliverability
+		WHERE suppressed_at IS NOT NULL
+		ORDER BY suppressed_at DESC, email
+		OFFSET $1
+		LIMIT $2;
+		`,
+		offset,
+		limit,
+	)
+	if err != nil {
+		return suppressions, 0, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d, err := scanDeliverability(rows)
+		if err != nil {
+			return suppressions, 0, err
+		}
+		suppressions = append(suppressions, d)
+	}
+
+	return suppressions, total, rows.Err()
+}
+
+func (s *DeliverabilityServicePgImpl) RemoveSuppression(ctx context.Context, email string) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE email_deliverability
+		SET
+			suppressed_at = NULL,
+			soft_bounces = 0
+		WHERE
+			email = $1 AND
+			suppressed_at IS NOT NULL;
+		`,
+		normalizeEmail(email),
+	)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return errIfNoRowsAffected(res)
+}
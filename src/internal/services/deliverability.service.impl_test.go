@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/helpers"
+	"github.com/LombardiDaniel/goliath/src/pkg/mail"
+)
+
+func Test_applyDeliveryEvent(t *testing.T) {
+	now := time.Now()
+	earlier := now.Add(-time.Hour)
+	suppressedAt := now.Add(-24 * time.Hour)
+
+	delivered := mail.DeliveryEvent{Type: mail.DeliveredEvent, OccurredAt: now}
+	softBounce := mail.DeliveryEvent{Type: mail.BouncedEvent, Reason: "mailbox full", OccurredAt: now}
+	hardBounce := mail.DeliveryEvent{Type: mail.BouncedEvent, Permanent: true, Reason: "no such user", OccurredAt: now}
+	complaint := mail.DeliveryEvent{Type: mail.ComplainedEvent, OccurredAt: now}
+
+	tests := []struct {
+		name           string
+		d              models.EmailDeliverability
+		event          mail.DeliveryEvent
+		wantApplied    bool
+		wantStatus     models.DeliverabilityStatus
+		wantBounces    int64
+		wantSuppressed bool
+	}{
+		{
+			name:        "delivered resets soft bounces",
+			d:           models.EmailDeliverability{Status: models.DeliverabilitySoftBounced, SoftBounces: 2, LastEventAt: &earlier},
+			event:       delivered,
+			wantApplied: true,
+			wantStatus:  models.DeliverabilityDelivered,
+		},
+		{
+			name:        "soft bounce below the limit",
+			d:           models.EmailDeliverability{},
+			event:       softBounce,
+			wantApplied: true,
+			wantStatus:  models.DeliverabilitySoftBounced,
+			wantBounces: 1,
+		},
+		{
+			name:           "soft bounces in a row suppress",
+			d:              models.EmailDeliverability{Status: models.DeliverabilitySoftBounced, SoftBounces: constants.EmailSoftBounceLimit - 1},
+			event:          softBounce,
+			wantApplied:    true,
+			wantStatus:     models.DeliverabilitySoftBounced,
+			wantBounces:    constants.EmailSoftBounceLimit,
+			wantSuppressed: true,
+		},
+		{
+			name:           "permanent bounce suppresses",
+			d:              models.EmailDeliverability{Status: models.DeliverabilityDelivered},
+			event:          hardBounce,
+			wantApplied:    true,
+			wantStatus:     models.DeliverabilityBounced,
+			wantSuppressed: true,
+		},
+		{
+			name:           "complaint suppresses",
+			d:              models.EmailDeliverability{Status: models.DeliverabilityDelivered},
+			event:          complaint,
+			wantApplied:    true,
+			wantStatus:     models.DeliverabilityComplained,
+			wantSuppressed: true,
+		},
+		{
+			name:           "delivered keeps a complaint suppressed",
+			d:              models.EmailDeliverability{Status: models.DeliverabilityComplained, LastEventAt: &earlier, SuppressedAt: &suppressedAt},
+			event:          delivered,
+			wantApplied:    true,
+			wantStatus:     models.DeliverabilityDelivered,
+			wantSuppressed: true,
+		},
+		{
+			name:           "out of order event is ignored",
+			d:              models.EmailDeliverability{Status: models.DeliverabilityBounced, LastEventAt: &now, SuppressedAt: &suppressedAt},
+			event:          mail.DeliveryEvent{Type: mail.DeliveredEvent, OccurredAt: earlier},
+			wantApplied:    false,
+			wantStatus:     models.DeliverabilityBounced,
+			wantSuppressed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, applied := applyDeliveryEvent(tt.d, tt.event)
+			if applied != tt.wantApplied {
+				t.Fatalf("applyDeliveryEvent() applied = %v, want %v", applied, tt.wantApplied)
+			}
+			if got.Status != tt.wantStatus || got.SoftBounces != tt.wantBounces || (got.SuppressedAt != nil) != tt.wantSuppressed {
+				t.Errorf("applyDeliveryEvent() = %+v, want status %s, %d soft bounces, suppressed %v", got, tt.wantStatus, tt.wantBounces, tt.wantSuppressed)
+			}
+			if tt.d.SuppressedAt != nil && got.SuppressedAt != tt.d.SuppressedAt {
+				t.Errorf("applyDeliveryEvent() should keep the time the address was suppressed")
+			}
+			if applied && (got.LastEventAt == nil || !got.LastEventAt.Equal(tt.event.OccurredAt)) {
+				t.Errorf("applyDeliveryEvent() last event at %v, want %s", got.LastEventAt, tt.event.OccurredAt)
+			}
+			if applied && tt.event.Reason != "" && (got.LastReason == nil || *got.LastReason != tt.event.Reason) {
+				t.Errorf("applyDeliveryEvent() last reason %v, want %s", got.LastReason, tt.event.Reason)
+			}
+		})
+	}
+}
+
+func TestDeliverabilityServicePgImpl_RecordEvent(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := helpers.NewPostgresContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %s", err)
+		}
+	})
+
+	s := NewDeliverabilityServicePgImpl(pgContainer.DB)
+
+	record := func(event mail.DeliveryEvent) {
+		t.Helper()
+		if err := s.RecordEvent(ctx, event); err != nil {
+			t.Fatalf("DeliverabilityServicePgImpl.RecordEvent() error = %v", err)
+		}
+	}
+	get := func(email string) models.EmailDeliverability {
+		t.Helper()
+		d, err := s.GetDeliverability(ctx, email)
+		if err != nil {
+			t.Fatalf("DeliverabilityServicePgImpl.GetDeliverability() error = %v", err)
+		}
+		return d
+	}
+
+	if d := get("jane@email.com"); d.Status != models.DeliverabilityUnknown {
+		t.Errorf("an address without events should be unknown, got %+v", d)
+	}
+
+	// a redelivered event is applied once, addresses are keyed regardless of case
+	now := time.Now()
+	softBounce := mail.DeliveryEvent{Id: "evt_1", Type: mail.BouncedEvent, Recipients: []string{"Jane@Email.com"}, OccurredAt: now}
+	for range constants.EmailSoftBounceLimit {
+		record(softBounce)
+	}
+	d := get("jane@email.com")
+	if d.Status != models.DeliverabilitySoftBounced || d.SoftBounces != 1 || d.SuppressedAt != nil {
+		t.Errorf("a redelivered event should be applied once, got %+v", d)
+	}
+
+	var claimed int
+	err = pgContainer.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM processed_webhook_events WHERE event_id = 'email:evt_1';`).Scan(&claimed)
+	if err != nil || claimed != 1 {
+		t.Errorf("the event should be claimed once, got %d, %v", claimed, err)
+	}
+
+	// events older than the last one applied are ignored
+	record(mail.DeliveryEvent{Id: "evt_2", Type: mail.DeliveredEvent, Recipients: []string{"jane@email.com"}, OccurredAt: now.Add(-time.Minute)})
+	if d := get("jane@email.com"); d.Status != models.DeliverabilitySoftBounced || d.SoftBounces != 1 {
+		t.Errorf("an out of order event should be ignored, got %+v", d)
+	}
+
+	// events of other types are not recorded
+	record(mail.DeliveryEvent{Id: "evt_3", Type: "opened", Recipients: []string{"jane@email.com"}, OccurredAt: now.Add(time.Minute)})
+	if d := get("jane@email.com"); !d.LastEventAt.Before(now.Add(time.Second)) {
+		t.Errorf("an event of another type should be ignored, got %+v", d)
+	}
+
+	record(mail.DeliveryEvent{Id: "evt_4", Type: mail.ComplainedEvent, Recipients: []string{"jane@email.com", "john@email.com"}, OccurredAt: now.Add(time.Minute)})
+	for _, email := range []string{"JANE@email.com", "john@email.com"} {
+		suppressed, err := s.IsSuppressed(ctx, email)
+		if err != nil || !suppressed {
+			t.Errorf("%s: a complaint should suppress the address, got %v, %v", email, suppressed, err)
+		}
+	}
+
+	suppressions, total, err := s.GetSuppressions(ctx, 0, 10)
+	if err != nil || total != 2 || len(suppressions) != 2 {
+		t.Errorf("DeliverabilityServicePgImpl.GetSuppressions() = %+v, %d, %v", suppressions, total, err)
+	}
+
+	if err := s.RemoveSuppression(ctx, "jane@email.com"); err != nil {
+		t.Fatalf("DeliverabilityServicePgImpl.RemoveSuppression() error = %v", err)
+	}
+	if err := s.RemoveSuppression(ctx, "jane@email.com"); !errors.Is(err, constants.ErrNoRows) {
+		t.Errorf("removing a lifted suppression should return ErrNoRows, got %v", err)
+	}
+	if suppressed, err := s.IsSuppressed(ctx, "jane@email.com"); err != nil || suppressed {
+		t.Errorf("the suppression should be lifted, got %v, %v", suppressed, err)
+	}
+}
+
+// suppressionStub suppresses the addresses in suppressed, the other methods are not used.
+type suppressionStub struct {
+	DeliverabilityService
+	suppressed map[string]bool
+	err        error
+}
+
+func (s *suppressionStub) IsSuppressed(ctx context.Context, email string) (bool, error) {
+	return s.suppressed[email], s.err
+}
+
+func TestEmailServiceSuppressionImpl_Send(t *testing.T) {
+	ctx := context.Background()
+	lookupErr := errors.New("database down")
+
+	tests := []struct {
+		name     string
+		to       string
+		err      error
+		wantErr  error
+		wantSent bool
+	}{
+		{name: "not suppressed", to: "jane@email.com", wantSent: true},
+		{name: "suppressed", to: "gone@email.com", wantErr: constants.ErrEmailSuppressed},
+		{name: "lookup fails", to: "jane@email.com", err: lookupErr, wantErr: lookupErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &recipientSender{calls: map[string]int{}}
+			deliverability := &suppressionStub{suppressed: map[string]bool{"gone@email.com": true}, err: tt.err}
+			s := NewEmailServiceSuppressionImpl(next, deliverability)
+
+			err := s.Send(ctx, "account-created", tt.to, "en", nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("EmailServiceSuppressionImpl.Send() error = %v, want %v", err, tt.wantErr)
+			}
+			if sent := next.calls[tt.to] == 1; sent != tt.wantSent {
+				t.Errorf("EmailServiceSuppressionImpl.Send() sent = %v, want %v", sent, tt.wantSent)
+			}
+		})
+	}
+}
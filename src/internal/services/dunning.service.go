@@ -0,0 +1,48 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/payments"
+)
+
+// DunningService defines the interface for the recovery of failed subscription charges.
+// A failed renewal opens a case that is retried on constants.DunningRetrySchedule, then
+// given constants.DunningGracePeriod before its subscription is suspended, its owner is
+// warned constants.DunningSuspensionWarning before that. The automatic
+// retries of the gateway should be turned off, so only this schedule charges the customer.
+// Like the payment transitions, the returned bool reports whether the case changed.
+type DunningService interface {
+	// OpenCase starts dunning a failed invoice of a subscription, cases that are
+	// already open (for the invoice or the subscription) are returned as they are.
+	OpenCase(ctx context.Context, sub models.Subscription, invoice payments.Invoice) (models.DunningCase, bool, error)
+
+	// GetOrganizationCases retrieves the dunning cases of an organization, newest first.
+	GetOrganizationCases(ctx context.Context, orgId string) ([]models.DunningCase, error)
+
+	// GetDueCases retrieves up to limit open cases whose next step is due by now and not deferred,
+	// the ones whose steps failed the fewest times in a row first, then the longest due.
+	GetDueCases(ctx context.Context, now time.Time, limit int) ([]models.DunningCase, error)
+
+	// DeferCase records that the due step of a case failed with cause, backing off its next try.
+	// Returns how many steps of the case failed in a row, a step that succeeds resets it.
+	DeferCase(ctx context.Context, dunningCase models.DunningCase, cause error) (int64, error)
+
+	// RetryCase charges the invoice of a retrying case again. The case is recovered if it is paid,
+	// otherwise it moves to its next attempt or, once the schedule runs out, to grace.
+	RetryCase(ctx context.Context, dunningCase models.DunningCase) (models.DunningCase, bool, error)
+
+	// WarnCase marks a case in grace as warned of the suspension, once.
+	WarnCase(ctx context.Context, dunningCase models.DunningCase) (models.DunningCase, bool, error)
+
+	// SuspendCase cancels the subscription of a case whose grace period ended, right away.
+	SuspendCase(ctx context.Context, dunningCase models.DunningCase, sub models.Subscription) (models.DunningCase, bool, error)
+
+	// RecoverInvoice closes the open case of a paid invoice as recovered.
+	RecoverInvoice(ctx context.Context, providerInvoiceId string) (models.DunningCase, bool, error)
+
+	// CancelSubscriptionCases closes the open case of a subscription canceled by other means.
+	CancelSubscriptionCases(ctx context.Context, subscriptionId string) error
+}
@@ -0,0 +1,328 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/payments"
+	"github.com/LombardiDaniel/goliath/src/pkg/validators"
+)
+
+type DunningServicePgImpl struct {
+	db      *sql.DB
+	gateway payments.Gateway
+}
+
+func NewDunningServicePgImpl(db *sql.DB, gateway payments.Gateway) DunningService {
+	return &DunningServicePgImpl{
+		db:      db,
+		gateway: gateway,
+	}
+}
+
+const dunningCaseColumns = `
+	dunning_case_id,
+	subscription_id,
+	organization_id,
+	provider_invoice_id,
+	ammount_due,
+	LOWER(currency),
+	dunning_status,
+	attempt_count,
+	next_attempt_at,
+	grace_ends_at,
+	warned_at,
+	last_error,
+	created_at,
+	updated_at,
+	resolved_at
+`
+
+func scanDunningCase(row rowScanner) (models.DunningCase, error) {
+	dc := models.DunningCase{}
+	err := row.Scan(
+		&dc.DunningCaseId,
+		&dc.SubscriptionId,
+		&dc.OrganizationId,
+		&dc.ProviderInvoiceId,
+		&dc.AmmountDue,
+		&dc.Currency,
+		&dc.Status,
+		&dc.AttemptCount,
+		&dc.NextAttemptAt,
+		&dc.GraceEndsAt,
+		&dc.WarnedAt,
+		&dc.LastError,
+		&dc.CreatedAt,
+		&dc.UpdatedAt,
+		&dc.ResolvedAt,
+	)
+	return dc, errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+func (s *DunningServicePgImpl) getCase(ctx context.Context, dunningCaseId string) (models.DunningCase, error) {
+	return scanDunningCase(s.db.QueryRowContext(ctx, `
+		SELECT `+dunningCaseColumns+`
+		FROM dunning_cases
+		WHERE dunning_case_id = $1;
+		`,
+		dunningCaseId,
+	))
+}
+
+func (s *DunningServicePgImpl) OpenCase(ctx context.Context, sub models.Subscription, invoice payments.Invoice) (models.DunningCase, bool, error) {
+	dc, err := scanDunningCase(s.db.QueryRowContext(ctx, `
+		INSERT INTO dunning_cases
+			(subscription_id, organization_id, provider_invoice_id, ammount_due, currency, next_attempt_at)
+		VALUES
+			($1, $2, $3, $4, LOWER($5), $6)
+		ON CONFLICT DO NOTHING
+		RETURNING `+dunningCaseColumns+`;
+		`,
+		sub.SubscriptionId,
+		sub.OrganizationId,
+		invoice.Id,
+		invoice.AmountDue,
+		invoice.Currency,
+		time.Now().Add(constants.DunningRetrySchedule[0]),
+	))
+	if !errors.Is(err, constants.ErrNoRows) {
+		return dc, err == nil, err
+	}
+
+	dc, err = scanDunningCase(s.db.QueryRowContext(ctx, `
+		SELECT `+dunningCaseColumns+`
+		FROM dunning_cases
+		WHERE
+			provider_invoice_id = $1 OR
+			(subscription_id = $2 AND dunning_status IN ('retrying', 'grace'))
+		ORDER BY created_at DESC
+		LIMIT 1;
+		`,
+		invoice.Id,
+		sub.SubscriptionId,
+	))
+	return dc, false, err
+}
+
+func (s *DunningServicePgImpl) queryCases(ctx context.Context, query string, args ...any) ([]models.DunningCase, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+	defer rows.Close()
+
+	cases := []models.DunningCase{}
+	for rows.Next() {
+		dc, err := scanDunningCase(rows)
+		if err != nil {
+			return nil, err
+		}
+		cases = append(cases, dc)
+	}
+
+	return cases, rows.Err()
+}
+
+func (s *DunningServicePgImpl) GetOrganizationCases(ctx context.Context, orgId string) ([]models.DunningCase, error) {
+	return s.queryCases(ctx, `
+		SELECT `+dunningCaseColumns+`
+		FROM dunning_cases
+		WHERE organization_id = $1
+		ORDER BY created_at DESC;
+		`,
+		orgId,
+	)
+}
+
+func (s *DunningServicePgImpl) GetDueCases(ctx context.Context, now time.Time, limit int) ([]models.DunningCase, error) {
+	return s.queryCases(ctx, `
+		SELECT `+dunningCaseColumns+`
+		FROM dunning_cases
+		WHERE
+			(process_after IS NULL OR process_after <= $1) AND (
+				(dunning_status = 'retrying' AND next_attempt_at <= $1) OR
+				(dunning_status = 'grace' AND grace_ends_at <= $1) OR
+				(dunning_status = 'grace' AND warned_at IS NULL AND grace_ends_at <= $1 + make_interval(secs => $3))
+			)
+		ORDER BY process_failures, COALESCE(next_attempt_at, grace_ends_at)
+		LIMIT $2;
+		`,
+		now,
+		limit,
+		constants.DunningSuspensionWarning.Seconds(),
+	)
+}
+
+func (s *DunningServicePgImpl) DeferCase(ctx context.Context, dc models.DunningCase, cause error) (int64, error) {
+	var failures int64
+
+	// the backoff doubles from DunningProcessBackoff with every failure, up to 64 times it
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE dunning_cases
+		SET
+			process_failures = process_failures + 1,
+			process_after = NOW() + make_interval(secs => $2 * POWER(2, LEAST(process_failures, 6))),
+			last_error = $3
+		WHERE dunning_case_id = $1
+		RETURNING process_failures;
+		`,
+		dc.DunningCaseId,
+		constants.DunningProcessBackoff.Seconds(),
+		cause.Error(),
+	).Scan(&failures)
+	if err != nil {
+		return 0, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return failures, nil
+}
+
+// transitionCase applies set to the case if it is still at the status and attempt it was read at,
+// otherwise it returns the case as it is now. A transition clears the failures of the case.
+func (s *DunningServicePgImpl) transitionCase(ctx context.Context, dc models.DunningCase, set string, args ...any) (models.DunningCase, bool, error) {
+	updated, err := scanDunningCase(s.db.QueryRowContext(ctx, `
+		UPDATE dunning_cases
+		SET
+			`+set+`,
+			process_failures = 0,
+			process_after = NULL
+		WHERE
+			dunning_case_id = $1 AND
+			dunning_status = $2 AND
+			attempt_count = $3
+		RETURNING `+dunningCaseColumns+`;
+		`,
+		append([]any{dc.DunningCaseId, dc.Status, dc.AttemptCount}, args...)...,
+	))
+	if errors.Is(err, constants.ErrNoRows) {
+		updated, err = s.getCase(ctx, dc.DunningCaseId)
+		return updated, false, err
+	}
+
+	return updated, err == nil, err
+}
+
+func (s *DunningServicePgImpl) RetryCase(ctx context.Context, dc models.DunningCase) (models.DunningCase, bool, error) {
+	if dc.Status != models.DunningRetrying {
+		return dc, false, nil
+	}
+
+	_, err := s.gateway.PayInvoice(ctx, dc.ProviderInvoiceId)
+	if err == nil {
+		// the invoice paid webhook may have recovered the case already
+		return s.transitionCase(ctx, dc, `
+			dunning_status = 'recovered',
+			next_attempt_at = NULL,
+			resolved_at = NOW()
+		`)
+	}
+	if !errors.Is(err, payments.ErrPaymentFailed) {
+		// the charge was not tried, the case stays due
+		return dc, false, err
+	}
+
+	attempt := dc.AttemptCount + 1
+	lastError := err.Error()
+	if int(attempt) <= len(constants.DunningRetrySchedule) {
+		return s.transitionCase(ctx, dc, `
+			attempt_count = $4,
+			next_attempt_at = $5,
+			last_error = $6
+			`,
+			attempt,
+			time.Now().Add(constants.DunningRetrySchedule[attempt-1]),
+			lastError,
+		)
+	}
+
+	return s.transitionCase(ctx, dc, `
+		dunning_status = 'grace',
+		attempt_count = $4,
+		next_attempt_at = NULL,
+		grace_ends_at = $5,
+		last_error = $6
+		`,
+		attempt,
+		time.Now().Add(constants.DunningGracePeriod),
+		lastError,
+	)
+}
+
+func (s *DunningServicePgImpl) WarnCase(ctx context.Context, dc models.DunningCase) (models.DunningCase, bool, error) {
+	if dc.Status != models.DunningGrace || dc.WarnedAt != nil {
+		return dc, false, nil
+	}
+
+	return s.transitionCase(ctx, dc, `warned_at = NOW()`)
+}
+
+func (s *DunningServicePgImpl) SuspendCase(ctx context.Context, dc models.DunningCase, sub models.Subscription) (models.DunningCase, bool, error) {
+	if dc.Status != models.DunningGrace {
+		return dc, false, nil
+	}
+	if sub.SubscriptionId != dc.SubscriptionId || sub.ProviderSubscriptionId == nil {
+		return dc, false, errors.Join(constants.ErrDbConflict, errors.New("subscription must be the one of the case and exist at the payment gateway"))
+	}
+
+	// the case is suspended first, so the cancellation webhook does not close it as canceled
+	suspended, changed, err := s.transitionCase(ctx, dc, `
+		dunning_status = 'suspended',
+		resolved_at = NOW()
+	`)
+	if err != nil || !changed {
+		return suspended, changed, err
+	}
+
+	_, err = s.gateway.CancelSubscription(ctx, *sub.ProviderSubscriptionId)
+	if err != nil {
+		_, _, revertErr := s.transitionCase(ctx, suspended, `
+			dunning_status = 'grace',
+			resolved_at = NULL
+		`)
+		return dc, false, errors.Join(err, revertErr)
+	}
+
+	return suspended, true, nil
+}
+
+func (s *DunningServicePgImpl) RecoverInvoice(ctx context.Context, providerInvoiceId string) (models.DunningCase, bool, error) {
+	dc, err := scanDunningCase(s.db.QueryRowContext(ctx, `
+		UPDATE dunning_cases
+		SET
+			dunning_status = 'recovered',
+			next_attempt_at = NULL,
+			resolved_at = NOW()
+		WHERE
+			provider_invoice_id = $1 AND
+			dunning_status IN ('retrying', 'grace')
+		RETURNING `+dunningCaseColumns+`;
+		`,
+		providerInvoiceId,
+	))
+	if errors.Is(err, constants.ErrNoRows) {
+		return dc, false, nil
+	}
+
+	return dc, err == nil, err
+}
+
+func (s *DunningServicePgImpl) CancelSubscriptionCases(ctx context.Context, subscriptionId string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE dunning_cases
+		SET
+			dunning_status = 'canceled',
+			next_attempt_at = NULL,
+			resolved_at = NOW()
+		WHERE
+			subscription_id = $1 AND
+			dunning_status IN ('retrying', 'grace');
+		`,
+		subscriptionId,
+	)
+	return errors.Join(err, validators.FilterSqlPgError(err))
+}
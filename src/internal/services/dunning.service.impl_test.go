@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/helpers"
+	"github.com/LombardiDaniel/goliath/src/pkg/payments"
+)
+
+func TestDunningServicePgImpl_Lifecycle(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := helpers.NewPostgresContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %s", err)
+		}
+	})
+
+	// the webhook events of the gateway are not under test
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(webhook.Close)
+	gateway := payments.NewFakeGateway("http://localhost", webhook.URL, "whsec_test")
+
+	const subscriptionId = "00000000-0000-0000-0000-000000000001"
+
+	db := pgContainer.DB
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO users (user_id, email, password_hash, first_name, last_name)
+		VALUES (1, 'owner@email.com', 'hashtest', 'Test', 'Owner');
+
+		INSERT INTO plans (plan_id, plan_name) VALUES (1, 'pro');
+		INSERT INTO prices (price_id, plan_id, unit_ammount, unit_currency, billing_interval, provider_price_id)
+		VALUES (1, 1, 1000, 'usd', 'month', 'price_test');
+
+		INSERT INTO organizations (organization_id, organization_name, owner_user_id, billing_plan_id)
+		VALUES ('ORG01', 'org', 1, 1);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seats := int64(1)
+	checkout, err := gateway.CreateCheckoutSession(ctx, payments.CheckoutParams{
+		Mode:     payments.SubscriptionMode,
+		LineItem: payments.LineItem{Name: "pro", Currency: "usd", UnitAmount: 1000, Interval: "month", Quantity: &seats},
+		Metadata: map[string]string{"subscription_id": subscriptionId},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkout, err = gateway.Pay(ctx, checkout.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO subscriptions (subscription_id, organization_id, plan_id, price_id)
+		VALUES ($1, 'ORG01', 1, 1);
+		`,
+		subscriptionId,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	billing := &BillingServicePgImpl{db: db, gateway: gateway}
+	s := &DunningServicePgImpl{db: db, gateway: gateway}
+
+	due := func(at time.Time) []models.DunningCase {
+		t.Helper()
+		cases, err := s.GetDueCases(ctx, at, 100)
+		if err != nil {
+			t.Fatalf("DunningServicePgImpl.GetDueCases() error = %v", err)
+		}
+		return cases
+	}
+
+	openCase := func() (models.DunningCase, models.Subscription) {
+		t.Helper()
+		invoice, err := gateway.FailRenewal(ctx, *checkout.SubscriptionId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sub, err := billing.SyncSubscription(ctx, *checkout.SubscriptionId)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dc, changed, err := s.OpenCase(ctx, sub, invoice)
+		if err != nil || !changed {
+			t.Fatalf("DunningServicePgImpl.OpenCase() = %v, %v, want a new case", changed, err)
+		}
+		if dc.Status != models.DunningRetrying || dc.AttemptCount != 1 || dc.AmmountDue != 1000 {
+			t.Fatalf("unexpected opened case %+v", dc)
+		}
+
+		again, changed, err := s.OpenCase(ctx, sub, invoice)
+		if err != nil || changed || again.DunningCaseId != dc.DunningCaseId {
+			t.Fatalf("opening the case again should return it unchanged, got %+v, %v, %v", again, changed, err)
+		}
+		return dc, sub
+	}
+
+	// open -> retry -> recover, once a new card is added
+	dc, sub := openCase()
+	if len(due(time.Now())) != 0 {
+		t.Errorf("a case should not be due before its first retry")
+	}
+	if cases := due(time.Now().Add(constants.DunningRetrySchedule[0] + time.Minute)); len(cases) != 1 {
+		t.Fatalf("expected the case due after its first retry delay, got %+v", cases)
+	}
+
+	dc, changed, err := s.RetryCase(ctx, dc)
+	if err != nil || !changed || dc.Status != models.DunningRetrying || dc.AttemptCount != 2 || dc.LastError == nil {
+		t.Fatalf("a declined retry should move to the next attempt, got %+v, %v, %v", dc, changed, err)
+	}
+
+	_, err = gateway.AddPaymentMethod(ctx, *sub.ProviderCustomerId, "mastercard", "4444")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc, changed, err = s.RetryCase(ctx, dc)
+	if err != nil || !changed || dc.Status != models.DunningRecovered || dc.ResolvedAt == nil {
+		t.Fatalf("a paid retry should recover the case, got %+v, %v, %v", dc, changed, err)
+	}
+
+	_, changed, err = s.RecoverInvoice(ctx, dc.ProviderInvoiceId)
+	if err != nil || changed {
+		t.Errorf("recovering a closed case should change nothing, got %v, %v", changed, err)
+	}
+
+	// open -> retry -> grace -> warn -> suspend
+	dc, sub = openCase()
+	for range constants.DunningRetrySchedule {
+		dc, changed, err = s.RetryCase(ctx, dc)
+		if err != nil || !changed {
+			t.Fatalf("DunningServicePgImpl.RetryCase() = %v, %v", changed, err)
+		}
+	}
+	if dc.Status != models.DunningGrace || dc.GraceEndsAt == nil || dc.NextAttemptAt != nil {
+		t.Fatalf("the case should be in grace once the retries run out, got %+v", dc)
+	}
+
+	// the warning is due before the grace ends, deferring it backs it off
+	_, err = db.ExecContext(ctx, `UPDATE dunning_cases SET grace_ends_at = NOW() + INTERVAL '1 day' WHERE dunning_case_id = $1;`, dc.DunningCaseId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due(time.Now())) != 1 {
+		t.Fatalf("the suspension warning should be due within %s of the end of grace", constants.DunningSuspensionWarning)
+	}
+
+	for want := int64(1); want <= 2; want++ {
+		failures, err := s.DeferCase(ctx, dc, errors.New("smtp down"))
+		if err != nil || failures != want {
+			t.Fatalf("DunningServicePgImpl.DeferCase() = %d, %v, want %d", failures, err, want)
+		}
+	}
+	if len(due(time.Now())) != 0 {
+		t.Errorf("a deferred case should not be due")
+	}
+	if len(due(time.Now().Add(4*constants.DunningProcessBackoff+time.Minute))) != 1 {
+		t.Errorf("a deferred case should be due again after its backoff")
+	}
+
+	dc, err = s.getCase(ctx, dc.DunningCaseId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc, changed, err = s.WarnCase(ctx, dc)
+	if err != nil || !changed || dc.WarnedAt == nil {
+		t.Fatalf("DunningServicePgImpl.WarnCase() = %+v, %v, %v", dc, changed, err)
+	}
+	_, changed, err = s.WarnCase(ctx, dc)
+	if err != nil || changed {
+		t.Errorf("a case should be warned once, got %v, %v", changed, err)
+	}
+	if len(due(time.Now())) != 0 {
+		t.Errorf("a warned case should not be due before grace ends")
+	}
+
+	// the transition cleared the failures
+	failures, err := s.DeferCase(ctx, dc, errors.New("gateway down"))
+	if err != nil || failures != 1 {
+		t.Errorf("a transition should reset the failures of the case, got %d, %v", failures, err)
+	}
+	if cases := due(time.Now().Add(constants.DunningGracePeriod)); len(cases) != 1 {
+		t.Fatalf("expected the case due once grace ends, got %+v", cases)
+	}
+
+	dc, changed, err = s.SuspendCase(ctx, dc, sub)
+	if err != nil || !changed || dc.Status != models.DunningSuspended {
+		t.Fatalf("DunningServicePgImpl.SuspendCase() = %+v, %v, %v", dc, changed, err)
+	}
+	providerSub, err := gateway.GetSubscription(ctx, *checkout.SubscriptionId)
+	if err != nil || providerSub.Status != "canceled" {
+		t.Errorf("suspending should cancel the subscription at the gateway, got %+v, %v", providerSub, err)
+	}
+
+	cases, err := s.GetOrganizationCases(ctx, "ORG01")
+	if err != nil || len(cases) != 2 || cases[0].Status != models.DunningSuspended || cases[1].Status != models.DunningRecovered {
+		t.Errorf("unexpected cases of the organization %+v, %v", cases, err)
+	}
+}
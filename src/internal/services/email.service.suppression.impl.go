@@ -0,0 +1,35 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+)
+
+// EmailServiceSuppressionImpl consults the suppression list before every send, emails to
+// suppressed addresses fail with constants.ErrEmailSuppressed instead of reaching next.
+type EmailServiceSuppressionImpl struct {
+	next                  EmailService
+	deliverabilityService DeliverabilityService
+}
+
+func NewEmailServiceSuppressionImpl(next EmailService, deliverabilityService DeliverabilityService) EmailService {
+	return &EmailServiceSuppressionImpl{
+		next:                  next,
+		deliverabilityService: deliverabilityService,
+	}
+}
+
+func (s *EmailServiceSuppressionImpl) Send(ctx context.Context, templateName string, to string, locale string, data any) error {
+	suppressed, err := s.deliverabilityService.IsSuppressed(ctx, to)
+	if err != nil {
+		return err
+	}
+	if suppressed {
+		return errors.Join(constants.ErrEmailSuppressed, fmt.Errorf("not sending email '%s' to suppressed address '%s'", templateName, to))
+	}
+
+	return s.next.Send(ctx, templateName, to, locale, data)
+}
@@ -0,0 +1,31 @@
+package services
+
+import (
+	"context"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+)
+
+// EntitlementService defines the interface for resolving what an organization's plan grants.
+// It provides methods for reading feature flags and checking numeric limits against usage.
+type EntitlementService interface {
+	// GetEntitlements retrieves the features and limits granted to an organization,
+	// organizations without a plan get the free tier.
+	GetEntitlements(ctx context.Context, orgId string) (models.Entitlements, error)
+
+	// Usage retrieves the current usage of a limit by an organization.
+	Usage(ctx context.Context, orgId string, limit models.Limit) (int64, error)
+
+	// CheckLimit returns constants.ErrLimitExceeded if adding delta to the current
+	// usage would exceed the organization's limit.
+	CheckLimit(ctx context.Context, orgId string, limit models.Limit, delta int64) error
+
+	// AddStorageUsage adds delta (negative when objects are removed) to the
+	// organization's stored bytes.
+	AddStorageUsage(ctx context.Context, orgId string, delta int64) error
+
+	// ReserveStorage adds delta to the organization's stored bytes only if they stay within
+	// its limit, constants.ErrLimitExceeded otherwise. The check and the addition are atomic,
+	// so concurrent uploads cannot exceed the limit together.
+	ReserveStorage(ctx context.Context, orgId string, delta int64) error
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/validators"
+)
+
+type EntitlementServicePgImpl struct {
+	db         *sql.DB
+	freeLimits map[models.Limit]int64
+}
+
+// NewEntitlementServicePgImpl creates the entitlement service, freeLimits are applied
+// to organizations that are not on a plan.
+func NewEntitlementServicePgImpl(db *sql.DB, freeLimits map[models.Limit]int64) EntitlementService {
+	return &EntitlementServicePgImpl{
+		db:         db,
+		freeLimits: freeLimits,
+	}
+}
+
+func (s *EntitlementServicePgImpl) GetEntitlements(ctx context.Context, orgId string) (models.Entitlements, error) {
+	ent := models.Entitlements{
+		OrganizationId: orgId,
+		Features:       []string{},
+		Limits:         map[models.Limit]int64{},
+	}
+
+	var features, limits []byte
+	var seats sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			o.billing_plan_id,
+			p.features,
+			p.limits,
+			s.seats
+		FROM organizations o
+		LEFT JOIN plans p ON p.plan_id = o.billing_plan_id
+		LEFT JOIN subscriptions s ON
+			s.organization_id = o.organization_id AND
+			s.subscription_status IN ('trialing', 'active', 'past_due') AND
+			s.price_id IN (SELECT price_id FROM prices WHERE meter IS NULL)
+		WHERE
+			o.organization_id = $1 AND
+			o.deleted_at IS NULL;
+		`,
+		orgId,
+	).Scan(
+		&ent.PlanId,
+		&features,
+		&limits,
+		&seats,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ent, constants.ErrNoRows
+		}
+		return ent, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	if ent.PlanId == nil {
+		for limit, maximum := range s.freeLimits {
+			ent.Limits[limit] = maximum
+		}
+		return ent, nil
+	}
+
+	err = json.Unmarshal(features, &ent.Features)
+	if err != nil {
+		return ent, errors.Join(err, errors.New("could not unmarshal plan features"))
+	}
+
+	err = json.Unmarshal(limits, &ent.Limits)
+	if err != nil {
+		return ent, errors.Join(err, errors.New("could not unmarshal plan limits"))
+	}
+
+	// per-seat subscriptions grant exactly the seats paid for
+	if seats.Valid {
+		ent.Limits[models.SeatsLimit] = seats.Int64
+	}
+
+	return ent, nil
+}
+
+func (s *EntitlementServicePgImpl) Usage(ctx context.Context, orgId string, limit models.Limit) (int64, error) {
+	var q string
+	switch limit {
+	case models.SeatsLimit:
+		// pending invites hold a seat so admins can't invite past the limit
+		q = `
+			SELECT
+				(SELECT COUNT(*) FROM organizations_users WHERE organization_id = $1) +
+				(SELECT COUNT(*) FROM organization_invites WHERE organization_id = $1 AND (exp IS NULL OR exp > NOW()));
+		`
+	case models.ApiCallsLimit:
+		// api calls are allowed per calendar month
+		q = `
+			SELECT COALESCE(SUM(quantity), 0)
+			FROM usage_records
+			WHERE
+				organization_id = $1 AND
+				meter = 'api_calls' AND
+				period_start >= DATE_TRUNC('month', NOW());
+		`
+	case models.StorageBytesLimit:
+		q = `
+			SELECT storage_bytes
+			FROM organizations
+			WHERE organization_id = $1;
+		`
+	default:
+		return 0, fmt.Errorf("usage of limit '%s' is not tracked", limit)
+	}
+
+	var usage int64
+	err := s.db.QueryRowContext(ctx, q, orgId).Scan(&usage)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, constants.ErrNoRows
+		}
+		return 0, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return usage, nil
+}
+
+func (s *EntitlementServicePgImpl) CheckLimit(ctx context.Context, orgId string, limit models.Limit, delta int64) error {
+	ent, err := s.GetEntitlements(ctx, orgId)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := ent.Limits[limit]; !ok {
+		return nil
+	}
+
+	usage, err := s.Usage(ctx, orgId, limit)
+	if err != nil {
+		return err
+	}
+
+	if !ent.Allows(limit, usage+delta) {
+		return constants.ErrLimitExceeded
+	}
+
+	return nil
+}
+
+func (s *EntitlementServicePgImpl) AddStorageUsage(ctx context.Context, orgId string, delta int64) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE organizations
+		SET storage_bytes = GREATEST(storage_bytes + $1, 0)
+		WHERE organization_id = $2;
+		`,
+		delta,
+		orgId,
+	)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return errIfNoRowsAffected(res)
+}
+
+func (s *EntitlementServicePgImpl) ReserveStorage(ctx context.Context, orgId string, delta int64) error {
+	ent, err := s.GetEntitlements(ctx, orgId)
+	if err != nil {
+		return err
+	}
+
+	maximum, ok := ent.Limits[models.StorageBytesLimit]
+	if !ok {
+		return s.AddStorageUsage(ctx, orgId, delta)
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE organizations
+		SET storage_bytes = storage_bytes + $1
+		WHERE organization_id = $2 AND storage_bytes + $1 <= $3;
+		`,
+		delta,
+		orgId,
+		maximum,
+	)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	err = errIfNoRowsAffected(res)
+	if errors.Is(err, constants.ErrNoRows) {
+		return constants.ErrLimitExceeded
+	}
+	return err
+}
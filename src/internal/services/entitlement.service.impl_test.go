@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/helpers"
+)
+
+func TestEntitlementServicePgImpl_ReserveStorage(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := helpers.NewPostgresContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %s", err)
+		}
+	})
+
+	db := pgContainer.DB
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO users (user_id, email, password_hash, first_name, last_name)
+		VALUES (1, 'jane@email.com', 'hashtest', 'Jane', 'Doe');
+
+		INSERT INTO organizations (organization_id, organization_name, owner_user_id)
+		VALUES ('ORG01', 'org', 1);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewEntitlementServicePgImpl(db, map[models.Limit]int64{models.StorageBytesLimit: 1000})
+
+	// concurrent reservations cannot exceed the limit together
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.ReserveStorage(ctx, "ORG01", 300)
+		}()
+	}
+	wg.Wait()
+	reserved := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			reserved++
+		case !errors.Is(err, constants.ErrLimitExceeded):
+			t.Errorf("EntitlementServicePgImpl.ReserveStorage() error = %v", err)
+		}
+	}
+	if reserved != 3 {
+		t.Errorf("expected 3 reservations within the limit, got %d", reserved)
+	}
+
+	usage, err := s.Usage(ctx, "ORG01", models.StorageBytesLimit)
+	if err != nil || usage != 900 {
+		t.Errorf("EntitlementServicePgImpl.Usage() = %d, %v, want 900", usage, err)
+	}
+
+	if err := s.ReserveStorage(ctx, "ORG01", 100); err != nil {
+		t.Errorf("reserving up to the limit should succeed, got %v", err)
+	}
+	if err := s.AddStorageUsage(ctx, "ORG01", -1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReserveStorage(ctx, "NOORG", 1); !errors.Is(err, constants.ErrNoRows) {
+		t.Errorf("reserving for no organization should return ErrNoRows, got %v", err)
+	}
+}
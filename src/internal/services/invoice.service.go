@@ -0,0 +1,42 @@
+package services
+
+import (
+	"context"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/payments"
+)
+
+// InvoiceService defines the interface for issuing and retrieving invoices.
+// Invoices are numbered sequentially and keep a snapshot of who they were billed to.
+type InvoiceService interface {
+	// CreatePaymentInvoice issues the invoice of a completed payment, billed to its user.
+	// Issuing it again returns the existing invoice.
+	CreatePaymentInvoice(ctx context.Context, payment models.Payment) (models.Invoice, error)
+
+	// CreateSubscriptionInvoice issues the invoice of a paid subscription period, billed to
+	// its organization. Issuing it again returns the existing invoice.
+	CreateSubscriptionInvoice(ctx context.Context, sub models.Subscription, invoice payments.Invoice) (models.Invoice, error)
+
+	// GetInvoice retrieves an invoice by its id.
+	GetInvoice(ctx context.Context, invoiceId string) (models.Invoice, error)
+
+	// GetUserInvoices retrieves a page of the invoices of a user, newest first, and their total count.
+	GetUserInvoices(ctx context.Context, userId uint32, offset int, limit int) ([]models.Invoice, int64, error)
+
+	// GetOrganizationInvoices retrieves a page of the invoices of an organization, newest first, and their total count.
+	GetOrganizationInvoices(ctx context.Context, orgId string, offset int, limit int) ([]models.Invoice, int64, error)
+
+	// RenderReceipt renders the PDF receipt of an invoice.
+	RenderReceipt(invoice models.Invoice) []byte
+
+	// SetReceiptPath records where the rendered receipt of an invoice is stored.
+	SetReceiptPath(ctx context.Context, invoiceId string, path string) error
+
+	// GetBillingDetails retrieves the billing details of an organization.
+	GetBillingDetails(ctx context.Context, orgId string) (models.BillingDetails, error)
+
+	// SetBillingDetails creates or replaces the billing details of an organization,
+	// they are used by the invoices issued from then on.
+	SetBillingDetails(ctx context.Context, orgId string, details models.BillingDetails) error
+}
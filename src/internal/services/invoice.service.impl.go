@@ -0,0 +1,451 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/common"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/payments"
+	"github.com/LombardiDaniel/goliath/src/pkg/pdf"
+	"github.com/LombardiDaniel/goliath/src/pkg/validators"
+)
+
+type InvoiceServicePgImpl struct {
+	db *sql.DB
+}
+
+func NewInvoiceServicePgImpl(db *sql.DB) InvoiceService {
+	return &InvoiceServicePgImpl{
+		db: db,
+	}
+}
+
+const invoiceColumns = `
+	invoice_id,
+	invoice_number,
+	user_id,
+	organization_id,
+	payment_id,
+	subscription_id,
+	provider_invoice_id,
+	description,
+	ammount,
+	currency,
+	billing_details,
+	period_start,
+	period_end,
+	receipt_path,
+	issued_at
+`
+
+func scanInvoice(row rowScanner) (models.Invoice, error) {
+	invoice := models.Invoice{}
+	var billingDetails []byte
+	err := row.Scan(
+		&invoice.InvoiceId,
+		&invoice.InvoiceNumber,
+		&invoice.UserId,
+		&invoice.OrganizationId,
+		&invoice.PaymentId,
+		&invoice.SubscriptionId,
+		&invoice.ProviderInvoiceId,
+		&invoice.Description,
+		&invoice.Ammount,
+		&invoice.Currency,
+		&billingDetails,
+		&invoice.PeriodStart,
+		&invoice.PeriodEnd,
+		&invoice.ReceiptPath,
+		&invoice.IssuedAt,
+	)
+	if err != nil {
+		return invoice, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	err = json.Unmarshal(billingDetails, &invoice.BillingDetails)
+	if err != nil {
+		return invoice, errors.Join(err, errors.New("could not unmarshal invoice billing details"))
+	}
+
+	return invoice, nil
+}
+
+func (s *InvoiceServicePgImpl) CreatePaymentInvoice(ctx context.Context, payment models.Payment) (models.Invoice, error) {
+	var details models.BillingDetails
+	var firstName, lastName, email string
+	var planName sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			u.first_name,
+			u.last_name,
+			u.email,
+			pl.plan_name
+		FROM payments p
+		INNER JOIN users u ON u.user_id = p.user_id
+		LEFT JOIN prices pr ON pr.price_id = p.price_id
+		LEFT JOIN plans pl ON pl.plan_id = pr.plan_id
+		WHERE p.payment_id = $1;
+		`,
+		payment.PaymentId,
+	).Scan(
+		&firstName,
+		&lastName,
+		&email,
+		&planName,
+	)
+	if err != nil {
+		return models.Invoice{}, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	details.CompanyName = strings.TrimSpace(firstName + " " + lastName)
+	details.Email = &email
+
+	description := "Payment"
+	if planName.Valid {
+		description = planName.String
+	}
+
+	billingDetails, err := json.Marshal(details)
+	if err != nil {
+		return models.Invoice{}, err
+	}
+
+	return s.issueInvoice(ctx, "payment_id", payment.PaymentId, `
+		INSERT INTO invoices
+			(invoice_number, user_id, payment_id, description, ammount, currency, billing_details)
+		VALUES
+			($1, $2, $3, $4, $5, LOWER($6), $7)
+		RETURNING `+invoiceColumns+`;
+		`,
+		payment.UserId,
+		payment.PaymentId,
+		description,
+		payment.UnitAmmount,
+		payment.UnitCurrency,
+		billingDetails,
+	)
+}
+
+func (s *InvoiceServicePgImpl) CreateSubscriptionInvoice(ctx context.Context, sub models.Subscription, invoice payments.Invoice) (models.Invoice, error) {
+	var planName string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT plan_name
+		FROM plans
+		WHERE plan_id = $1;
+		`,
+		sub.PlanId,
+	).Scan(&planName)
+	if err != nil {
+		return models.Invoice{}, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	details, err := s.GetBillingDetails(ctx, sub.OrganizationId)
+	if errors.Is(err, constants.ErrNoRows) {
+		// organizations that did not fill their billing details are billed by name
+		err = s.db.QueryRowContext(ctx, `
+			SELECT organization_name
+			FROM organizations
+			WHERE organization_id = $1;
+			`,
+			sub.OrganizationId,
+		).Scan(&details.CompanyName)
+	}
+	if err != nil {
+		return models.Invoice{}, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	billingDetails, err := json.Marshal(details)
+	if err != nil {
+		return models.Invoice{}, err
+	}
+
+	description := fmt.Sprintf("%s subscription (%d seats)", planName, sub.Seats)
+
+	return s.issueInvoice(ctx, "provider_invoice_id", invoice.Id, `
+		INSERT INTO invoices
+			(invoice_number, organization_id, subscription_id, provider_invoice_id, description, ammount, currency, billing_details, period_start, period_end)
+		VALUES
+			($1, $2, $3, $4, $5, $6, LOWER($7), $8, $9, $10)
+		RETURNING `+invoiceColumns+`;
+		`,
+		sub.OrganizationId,
+		sub.SubscriptionId,
+		invoice.Id,
+		description,
+		invoice.AmountPaid,
+		invoice.Currency,
+		billingDetails,
+		invoice.PeriodStart,
+		invoice.PeriodEnd,
+	)
+}
+
+// issueInvoice inserts an invoice with the next number, unless one with the key was already
+// issued, which is returned instead. insert takes the number as $1 and args after it, keyColumn
+// is never user input. The number is taken in the transaction of the insert, so it is only
+// used up if the invoice is inserted.
+func (s *InvoiceServicePgImpl) issueInvoice(ctx context.Context, keyColumn string, key any, insert string, args ...any) (models.Invoice, error) {
+	getIssued := func() (models.Invoice, error) {
+		return scanInvoice(s.db.QueryRowContext(ctx, `
+			SELECT `+invoiceColumns+`
+			FROM invoices
+			WHERE `+keyColumn+` = $1;
+			`,
+			key,
+		))
+	}
+
+	invoice, err := getIssued()
+	if !errors.Is(err, constants.ErrNoRows) {
+		return invoice, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return invoice, errors.Join(err, constants.ErrDbTransactionCreate)
+	}
+	defer tx.Rollback()
+
+	var number int64
+	err = tx.QueryRowContext(ctx, `
+		UPDATE invoice_numbers
+		SET last_number = last_number + 1
+		RETURNING last_number;
+	`).Scan(&number)
+	if err != nil {
+		return invoice, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	invoice, err = scanInvoice(tx.QueryRowContext(ctx, insert, append([]any{fmt.Sprintf("INV-%06d", number)}, args...)...))
+	if errors.Is(err, constants.ErrDbConflict) {
+		// issued concurrently, the rollback gives the number back
+		tx.Rollback()
+		return getIssued()
+	}
+	if err != nil {
+		return invoice, err
+	}
+
+	return invoice, tx.Commit()
+}
+
+func (s *InvoiceServicePgImpl) GetInvoice(ctx context.Context, invoiceId string) (models.Invoice, error) {
+	return scanInvoice(s.db.QueryRowContext(ctx, `
+		SELECT `+invoiceColumns+`
+		FROM invoices
+		WHERE invoice_id = $1;
+		`,
+		invoiceId,
+	))
+}
+
+func (s *InvoiceServicePgImpl) GetUserInvoices(ctx context.Context, userId uint32, offset int, limit int) ([]models.Invoice, int64, error) {
+	return s.listInvoices(ctx, "user_id", userId, offset, limit)
+}
+
+func (s *InvoiceServicePgImpl) GetOrganizationInvoices(ctx context.Context, orgId string, offset int, limit int) ([]models.Invoice, int64, error) {
+	return s.listInvoices(ctx, "organization_id", orgId, offset, limit)
+}
+
+// listInvoices lists the invoices billed to owner, ownerColumn is never user input.
+func (s *InvoiceServicePgImpl) listInvoices(ctx context.Context, ownerColumn string, owner any, offset int, limit int) ([]models.Invoice, int64, error) {
+	invoices := []models.Invoice{}
+
+	var total int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM invoices
+		WHERE `+ownerColumn+` = $1;
+		`,
+		owner,
+	).Scan(&total)
+	if err != nil {
+		return invoices, 0, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+invoiceColumns+`
+		FROM invoices
+		WHERE `+ownerColumn+` = $1
+		ORDER BY issued_at DESC, invoice_number DESC
+		OFFSET $2
+		LIMIT $3;
+		`,
+		owner,
+		offset,
+		limit,
+	)
+	if err != nil {
+		return invoices, 0, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		invoice, err := scanInvoice(rows)
+		if err != nil {
+			return invoices, 0, err
+		}
+		invoices = append(invoices, invoice)
+	}
+
+	return invoices, total, rows.Err()
+}
+
+func (s *InvoiceServicePgImpl) SetReceiptPath(ctx context.Context, invoiceId string, path string) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE invoices
+		SET receipt_path = $1
+		WHERE invoice_id = $2;
+		`,
+		path,
+		invoiceId,
+	)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return errIfNoRowsAffected(res)
+}
+
+func (s *InvoiceServicePgImpl) GetBillingDetails(ctx context.Context, orgId string) (models.BillingDetails, error) {
+	details := models.BillingDetails{}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			company_name,
+			tax_id,
+			address_line1,
+			address_line2,
+			city,
+			state,
+			postal_code,
+			country
+		FROM organization_billing_details
+		WHERE organization_id = $1;
+		`,
+		orgId,
+	).Scan(
+		&details.CompanyName,
+		&details.TaxId,
+		&details.AddressLine1,
+		&details.AddressLine2,
+		&details.City,
+		&details.State,
+		&details.PostalCode,
+		&details.Country,
+	)
+
+	return details, errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+func (s *InvoiceServicePgImpl) SetBillingDetails(ctx context.Context, orgId string, details models.BillingDetails) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO organization_billing_details
+			(organization_id, company_name, tax_id, address_line1, address_line2, city, state, postal_code, country)
+		VALUES
+			($1, $2, $3, $4, $5, $6, $7, $8, UPPER($9))
+		ON CONFLICT (organization_id) DO UPDATE SET
+			company_name = EXCLUDED.company_name,
+			tax_id = EXCLUDED.tax_id,
+			address_line1 = EXCLUDED.address_line1,
+			address_line2 = EXCLUDED.address_line2,
+			city = EXCLUDED.city,
+			state = EXCLUDED.state,
+			postal_code = EXCLUDED.postal_code,
+			country = EXCLUDED.country;
+		`,
+		orgId,
+		details.CompanyName,
+		details.TaxId,
+		details.AddressLine1,
+		details.AddressLine2,
+		details.City,
+		details.State,
+		details.PostalCode,
+		details.Country,
+	)
+
+	return errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+func (s *InvoiceServicePgImpl) RenderReceipt(invoice models.Invoice) []byte {
+	const left, right float64 = 50, pdf.PageWidth - 50
+
+	doc := pdf.New()
+	doc.Text(left, 70, 22, true, "Receipt")
+	doc.TextRight(right, 70, 12, true, constants.ProjectName)
+
+	y := 110.0
+	for _, l := range [][2]string{
+		{"Invoice number", invoice.InvoiceNumber},
+		{"Date paid", invoice.IssuedAt.Format("January 2, 2006")},
+	} {
+		doc.Text(left, y, 10, true, l[0])
+		doc.Text(left+110, y, 10, false, l[1])
+		y += 16
+	}
+
+	y += 20
+	doc.Text(left, y, 10, true, "Billed to")
+	y += 16
+	for _, l := range billingLines(invoice.BillingDetails) {
+		doc.Text(left, y, 10, false, l)
+		y += 14
+	}
+
+	y += 30
+	doc.Text(left, y, 10, true, "Description")
+	doc.TextRight(right, y, 10, true, "Amount")
+	y += 8
+	doc.Line(left, y, right, y)
+	y += 18
+
+	description := invoice.Description
+	if invoice.PeriodStart != nil && invoice.PeriodEnd != nil {
+		description = fmt.Sprintf("%s, %s - %s", description, invoice.PeriodStart.Format("Jan 2, 2006"), invoice.PeriodEnd.Format("Jan 2, 2006"))
+	}
+	doc.Text(left, y, 10, false, description)
+	doc.TextRight(right, y, 10, false, common.FormatAmmount(invoice.Ammount, invoice.Currency))
+	y += 10
+	doc.Line(left, y, right, y)
+	y += 18
+
+	doc.Text(right-160, y, 10, true, "Amount paid")
+	doc.TextRight(right, y, 10, true, common.FormatAmmount(invoice.Ammount, invoice.Currency))
+
+	return doc.Bytes()
+}
+
+// billingLines formats billing details as the lines of an address block, skipping empty fields.
+func billingLines(d models.BillingDetails) []string {
+	deref := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+
+	lines := []string{}
+	for _, l := range []string{
+		d.CompanyName,
+		d.AddressLine1,
+		deref(d.AddressLine2),
+		strings.TrimSpace(strings.Join([]string{d.City, deref(d.State), deref(d.PostalCode)}, " ")),
+		d.Country,
+		deref(d.Email),
+	} {
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	if d.TaxId != nil && *d.TaxId != "" {
+		lines = append(lines, "Tax ID: "+*d.TaxId)
+	}
+
+	return lines
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/helpers"
+)
+
+func TestInvoiceServicePgImpl_CreatePaymentInvoice(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := helpers.NewPostgresContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %s", err)
+		}
+	})
+
+	db := pgContainer.DB
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO users (user_id, email, password_hash, first_name, last_name)
+		VALUES (1, 'buyer@email.com', 'hashtest', 'Test', 'Buyer');
+
+		INSERT INTO payments (payment_id, user_id, unit_ammount, unit_currency, payment_status)
+		VALUES
+			('00000000-0000-0000-0000-000000000001', 1, 1000, 'brl', 'complete'),
+			('00000000-0000-0000-0000-000000000002', 1, 2000, 'brl', 'complete');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &InvoiceServicePgImpl{db: db}
+
+	issue := func(paymentId string, ammount uint32) models.Invoice {
+		t.Helper()
+		invoice, err := s.CreatePaymentInvoice(ctx, models.Payment{
+			PaymentId:    paymentId,
+			UserId:       1,
+			UnitAmmount:  ammount,
+			UnitCurrency: "BRL",
+		})
+		if err != nil {
+			t.Fatalf("InvoiceServicePgImpl.CreatePaymentInvoice() error = %v", err)
+		}
+		return invoice
+	}
+
+	first := issue("00000000-0000-0000-0000-000000000001", 1000)
+	if first.InvoiceNumber != "INV-000001" {
+		t.Errorf("expected the first invoice to be INV-000001, got %s", first.InvoiceNumber)
+	}
+
+	// issuing again returns the invoice and does not use up a number
+	again := issue("00000000-0000-0000-0000-000000000001", 1000)
+	if again.InvoiceId != first.InvoiceId || again.InvoiceNumber != first.InvoiceNumber {
+		t.Errorf("expected the issued invoice %s back, got %s", first.InvoiceNumber, again.InvoiceNumber)
+	}
+
+	second := issue("00000000-0000-0000-0000-000000000002", 2000)
+	if second.InvoiceNumber != "INV-000002" {
+		t.Errorf("expected numbers without gaps, got %s after %s", second.InvoiceNumber, first.InvoiceNumber)
+	}
+}
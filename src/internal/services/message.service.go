@@ -0,0 +1,26 @@
+package services
+
+import (
+	"context"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+)
+
+// Message is how a user is told about something: an email and an in-app notification.
+type Message struct {
+	UserId           uint32
+	Kind             models.NotificationKind
+	NotificationData map[string]any
+	TemplateName     string
+	To               string
+	Locale           string
+	EmailData        any
+}
+
+// MessageService defines the interface for telling users about something both by email
+// and in-app, for the events that are processed again when they fail.
+type MessageService interface {
+	// Send enqueues the email of the message and creates its notification in one transaction,
+	// so a failure leaves neither and sending the message again does not repeat either.
+	Send(ctx context.Context, msg Message) error
+}
@@ -0,0 +1,43 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+)
+
+type MessageServicePgImpl struct {
+	db                  *sql.DB
+	outboxService       OutboxService
+	notificationService NotificationService
+}
+
+func NewMessageServicePgImpl(db *sql.DB, outboxService OutboxService, notificationService NotificationService) MessageService {
+	return &MessageServicePgImpl{
+		db:                  db,
+		outboxService:       outboxService,
+		notificationService: notificationService,
+	}
+}
+
+func (s *MessageServicePgImpl) Send(ctx context.Context, msg Message) error {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return errors.Join(err, constants.ErrDbTransactionCreate)
+	}
+	defer tx.Rollback()
+
+	err = s.outboxService.Tx(tx).Send(ctx, msg.TemplateName, msg.To, msg.Locale, msg.EmailData)
+	if err != nil {
+		return err
+	}
+
+	err = s.notificationService.Tx(tx).Notify(ctx, msg.UserId, msg.Kind, msg.NotificationData)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
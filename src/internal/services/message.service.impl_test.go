@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/templates"
+	"github.com/LombardiDaniel/goliath/src/pkg/helpers"
+)
+
+func TestMessageServicePgImpl_Send(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := helpers.NewPostgresContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %s", err)
+		}
+	})
+
+	db := pgContainer.DB
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO users (user_id, email, password_hash, first_name, last_name)
+		VALUES (1, 'jane@email.com', 'hashtest', 'Jane', 'Doe');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := templates.NewRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outboxService := NewOutboxServicePgImpl(db, &EmailServiceMock{}, registry)
+	notificationService := NewNotificationServicePgImpl(db, pgContainer.ConnString)
+	s := NewMessageServicePgImpl(db, outboxService, notificationService)
+
+	msg := func(userId uint32) Message {
+		return Message{
+			UserId:           userId,
+			Kind:             models.PaymentCanceledNotification,
+			NotificationData: map[string]any{"PaymentId": "pay_1"},
+			TemplateName:     templates.PaymentCanceled,
+			To:               "jane@email.com",
+			EmailData:        map[string]any{"FirstName": "Jane", "PaymentId": "pay_1"},
+		}
+	}
+
+	if err := s.Send(ctx, msg(1)); err != nil {
+		t.Fatalf("MessageServicePgImpl.Send() error = %v", err)
+	}
+
+	// a failed notification leaves no email, so the message can be sent again without a duplicate
+	if err := s.Send(ctx, msg(2)); err == nil {
+		t.Fatalf("MessageServicePgImpl.Send() to no user should fail")
+	}
+
+	_, emails, err := outboxService.GetEmails(ctx, nil, 0, 10)
+	if err != nil || emails != 1 {
+		t.Errorf("expected the email of the sent message only, got %d, %v", emails, err)
+	}
+	if count, err := notificationService.CountUnread(ctx, 1); err != nil || count != 1 {
+		t.Errorf("expected the notification of the sent message, got %d, %v", count, err)
+	}
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+)
+
+// Notifier defines the interface for creating in-app notifications.
+type Notifier interface {
+	// Notify creates a notification of a user and publishes it to their live streams.
+	Notify(ctx context.Context, userId uint32, kind models.NotificationKind, data map[string]any) error
+}
+
+// NotificationService defines the interface for the in-app notifications of users: their
+// feed, what they read and the live streams of their new notifications. Notifications are
+// published through Postgres, so every instance streams the ones created by the others.
+type NotificationService interface {
+	Notifier
+
+	// Tx returns a Notifier that creates notifications within tx, they are only published if tx commits.
+	Tx(tx *sql.Tx) Notifier
+
+	// GetNotifications retrieves a page of the notifications of a user (only unread ones if
+	// unread), newest first, their total count and the count of unread ones.
+	GetNotifications(ctx context.Context, userId uint32, unread bool, offset int, limit int) ([]models.Notification, int64, int64, error)
+
+	// CountUnread counts the unread notifications of a user.
+	CountUnread(ctx context.Context, userId uint32) (int64, error)
+
+	// MarkAsRead marks a notification of a user as read, returns constants.ErrNoRows if the user has no such notification.
+	MarkAsRead(ctx context.Context, userId uint32, notificationId int64) error
+
+	// MarkAllAsRead marks every notification of a user as read.
+	MarkAllAsRead(ctx context.Context, userId uint32) error
+
+	// Subscribe returns the live stream of the new notifications of a user and the function
+	// that closes it. Slow streams miss the notifications they fall too far behind of.
+	Subscribe(userId uint32) (<-chan models.Notification, func())
+
+	// Listen receives the notifications published by every instance and fans them out to
+	// the streams of this one, it blocks until the connection to Postgres is lost.
+	Listen() error
+}
@@ -0,0 +1,303 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/validators"
+	"github.com/lib/pq"
+)
+
+type queryer interface {
+	execer
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// notificationWriter is the Notifier that creates notifications through q.
+type notificationWriter struct {
+	q queryer
+}
+
+func (w notificationWriter) Notify(ctx context.Context, userId uint32, kind models.NotificationKind, data map[string]any) error {
+	if data == nil {
+		data = map[string]any{}
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return errors.Join(err, errors.New("could not marshal notification data"))
+	}
+
+	n := models.Notification{
+		UserId:   userId,
+		Kind:     kind,
+		Category: kind.Category(),
+		Data:     data,
+	}
+	err = w.q.QueryRowContext(ctx, `
+		INSERT INTO notifications (user_id, kind, category, data)
+		VALUES ($1, $2, $3, $4)
+		RETURNING notification_id, created_at;
+		`,
+		n.UserId,
+		n.Kind,
+		n.Category,
+		payload,
+	).Scan(&n.NotificationId, &n.CreatedAt)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	msg, err := json.Marshal(n)
+	if err != nil {
+		return errors.Join(err, errors.New("could not marshal notification"))
+	}
+
+	// within a transaction, postgres only delivers it once committed
+	_, err = w.q.ExecContext(ctx, `
+		SELECT pg_notify($1, $2);
+		`,
+		constants.NotificationsPgChannel,
+		string(msg),
+	)
+	return errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+type NotificationServicePgImpl struct {
+	notificationWriter
+	db        *sql.DB
+	pgConnStr string
+
+	mu      sync.Mutex
+	streams map[uint32]map[chan models.Notification]struct{}
+}
+
+// NewNotificationServicePgImpl creates the notification service, pgConnStr is the one of db,
+// Listen opens a connection of its own with it.
+func NewNotificationServicePgImpl(db *sql.DB, pgConnStr string) NotificationService {
+	return &NotificationServicePgImpl{
+		notificationWriter: notificationWriter{q: db},
+		db:                 db,
+		pgConnStr:          pgConnStr,
+		streams:            map[uint32]map[chan models.Notification]struct{}{},
+	}
+}
+
+func (s *NotificationServicePgImpl) Tx(tx *sql.Tx) Notifier {
+	return notificationWriter{q: tx}
+}
+
+const notificationColumns = `
+	notification_id,
+	user_id,
+	kind,
+	category,
+	data,
+	read_at,
+	created_at`
+
+func scanNotification(row rowScanner) (models.Notification, error) {
+	n := models.Notification{}
+	var data []byte
+	err := row.Scan(
+		&n.NotificationId,
+		&n.UserId,
+		&n.Kind,
+		&n.Category,
+		&data,
+		&n.ReadAt,
+		&n.CreatedAt,
+	)
+	if err != nil {
+		return n, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	err = json.Unmarshal(data, &n.Data)
+	if err != nil {
+		return n, errors.Join(err, errors.New("could not unmarshal notification data"))
+	}
+
+	return n, nil
+}
+
+func (s *NotificationServicePgImpl) GetNotifications(ctx context.Context, userId uint32, unread bool, offset int, limit int) ([]models.Notification, int64, int64, error) {
+	notifications := []models.Notification{}
+
+	var total, unreadCount int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*) FILTER (WHERE NOT $2 OR read_at IS NULL),
+			COUNT(*) FILTER (WHERE read_at IS NULL)
+		FROM notifications
+		WHERE user_id = $1;
+		`,
+		userId,
+		unread,
+	).Scan(&total, &unreadCount)
+	if err != nil {
+		return notifications, 0, 0, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+notificationColumns+`
+		FROM notifications
+		WHERE
+			user_id = $1 AND
+			(NOT $2 OR read_at IS NULL)
+		ORDER BY created_at DESC, notification_id DESC
+		OFFSET $3
+		LIMIT $4;
+		`,
+		userId,
+		unread,
+		offset,
+		limit,
+	)
+	if err != nil {
+		return notifications, 0, 0, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return notifications, 0, 0, err
+		}
+		notifications = append(notifications, n)
+	}
+
+	return notifications, total, unreadCount, rows.Err()
+}
+
+func (s *NotificationServicePgImpl) CountUnread(ctx context.Context, userId uint32) (int64, error) {
+	var unread int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM notifications
+		WHERE
+			user_id = $1 AND
+			read_at IS NULL;
+		`,
+		userId,
+	).Scan(&unread)
+	return unread, errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+func (s *NotificationServicePgImpl) MarkAsRead(ctx context.Context, userId uint32, notificationId int64) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE notifications
+		SET read_at = COALESCE(read_at, NOW())
+		WHERE
+			notification_id = $1 AND
+			user_id = $2;
+		`,
+		notificationId,
+		userId,
+	)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return errIfNoRowsAffected(res)
+}
+
+func (s *NotificationServicePgImpl) MarkAllAsRead(ctx context.Context, userId uint32) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE notifications
+		SET read_at = NOW()
+		WHERE
+			user_id = $1 AND
+			read_at IS NULL;
+		`,
+		userId,
+	)
+	return errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+func (s *NotificationServicePgImpl) Subscribe(userId uint32) (<-chan models.Notification, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream := make(chan models.Notification, constants.NotificationStreamBuffer)
+	if s.streams[userId] == nil {
+		s.streams[userId] = map[chan models.Notification]struct{}{}
+	}
+	s.streams[userId][stream] = struct{}{}
+
+	return stream, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if _, ok := s.streams[userId][stream]; !ok {
+			return
+		}
+		delete(s.streams[userId], stream)
+		if len(s.streams[userId]) == 0 {
+			delete(s.streams, userId)
+		}
+		close(stream)
+	}
+}
+
+func (s *NotificationServicePgImpl) publish(n models.Notification) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for stream := range s.streams[n.UserId] {
+		select {
+		case stream <- n:
+		default:
+			slog.Warn(fmt.Sprintf("notification stream of user %d is full, dropped notification %d", n.UserId, n.NotificationId))
+		}
+	}
+}
+
+func (s *NotificationServicePgImpl) Listen() error {
+	listener := pq.NewListener(s.pgConnStr, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Warn(fmt.Sprintf("notifications listener: %s", err.Error()))
+		}
+	})
+	defer listener.Close()
+
+	err := listener.Listen(constants.NotificationsPgChannel)
+	if err != nil {
+		return errors.Join(err, errors.New("could not listen for notifications"))
+	}
+
+	ping := time.NewTicker(time.Minute)
+	defer ping.Stop()
+
+	for {
+		select {
+		case pgNotification := <-listener.Notify:
+			// the listener reconnected, the notifications published meanwhile are lost to the
+			// streams, clients catch up through the feed
+			if pgNotification == nil {
+				slog.Warn("notifications listener reconnected")
+				continue
+			}
+
+			var n models.Notification
+			err := json.Unmarshal([]byte(pgNotification.Extra), &n)
+			if err != nil {
+				slog.Error(fmt.Sprintf("could not unmarshal published notification: %s", err.Error()))
+				continue
+			}
+			s.publish(n)
+		case <-ping.C:
+			err := listener.Ping()
+			if err != nil {
+				return errors.Join(err, errors.New("notifications listener is disconnected"))
+			}
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/helpers"
+)
+
+func TestNotificationServicePgImpl_Feed(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := helpers.NewPostgresContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %s", err)
+		}
+	})
+
+	db := pgContainer.DB
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO users (user_id, email, password_hash, first_name, last_name)
+		VALUES
+			(1, 'jane@email.com', 'hashtest', 'Jane', 'Doe'),
+			(2, 'john@email.com', 'hashtest', 'John', 'Doe');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewNotificationServicePgImpl(db, pgContainer.ConnString)
+
+	for _, kind := range []models.NotificationKind{
+		models.PaymentAcceptedNotification,
+		models.OrganizationInviteNotification,
+		models.PaymentFailedNotification,
+	} {
+		err := s.Notify(ctx, 1, kind, map[string]any{"OrganizationName": "org"})
+		if err != nil {
+			t.Fatalf("NotificationServicePgImpl.Notify() error = %v", err)
+		}
+	}
+	if err := s.Notify(ctx, 2, models.PaymentAcceptedNotification, nil); err != nil {
+		t.Fatalf("NotificationServicePgImpl.Notify() error = %v", err)
+	}
+
+	// notifications of a rolled back transaction are not created
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Tx(tx).Notify(ctx, 1, models.PaymentCanceledNotification, nil); err != nil {
+		t.Fatalf("NotificationServicePgImpl.Tx().Notify() error = %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	notifications, total, unread, err := s.GetNotifications(ctx, 1, false, 0, 2)
+	if err != nil || total != 3 || unread != 3 || len(notifications) != 2 {
+		t.Fatalf("NotificationServicePgImpl.GetNotifications() = %+v, %d, %d, %v", notifications, total, unread, err)
+	}
+	newest := notifications[0]
+	if newest.Kind != models.PaymentFailedNotification || newest.Category != models.BillingNotifications || newest.Data["OrganizationName"] != "org" {
+		t.Errorf("expected the newest notification first with its data, got %+v", newest)
+	}
+	if notifications[1].Category != models.OrgActivityNotifications {
+		t.Errorf("expected the invite in the org activity category, got %+v", notifications[1])
+	}
+
+	// users only read their own notifications
+	if err := s.MarkAsRead(ctx, 2, newest.NotificationId); !errors.Is(err, constants.ErrNoRows) {
+		t.Errorf("marking the notification of another user should return ErrNoRows, got %v", err)
+	}
+	if err := s.MarkAsRead(ctx, 1, newest.NotificationId); err != nil {
+		t.Fatalf("NotificationServicePgImpl.MarkAsRead() error = %v", err)
+	}
+	if err := s.MarkAsRead(ctx, 1, newest.NotificationId); err != nil {
+		t.Errorf("marking a read notification again should succeed, got %v", err)
+	}
+
+	notifications, total, unread, err = s.GetNotifications(ctx, 1, true, 0, 10)
+	if err != nil || total != 2 || unread != 2 || len(notifications) != 2 {
+		t.Fatalf("NotificationServicePgImpl.GetNotifications() unread = %+v, %d, %d, %v", notifications, total, unread, err)
+	}
+	for _, n := range notifications {
+		if n.NotificationId == newest.NotificationId || n.ReadAt != nil {
+			t.Errorf("only unread notifications should be listed, got %+v", n)
+		}
+	}
+
+	if err := s.MarkAllAsRead(ctx, 1); err != nil {
+		t.Fatalf("NotificationServicePgImpl.MarkAllAsRead() error = %v", err)
+	}
+	if count, err := s.CountUnread(ctx, 1); err != nil || count != 0 {
+		t.Errorf("NotificationServicePgImpl.CountUnread() = %d, %v, want 0", count, err)
+	}
+	if count, err := s.CountUnread(ctx, 2); err != nil || count != 1 {
+		t.Errorf("the notifications of other users should stay unread, got %d, %v", count, err)
+	}
+}
+
+func TestNotificationServicePgImpl_Listen(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := helpers.NewPostgresContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %s", err)
+		}
+	})
+
+	db := pgContainer.DB
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO users (user_id, email, password_hash, first_name, last_name)
+		VALUES
+			(1, 'jane@email.com', 'hashtest', 'Jane', 'Doe'),
+			(2, 'john@email.com', 'hashtest', 'John', 'Doe');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the listener only returns once the container is gone
+	s := NewNotificationServicePgImpl(db, pgContainer.ConnString)
+	go s.Listen()
+
+	// another instance publishes the notifications, the streams of this one get them
+	publisher := NewNotificationServicePgImpl(db, pgContainer.ConnString)
+
+	stream, unsubscribe := s.Subscribe(1)
+	defer unsubscribe()
+	other, unsubscribeOther := s.Subscribe(2)
+	defer unsubscribeOther()
+
+	// notifications published before the listener is up are missed, so publish until one arrives
+	var received models.Notification
+	deadline := time.After(30 * time.Second)
+wait:
+	for {
+		if err := publisher.Notify(ctx, 1, models.PaymentAcceptedNotification, map[string]any{"PaymentId": "pay_1"}); err != nil {
+			t.Fatalf("NotificationServicePgImpl.Notify() error = %v", err)
+		}
+		select {
+		case received = <-stream:
+			break wait
+		case <-time.After(500 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("the stream did not get the published notification")
+		}
+	}
+	if received.UserId != 1 || received.Kind != models.PaymentAcceptedNotification || received.NotificationId == 0 || received.Data["PaymentId"] != "pay_1" {
+		t.Errorf("unexpected streamed notification %+v", received)
+	}
+
+	// notifications are only streamed once their transaction commits
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.Tx(tx).Notify(ctx, 2, models.PaymentCanceledNotification, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case n := <-other:
+		t.Fatalf("a notification was streamed before its transaction committed: %+v", n)
+	case <-time.After(time.Second):
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case n := <-other:
+		if n.UserId != 2 || n.Kind != models.PaymentCanceledNotification {
+			t.Errorf("unexpected streamed notification %+v", n)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the committed notification was not streamed")
+	}
+
+	// closed streams get nothing more
+	unsubscribeOther()
+	if _, ok := <-other; ok {
+		t.Errorf("an unsubscribed stream should be closed")
+	}
+}
@@ -0,0 +1,619 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/storage"
+)
+
+// fsObjectAttrs are the attributes of an object the filesystem does not keep.
+type fsObjectAttrs struct {
+	ContentType string            `json:"contentType"`
+	ETag        string            `json:"etag"`
+	SHA256      string            `json:"sha256,omitempty"`
+	Metadata    map[string]string `json:"metadata"`
+	Tags        map[string]string `json:"tags"`
+}
+
+// fsUpload is a multipart upload in progress.
+type fsUpload struct {
+	Bucket string        `json:"bucket"`
+	Path   string        `json:"path"`
+	Attrs  fsObjectAttrs `json:"attrs"`
+}
+
+// ObjectServiceFsImpl keeps objects as files under a directory, for local development.
+// The content of an object is at objects/<bucket>/<path> and its attributes at
+// attrs/<bucket>/<path>.json, so unlike S3 a path cannot be both an object and the prefix
+// of others (a and a/b). The parts of multipart uploads are at uploads/<id>/<number>-<etag>,
+// with the upload in uploads/<id>.json. Its URLs are served by the API, see handlers.ObjectHandler.
+type ObjectServiceFsImpl struct {
+	root   string
+	signer *storage.UrlSigner
+
+	mu sync.Mutex // serializes the updates of attributes
+}
+
+func NewObjectServiceFsImpl(root string, signer *storage.UrlSigner) (ObjectService, error) {
+	for _, dir := range []string{"objects", "attrs", "uploads", "tmp"} {
+		err := os.MkdirAll(filepath.Join(root, dir), 0o755)
+		if err != nil {
+			return nil, errors.Join(err, fmt.Errorf("could not create object storage dir '%s'", root))
+		}
+	}
+
+	return &ObjectServiceFsImpl{
+		root:   root,
+		signer: signer,
+	}, nil
+}
+
+func (s *ObjectServiceFsImpl) objectFile(bucket string, path string) string {
+	return filepath.Join(s.root, "objects", bucket, filepath.FromSlash(path))
+}
+
+func (s *ObjectServiceFsImpl) attrsFile(bucket string, path string) string {
+	return filepath.Join(s.root, "attrs", bucket, filepath.FromSlash(path)+".json")
+}
+
+// fsErr joins constants.ErrObjectNotFound to the errors of missing files.
+func fsErr(err error, msg string) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return errors.Join(err, errors.New(msg), constants.ErrObjectNotFound)
+	}
+	return errors.Join(err, errors.New(msg))
+}
+
+// writeFile writes the file through a temporary one, so it is never seen half written.
+func (s *ObjectServiceFsImpl) writeFile(name string, data io.Reader) (int64, error) {
+	err := os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(s.root, "tmp"), "object-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, data)
+	if err != nil {
+		tmp.Close()
+		return n, err
+	}
+	if err := tmp.Close(); err != nil {
+		return n, err
+	}
+
+	return n, os.Rename(tmp.Name(), name)
+}
+
+func (s *ObjectServiceFsImpl) readAttrs(bucket string, path string) (fsObjectAttrs, error) {
+	attrs := fsObjectAttrs{}
+	b, err := os.ReadFile(s.attrsFile(bucket, path))
+	if err != nil {
+		return attrs, err
+	}
+	return attrs, json.Unmarshal(b, &attrs)
+}
+
+func (s *ObjectServiceFsImpl) writeAttrs(bucket string, path string, attrs fsObjectAttrs) error {
+	b, err := json.Marshal(attrs)
+	if err != nil {
+		return err
+	}
+	_, err = s.writeFile(s.attrsFile(bucket, path), strings.NewReader(string(b)))
+	return err
+}
+
+func (s *ObjectServiceFsImpl) Upload(ctx context.Context, bucket string, path string, size int64, data io.Reader, opts models.ObjectOptions) error {
+	if err := checkObjectPath(bucket, path); err != nil {
+		return err
+	}
+
+	tmp, etag, checksum, err := s.writeTemp(size, data)
+	if err != nil {
+		return errors.Join(err, errors.New("could not write obj"))
+	}
+	defer os.Remove(tmp)
+
+	if opts.SHA256 != "" && checksum != opts.SHA256 {
+		return constants.ErrChecksumMismatch
+	}
+
+	return s.commit(bucket, path, tmp, fsObjectAttrs{
+		ContentType: cmp.Or(opts.ContentType, "application/octet-stream"),
+		ETag:        etag,
+		SHA256:      opts.SHA256,
+		Metadata:    lowerKeys(opts.Metadata),
+		Tags:        opts.Tags,
+	})
+}
+
+// writeTemp writes data to a temporary file, returning its name, md5 and sha256. The caller
+// removes it.
+func (s *ObjectServiceFsImpl) writeTemp(size int64, data io.Reader) (string, string, string, error) {
+	tmp, err := os.CreateTemp(filepath.Join(s.root, "tmp"), "object-*")
+	if err != nil {
+		return "", "", "", err
+	}
+
+	md5Hash, sha256Hash := md5.New(), sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, md5Hash, sha256Hash), data)
+	tmp.Close()
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("has %d bytes, expected %d", n, size)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", "", "", err
+	}
+
+	return tmp.Name(), hex.EncodeToString(md5Hash.Sum(nil)), hex.EncodeToString(sha256Hash.Sum(nil)), nil
+}
+
+// commit moves the written content of an object in place. Its attributes are written first,
+// an object without them would be listed with no etag.
+func (s *ObjectServiceFsImpl) commit(bucket string, path string, tmp string, attrs fsObjectAttrs) error {
+	err := s.writeAttrs(bucket, path, attrs)
+	if err != nil {
+		return errors.Join(err, errors.New("could not write obj attrs"))
+	}
+
+	name := s.objectFile(bucket, path)
+	err = os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return errors.Join(err, errors.New("could not create obj dir"))
+	}
+	return fsErr(os.Rename(tmp, name), "could not put obj")
+}
+
+// fsObjectReader reads a range of an object file.
+type fsObjectReader struct {
+	io.Reader
+	io.Closer
+}
+
+func (s *ObjectServiceFsImpl) Download(ctx context.Context, bucket string, path string, rng *models.ByteRange) (io.ReadCloser, error) {
+	if err := checkObjectPath(bucket, path); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(s.objectFile(bucket, path))
+	if err != nil {
+		return nil, fsErr(err, "could not open obj")
+	}
+	if rng == nil {
+		return f, nil
+	}
+
+	if rng.Start < 0 || rng.Start > rng.End {
+		f.Close()
+		return nil, fmt.Errorf("invalid obj range %d-%d", rng.Start, rng.End)
+	}
+	_, err = f.Seek(rng.Start, io.SeekStart)
+	if err != nil {
+		f.Close()
+		return nil, errors.Join(err, errors.New("could not seek obj"))
+	}
+	return fsObjectReader{Reader: io.LimitReader(f, rng.Len()), Closer: f}, nil
+}
+
+func (s *ObjectServiceFsImpl) Stat(ctx context.Context, bucket string, path string) (models.ObjectInfo, error) {
+	if err := checkObjectPath(bucket, path); err != nil {
+		return models.ObjectInfo{}, err
+	}
+
+	fi, err := os.Stat(s.objectFile(bucket, path))
+	if err != nil {
+		return models.ObjectInfo{}, fsErr(err, "could not stat obj")
+	}
+
+	attrs, err := s.readAttrs(bucket, path)
+	if err != nil {
+		return models.ObjectInfo{}, fsErr(err, "could not read obj attrs")
+	}
+
+	return models.ObjectInfo{
+		Path:         path,
+		Size:         fi.Size(),
+		ContentType:  attrs.ContentType,
+		ETag:         attrs.ETag,
+		LastModified: fi.ModTime(),
+		Metadata:     lowerKeys(attrs.Metadata),
+		SHA256:       attrs.SHA256,
+	}, nil
+}
+
+func (s *ObjectServiceFsImpl) List(ctx context.Context, bucket string, prefix string, after string, limit int) ([]models.ObjectInfo, string, error) {
+	if err := checkBucket(bucket); err != nil {
+		return nil, "", err
+	}
+
+	dir := filepath.Join(s.root, "objects", bucket)
+	objs := []models.ObjectInfo{}
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		path := filepath.ToSlash(rel)
+		if !strings.HasPrefix(path, prefix) || path <= after {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		attrs, err := s.readAttrs(bucket, path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		objs = append(objs, models.ObjectInfo{
+			Path:         path,
+			Size:         fi.Size(),
+			ETag:         attrs.ETag,
+			LastModified: fi.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, "", errors.Join(err, errors.New("could not list objs"))
+	}
+
+	// directories are walked in lexical order of their names, not of the full paths
+	slices.SortFunc(objs, func(a, b models.ObjectInfo) int { return strings.Compare(a.Path, b.Path) })
+	return objectPage(objs, prefix, after, limit)
+}
+
+func (s *ObjectServiceFsImpl) Delete(ctx context.Context, bucket string, path string) error {
+	if err := checkObjectPath(bucket, path); err != nil {
+		return err
+	}
+
+	for _, name := range []string{s.objectFile(bucket, path), s.attrsFile(bucket, path)} {
+		err := os.Remove(name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return errors.Join(err, errors.New("could not remove obj"))
+		}
+	}
+	return nil
+}
+
+func (s *ObjectServiceFsImpl) DeleteMany(ctx context.Context, bucket string, paths []string) error {
+	var errs []error
+	for _, path := range paths {
+		errs = append(errs, s.Delete(ctx, bucket, path))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *ObjectServiceFsImpl) Copy(ctx context.Context, bucket string, src string, dst string) error {
+	if err := checkObjectPath(bucket, src); err != nil {
+		return err
+	}
+	if err := checkObjectPath(bucket, dst); err != nil {
+		return err
+	}
+
+	attrs, err := s.readAttrs(bucket, src)
+	if err != nil {
+		return fsErr(err, "could not read obj attrs")
+	}
+
+	f, err := os.Open(s.objectFile(bucket, src))
+	if err != nil {
+		return fsErr(err, "could not open obj")
+	}
+	defer f.Close()
+
+	err = s.writeAttrs(bucket, dst, attrs)
+	if err != nil {
+		return errors.Join(err, errors.New("could not write obj attrs"))
+	}
+	_, err = s.writeFile(s.objectFile(bucket, dst), f)
+	return fsErr(err, "could not copy obj")
+}
+
+func (s *ObjectServiceFsImpl) Move(ctx context.Context, bucket string, src string, dst string) error {
+	err := s.Copy(ctx, bucket, src, dst)
+	if err != nil {
+		return err
+	}
+	return s.Delete(ctx, bucket, src)
+}
+
+// updateAttrs applies update to the attributes of an existing object.
+func (s *ObjectServiceFsImpl) updateAttrs(bucket string, path string, update func(*fsObjectAttrs)) error {
+	if err := checkObjectPath(bucket, path); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attrs, err := s.readAttrs(bucket, path)
+	if err != nil {
+		return fsErr(err, "could not read obj attrs")
+	}
+
+	update(&attrs)
+	err = s.writeAttrs(bucket, path, attrs)
+	return fsErr(err, "could not write obj attrs")
+}
+
+func (s *ObjectServiceFsImpl) SetMetadata(ctx context.Context, bucket string, path string, metadata map[string]string) error {
+	return s.updateAttrs(bucket, path, func(attrs *fsObjectAttrs) {
+		attrs.Metadata = lowerKeys(metadata)
+	})
+}
+
+func (s *ObjectServiceFsImpl) GetTags(ctx context.Context, bucket string, path string) (map[string]string, error) {
+	if err := checkObjectPath(bucket, path); err != nil {
+		return nil, err
+	}
+
+	attrs, err := s.readAttrs(bucket, path)
+	if err != nil {
+		return nil, fsErr(err, "could not read obj attrs")
+	}
+	if attrs.Tags == nil {
+		attrs.Tags = map[string]string{}
+	}
+	return attrs.Tags, nil
+}
+
+func (s *ObjectServiceFsImpl) SetTags(ctx context.Context, bucket string, path string, tags map[string]string) error {
+	return s.updateAttrs(bucket, path, func(attrs *fsObjectAttrs) {
+		attrs.Tags = tags
+	})
+}
+
+func (s *ObjectServiceFsImpl) uploadDir(uploadId string) string {
+	return filepath.Join(s.root, "uploads", uploadId)
+}
+
+func (s *ObjectServiceFsImpl) CreateMultipartUpload(ctx context.Context, bucket string, path string, opts models.ObjectOptions) (string, error) {
+	if err := checkObjectPath(bucket, path); err != nil {
+		return "", err
+	}
+	if opts.SHA256 != "" {
+		return "", errors.New("multipart uploads are verified by part, not by a checksum of the obj")
+	}
+
+	uploadId := rand.Text()
+	b, err := json.Marshal(fsUpload{
+		Bucket: bucket,
+		Path:   path,
+		Attrs: fsObjectAttrs{
+			ContentType: cmp.Or(opts.ContentType, "application/octet-stream"),
+			Metadata:    lowerKeys(opts.Metadata),
+			Tags:        opts.Tags,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = os.Mkdir(s.uploadDir(uploadId), 0o755)
+	if err != nil {
+		return "", errors.Join(err, errors.New("could not create multipart upload"))
+	}
+	_, err = s.writeFile(s.uploadDir(uploadId)+".json", strings.NewReader(string(b)))
+	if err != nil {
+		return "", errors.Join(err, errors.New("could not create multipart upload"))
+	}
+	return uploadId, nil
+}
+
+// readUpload retrieves an upload of the object.
+func (s *ObjectServiceFsImpl) readUpload(bucket string, path string, uploadId string) (fsUpload, error) {
+	upload := fsUpload{}
+
+	// ids are generated by rand.Text, anything else could escape the uploads directory
+	if uploadId == "" || strings.ContainsFunc(uploadId, func(r rune) bool { return !('A' <= r && r <= 'Z' || '0' <= r && r <= '9') }) {
+		return upload, errors.Join(constants.ErrUploadNotFound, fmt.Errorf("invalid upload id '%s'", uploadId))
+	}
+
+	b, err := os.ReadFile(s.uploadDir(uploadId) + ".json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return upload, errors.Join(err, constants.ErrUploadNotFound)
+	}
+	if err != nil {
+		return upload, errors.Join(err, errors.New("could not read multipart upload"))
+	}
+	err = json.Unmarshal(b, &upload)
+	if err != nil {
+		return upload, errors.Join(err, errors.New("could not read multipart upload"))
+	}
+
+	if upload.Bucket != bucket || upload.Path != path {
+		return upload, errors.Join(constants.ErrUploadNotFound, fmt.Errorf("upload '%s' is not of '%s/%s'", uploadId, bucket, path))
+	}
+	return upload, nil
+}
+
+// readParts retrieves the uploaded parts of an upload by number, with the names of their files.
+func (s *ObjectServiceFsImpl) readParts(uploadId string) (map[int]models.ObjectPart, map[int]string, error) {
+	entries, err := os.ReadDir(s.uploadDir(uploadId))
+	if err != nil {
+		return nil, nil, fsErr(err, "could not read obj parts")
+	}
+
+	parts := map[int]models.ObjectPart{}
+	names := map[int]string{}
+	for _, entry := range entries {
+		numberStr, etag, _ := strings.Cut(entry.Name(), "-")
+		partNumber, err := strconv.Atoi(numberStr)
+		if err != nil {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			return nil, nil, fsErr(err, "could not stat obj part")
+		}
+		parts[partNumber] = models.ObjectPart{PartNumber: partNumber, ETag: etag, Size: fi.Size()}
+		names[partNumber] = filepath.Join(s.uploadDir(uploadId), entry.Name())
+	}
+	return parts, names, nil
+}
+
+func (s *ObjectServiceFsImpl) UploadPart(ctx context.Context, bucket string, path string, uploadId string, partNumber int, size int64, data io.Reader, sha256 string) (models.ObjectPart, error) {
+	if err := checkPartNumber(partNumber); err != nil {
+		return models.ObjectPart{}, err
+	}
+	if _, err := s.readUpload(bucket, path, uploadId); err != nil {
+		return models.ObjectPart{}, err
+	}
+
+	tmp, etag, checksum, err := s.writeTemp(size, data)
+	if err != nil {
+		return models.ObjectPart{}, errors.Join(err, errors.New("could not write obj part"))
+	}
+	defer os.Remove(tmp)
+
+	if sha256 != "" && checksum != sha256 {
+		return models.ObjectPart{}, constants.ErrChecksumMismatch
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the etag is kept in the name, a part uploaded again replaces the previous one
+	_, names, err := s.readParts(uploadId)
+	if err != nil {
+		return models.ObjectPart{}, err
+	}
+	name := filepath.Join(s.uploadDir(uploadId), fmt.Sprintf("%05d-%s", partNumber, etag))
+	err = os.Rename(tmp, name)
+	if err != nil {
+		return models.ObjectPart{}, fsErr(err, "could not put obj part")
+	}
+	if prev, ok := names[partNumber]; ok && prev != name {
+		os.Remove(prev)
+	}
+
+	fi, err := os.Stat(name)
+	if err != nil {
+		return models.ObjectPart{}, fsErr(err, "could not stat obj part")
+	}
+	return models.ObjectPart{PartNumber: partNumber, ETag: etag, Size: fi.Size()}, nil
+}
+
+func (s *ObjectServiceFsImpl) ListParts(ctx context.Context, bucket string, path string, uploadId string) ([]models.ObjectPart, error) {
+	if _, err := s.readUpload(bucket, path, uploadId); err != nil {
+		return nil, err
+	}
+
+	parts, _, err := s.readParts(uploadId)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []models.ObjectPart{}
+	for _, partNumber := range slices.Sorted(maps.Keys(parts)) {
+		list = append(list, parts[partNumber])
+	}
+	return list, nil
+}
+
+func (s *ObjectServiceFsImpl) CompleteMultipartUpload(ctx context.Context, bucket string, path string, uploadId string, parts []models.ObjectPart) error {
+	upload, err := s.readUpload(bucket, path, uploadId)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	uploaded, names, err := s.readParts(uploadId)
+	if err != nil {
+		return err
+	}
+	if err := checkCompleteParts(parts, uploaded); err != nil {
+		return err
+	}
+
+	files := []io.Reader{}
+	for _, part := range parts {
+		f, err := os.Open(names[part.PartNumber])
+		if err != nil {
+			return fsErr(err, "could not open obj part")
+		}
+		defer f.Close()
+		files = append(files, f)
+	}
+
+	tmp, etag, _, err := s.writeTemp(-1, io.MultiReader(files...))
+	if err != nil {
+		return errors.Join(err, errors.New("could not write obj"))
+	}
+	defer os.Remove(tmp)
+
+	upload.Attrs.ETag = etag
+	err = s.commit(bucket, path, tmp, upload.Attrs)
+	if err != nil {
+		return err
+	}
+	return s.removeUpload(uploadId)
+}
+
+func (s *ObjectServiceFsImpl) removeUpload(uploadId string) error {
+	err := os.Remove(s.uploadDir(uploadId) + ".json")
+	if err != nil {
+		return fsErr(err, "could not remove multipart upload")
+	}
+	return fsErr(os.RemoveAll(s.uploadDir(uploadId)), "could not remove obj parts")
+}
+
+func (s *ObjectServiceFsImpl) AbortMultipartUpload(ctx context.Context, bucket string, path string, uploadId string) error {
+	if _, err := s.readUpload(bucket, path, uploadId); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.removeUpload(uploadId)
+}
+
+func (s *ObjectServiceFsImpl) SignedUrl(ctx context.Context, bucket string, path string, exp time.Duration) (string, error) {
+	return s.signer.Sign(http.MethodGet, bucket, path, nil, exp)
+}
+
+func (s *ObjectServiceFsImpl) UploadUrl(ctx context.Context, bucket string, path string, size int64, contentType string, exp time.Duration) (string, error) {
+	return s.signer.Sign(http.MethodPut, bucket, path, storage.UploadParams(size, contentType), exp)
+}
@@ -4,21 +4,78 @@ import (
 	"context"
 	"io"
 	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
 )
 
 // ObjectService defines the interface for object storage operations.
-// It provides methods for uploading and downloading objects, as well as
-// generating signed URLs for secure access and upload URLs for pre-signed uploads.
+// It provides methods for uploading, downloading, inspecting and managing objects, uploading
+// them in parts, as well as generating signed URLs for secure access and upload URLs for
+// pre-signed uploads. Methods on a single object return constants.ErrObjectNotFound if it
+// does not exist, and those on a multipart upload constants.ErrUploadNotFound.
 type ObjectService interface {
-	// Upload uploads an object to the specified bucket and path.
-	Upload(ctx context.Context, bucket string, path string, size int64, data io.Reader) error
+	// Upload uploads an object to the specified bucket and path, size is -1 if unknown. With
+	// opts.SHA256, content that does not match it is not kept and constants.ErrChecksumMismatch
+	// is returned.
+	Upload(ctx context.Context, bucket string, path string, size int64, data io.Reader, opts models.ObjectOptions) error
+
+	// Download streams an object from the specified bucket and path, or only the range of its
+	// bytes if not nil, which must be within the object (see Stat). The caller closes it.
+	Download(ctx context.Context, bucket string, path string, rng *models.ByteRange) (io.ReadCloser, error)
+
+	// Stat retrieves the size, content type, etag, modification time and user metadata of an object.
+	Stat(ctx context.Context, bucket string, path string) (models.ObjectInfo, error)
+
+	// List retrieves up to limit objects under the prefix in lexical order, starting after the
+	// path after (from the start if empty), and the after of the next page, empty on the last one.
+	List(ctx context.Context, bucket string, prefix string, after string, limit int) ([]models.ObjectInfo, string, error)
+
+	// Delete deletes an object, deleting an object that does not exist is not an error.
+	Delete(ctx context.Context, bucket string, path string) error
+
+	// DeleteMany deletes the objects of the paths in batches, those that do not exist are skipped.
+	DeleteMany(ctx context.Context, bucket string, paths []string) error
+
+	// Copy copies an object to another path within the storage, with its metadata and tags.
+	Copy(ctx context.Context, bucket string, src string, dst string) error
+
+	// Move copies an object to another path within the storage, then deletes the original.
+	Move(ctx context.Context, bucket string, src string, dst string) error
+
+	// SetMetadata replaces the user metadata of an object, keeping its content.
+	SetMetadata(ctx context.Context, bucket string, path string, metadata map[string]string) error
+
+	// GetTags retrieves the tags of an object.
+	GetTags(ctx context.Context, bucket string, path string) (map[string]string, error)
+
+	// SetTags replaces the tags of an object.
+	SetTags(ctx context.Context, bucket string, path string, tags map[string]string) error
+
+	// CreateMultipartUpload starts uploading an object in parts, returning the id of the upload.
+	// The object is only created once the upload is completed. opts.SHA256 is not supported,
+	// the parts are verified on their own.
+	CreateMultipartUpload(ctx context.Context, bucket string, path string, opts models.ObjectOptions) (string, error)
+
+	// UploadPart uploads the part partNumber (1 to 10000) of an upload, replacing a previous one
+	// with the same number. With sha256, a part that does not match it is not kept and
+	// constants.ErrChecksumMismatch is returned.
+	UploadPart(ctx context.Context, bucket string, path string, uploadId string, partNumber int, size int64, data io.Reader, sha256 string) (models.ObjectPart, error)
+
+	// ListParts retrieves the parts uploaded so far, in order, to resume an upload.
+	ListParts(ctx context.Context, bucket string, path string, uploadId string) ([]models.ObjectPart, error)
+
+	// CompleteMultipartUpload creates the object from the parts, in ascending order of number.
+	// All parts but the last must be at least constants.MinUploadPartSize, parts that were not
+	// uploaded or are too small return constants.ErrInvalidParts.
+	CompleteMultipartUpload(ctx context.Context, bucket string, path string, uploadId string, parts []models.ObjectPart) error
 
-	// Download retrieves an object from the specified bucket and path.
-	Download(ctx context.Context, bucket string, path string) ([]byte, error)
+	// AbortMultipartUpload discards an upload and its parts.
+	AbortMultipartUpload(ctx context.Context, bucket string, path string, uploadId string) error
 
 	// SignedUrl generates a signed URL for accessing an object in the specified bucket and path.
 	SignedUrl(ctx context.Context, bucket string, path string, exp time.Duration) (string, error)
 
-	// UploadUrl generates a pre-signed URL for uploading an object to the specified bucket and path.
-	UploadUrl(ctx context.Context, bucket string, path string, exp time.Duration) (string, error)
+	// UploadUrl generates a pre-signed URL for uploading an object to the specified bucket and path,
+	// only valid for a body of the size and content type given.
+	UploadUrl(ctx context.Context, bucket string, path string, size int64, contentType string, exp time.Duration) (string, error)
 }
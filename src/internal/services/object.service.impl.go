@@ -2,11 +2,20 @@ package services
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
 	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
 )
 
 type ObjectServiceMinioImpl struct {
@@ -19,23 +28,217 @@ func NewObjectServiceMinioImpl(client *minio.Client) ObjectService {
 	}
 }
 
-func (s *ObjectServiceMinioImpl) Upload(ctx context.Context, bucket string, path string, size int64, data io.Reader) error {
-	_, err := s.client.PutObject(ctx, bucket, path, data, size, minio.PutObjectOptions{})
-	return errors.Join(err, errors.New("could not put obj"))
+// minioErr joins constants.ErrObjectNotFound, constants.ErrUploadNotFound,
+// constants.ErrChecksumMismatch and constants.ErrInvalidParts to the errors they correspond to.
+func minioErr(err error, msg string) error {
+	if err == nil {
+		return nil
+	}
+
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey":
+		return errors.Join(err, errors.New(msg), constants.ErrObjectNotFound)
+	case "NoSuchUpload":
+		return errors.Join(err, errors.New(msg), constants.ErrUploadNotFound)
+	case "XAmzContentSHA256Mismatch", "BadDigest":
+		return errors.Join(err, errors.New(msg), constants.ErrChecksumMismatch)
+	case "InvalidPart", "InvalidPartOrder", "EntityTooSmall":
+		return errors.Join(err, errors.New(msg), constants.ErrInvalidParts)
+	}
+	return errors.Join(err, errors.New(msg))
+}
+
+// checksumMetadataKey is the user metadata the checksum of an object is kept in.
+const checksumMetadataKey = "checksum-sha256"
+
+// minioMetadata copies the user metadata of an object with its checksum, if any.
+func minioMetadata(metadata map[string]string, sha256 string) map[string]string {
+	userMetadata := map[string]string{}
+	for k, v := range metadata {
+		userMetadata[k] = v
+	}
+	if sha256 != "" {
+		userMetadata[checksumMetadataKey] = sha256
+	}
+	return userMetadata
+}
+
+func (s *ObjectServiceMinioImpl) Upload(ctx context.Context, bucket string, path string, size int64, data io.Reader, opts models.ObjectOptions) error {
+	putOpts := minio.PutObjectOptions{
+		ContentType:  opts.ContentType,
+		UserMetadata: minioMetadata(opts.Metadata, opts.SHA256),
+		UserTags:     opts.Tags,
+	}
+
+	if opts.SHA256 == "" {
+		_, err := s.client.PutObject(ctx, bucket, path, data, size, putOpts)
+		return minioErr(err, "could not put obj")
+	}
+
+	// with a known size the checksum signs the payload and the storage verifies it, without one
+	// the object is hashed on its way and removed if it does not match
+	putOpts.DisableContentSha256 = true
+	if size >= 0 {
+		_, err := minio.Core{Client: s.client}.PutObject(ctx, bucket, path, data, size, "", opts.SHA256, putOpts)
+		return minioErr(err, "could not put obj")
+	}
+
+	hash := sha256.New()
+	_, err := s.client.PutObject(ctx, bucket, path, io.TeeReader(data, hash), size, putOpts)
+	if err != nil {
+		return minioErr(err, "could not put obj")
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != opts.SHA256 {
+		return errors.Join(constants.ErrChecksumMismatch, s.Delete(ctx, bucket, path))
+	}
+	return nil
+}
+
+func (s *ObjectServiceMinioImpl) Download(ctx context.Context, bucket string, path string, rng *models.ByteRange) (io.ReadCloser, error) {
+	getOpts := minio.GetObjectOptions{}
+	if rng != nil {
+		err := getOpts.SetRange(rng.Start, rng.End)
+		if err != nil {
+			return nil, errors.Join(err, errors.New("invalid obj range"))
+		}
+	}
+
+	// unlike the client, the core requests the object right away, so missing ones fail here
+	obj, _, _, err := minio.Core{Client: s.client}.GetObject(ctx, bucket, path, getOpts)
+	if err != nil {
+		return nil, minioErr(err, "could not get obj")
+	}
+	return obj, nil
+}
+
+func (s *ObjectServiceMinioImpl) Stat(ctx context.Context, bucket string, path string) (models.ObjectInfo, error) {
+	info, err := s.client.StatObject(ctx, bucket, path, minio.StatObjectOptions{})
+	if err != nil {
+		return models.ObjectInfo{}, minioErr(err, "could not stat obj")
+	}
+
+	metadata := lowerKeys(info.UserMetadata) // headers come back canonicalized
+	checksum := metadata[checksumMetadataKey]
+	delete(metadata, checksumMetadataKey)
+
+	return models.ObjectInfo{
+		Path:         info.Key,
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		ETag:         info.ETag,
+		LastModified: info.LastModified,
+		Metadata:     metadata,
+		SHA256:       checksum,
+	}, nil
+}
+
+func (s *ObjectServiceMinioImpl) List(ctx context.Context, bucket string, prefix string, after string, limit int) ([]models.ObjectInfo, string, error) {
+	if limit < 1 {
+		return nil, "", errors.New("list limit must be positive")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // stops the listing once the page is full
+
+	objs := []models.ObjectInfo{}
+	next := ""
+	for info := range s.client.ListObjects(ctx, bucket, minio.ListObjectsOptions{
+		Prefix:     prefix,
+		StartAfter: after,
+		Recursive:  true,
+		MaxKeys:    limit + 1,
+	}) {
+		if info.Err != nil {
+			return nil, "", minioErr(info.Err, "could not list objs")
+		}
+
+		// the object after a full page only tells there is a next one
+		if len(objs) == limit {
+			next = objs[len(objs)-1].Path
+			break
+		}
+
+		objs = append(objs, models.ObjectInfo{
+			Path:         info.Key,
+			Size:         info.Size,
+			ETag:         info.ETag,
+			LastModified: info.LastModified,
+		})
+	}
+
+	return objs, next, nil
 }
 
-func (s *ObjectServiceMinioImpl) Download(ctx context.Context, bucket string, path string) ([]byte, error) {
-	obj, err := s.client.GetObject(ctx, bucket, path, minio.GetObjectOptions{})
+func (s *ObjectServiceMinioImpl) Delete(ctx context.Context, bucket string, path string) error {
+	err := s.client.RemoveObject(ctx, bucket, path, minio.RemoveObjectOptions{})
+	return minioErr(err, "could not remove obj")
+}
+
+func (s *ObjectServiceMinioImpl) DeleteMany(ctx context.Context, bucket string, paths []string) error {
+	objs := make(chan minio.ObjectInfo, len(paths))
+	for _, path := range paths {
+		objs <- minio.ObjectInfo{Key: path}
+	}
+	close(objs)
+
+	// the client sends them in batches of up to 1000 objects
+	var errs []error
+	for removeErr := range s.client.RemoveObjects(ctx, bucket, objs, minio.RemoveObjectsOptions{}) {
+		errs = append(errs, fmt.Errorf("could not remove obj '%s': %w", removeErr.ObjectName, removeErr.Err))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *ObjectServiceMinioImpl) Copy(ctx context.Context, bucket string, src string, dst string) error {
+	_, err := s.client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: bucket, Object: dst},
+		minio.CopySrcOptions{Bucket: bucket, Object: src},
+	)
+	return minioErr(err, "could not copy obj")
+}
+
+func (s *ObjectServiceMinioImpl) Move(ctx context.Context, bucket string, src string, dst string) error {
+	err := s.Copy(ctx, bucket, src, dst)
+	if err != nil {
+		return err
+	}
+	return s.Delete(ctx, bucket, src)
+}
+
+func (s *ObjectServiceMinioImpl) SetMetadata(ctx context.Context, bucket string, path string, metadata map[string]string) error {
+	info, err := s.Stat(ctx, bucket, path)
+	if err != nil {
+		return err
+	}
+
+	// metadata is immutable, the object is copied onto itself with the new one, replacing
+	// the metadata also replaces the content type and checksum unless they are given again
+	userMetadata := minioMetadata(metadata, info.SHA256)
+	userMetadata["Content-Type"] = info.ContentType
+
+	_, err = s.client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: bucket, Object: path, UserMetadata: userMetadata, ReplaceMetadata: true},
+		minio.CopySrcOptions{Bucket: bucket, Object: path},
+	)
+	return minioErr(err, "could not replace obj metadata")
+}
+
+func (s *ObjectServiceMinioImpl) GetTags(ctx context.Context, bucket string, path string) (map[string]string, error) {
+	t, err := s.client.GetObjectTagging(ctx, bucket, path, minio.GetObjectTaggingOptions{})
 	if err != nil {
-		return nil, errors.Join(err, errors.New("could not get obj"))
+		return nil, minioErr(err, "could not get obj tags")
 	}
-	defer obj.Close()
+	return t.ToMap(), nil
+}
 
-	data, err := io.ReadAll(obj)
+func (s *ObjectServiceMinioImpl) SetTags(ctx context.Context, bucket string, path string, tagMap map[string]string) error {
+	t, err := tags.NewTags(tagMap, true)
 	if err != nil {
-		return nil, errors.Join(err, errors.New("could not io.ReadAll(obj)"))
+		return errors.Join(err, errors.New("invalid obj tags"))
 	}
-	return data, nil
+
+	err = s.client.PutObjectTagging(ctx, bucket, path, t, minio.PutObjectTaggingOptions{})
+	return minioErr(err, "could not put obj tags")
 }
 
 func (s *ObjectServiceMinioImpl) SignedUrl(ctx context.Context, bucket string, path string, exp time.Duration) (string, error) {
@@ -46,10 +249,82 @@ func (s *ObjectServiceMinioImpl) SignedUrl(ctx context.Context, bucket string, p
 	return url.String(), nil
 }
 
-func (s *ObjectServiceMinioImpl) UploadUrl(ctx context.Context, bucket string, path string, exp time.Duration) (string, error) {
-	url, err := s.client.PresignedPutObject(ctx, bucket, path, time.Minute*5)
+func (s *ObjectServiceMinioImpl) UploadUrl(ctx context.Context, bucket string, path string, size int64, contentType string, exp time.Duration) (string, error) {
+	// signed headers must be sent as signed, so the storage rejects other sizes and types
+	url, err := s.client.PresignHeader(ctx, http.MethodPut, bucket, path, exp, nil, http.Header{
+		"Content-Length": {strconv.FormatInt(size, 10)},
+		"Content-Type":   {contentType},
+	})
 	if err != nil {
 		return "", errors.Join(err, errors.New("could not presign put obj"))
 	}
 	return url.String(), nil
 }
+
+func (s *ObjectServiceMinioImpl) CreateMultipartUpload(ctx context.Context, bucket string, path string, opts models.ObjectOptions) (string, error) {
+	if opts.SHA256 != "" {
+		return "", errors.New("multipart uploads are verified by part, not by a checksum of the obj")
+	}
+
+	uploadId, err := minio.Core{Client: s.client}.NewMultipartUpload(ctx, bucket, path, minio.PutObjectOptions{
+		ContentType:  opts.ContentType,
+		UserMetadata: opts.Metadata,
+		UserTags:     opts.Tags,
+	})
+	return uploadId, minioErr(err, "could not create multipart upload")
+}
+
+func (s *ObjectServiceMinioImpl) UploadPart(ctx context.Context, bucket string, path string, uploadId string, partNumber int, size int64, data io.Reader, sha256 string) (models.ObjectPart, error) {
+	part, err := minio.Core{Client: s.client}.PutObjectPart(ctx, bucket, path, uploadId, partNumber, data, size, minio.PutObjectPartOptions{
+		Sha256Hex:            sha256,
+		DisableContentSha256: sha256 != "", // so the checksum signs the payload instead of its chunks
+	})
+	if err != nil {
+		return models.ObjectPart{}, minioErr(err, "could not put obj part")
+	}
+
+	return models.ObjectPart{
+		PartNumber: part.PartNumber,
+		ETag:       strings.Trim(part.ETag, `"`),
+		Size:       part.Size,
+	}, nil
+}
+
+func (s *ObjectServiceMinioImpl) ListParts(ctx context.Context, bucket string, path string, uploadId string) ([]models.ObjectPart, error) {
+	core := minio.Core{Client: s.client}
+
+	parts := []models.ObjectPart{}
+	marker := 0
+	for {
+		res, err := core.ListObjectParts(ctx, bucket, path, uploadId, marker, 1000)
+		if err != nil {
+			return nil, minioErr(err, "could not list obj parts")
+		}
+		for _, part := range res.ObjectParts {
+			parts = append(parts, models.ObjectPart{
+				PartNumber: part.PartNumber,
+				ETag:       strings.Trim(part.ETag, `"`),
+				Size:       part.Size,
+			})
+		}
+		if !res.IsTruncated {
+			return parts, nil
+		}
+		marker = res.NextPartNumberMarker
+	}
+}
+
+func (s *ObjectServiceMinioImpl) CompleteMultipartUpload(ctx context.Context, bucket string, path string, uploadId string, parts []models.ObjectPart) error {
+	completeParts := make([]minio.CompletePart, len(parts))
+	for i, part := range parts {
+		completeParts[i] = minio.CompletePart{PartNumber: part.PartNumber, ETag: part.ETag}
+	}
+
+	_, err := minio.Core{Client: s.client}.CompleteMultipartUpload(ctx, bucket, path, uploadId, completeParts, minio.PutObjectOptions{})
+	return minioErr(err, "could not complete multipart upload")
+}
+
+func (s *ObjectServiceMinioImpl) AbortMultipartUpload(ctx context.Context, bucket string, path string, uploadId string) error {
+	err := minio.Core{Client: s.client}.AbortMultipartUpload(ctx, bucket, path, uploadId)
+	return minioErr(err, "could not abort multipart upload")
+}
@@ -0,0 +1,363 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/helpers"
+	"github.com/LombardiDaniel/goliath/src/pkg/storage"
+	"github.com/minio/minio-go/v7"
+)
+
+func newObjectServiceMinio(t *testing.T) ObjectService {
+	ctx := context.Background()
+
+	minioContainer, err := helpers.NewMinioContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := minioContainer.Container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate minioContainer: %s", err)
+		}
+	})
+
+	if err := minioContainer.Client.MakeBucket(ctx, "bucket", minio.MakeBucketOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewObjectServiceMinioImpl(minioContainer.Client)
+}
+
+func uploadString(t *testing.T, s ObjectService, path string, content string, opts models.ObjectOptions) {
+	err := s.Upload(context.Background(), "bucket", path, int64(len(content)), strings.NewReader(content), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func downloadString(t *testing.T, s ObjectService, path string, rng *models.ByteRange) (string, error) {
+	obj, err := s.Download(context.Background(), "bucket", path, rng)
+	if err != nil {
+		return "", err
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), nil
+}
+
+// testObjects runs the operations on single objects of an ObjectService.
+func testObjects(t *testing.T, s ObjectService) {
+	ctx := context.Background()
+
+	uploadString(t, s, "private/receipts/1.pdf", "receipt", models.ObjectOptions{
+		ContentType: "application/pdf",
+		Metadata:    map[string]string{"owner": "42"},
+		Tags:        map[string]string{"kind": "receipt"},
+	})
+
+	info, err := s.Stat(ctx, "bucket", "private/receipts/1.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 7 || info.ContentType != "application/pdf" || info.ETag == "" || info.LastModified.IsZero() {
+		t.Errorf("unexpected stat %+v", info)
+	}
+	if info.Metadata["owner"] != "42" {
+		t.Errorf("unexpected metadata %v", info.Metadata)
+	}
+
+	data, err := downloadString(t, s, "private/receipts/1.pdf", nil)
+	if err != nil || data != "receipt" {
+		t.Errorf("unexpected download %q, %v", data, err)
+	}
+	data, err = downloadString(t, s, "private/receipts/1.pdf", &models.ByteRange{Start: 2, End: 4})
+	if err != nil || data != "cei" {
+		t.Errorf("unexpected range download %q, %v", data, err)
+	}
+
+	err = s.SetMetadata(ctx, "bucket", "private/receipts/1.pdf", map[string]string{"owner": "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err = s.Stat(ctx, "bucket", "private/receipts/1.pdf")
+	if err != nil || info.Metadata["owner"] != "7" || info.ContentType != "application/pdf" {
+		t.Errorf("metadata not replaced: %+v, %v", info, err)
+	}
+
+	err = s.SetTags(ctx, "bucket", "private/receipts/1.pdf", map[string]string{"kind": "invoice", "year": "2026"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, err := s.GetTags(ctx, "bucket", "private/receipts/1.pdf")
+	if err != nil || tags["kind"] != "invoice" || tags["year"] != "2026" {
+		t.Errorf("unexpected tags %v, %v", tags, err)
+	}
+
+	err = s.Move(ctx, "bucket", "private/receipts/1.pdf", "private/receipts/2.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stat(ctx, "bucket", "private/receipts/1.pdf"); !errors.Is(err, constants.ErrObjectNotFound) {
+		t.Errorf("moved object still exists: %v", err)
+	}
+	info, err = s.Stat(ctx, "bucket", "private/receipts/2.pdf")
+	if err != nil || info.Metadata["owner"] != "7" {
+		t.Errorf("metadata not copied: %+v, %v", info, err)
+	}
+
+	err = s.Delete(ctx, "bucket", "private/receipts/2.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Download(ctx, "bucket", "private/receipts/2.pdf", nil); !errors.Is(err, constants.ErrObjectNotFound) {
+		t.Errorf("deleted object still exists: %v", err)
+	}
+	if err := s.Copy(ctx, "bucket", "private/receipts/2.pdf", "private/receipts/3.pdf"); !errors.Is(err, constants.ErrObjectNotFound) {
+		t.Errorf("expected ErrObjectNotFound copying a missing object, got %v", err)
+	}
+}
+
+// testObjectChecksum runs the uploads of an ObjectService verified by checksum, of unknown size too.
+func testObjectChecksum(t *testing.T, s ObjectService) {
+	ctx := context.Background()
+	checksum := sha256Hex([]byte("receipt"))
+
+	for _, size := range []int64{7, -1} {
+		err := s.Upload(ctx, "bucket", "private/receipts/1.pdf", size, strings.NewReader("receipt"), models.ObjectOptions{SHA256: checksum})
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := s.Stat(ctx, "bucket", "private/receipts/1.pdf")
+		if err != nil || info.SHA256 != checksum || len(info.Metadata) != 0 {
+			t.Errorf("checksum not kept: %+v, %v", info, err)
+		}
+
+		err = s.Upload(ctx, "bucket", "private/receipts/2.pdf", size, strings.NewReader("tampere"), models.ObjectOptions{SHA256: checksum})
+		if !errors.Is(err, constants.ErrChecksumMismatch) {
+			t.Errorf("expected ErrChecksumMismatch, got %v", err)
+		}
+		if _, err := s.Stat(ctx, "bucket", "private/receipts/2.pdf"); !errors.Is(err, constants.ErrObjectNotFound) {
+			t.Errorf("object not matching its checksum was kept: %v", err)
+		}
+	}
+
+	err := s.SetMetadata(ctx, "bucket", "private/receipts/1.pdf", map[string]string{"owner": "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := s.Stat(ctx, "bucket", "private/receipts/1.pdf")
+	if err != nil || info.SHA256 != checksum {
+		t.Errorf("checksum not kept with new metadata: %+v, %v", info, err)
+	}
+}
+
+// testObjectMultipart runs the multipart uploads of an ObjectService.
+func testObjectMultipart(t *testing.T, s ObjectService) {
+	ctx := context.Background()
+	first := bytes.Repeat([]byte("a"), int(constants.MinUploadPartSize))
+
+	uploadId, err := s.CreateMultipartUpload(ctx, "bucket", "private/uploads/1", models.ObjectOptions{ContentType: "text/plain"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.UploadPart(ctx, "bucket", "private/uploads/1", uploadId, 2, 3, strings.NewReader("bcd"), sha256Hex([]byte("xyz")))
+	if !errors.Is(err, constants.ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch, got %v", err)
+	}
+	_, err = s.UploadPart(ctx, "bucket", "private/uploads/1", uploadId, 2, 3, strings.NewReader("xyz"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// parts can be uploaded again, in any order
+	second, err := s.UploadPart(ctx, "bucket", "private/uploads/1", uploadId, 2, 3, strings.NewReader("bcd"), sha256Hex([]byte("bcd")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UploadPart(ctx, "bucket", "private/uploads/1", uploadId, 1, int64(len(first)), bytes.NewReader(first), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	parts, err := s.ListParts(ctx, "bucket", "private/uploads/1", uploadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 || parts[0].PartNumber != 1 || parts[0].Size != int64(len(first)) || parts[1] != second {
+		t.Fatalf("unexpected parts %+v", parts)
+	}
+
+	err = s.CompleteMultipartUpload(ctx, "bucket", "private/uploads/1", uploadId, []models.ObjectPart{parts[0], {PartNumber: 3, ETag: second.ETag}})
+	if !errors.Is(err, constants.ErrInvalidParts) {
+		t.Errorf("expected ErrInvalidParts completing with a part not uploaded, got %v", err)
+	}
+	err = s.CompleteMultipartUpload(ctx, "bucket", "private/uploads/1", uploadId, parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := s.Stat(ctx, "bucket", "private/uploads/1")
+	if err != nil || info.Size != int64(len(first))+3 || info.ContentType != "text/plain" {
+		t.Errorf("unexpected stat %+v, %v", info, err)
+	}
+	end := int64(len(first)) + 1
+	data, err := downloadString(t, s, "private/uploads/1", &models.ByteRange{Start: end - 2, End: end})
+	if err != nil || data != "abc" {
+		t.Errorf("unexpected range download across parts %q, %v", data, err)
+	}
+	if _, err := s.ListParts(ctx, "bucket", "private/uploads/1", uploadId); !errors.Is(err, constants.ErrUploadNotFound) {
+		t.Errorf("expected ErrUploadNotFound after completing, got %v", err)
+	}
+
+	uploadId, err = s.CreateMultipartUpload(ctx, "bucket", "private/uploads/2", models.ObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AbortMultipartUpload(ctx, "bucket", "private/uploads/2", uploadId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UploadPart(ctx, "bucket", "private/uploads/2", uploadId, 1, 1, strings.NewReader("a"), ""); !errors.Is(err, constants.ErrUploadNotFound) {
+		t.Errorf("expected ErrUploadNotFound after aborting, got %v", err)
+	}
+	if _, err := s.Stat(ctx, "bucket", "private/uploads/2"); !errors.Is(err, constants.ErrObjectNotFound) {
+		t.Errorf("aborted upload created the object: %v", err)
+	}
+}
+
+// testObjectList runs the listing and batch deletion of an ObjectService.
+func testObjectList(t *testing.T, s ObjectService) {
+	ctx := context.Background()
+
+	for i := range 5 {
+		uploadString(t, s, fmt.Sprintf("public/user-avatars/%d", i), "avatar", models.ObjectOptions{})
+	}
+	uploadString(t, s, "private/receipts/1.pdf", "receipt", models.ObjectOptions{})
+
+	var paths []string
+	after := ""
+	for pages := 1; ; pages++ {
+		objs, next, err := s.List(ctx, "bucket", "public/", after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, obj := range objs {
+			paths = append(paths, obj.Path)
+		}
+		if next == "" {
+			if pages != 3 {
+				t.Errorf("expected 3 pages, got %d", pages)
+			}
+			break
+		}
+		after = next
+	}
+
+	want := []string{"public/user-avatars/0", "public/user-avatars/1", "public/user-avatars/2", "public/user-avatars/3", "public/user-avatars/4"}
+	if !slices.Equal(paths, want) {
+		t.Errorf("expected %v, got %v", want, paths)
+	}
+
+	err := s.DeleteMany(ctx, "bucket", []string{"public/user-avatars/0", "public/user-avatars/1", "public/user-avatars/9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs, next, err := s.List(ctx, "bucket", "public/", "", 10)
+	if err != nil || len(objs) != 3 || next != "" {
+		t.Errorf("unexpected listing after delete: %v, %q, %v", objs, next, err)
+	}
+}
+
+func TestObjectServiceMinioImpl(t *testing.T) {
+	t.Run("objects", func(t *testing.T) { testObjects(t, newObjectServiceMinio(t)) })
+	t.Run("list", func(t *testing.T) { testObjectList(t, newObjectServiceMinio(t)) })
+	t.Run("checksum", func(t *testing.T) { testObjectChecksum(t, newObjectServiceMinio(t)) })
+	t.Run("multipart", func(t *testing.T) { testObjectMultipart(t, newObjectServiceMinio(t)) })
+}
+
+func TestObjectServiceMemoryImpl(t *testing.T) {
+	signer := storage.NewUrlSigner("http://localhost/v1/objects", []byte("secret"))
+	t.Run("objects", func(t *testing.T) { testObjects(t, NewObjectServiceMemoryImpl(signer)) })
+	t.Run("list", func(t *testing.T) { testObjectList(t, NewObjectServiceMemoryImpl(signer)) })
+	t.Run("checksum", func(t *testing.T) { testObjectChecksum(t, NewObjectServiceMemoryImpl(signer)) })
+	t.Run("multipart", func(t *testing.T) { testObjectMultipart(t, NewObjectServiceMemoryImpl(signer)) })
+}
+
+func TestObjectServiceFsImpl(t *testing.T) {
+	signer := storage.NewUrlSigner("http://localhost/v1/objects", []byte("secret"))
+	newFs := func(t *testing.T) ObjectService {
+		s, err := NewObjectServiceFsImpl(t.TempDir(), signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	t.Run("objects", func(t *testing.T) { testObjects(t, newFs(t)) })
+	t.Run("list", func(t *testing.T) { testObjectList(t, newFs(t)) })
+	t.Run("checksum", func(t *testing.T) { testObjectChecksum(t, newFs(t)) })
+	t.Run("multipart", func(t *testing.T) { testObjectMultipart(t, newFs(t)) })
+
+	t.Run("paths", func(t *testing.T) {
+		s := newFs(t)
+		for _, path := range []string{"../escape", "/abs", "a//b", ""} {
+			err := s.Upload(context.Background(), "bucket", path, 1, strings.NewReader("x"), models.ObjectOptions{})
+			if err == nil {
+				t.Errorf("expected an error uploading to %q", path)
+			}
+		}
+	})
+
+	t.Run("signed urls", func(t *testing.T) {
+		s := newFs(t)
+		raw, err := s.UploadUrl(context.Background(), "bucket", "public/user-avatars/1", 42, "image/png", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Path != "/v1/objects/bucket/public/user-avatars/1" {
+			t.Errorf("unexpected url %s", raw)
+		}
+		if err := signer.Verify(http.MethodPut, "bucket", "public/user-avatars/1", u.Query()); err != nil {
+			t.Errorf("upload url does not verify: %v", err)
+		}
+		if err := signer.Verify(http.MethodGet, "bucket", "public/user-avatars/1", u.Query()); err == nil {
+			t.Error("upload url verifies for downloads")
+		}
+		if err := signer.Verify(http.MethodPut, "bucket", "public/user-avatars/2", u.Query()); err == nil {
+			t.Error("upload url verifies for another object")
+		}
+
+		// the size and content type are signed, the body must match them
+		if err := storage.CheckUpload(u.Query(), 42, "image/png"); err != nil {
+			t.Errorf("upload url does not check the body it was signed for: %v", err)
+		}
+		if err := storage.CheckUpload(u.Query(), 43, "image/png"); err == nil {
+			t.Error("upload url checks a body of another size")
+		}
+		if err := storage.CheckUpload(u.Query(), 42, "text/html"); err == nil {
+			t.Error("upload url checks a body of another content type")
+		}
+		tampered := u.Query()
+		tampered.Set("size", "1073741824")
+		if err := signer.Verify(http.MethodPut, "bucket", "public/user-avatars/1", tampered); err == nil {
+			t.Error("upload url verifies with another size")
+		}
+	})
+}
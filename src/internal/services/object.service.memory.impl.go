@@ -0,0 +1,362 @@
+package services
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/storage"
+)
+
+type memoryObject struct {
+	data []byte
+	info models.ObjectInfo
+	tags map[string]string
+}
+
+type memoryUpload struct {
+	bucket string
+	path   string
+	opts   models.ObjectOptions
+	parts  map[int][]byte
+}
+
+// ObjectServiceMemoryImpl keeps objects in memory, for tests and throwaway environments.
+// Its URLs are served by the API, see handlers.ObjectHandler.
+type ObjectServiceMemoryImpl struct {
+	signer *storage.UrlSigner
+
+	mu      sync.RWMutex
+	objects map[string]memoryObject // by bucket/path
+	uploads map[string]memoryUpload // by id
+}
+
+func NewObjectServiceMemoryImpl(signer *storage.UrlSigner) ObjectService {
+	return &ObjectServiceMemoryImpl{
+		signer:  signer,
+		objects: map[string]memoryObject{},
+		uploads: map[string]memoryUpload{},
+	}
+}
+
+func (s *ObjectServiceMemoryImpl) get(bucket string, path string) (memoryObject, error) {
+	obj, ok := s.objects[bucket+"/"+path]
+	if !ok {
+		return obj, errors.Join(constants.ErrObjectNotFound, fmt.Errorf("object '%s/%s' does not exist", bucket, path))
+	}
+	return obj, nil
+}
+
+func (s *ObjectServiceMemoryImpl) Upload(ctx context.Context, bucket string, path string, size int64, data io.Reader, opts models.ObjectOptions) error {
+	if err := checkObjectPath(bucket, path); err != nil {
+		return err
+	}
+
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return errors.Join(err, errors.New("could not read obj"))
+	}
+	if size >= 0 && int64(len(b)) != size {
+		return fmt.Errorf("obj has %d bytes, expected %d", len(b), size)
+	}
+	if opts.SHA256 != "" && sha256Hex(b) != opts.SHA256 {
+		return constants.ErrChecksumMismatch
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(bucket, path, b, opts)
+	return nil
+}
+
+// put stores an object, the caller holds the lock.
+func (s *ObjectServiceMemoryImpl) put(bucket string, path string, data []byte, opts models.ObjectOptions) {
+	sum := md5.Sum(data)
+	s.objects[bucket+"/"+path] = memoryObject{
+		data: data,
+		info: models.ObjectInfo{
+			Path:         path,
+			Size:         int64(len(data)),
+			ContentType:  cmp.Or(opts.ContentType, "application/octet-stream"),
+			ETag:         hex.EncodeToString(sum[:]),
+			LastModified: time.Now(),
+			Metadata:     lowerKeys(opts.Metadata),
+			SHA256:       opts.SHA256,
+		},
+		tags: maps.Clone(opts.Tags),
+	}
+}
+
+func (s *ObjectServiceMemoryImpl) Download(ctx context.Context, bucket string, path string, rng *models.ByteRange) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, err := s.get(bucket, path)
+	if err != nil {
+		return nil, err
+	}
+
+	// the data is never modified in place, it is read without a copy
+	data := obj.data
+	if rng != nil {
+		if rng.Start < 0 || rng.Start > rng.End || rng.Start >= int64(len(data)) {
+			return nil, fmt.Errorf("invalid obj range %d-%d", rng.Start, rng.End)
+		}
+		data = data[rng.Start:min(rng.End+1, int64(len(data)))]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *ObjectServiceMemoryImpl) Stat(ctx context.Context, bucket string, path string) (models.ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, err := s.get(bucket, path)
+	if err != nil {
+		return models.ObjectInfo{}, err
+	}
+
+	info := obj.info
+	info.Metadata = maps.Clone(info.Metadata)
+	return info, nil
+}
+
+func (s *ObjectServiceMemoryImpl) List(ctx context.Context, bucket string, prefix string, after string, limit int) ([]models.ObjectInfo, string, error) {
+	s.mu.RLock()
+	objs := []models.ObjectInfo{}
+	for key, obj := range s.objects {
+		if strings.HasPrefix(key, bucket+"/") {
+			info := obj.info
+			info.ContentType = ""
+			info.Metadata = nil
+			objs = append(objs, info)
+		}
+	}
+	s.mu.RUnlock()
+
+	slices.SortFunc(objs, func(a, b models.ObjectInfo) int { return strings.Compare(a.Path, b.Path) })
+	return objectPage(objs, prefix, after, limit)
+}
+
+func (s *ObjectServiceMemoryImpl) Delete(ctx context.Context, bucket string, path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.objects, bucket+"/"+path)
+	return nil
+}
+
+func (s *ObjectServiceMemoryImpl) DeleteMany(ctx context.Context, bucket string, paths []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, path := range paths {
+		delete(s.objects, bucket+"/"+path)
+	}
+	return nil
+}
+
+func (s *ObjectServiceMemoryImpl) Copy(ctx context.Context, bucket string, src string, dst string) error {
+	if err := checkObjectPath(bucket, dst); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, err := s.get(bucket, src)
+	if err != nil {
+		return err
+	}
+
+	// the data is never modified in place, only the attributes need their own copy
+	obj.info.Path = dst
+	obj.info.LastModified = time.Now()
+	obj.info.Metadata = maps.Clone(obj.info.Metadata)
+	obj.tags = maps.Clone(obj.tags)
+	s.objects[bucket+"/"+dst] = obj
+	return nil
+}
+
+func (s *ObjectServiceMemoryImpl) Move(ctx context.Context, bucket string, src string, dst string) error {
+	err := s.Copy(ctx, bucket, src, dst)
+	if err != nil {
+		return err
+	}
+	return s.Delete(ctx, bucket, src)
+}
+
+func (s *ObjectServiceMemoryImpl) SetMetadata(ctx context.Context, bucket string, path string, metadata map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, err := s.get(bucket, path)
+	if err != nil {
+		return err
+	}
+
+	obj.info.Metadata = lowerKeys(metadata)
+	s.objects[bucket+"/"+path] = obj
+	return nil
+}
+
+func (s *ObjectServiceMemoryImpl) GetTags(ctx context.Context, bucket string, path string) (map[string]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, err := s.get(bucket, path)
+	if err != nil {
+		return nil, err
+	}
+	tags := maps.Clone(obj.tags)
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	return tags, nil
+}
+
+func (s *ObjectServiceMemoryImpl) SetTags(ctx context.Context, bucket string, path string, tags map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, err := s.get(bucket, path)
+	if err != nil {
+		return err
+	}
+
+	obj.tags = maps.Clone(tags)
+	s.objects[bucket+"/"+path] = obj
+	return nil
+}
+
+func (s *ObjectServiceMemoryImpl) CreateMultipartUpload(ctx context.Context, bucket string, path string, opts models.ObjectOptions) (string, error) {
+	if err := checkObjectPath(bucket, path); err != nil {
+		return "", err
+	}
+	if opts.SHA256 != "" {
+		return "", errors.New("multipart uploads are verified by part, not by a checksum of the obj")
+	}
+
+	uploadId := rand.Text()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploads[uploadId] = memoryUpload{bucket: bucket, path: path, opts: opts, parts: map[int][]byte{}}
+	return uploadId, nil
+}
+
+// getUpload retrieves an upload of the object, the caller holds the lock.
+func (s *ObjectServiceMemoryImpl) getUpload(bucket string, path string, uploadId string) (memoryUpload, error) {
+	upload, ok := s.uploads[uploadId]
+	if !ok || upload.bucket != bucket || upload.path != path {
+		return upload, errors.Join(constants.ErrUploadNotFound, fmt.Errorf("upload '%s' of '%s/%s' does not exist", uploadId, bucket, path))
+	}
+	return upload, nil
+}
+
+func (s *ObjectServiceMemoryImpl) UploadPart(ctx context.Context, bucket string, path string, uploadId string, partNumber int, size int64, data io.Reader, sha256 string) (models.ObjectPart, error) {
+	if err := checkPartNumber(partNumber); err != nil {
+		return models.ObjectPart{}, err
+	}
+
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return models.ObjectPart{}, errors.Join(err, errors.New("could not read obj part"))
+	}
+	if size >= 0 && int64(len(b)) != size {
+		return models.ObjectPart{}, fmt.Errorf("obj part has %d bytes, expected %d", len(b), size)
+	}
+	if sha256 != "" && sha256Hex(b) != sha256 {
+		return models.ObjectPart{}, constants.ErrChecksumMismatch
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, err := s.getUpload(bucket, path, uploadId)
+	if err != nil {
+		return models.ObjectPart{}, err
+	}
+	upload.parts[partNumber] = b
+	return memoryPart(partNumber, b), nil
+}
+
+func memoryPart(partNumber int, data []byte) models.ObjectPart {
+	sum := md5.Sum(data)
+	return models.ObjectPart{PartNumber: partNumber, ETag: hex.EncodeToString(sum[:]), Size: int64(len(data))}
+}
+
+func (s *ObjectServiceMemoryImpl) ListParts(ctx context.Context, bucket string, path string, uploadId string) ([]models.ObjectPart, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	upload, err := s.getUpload(bucket, path, uploadId)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := []models.ObjectPart{}
+	for _, partNumber := range slices.Sorted(maps.Keys(upload.parts)) {
+		parts = append(parts, memoryPart(partNumber, upload.parts[partNumber]))
+	}
+	return parts, nil
+}
+
+func (s *ObjectServiceMemoryImpl) CompleteMultipartUpload(ctx context.Context, bucket string, path string, uploadId string, parts []models.ObjectPart) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, err := s.getUpload(bucket, path, uploadId)
+	if err != nil {
+		return err
+	}
+
+	uploaded := map[int]models.ObjectPart{}
+	for partNumber, data := range upload.parts {
+		uploaded[partNumber] = memoryPart(partNumber, data)
+	}
+	if err := checkCompleteParts(parts, uploaded); err != nil {
+		return err
+	}
+
+	var data []byte
+	for _, part := range parts {
+		data = append(data, upload.parts[part.PartNumber]...)
+	}
+	s.put(bucket, path, data, upload.opts)
+	delete(s.uploads, uploadId)
+	return nil
+}
+
+func (s *ObjectServiceMemoryImpl) AbortMultipartUpload(ctx context.Context, bucket string, path string, uploadId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.getUpload(bucket, path, uploadId); err != nil {
+		return err
+	}
+	delete(s.uploads, uploadId)
+	return nil
+}
+
+func (s *ObjectServiceMemoryImpl) SignedUrl(ctx context.Context, bucket string, path string, exp time.Duration) (string, error) {
+	return s.signer.Sign(http.MethodGet, bucket, path, nil, exp)
+}
+
+func (s *ObjectServiceMemoryImpl) UploadUrl(ctx context.Context, bucket string, path string, size int64, contentType string, exp time.Duration) (string, error) {
+	return s.signer.Sign(http.MethodPut, bucket, path, storage.UploadParams(size, contentType), exp)
+}
@@ -16,21 +16,29 @@ type OrganizationService interface {
 	// CreateOrganization creates a new organization.
 	CreateOrganization(ctx context.Context, org models.Organization) error
 
-	// CreateOrganizationInvite creates an invitation for a user to join an organization.
+	// CreateOrganizationInvite creates an invitation for a user to join an organization and enqueues its email.
 	CreateOrganizationInvite(ctx context.Context, invite models.OrganizationInvite) error
 
 	// ConfirmOrganizationInvite confirms an organization invite using a one-time password (OTP).
 	ConfirmOrganizationInvite(ctx context.Context, otp string) error
 
+	// AcceptOrganizationInvite accepts the pending invite of a user to an organization, as the app
+	// does from its notification, returns constants.ErrNoRows if the user has none.
+	AcceptOrganizationInvite(ctx context.Context, orgId string, userId uint32) error
+
 	// RemoveUserFromOrg removes a user from an organization by their user ID.
 	RemoveUserFromOrg(ctx context.Context, orgId string, userId uint32) error
 
 	// SetOrganizationOwner sets a user as the owner of an organization.
 	SetOrganizationOwner(ctx context.Context, orgId string, userId uint32) error
 
+	// SetDefaultLocale sets the locale of the members of an organization without one of their own, nil clears it.
+	SetDefaultLocale(ctx context.Context, orgId string, locale *string) error
+
 	// DeleteExpiredOrgInvites deletes all expired organization invites.
 	DeleteExpiredOrgInvites() error
 
-	// SetPerms changes the permission of a user
-	SetPerms(ctx context.Context, action string, userId uint32, perms models.Permission) error
+	// SetPerms changes the permission of a member of an organization on an action and notifies them,
+	// returns constants.ErrNoRows if the user is not a member.
+	SetPerms(ctx context.Context, orgId string, action string, userId uint32, perms models.Permission) error
 }
@@ -7,17 +7,22 @@ import (
 	"errors"
 
 	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/templates"
 	"github.com/LombardiDaniel/goliath/src/pkg/constants"
 	"github.com/LombardiDaniel/goliath/src/pkg/validators"
 )
 
 type OrganizationServicePgImpl struct {
-	db *sql.DB
+	db                  *sql.DB
+	outboxService       OutboxService
+	notificationService NotificationService
 }
 
-func NewOrganizationServicePgImpl(db *sql.DB) OrganizationService {
+func NewOrganizationServicePgImpl(db *sql.DB, outboxService OutboxService, notificationService NotificationService) OrganizationService {
 	return &OrganizationServicePgImpl{
-		db: db,
+		db:                  db,
+		outboxService:       outboxService,
+		notificationService: notificationService,
 	}
 }
 
@@ -29,7 +34,8 @@ func (s *OrganizationServicePgImpl) GetOrganization(ctx context.Context, orgId s
 			billing_plan_id,
 			created_at,
 			deleted_at,
-			owner_user_id
+			owner_user_id,
+			default_locale
 		FROM
 			organizations
 		WHERE
@@ -45,6 +51,7 @@ func (s *OrganizationServicePgImpl) GetOrganization(ctx context.Context, orgId s
 		&org.CreatedAt,
 		&org.DeletedAt,
 		&org.OwnerUserId,
+		&org.DefaultLocale,
 	)
 	return org, errors.Join(err, validators.FilterSqlPgError(err))
 }
@@ -103,7 +110,38 @@ func (s *OrganizationServicePgImpl) CreateOrganization(ctx context.Context, org
 }
 
 func (s *OrganizationServicePgImpl) CreateOrganizationInvite(ctx context.Context, invite models.OrganizationInvite) error {
-	query := `
+	if invite.Otp == nil {
+		return errors.New("organization invite must have an otp")
+	}
+
+	permStr, err := json.Marshal(invite.Perms)
+	if err != nil {
+		return errors.Join(err, errors.New("could not marshal invite perms"))
+	}
+
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return errors.Join(err, constants.ErrDbTransactionCreate)
+	}
+	defer tx.Rollback()
+
+	// invitees without a locale of their own get the one of the organization
+	var email, firstName, orgName, locale string
+	err = tx.QueryRowContext(ctx, `
+		SELECT u.email, u.first_name, o.organization_name, COALESCE(u.locale, o.default_locale, '')
+		FROM users u, organizations o
+		WHERE
+			u.user_id = $1 AND
+			o.organization_id = $2;
+		`,
+		invite.UserId,
+		invite.OrganizationId,
+	).Scan(&email, &firstName, &orgName, &locale)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	_, err = tx.ExecContext(ctx, `
 		INSERT INTO organization_invites (
 			organization_id,
 			user_id,
@@ -112,23 +150,49 @@ func (s *OrganizationServicePgImpl) CreateOrganizationInvite(ctx context.Context
 			exp
 		)
 		VALUES ($1, $2, $3, $4, $5);
-	`
-
-	permStr, err := json.Marshal(invite.Perms)
-	if err != nil {
-		return errors.Join(err, errors.New("could not marshal invite perms"))
-	}
-	_, err = s.db.ExecContext(ctx, query,
+		`,
 		invite.OrganizationId,
 		invite.UserId,
 		permStr,
 		invite.Otp,
 		invite.Exp,
 	)
-	return errors.Join(err, validators.FilterSqlPgError(err))
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	err = s.outboxService.Tx(tx).Send(ctx, templates.OrganizationInvite, email, locale, map[string]any{
+		"FirstName":        firstName,
+		"OrganizationName": orgName,
+		"Otp":              *invite.Otp,
+	})
+	if err != nil {
+		return err
+	}
+
+	// the otp stays in the email, the app accepts the invite as the signed in user
+	err = s.notificationService.Tx(tx).Notify(ctx, invite.UserId, models.OrganizationInviteNotification, map[string]any{
+		"OrganizationId":   invite.OrganizationId,
+		"OrganizationName": orgName,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (s *OrganizationServicePgImpl) ConfirmOrganizationInvite(ctx context.Context, otp string) error {
+	return s.acceptInvite(ctx, `otp = $1`, otp)
+}
+
+func (s *OrganizationServicePgImpl) AcceptOrganizationInvite(ctx context.Context, orgId string, userId uint32) error {
+	return s.acceptInvite(ctx, `organization_id = $1 AND user_id = $2`, orgId, userId)
+}
+
+// acceptInvite makes the user of the unexpired invite matched by where a member of its
+// organization with the perms of the invite, the invites of the user to it are deleted.
+func (s *OrganizationServicePgImpl) acceptInvite(ctx context.Context, where string, args ...any) error {
 	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{})
 	if err != nil {
 		return errors.Join(err, constants.ErrDbTransactionCreate)
@@ -147,9 +211,12 @@ func (s *OrganizationServicePgImpl) ConfirmOrganizationInvite(ctx context.Contex
 		FROM
 			organization_invites
 		WHERE
-			otp = $1 AND
-			exp > NOW();
-	`, otp).Scan(
+			`+where+` AND
+			exp > NOW()
+		ORDER BY exp DESC
+		LIMIT 1
+		FOR UPDATE;
+	`, args...).Scan(
 		&inv.OrganizationId,
 		&inv.UserId,
 		&permsString,
@@ -193,8 +260,13 @@ func (s *OrganizationServicePgImpl) ConfirmOrganizationInvite(ctx context.Contex
 
 	_, err = tx.ExecContext(ctx, `
 		DELETE FROM organization_invites
-		WHERE otp = $1;
-	`, otp)
+		WHERE
+			organization_id = $1 AND
+			user_id = $2;
+	`,
+		inv.OrganizationId,
+		inv.UserId,
+	)
 	if err != nil {
 		return errors.Join(err, validators.FilterSqlPgError(err))
 	}
@@ -210,14 +282,15 @@ func (s *OrganizationServicePgImpl) RemoveUserFromOrg(ctx context.Context, orgId
 	defer tx.Rollback()
 
 	var isOwner bool
-	err = s.db.QueryRowContext(ctx, `
-		SELECT owner_user_id = $1
+	var orgName string
+	err = tx.QueryRowContext(ctx, `
+		SELECT owner_user_id = $1, organization_name
 		FROM organizations
 		WHERE organization_id = $2;
 	`,
 		userId,
 		orgId,
-	).Scan(&isOwner)
+	).Scan(&isOwner, &orgName)
 	if err != nil {
 		return errors.Join(err, validators.FilterSqlPgError(err))
 	}
@@ -226,7 +299,7 @@ func (s *OrganizationServicePgImpl) RemoveUserFromOrg(ctx context.Context, orgId
 		return errors.Join(constants.ErrDbConflict, errors.New("cannot remove owner of organization"))
 	}
 
-	_, err = s.db.ExecContext(ctx, `
+	_, err = tx.ExecContext(ctx, `
 		DELETE FROM organizations_users
 		WHERE organization_id = $1 AND user_id = $2;
 	`,
@@ -237,9 +310,35 @@ func (s *OrganizationServicePgImpl) RemoveUserFromOrg(ctx context.Context, orgId
 		return errors.Join(err, validators.FilterSqlPgError(err))
 	}
 
+	err = s.notificationService.Tx(tx).Notify(ctx, userId, models.OrganizationRemovalNotification, map[string]any{
+		"OrganizationId":   orgId,
+		"OrganizationName": orgName,
+	})
+	if err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
+func (s *OrganizationServicePgImpl) SetDefaultLocale(ctx context.Context, orgId string, locale *string) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE organizations
+		SET default_locale = $1
+		WHERE
+			organization_id = $2 AND
+			deleted_at IS NULL;
+	`,
+		locale,
+		orgId,
+	)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return errIfNoRowsAffected(res)
+}
+
 func (s *OrganizationServicePgImpl) SetOrganizationOwner(ctx context.Context, orgId string, userId uint32) error {
 	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{})
 	if err != nil {
@@ -247,14 +346,16 @@ func (s *OrganizationServicePgImpl) SetOrganizationOwner(ctx context.Context, or
 	}
 	defer tx.Rollback()
 
-	_, err = tx.ExecContext(ctx, `
+	var orgName string
+	err = tx.QueryRowContext(ctx, `
 		UPDATE organizations
 		SET owner_user_id = $1
-		WHERE organization_id = $2;
+		WHERE organization_id = $2
+		RETURNING organization_name;
 	`,
 		userId,
 		orgId,
-	)
+	).Scan(&orgName)
 	if err != nil {
 		return errors.Join(err, validators.FilterSqlPgError(err))
 	}
@@ -273,6 +374,14 @@ func (s *OrganizationServicePgImpl) SetOrganizationOwner(ctx context.Context, or
 		return errors.Join(err, validators.FilterSqlPgError(err))
 	}
 
+	err = s.notificationService.Tx(tx).Notify(ctx, userId, models.OrganizationOwnerNotification, map[string]any{
+		"OrganizationId":   orgId,
+		"OrganizationName": orgName,
+	})
+	if err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
@@ -284,13 +393,54 @@ func (s *OrganizationServicePgImpl) DeleteExpiredOrgInvites() error {
 	return errors.Join(err, validators.FilterSqlPgError(err))
 }
 
-func (s *OrganizationServicePgImpl) SetPerms(ctx context.Context, action string, userId uint32, perms models.Permission) error {
-	_, err := s.db.Exec(`
-        INSERT INTO organization_user_permissions (organization_id, user_id, action_name, permission)
-        VALUES ($1, $2, $3, $4)
-        ON CONFLICT (action_name, organization_id, user_id)
-        DO UPDATE SET
-            permission = EXCLUDED.permission;
-    `)
-	return errors.Join(err, validators.FilterSqlPgError(err))
+func (s *OrganizationServicePgImpl) SetPerms(ctx context.Context, orgId string, action string, userId uint32, perms models.Permission) error {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return errors.Join(err, constants.ErrDbTransactionCreate)
+	}
+	defer tx.Rollback()
+
+	var orgName string
+	err = tx.QueryRowContext(ctx, `
+		SELECT o.organization_name
+		FROM organizations o
+		INNER JOIN organizations_users ou ON ou.organization_id = o.organization_id
+		WHERE
+			o.organization_id = $1 AND
+			ou.user_id = $2;
+	`,
+		orgId,
+		userId,
+	).Scan(&orgName)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO organization_user_permissions (organization_id, user_id, action_name, permission)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (action_name, organization_id, user_id)
+		DO UPDATE SET
+			permission = EXCLUDED.permission;
+	`,
+		orgId,
+		userId,
+		action,
+		perms,
+	)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	err = s.notificationService.Tx(tx).Notify(ctx, userId, models.OrganizationPermsNotification, map[string]any{
+		"OrganizationId":   orgId,
+		"OrganizationName": orgName,
+		"Action":           action,
+		"Permission":       perms,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
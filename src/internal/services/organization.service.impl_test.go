@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/templates"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/helpers"
+)
+
+func TestOrganizationServicePgImpl_Notifications(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := helpers.NewPostgresContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %s", err)
+		}
+	})
+
+	db := pgContainer.DB
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO users (user_id, email, password_hash, first_name, last_name)
+		VALUES
+			(1, 'owner@email.com', 'hashtest', 'Test', 'Owner'),
+			(2, 'jane@email.com', 'hashtest', 'Jane', 'Doe');
+
+		INSERT INTO organizations (organization_id, organization_name, owner_user_id)
+		VALUES ('ORG01', 'org', 1);
+
+		INSERT INTO organizations_users (organization_id, user_id)
+		VALUES ('ORG01', 1);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := templates.NewRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	notificationService := NewNotificationServicePgImpl(db, pgContainer.ConnString)
+	s := NewOrganizationServicePgImpl(db, NewOutboxServicePgImpl(db, &EmailServiceMock{}, registry), notificationService)
+
+	otp := "invite-otp"
+	exp := time.Now().Add(time.Hour)
+	err = s.CreateOrganizationInvite(ctx, models.OrganizationInvite{
+		OrganizationId: "ORG01",
+		UserId:         2,
+		Perms:          map[string]models.Permission{"members": models.ReadPermission},
+		Otp:            &otp,
+		Exp:            &exp,
+	})
+	if err != nil {
+		t.Fatalf("OrganizationServicePgImpl.CreateOrganizationInvite() error = %v", err)
+	}
+
+	// the otp is only sent by email, the notification is readable by the app and published to every instance
+	notifications, _, _, err := notificationService.GetNotifications(ctx, 2, false, 0, 10)
+	if err != nil || len(notifications) != 1 || notifications[0].Kind != models.OrganizationInviteNotification {
+		t.Fatalf("expected the invite notification, got %+v, %v", notifications, err)
+	}
+	if _, ok := notifications[0].Data["Otp"]; ok || notifications[0].Data["OrganizationId"] != "ORG01" {
+		t.Errorf("unexpected invite notification data %+v", notifications[0].Data)
+	}
+
+	if err := s.AcceptOrganizationInvite(ctx, "ORG01", 1); !errors.Is(err, constants.ErrNoRows) {
+		t.Errorf("accepting without an invite should return ErrNoRows, got %v", err)
+	}
+	if err := s.AcceptOrganizationInvite(ctx, "ORG01", 2); err != nil {
+		t.Fatalf("OrganizationServicePgImpl.AcceptOrganizationInvite() error = %v", err)
+	}
+	if err := s.ConfirmOrganizationInvite(ctx, otp); !errors.Is(err, constants.ErrNoRows) {
+		t.Errorf("an accepted invite should be gone, got %v", err)
+	}
+
+	if err := s.SetPerms(ctx, "ORG01", "admin", 2, models.ReadWritePermission); err != nil {
+		t.Fatalf("OrganizationServicePgImpl.SetPerms() error = %v", err)
+	}
+	var perm models.Permission
+	err = db.QueryRowContext(ctx, `
+		SELECT permission
+		FROM organization_user_permissions
+		WHERE organization_id = 'ORG01' AND user_id = 2 AND action_name = 'admin';
+	`).Scan(&perm)
+	if err != nil || perm != models.ReadWritePermission {
+		t.Errorf("expected the admin permission set, got %d, %v", perm, err)
+	}
+
+	notifications, _, _, err = notificationService.GetNotifications(ctx, 2, false, 0, 1)
+	if err != nil || len(notifications) != 1 || notifications[0].Kind != models.OrganizationPermsNotification || notifications[0].Data["Action"] != "admin" {
+		t.Errorf("expected the role change notification, got %+v, %v", notifications, err)
+	}
+
+	if err := s.SetPerms(ctx, "ORG01", "admin", 3, models.ReadWritePermission); !errors.Is(err, constants.ErrNoRows) {
+		t.Errorf("setting the perms of a non member should return ErrNoRows, got %v", err)
+	}
+}
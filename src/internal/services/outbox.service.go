@@ -0,0 +1,33 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+)
+
+// OutboxService defines the interface for the transactional email outbox. It is an
+// EmailService whose Send method enqueues the email instead of sending it, Deliver then
+// sends the enqueued emails through the provider EmailService, retrying with backoff.
+type OutboxService interface {
+	EmailService
+
+	// Tx returns an EmailService that enqueues within tx, its emails are only sent if tx commits.
+	Tx(tx *sql.Tx) EmailService
+
+	// Deliver sends the pending emails that are due, retrying failures with exponential
+	// backoff until they run out of attempts, except for the emails to suppressed addresses
+	// or to users that opted out of them, which are not retried. This method should be called periodically.
+	Deliver() error
+
+	// GetEmails retrieves a page of the emails in a status (any if nil), newest first, and their total count.
+	GetEmails(ctx context.Context, status *models.OutboxStatus, offset int, limit int) ([]models.OutboxEmail, int64, error)
+
+	// GetEmail retrieves an email of the outbox by its ID.
+	GetEmail(ctx context.Context, emailId int64) (models.OutboxEmail, error)
+
+	// RetryEmail makes a dead email pending again with a fresh set of attempts,
+	// returns constants.ErrNoRows if it is not dead.
+	RetryEmail(ctx context.Context, emailId int64) (models.OutboxEmail, error)
+}
@@ -0,0 +1,310 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/mail"
+	"github.com/LombardiDaniel/goliath/src/pkg/validators"
+)
+
+// execer is implemented by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// outboxWriter is the EmailService that enqueues emails through exec.
+type outboxWriter struct {
+	exec     execer
+	registry *mail.Registry
+}
+
+func (w outboxWriter) Send(ctx context.Context, templateName string, to string, locale string, data any) error {
+	// unknown templates and missing data would only fail when delivered, after the change they notify about
+	err := w.registry.Validate(templateName, data)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return errors.Join(err, errors.New("could not marshal outbox payload"))
+	}
+
+	_, err = w.exec.ExecContext(ctx, `
+		INSERT INTO email_outbox (template_name, recipient, locale, payload)
+		VALUES ($1, $2, $3, $4);
+		`,
+		templateName,
+		to,
+		locale,
+		payload,
+	)
+	return errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+type OutboxServicePgImpl struct {
+	outboxWriter
+	db     *sql.DB
+	sender EmailService
+}
+
+// NewOutboxServicePgImpl creates the outbox of the emails of registry, they are delivered through sender.
+func NewOutboxServicePgImpl(db *sql.DB, sender EmailService, registry *mail.Registry) OutboxService {
+	return &OutboxServicePgImpl{
+		outboxWriter: outboxWriter{exec: db, registry: registry},
+		db:           db,
+		sender:       sender,
+	}
+}
+
+func (s *OutboxServicePgImpl) Tx(tx *sql.Tx) EmailService {
+	return outboxWriter{exec: tx, registry: s.registry}
+}
+
+type outboxMessage struct {
+	emailId      int64
+	templateName string
+	recipient    string
+	locale       string
+	payload      []byte
+	attempts     int64
+}
+
+// claim takes up to limit due emails for delivery, counting the attempt and leasing them for
+// constants.EmailOutboxLease, so other deliveries skip them while they are sent outside of any
+// transaction. Emails left claimed by a crash are taken again once their lease runs out.
+func (s *OutboxServicePgImpl) claim(ctx context.Context, limit int) ([]outboxMessage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE email_outbox
+		SET
+			attempts = attempts + 1,
+			locked_until = NOW() + make_interval(secs => $1)
+		WHERE email_id IN (
+			SELECT email_id
+			FROM email_outbox
+			WHERE
+				email_status = 'pending' AND
+				next_attempt_at <= NOW() AND
+				(locked_until IS NULL OR locked_until <= NOW())
+			ORDER BY next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING email_id, template_name, recipient, locale, payload, attempts;
+		`,
+		constants.EmailOutboxLease.Seconds(),
+		limit,
+	)
+	if err != nil {
+		return nil, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+	defer rows.Close()
+
+	messages := []outboxMessage{}
+	for rows.Next() {
+		m := outboxMessage{}
+		err := rows.Scan(&m.emailId, &m.templateName, &m.recipient, &m.locale, &m.payload, &m.attempts)
+		if err != nil {
+			return nil, errors.Join(err, validators.FilterSqlPgError(err))
+		}
+		messages = append(messages, m)
+	}
+
+	return messages, rows.Err()
+}
+
+func (s *OutboxServicePgImpl) Deliver() error {
+	ctx := context.Background()
+
+	messages, err := s.claim(ctx, 50)
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	for _, m := range messages {
+		errs = append(errs, s.deliver(ctx, m))
+	}
+
+	return errors.Join(errs...)
+}
+
+// deliver sends a claimed email and records the outcome, releasing it.
+func (s *OutboxServicePgImpl) deliver(ctx context.Context, m outboxMessage) error {
+	// attempts are counted when claimed, past the limit the earlier ones were cut short by crashes
+	if m.attempts > constants.EmailOutboxMaxAttempts {
+		slog.Error(fmt.Sprintf("email %d (%s) is dead after %d attempts that did not finish", m.emailId, m.templateName, m.attempts-1))
+		return s.release(ctx, m.emailId, `
+			email_status = 'dead',
+			last_error = 'delivery did not finish'
+		`)
+	}
+
+	err := s.sender.Send(ctx, m.templateName, m.recipient, m.locale, json.RawMessage(m.payload))
+	if err == nil {
+		// the payload is dropped once sent, it may hold one-time passwords
+		return s.release(ctx, m.emailId, `
+			email_status = 'sent',
+			sent_at = NOW(),
+			payload = NULL,
+			last_error = NULL
+		`)
+	}
+
+	// retrying would not change anything, the payload is dropped as for sent emails
+	if errors.Is(err, constants.ErrEmailSuppressed) || errors.Is(err, constants.ErrEmailOptedOut) {
+		slog.Info(fmt.Sprintf("email %d (%s) is not sent: %s", m.emailId, m.templateName, err.Error()))
+		return s.release(ctx, m.emailId, `
+			email_status = 'suppressed',
+			payload = NULL,
+			last_error = $2
+			`,
+			err.Error(),
+		)
+	}
+
+	// emails that cannot be rendered are not retried either
+	status := models.OutboxPending
+	if m.attempts >= constants.EmailOutboxMaxAttempts || errors.Is(err, mail.ErrMissingData) || errors.Is(err, mail.ErrUnknownTemplate) {
+		status = models.OutboxDead
+		slog.Error(fmt.Sprintf("email %d (%s) is dead after %d attempts: %s", m.emailId, m.templateName, m.attempts, err.Error()))
+	} else {
+		slog.Warn(fmt.Sprintf("could not send email %d (%s), attempt %d: %s", m.emailId, m.templateName, m.attempts, err.Error()))
+	}
+
+	return s.release(ctx, m.emailId, `
+		email_status = $2,
+		next_attempt_at = $3,
+		last_error = $4
+		`,
+		status,
+		time.Now().Add(outboxBackoff(m.attempts)),
+		err.Error(),
+	)
+}
+
+// release applies set to a claimed email and clears its lease, set continues the args from $2.
+func (s *OutboxServicePgImpl) release(ctx context.Context, emailId int64, set string, args ...any) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE email_outbox
+		SET
+			`+set+`,
+			locked_until = NULL
+		WHERE email_id = $1;
+		`,
+		append([]any{emailId}, args...)...,
+	)
+	return errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+// outboxBackoff is how long to wait after the failed attempt before the next one.
+func outboxBackoff(attempts int64) time.Duration {
+	return constants.EmailOutboxBackoff * time.Duration(1<<min(attempts-1, 16))
+}
+
+const outboxEmailColumns = `
+	email_id,
+	template_name,
+	recipient,
+	locale,
+	email_status,
+	attempts,
+	next_attempt_at,
+	last_error,
+	created_at,
+	sent_at
+`
+
+func scanOutboxEmail(row rowScanner) (models.OutboxEmail, error) {
+	e := models.OutboxEmail{}
+	err := row.Scan(
+		&e.EmailId,
+		&e.TemplateName,
+		&e.Recipient,
+		&e.Locale,
+		&e.Status,
+		&e.Attempts,
+		&e.NextAttemptAt,
+		&e.LastError,
+		&e.CreatedAt,
+		&e.SentAt,
+	)
+	return e, errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+func (s *OutboxServicePgImpl) GetEmails(ctx context.Context, status *models.OutboxStatus, offset int, limit int) ([]models.OutboxEmail, int64, error) {
+	emails := []models.OutboxEmail{}
+
+	var total int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM email_outbox
+		WHERE $1::TEXT IS NULL OR email_status = $1;
+		`,
+		status,
+	).Scan(&total)
+	if err != nil {
+		return emails, 0, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+outboxEmailColumns+`
+		FROM email_outbox
+		WHERE $1::TEXT IS NULL OR email_status = $1
+		ORDER BY created_at DESC, email_id DESC
+		OFFSET $2
+		LIMIT $3;
+		`,
+		status,
+		offset,
+		limit,
+	)
+	if err != nil {
+		return emails, 0, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanOutboxEmail(rows)
+		if err != nil {
+			return emails, 0, err
+		}
+		emails = append(emails, e)
+	}
+
+	return emails, total, rows.Err()
+}
+
+func (s *OutboxServicePgImpl) GetEmail(ctx context.Context, emailId int64) (models.OutboxEmail, error) {
+	return scanOutboxEmail(s.db.QueryRowContext(ctx, `
+		SELECT `+outboxEmailColumns+`
+		FROM email_outbox
+		WHERE email_id = $1;
+		`,
+		emailId,
+	))
+}
+
+func (s *OutboxServicePgImpl) RetryEmail(ctx context.Context, emailId int64) (models.OutboxEmail, error) {
+	return scanOutboxEmail(s.db.QueryRowContext(ctx, `
+		UPDATE email_outbox
+		SET
+			email_status = 'pending',
+			attempts = 0,
+			next_attempt_at = NOW()
+		WHERE
+			email_id = $1 AND
+			email_status = 'dead'
+		RETURNING `+outboxEmailColumns+`;
+		`,
+		emailId,
+	))
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/templates"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/helpers"
+)
+
+// recipientSender fails the emails to the recipients in errs and counts the emails it gets.
+type recipientSender struct {
+	mu    sync.Mutex
+	errs  map[string]error
+	calls map[string]int
+}
+
+func (s *recipientSender) Send(ctx context.Context, templateName string, to string, locale string, data any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[to]++
+	return s.errs[to]
+}
+
+func TestOutboxServicePgImpl_Deliver(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := helpers.NewPostgresContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %s", err)
+		}
+	})
+
+	registry, err := templates.NewRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := &recipientSender{
+		errs: map[string]error{
+			"failing@email.com":   errors.New("provider down"),
+			"opted-out@email.com": constants.ErrEmailOptedOut,
+		},
+		calls: map[string]int{},
+	}
+
+	db := pgContainer.DB
+	s := NewOutboxServicePgImpl(db, sender, registry).(*OutboxServicePgImpl)
+
+	enqueue := func(to string) int64 {
+		t.Helper()
+		err := s.Send(ctx, templates.EmailConfirmation, to, "en", map[string]any{"FirstName": "Jane", "Otp": "otp123"})
+		if err != nil {
+			t.Fatalf("OutboxServicePgImpl.Send() error = %v", err)
+		}
+		var emailId int64
+		err = db.QueryRowContext(ctx, `SELECT MAX(email_id) FROM email_outbox;`).Scan(&emailId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return emailId
+	}
+
+	type outboxRow struct {
+		email    models.OutboxEmail
+		payload  bool
+		isLocked bool
+	}
+	get := func(emailId int64) outboxRow {
+		t.Helper()
+		email, err := s.GetEmail(ctx, emailId)
+		if err != nil {
+			t.Fatalf("OutboxServicePgImpl.GetEmail() error = %v", err)
+		}
+		row := outboxRow{email: email}
+		err = db.QueryRowContext(ctx, `
+			SELECT payload IS NOT NULL, locked_until IS NOT NULL
+			FROM email_outbox
+			WHERE email_id = $1;
+			`,
+			emailId,
+		).Scan(&row.payload, &row.isLocked)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return row
+	}
+
+	sent := enqueue("sent@email.com")
+	failing := enqueue("failing@email.com")
+	optedOut := enqueue("opted-out@email.com")
+
+	if err := s.Deliver(); err != nil {
+		t.Fatalf("OutboxServicePgImpl.Deliver() error = %v", err)
+	}
+
+	// sent and suppressed emails drop their payload, it may hold one-time passwords
+	row := get(sent)
+	if row.email.Status != models.OutboxSent || row.email.Attempts != 1 || row.email.SentAt == nil || row.payload || row.isLocked {
+		t.Errorf("unexpected sent email %+v", row)
+	}
+	row = get(optedOut)
+	if row.email.Status != models.OutboxSuppressed || row.payload || row.isLocked {
+		t.Errorf("unexpected suppressed email %+v", row)
+	}
+
+	row = get(failing)
+	if row.email.Status != models.OutboxPending || row.email.Attempts != 1 || !row.payload || row.isLocked {
+		t.Errorf("unexpected failed email %+v", row)
+	}
+	if row.email.LastError == nil || *row.email.LastError != "provider down" {
+		t.Errorf("failed email should keep its error, got %v", row.email.LastError)
+	}
+	if row.email.NextAttemptAt.Before(time.Now().Add(constants.EmailOutboxBackoff - time.Second)) {
+		t.Errorf("failed email should be backed off, next attempt at %s", row.email.NextAttemptAt)
+	}
+
+	// backed off emails are not due
+	if err := s.Deliver(); err != nil {
+		t.Fatalf("OutboxServicePgImpl.Deliver() error = %v", err)
+	}
+	if sender.calls["failing@email.com"] != 1 || sender.calls["sent@email.com"] != 1 {
+		t.Errorf("only due pending emails should be sent, got %v", sender.calls)
+	}
+
+	// the last attempt kills the email
+	_, err = db.ExecContext(ctx, `
+		UPDATE email_outbox
+		SET attempts = $2, next_attempt_at = NOW()
+		WHERE email_id = $1;
+		`,
+		failing,
+		constants.EmailOutboxMaxAttempts-1,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Deliver(); err != nil {
+		t.Fatalf("OutboxServicePgImpl.Deliver() error = %v", err)
+	}
+	row = get(failing)
+	if row.email.Status != models.OutboxDead || row.email.Attempts != constants.EmailOutboxMaxAttempts {
+		t.Errorf("email should be dead after %d attempts, got %+v", constants.EmailOutboxMaxAttempts, row)
+	}
+
+	retried, err := s.RetryEmail(ctx, failing)
+	if err != nil || retried.Status != models.OutboxPending || retried.Attempts != 0 {
+		t.Errorf("OutboxServicePgImpl.RetryEmail() = %+v, %v", retried, err)
+	}
+}
+
+func TestOutboxServicePgImpl_Claim(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := helpers.NewPostgresContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %s", err)
+		}
+	})
+
+	registry, err := templates.NewRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := &recipientSender{calls: map[string]int{}}
+
+	db := pgContainer.DB
+	s := NewOutboxServicePgImpl(db, sender, registry).(*OutboxServicePgImpl)
+
+	err = s.Send(ctx, templates.AccountCreated, "jane@email.com", "en", map[string]any{"FirstName": "Jane"})
+	if err != nil {
+		t.Fatalf("OutboxServicePgImpl.Send() error = %v", err)
+	}
+
+	claimed, err := s.claim(ctx, 50)
+	if err != nil || len(claimed) != 1 || claimed[0].attempts != 1 {
+		t.Fatalf("OutboxServicePgImpl.claim() = %+v, %v, want the email on its first attempt", claimed, err)
+	}
+
+	// a claimed email is left to its delivery, even once it commits
+	again, err := s.claim(ctx, 50)
+	if err != nil || len(again) != 0 {
+		t.Fatalf("a claimed email should not be claimed again, got %+v, %v", again, err)
+	}
+	if err := s.Deliver(); err != nil || sender.calls["jane@email.com"] != 0 {
+		t.Fatalf("a claimed email should not be sent by another delivery, got %v, %v", sender.calls, err)
+	}
+
+	// once the lease of a crashed delivery runs out the email is taken over
+	_, err = db.ExecContext(ctx, `UPDATE email_outbox SET locked_until = NOW() - INTERVAL '1 second';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Deliver(); err != nil {
+		t.Fatalf("OutboxServicePgImpl.Deliver() error = %v", err)
+	}
+	email, err := s.GetEmail(ctx, claimed[0].emailId)
+	if err != nil || email.Status != models.OutboxSent || email.Attempts != 2 || sender.calls["jane@email.com"] != 1 {
+		t.Errorf("the abandoned email should be sent once on its second attempt, got %+v, %v, %v", email, sender.calls, err)
+	}
+
+	// emails whose attempts were all cut short are given up without being sent again
+	err = s.Send(ctx, templates.AccountCreated, "crashed@email.com", "en", map[string]any{"FirstName": "Jane"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.ExecContext(ctx, `
+		UPDATE email_outbox
+		SET attempts = $1
+		WHERE recipient = 'crashed@email.com';
+		`,
+		constants.EmailOutboxMaxAttempts,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Deliver(); err != nil {
+		t.Fatalf("OutboxServicePgImpl.Deliver() error = %v", err)
+	}
+	dead := models.OutboxDead
+	emails, total, err := s.GetEmails(ctx, &dead, 0, 10)
+	if err != nil || total != 1 || emails[0].Recipient != "crashed@email.com" || sender.calls["crashed@email.com"] != 0 {
+		t.Errorf("expected the crashed email dead without being sent, got %+v, %v, %v", emails, sender.calls, err)
+	}
+}
@@ -0,0 +1,43 @@
+package services
+
+import (
+	"context"
+
+	"github.com/LombardiDaniel/goliath/src/internal/dto"
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+)
+
+// PlanService defines the interface for the billing catalog operations.
+// It provides methods for managing plans and the prices they are sold for.
+type PlanService interface {
+	// CreatePlan creates a new plan and returns its ID.
+	CreatePlan(ctx context.Context, plan models.Plan) (uint32, error)
+
+	// GetPlan retrieves a plan, with all its prices, by its ID.
+	GetPlan(ctx context.Context, planId uint32) (models.Plan, error)
+
+	// GetPlans retrieves all plans with their prices, optionally only the active ones.
+	GetPlans(ctx context.Context, activeOnly bool) ([]models.Plan, error)
+
+	// EditPlan updates a plan's name, features and active flag.
+	EditPlan(ctx context.Context, planId uint32, plan dto.EditPlan) error
+
+	// DeactivatePlan deactivates a plan and all its prices, plans are never deleted
+	// since payments and organizations reference them.
+	DeactivatePlan(ctx context.Context, planId uint32) error
+
+	// SetPlanProviderProductId links a plan to its gateway Product.
+	SetPlanProviderProductId(ctx context.Context, planId uint32, providerProductId string) error
+
+	// CreatePrice creates a new price for a plan and returns its ID.
+	CreatePrice(ctx context.Context, price models.Price) (uint32, error)
+
+	// GetPrice retrieves a price by its ID.
+	GetPrice(ctx context.Context, priceId uint32) (models.Price, error)
+
+	// SetPriceActive activates or deactivates a price.
+	SetPriceActive(ctx context.Context, priceId uint32, isActive bool) error
+
+	// SetPriceProviderPriceId links a price to its gateway Price.
+	SetPriceProviderPriceId(ctx context.Context, priceId uint32, providerPriceId string) error
+}
@@ -0,0 +1,373 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/LombardiDaniel/goliath/src/internal/dto"
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/validators"
+)
+
+type PlanServicePgImpl struct {
+	db *sql.DB
+}
+
+func NewPlanServicePgImpl(db *sql.DB) PlanService {
+	return &PlanServicePgImpl{
+		db: db,
+	}
+}
+
+func (s *PlanServicePgImpl) CreatePlan(ctx context.Context, plan models.Plan) (uint32, error) {
+	features, err := json.Marshal(plan.Features)
+	if err != nil {
+		return 0, errors.Join(err, errors.New("could not marshal plan features"))
+	}
+
+	limits, err := json.Marshal(plan.Limits)
+	if err != nil {
+		return 0, errors.Join(err, errors.New("could not marshal plan limits"))
+	}
+
+	var planId uint32
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO plans (plan_name, features, limits)
+		VALUES ($1, $2, $3)
+		RETURNING plan_id;
+		`,
+		plan.PlanName,
+		features,
+		limits,
+	).Scan(&planId)
+
+	return planId, errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+func (s *PlanServicePgImpl) GetPlan(ctx context.Context, planId uint32) (models.Plan, error) {
+	plan := models.Plan{}
+	var features, limits []byte
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			plan_id,
+			plan_name,
+			features,
+			limits,
+			is_active,
+			provider_product_id,
+			created_at
+		FROM plans
+		WHERE plan_id = $1;
+		`,
+		planId,
+	).Scan(
+		&plan.PlanId,
+		&plan.PlanName,
+		&features,
+		&limits,
+		&plan.IsActive,
+		&plan.ProviderProductId,
+		&plan.CreatedAt,
+	)
+	if err != nil {
+		return plan, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	err = json.Unmarshal(features, &plan.Features)
+	if err != nil {
+		return plan, errors.Join(err, errors.New("could not unmarshal plan features"))
+	}
+
+	err = json.Unmarshal(limits, &plan.Limits)
+	if err != nil {
+		return plan, errors.Join(err, errors.New("could not unmarshal plan limits"))
+	}
+
+	prices, err := s.getPrices(ctx, &plan.PlanId, false)
+	if err != nil {
+		return plan, err
+	}
+	plan.Prices = prices[plan.PlanId]
+
+	return plan, nil
+}
+
+func (s *PlanServicePgImpl) GetPlans(ctx context.Context, activeOnly bool) ([]models.Plan, error) {
+	plans := []models.Plan{}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			plan_id,
+			plan_name,
+			features,
+			limits,
+			is_active,
+			provider_product_id,
+			created_at
+		FROM plans
+		WHERE is_active OR NOT $1
+		ORDER BY plan_id;
+		`,
+		activeOnly,
+	)
+	if err != nil {
+		return plans, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p := models.Plan{}
+		var features, limits []byte
+		err := rows.Scan(
+			&p.PlanId,
+			&p.PlanName,
+			&features,
+			&limits,
+			&p.IsActive,
+			&p.ProviderProductId,
+			&p.CreatedAt,
+		)
+		if err != nil {
+			return plans, errors.Join(err, validators.FilterSqlPgError(err))
+		}
+
+		err = json.Unmarshal(features, &p.Features)
+		if err != nil {
+			return plans, errors.Join(err, errors.New("could not unmarshal plan features"))
+		}
+
+		err = json.Unmarshal(limits, &p.Limits)
+		if err != nil {
+			return plans, errors.Join(err, errors.New("could not unmarshal plan limits"))
+		}
+		plans = append(plans, p)
+	}
+
+	prices, err := s.getPrices(ctx, nil, activeOnly)
+	if err != nil {
+		return plans, err
+	}
+
+	for i := range plans {
+		plans[i].Prices = prices[plans[i].PlanId]
+	}
+
+	return plans, nil
+}
+
+// getPrices retrieves prices grouped by plan ID, of a single plan if planId is not nil.
+func (s *PlanServicePgImpl) getPrices(ctx context.Context, planId *uint32, activeOnly bool) (map[uint32][]models.Price, error) {
+	prices := make(map[uint32][]models.Price)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			price_id,
+			plan_id,
+			unit_ammount,
+			LOWER(unit_currency),
+			billing_interval,
+			meter,
+			trial_days,
+			is_active,
+			provider_price_id,
+			created_at
+		FROM prices
+		WHERE
+			(plan_id = $1 OR $1::INT IS NULL) AND
+			(is_active OR NOT $2)
+		ORDER BY price_id;
+		`,
+		planId,
+		activeOnly,
+	)
+	if err != nil {
+		return prices, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p := models.Price{}
+		err := rows.Scan(
+			&p.PriceId,
+			&p.PlanId,
+			&p.UnitAmmount,
+			&p.UnitCurrency,
+			&p.Interval,
+			&p.Meter,
+			&p.TrialDays,
+			&p.IsActive,
+			&p.ProviderPriceId,
+			&p.CreatedAt,
+		)
+		if err != nil {
+			return prices, errors.Join(err, validators.FilterSqlPgError(err))
+		}
+		prices[p.PlanId] = append(prices[p.PlanId], p)
+	}
+
+	return prices, nil
+}
+
+func (s *PlanServicePgImpl) EditPlan(ctx context.Context, planId uint32, plan dto.EditPlan) error {
+	features, err := json.Marshal(plan.Features)
+	if err != nil {
+		return errors.Join(err, errors.New("could not marshal plan features"))
+	}
+
+	limits, err := json.Marshal(plan.Limits)
+	if err != nil {
+		return errors.Join(err, errors.New("could not marshal plan limits"))
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE plans
+		SET
+			plan_name = $1,
+			features = $2,
+			limits = $3,
+			is_active = $4
+		WHERE plan_id = $5;
+		`,
+		plan.PlanName,
+		features,
+		limits,
+		plan.IsActive,
+		planId,
+	)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return errIfNoRowsAffected(res)
+}
+
+func (s *PlanServicePgImpl) DeactivatePlan(ctx context.Context, planId uint32) error {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return errors.Join(err, constants.ErrDbTransactionCreate)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE plans
+		SET is_active = false
+		WHERE plan_id = $1;
+		`,
+		planId,
+	)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+	if err := errIfNoRowsAffected(res); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE prices
+		SET is_active = false
+		WHERE plan_id = $1;
+		`,
+		planId,
+	)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return tx.Commit()
+}
+
+func (s *PlanServicePgImpl) SetPlanProviderProductId(ctx context.Context, planId uint32, providerProductId string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE plans
+		SET provider_product_id = $1
+		WHERE plan_id = $2;
+		`,
+		providerProductId,
+		planId,
+	)
+	return errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+func (s *PlanServicePgImpl) CreatePrice(ctx context.Context, price models.Price) (uint32, error) {
+	var priceId uint32
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO prices (plan_id, unit_ammount, unit_currency, billing_interval, meter, trial_days)
+		VALUES ($1, $2, LOWER($3), $4, $5, $6)
+		RETURNING price_id;
+		`,
+		price.PlanId,
+		price.UnitAmmount,
+		price.UnitCurrency,
+		price.Interval,
+		price.Meter,
+		price.TrialDays,
+	).Scan(&priceId)
+
+	return priceId, errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+func (s *PlanServicePgImpl) GetPrice(ctx context.Context, priceId uint32) (models.Price, error) {
+	p := models.Price{}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			price_id,
+			plan_id,
+			unit_ammount,
+			LOWER(unit_currency),
+			billing_interval,
+			meter,
+			trial_days,
+			is_active,
+			provider_price_id,
+			created_at
+		FROM prices
+		WHERE price_id = $1;
+		`,
+		priceId,
+	).Scan(
+		&p.PriceId,
+		&p.PlanId,
+		&p.UnitAmmount,
+		&p.UnitCurrency,
+		&p.Interval,
+		&p.Meter,
+		&p.TrialDays,
+		&p.IsActive,
+		&p.ProviderPriceId,
+		&p.CreatedAt,
+	)
+
+	return p, errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+func (s *PlanServicePgImpl) SetPriceActive(ctx context.Context, priceId uint32, isActive bool) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE prices
+		SET is_active = $1
+		WHERE price_id = $2;
+		`,
+		isActive,
+		priceId,
+	)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return errIfNoRowsAffected(res)
+}
+
+func (s *PlanServicePgImpl) SetPriceProviderPriceId(ctx context.Context, priceId uint32, providerPriceId string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE prices
+		SET provider_price_id = $1
+		WHERE price_id = $2;
+		`,
+		providerPriceId,
+		priceId,
+	)
+	return errors.Join(err, validators.FilterSqlPgError(err))
+}
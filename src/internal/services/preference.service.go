@@ -0,0 +1,33 @@
+package services
+
+import (
+	"context"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+)
+
+// PreferenceService defines the interface for the notification preferences of users: the
+// categories of emails they get and the unsubscribe links of the emails they can opt out of.
+type PreferenceService interface {
+	// GetPreferences retrieves the preferences of a user in every category, the defaults
+	// where they set none.
+	GetPreferences(ctx context.Context, userId uint32) ([]models.NotificationPreference, error)
+
+	// SetPreference sets whether a user gets the emails of a category, mandatory categories cannot be changed.
+	SetPreference(ctx context.Context, userId uint32, category models.NotificationCategory, email bool) error
+
+	// ShouldSend reports whether an email is sent to the address. Transactional emails always
+	// are, the others unless the user of the address opted out of their category.
+	ShouldSend(ctx context.Context, templateName string, to string) (bool, error)
+
+	// UnsubscribeUrl returns the one-click link that opts the user of the address out of the
+	// category of the email, empty for transactional emails and addresses of no user.
+	UnsubscribeUrl(ctx context.Context, templateName string, to string) (string, error)
+
+	// Unsubscribe opts the user of an unsubscribe link token out of its category, returns
+	// token.ErrInvalidToken if the token was not made by UnsubscribeUrl, expired or was revoked.
+	Unsubscribe(ctx context.Context, tok string) (models.NotificationCategory, error)
+
+	// RevokeUnsubscribeUrls invalidates the unsubscribe links issued to a user so far.
+	RevokeUnsubscribeUrls(ctx context.Context, userId uint32) error
+}
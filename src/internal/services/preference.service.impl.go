@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/templates"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/token"
+	"github.com/LombardiDaniel/goliath/src/pkg/validators"
+)
+
+type PreferenceServicePgImpl struct {
+	db     *sql.DB
+	secret []byte
+}
+
+// NewPreferenceServicePgImpl creates the preference service, unsubscribe links are signed with
+// secret, a key dedicated to them, as one derived with token.DeriveKey.
+func NewPreferenceServicePgImpl(db *sql.DB, secret []byte) PreferenceService {
+	return &PreferenceServicePgImpl{
+		db:     db,
+		secret: secret,
+	}
+}
+
+func (s *PreferenceServicePgImpl) GetPreferences(ctx context.Context, userId uint32) ([]models.NotificationPreference, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT category, email_enabled
+		FROM notification_preferences
+		WHERE user_id = $1;
+		`,
+		userId,
+	)
+	if err != nil {
+		return nil, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+	defer rows.Close()
+
+	set := map[models.NotificationCategory]bool{}
+	for rows.Next() {
+		var category models.NotificationCategory
+		var email bool
+		err := rows.Scan(&category, &email)
+		if err != nil {
+			return nil, errors.Join(err, validators.FilterSqlPgError(err))
+		}
+		set[category] = email
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	prefs := []models.NotificationPreference{}
+	for _, category := range models.NotificationCategories {
+		email, ok := set[category]
+		if !ok || category.Mandatory() {
+			email = category.EmailByDefault()
+		}
+		prefs = append(prefs, models.NotificationPreference{
+			Category:  category,
+			Email:     email,
+			Mandatory: category.Mandatory(),
+		})
+	}
+
+	return prefs, nil
+}
+
+func (s *PreferenceServicePgImpl) SetPreference(ctx context.Context, userId uint32, category models.NotificationCategory, email bool) error {
+	if !category.OptOutable() {
+		return fmt.Errorf("notification category '%s' cannot be opted out of", category)
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO notification_preferences (user_id, category, email_enabled)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, category) DO UPDATE
+		SET
+			email_enabled = EXCLUDED.email_enabled,
+			updated_at = NOW();
+		`,
+		userId,
+		category,
+		email,
+	)
+	return errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+// optional returns the category of an email users can opt out of, false for the others.
+func optional(templateName string) (models.NotificationCategory, bool) {
+	// emails registered elsewhere are sent as transactional ones
+	kind, ok := templates.Kinds[templateName]
+	if !ok || kind.Transactional || kind.Category.Mandatory() {
+		return "", false
+	}
+	return kind.Category, true
+}
+
+func (s *PreferenceServicePgImpl) ShouldSend(ctx context.Context, templateName string, to string) (bool, error) {
+	category, ok := optional(templateName)
+	if !ok {
+		return true, nil
+	}
+
+	var email bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(np.email_enabled, $3)
+		FROM users u
+		LEFT JOIN notification_preferences np ON
+			np.user_id = u.user_id AND
+			np.category = $2
+		WHERE u.email = $1;
+		`,
+		to,
+		category,
+		category.EmailByDefault(),
+	).Scan(&email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	return email, errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+func (s *PreferenceServicePgImpl) UnsubscribeUrl(ctx context.Context, templateName string, to string) (string, error) {
+	category, ok := optional(templateName)
+	if !ok {
+		return "", nil
+	}
+
+	var userId uint32
+	err := s.db.QueryRowContext(ctx, `
+		SELECT user_id
+		FROM users
+		WHERE email = $1;
+		`,
+		to,
+	).Scan(&userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	u, err := url.JoinPath(constants.ApiHostUrl, "/v1/notifications/unsubscribe")
+	if err != nil {
+		return "", err
+	}
+	tok := token.Sign(s.secret, fmt.Sprintf("%d:%s", userId, category))
+
+	return u + "?" + url.Values{"token": {tok}}.Encode(), nil
+}
+
+func (s *PreferenceServicePgImpl) Unsubscribe(ctx context.Context, tok string) (models.NotificationCategory, error) {
+	value, issuedAt, err := token.Verify(s.secret, tok, constants.UnsubscribeUrlExpiry)
+	if err != nil {
+		return "", err
+	}
+
+	rawUserId, category, ok := strings.Cut(value, ":")
+	userId, err := strconv.ParseUint(rawUserId, 10, 32)
+	if !ok || err != nil {
+		return "", token.ErrInvalidToken
+	}
+
+	var revokedAt sql.NullTime
+	err = s.db.QueryRowContext(ctx, `
+		SELECT unsubscribe_revoked_at
+		FROM users
+		WHERE user_id = $1;
+		`,
+		userId,
+	).Scan(&revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", token.ErrInvalidToken
+	}
+	if err != nil {
+		return "", errors.Join(err, validators.FilterSqlPgError(err))
+	}
+	// tokens carry the second they were issued at, the ones of the second links were revoked at are revoked too
+	if revokedAt.Valid && !issuedAt.After(revokedAt.Time) {
+		return "", token.ErrInvalidToken
+	}
+
+	return models.NotificationCategory(category), s.SetPreference(ctx, uint32(userId), models.NotificationCategory(category), false)
+}
+
+func (s *PreferenceServicePgImpl) RevokeUnsubscribeUrls(ctx context.Context, userId uint32) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE users
+		SET unsubscribe_revoked_at = NOW()
+		WHERE user_id = $1;
+		`,
+		userId,
+	)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return errIfNoRowsAffected(res)
+}
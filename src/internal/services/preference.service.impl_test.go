@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/templates"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/helpers"
+	"github.com/LombardiDaniel/goliath/src/pkg/token"
+)
+
+func TestPreferenceServicePgImpl(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := helpers.NewPostgresContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %s", err)
+		}
+	})
+
+	db := pgContainer.DB
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO users (user_id, email, password_hash, first_name, last_name)
+		VALUES (1, 'jane@email.com', 'hashtest', 'Jane', 'Doe');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewPreferenceServicePgImpl(db, []byte("unsubscribe-key"))
+
+	shouldSend := func(templateName string) bool {
+		t.Helper()
+		send, err := s.ShouldSend(ctx, templateName, "jane@email.com")
+		if err != nil {
+			t.Fatalf("PreferenceServicePgImpl.ShouldSend() error = %v", err)
+		}
+		return send
+	}
+
+	prefs, err := s.GetPreferences(ctx, 1)
+	if err != nil || len(prefs) != len(models.NotificationCategories) {
+		t.Fatalf("PreferenceServicePgImpl.GetPreferences() = %+v, %v", prefs, err)
+	}
+	for _, pref := range prefs {
+		if pref.Email != pref.Category.EmailByDefault() || pref.Mandatory != pref.Category.Mandatory() {
+			t.Errorf("expected the default preference of %s, got %+v", pref.Category, pref)
+		}
+	}
+
+	if err := s.SetPreference(ctx, 1, models.SecurityNotifications, false); err == nil {
+		t.Errorf("opting out of a mandatory category should fail")
+	}
+
+	// transactional emails are sent regardless of the preferences
+	if err := s.SetPreference(ctx, 1, models.BillingNotifications, false); err != nil {
+		t.Fatalf("PreferenceServicePgImpl.SetPreference() error = %v", err)
+	}
+	if shouldSend(templates.PaymentAccepted) || !shouldSend(templates.PaymentFailed) || !shouldSend(templates.OrganizationInvite) {
+		t.Errorf("only optional billing emails should be held back")
+	}
+	if send, err := s.ShouldSend(ctx, templates.PaymentAccepted, "nobody@email.com"); err != nil || !send {
+		t.Errorf("emails to addresses of no user should be sent, got %v, %v", send, err)
+	}
+
+	// transactional emails have no unsubscribe link
+	if u, err := s.UnsubscribeUrl(ctx, templates.PasswordReset, "jane@email.com"); err != nil || u != "" {
+		t.Errorf("PreferenceServicePgImpl.UnsubscribeUrl() = %q, %v, want no link", u, err)
+	}
+
+	if err := s.SetPreference(ctx, 1, models.BillingNotifications, true); err != nil {
+		t.Fatal(err)
+	}
+	unsubscribeToken := func() string {
+		t.Helper()
+		rawUrl, err := s.UnsubscribeUrl(ctx, templates.PaymentAccepted, "jane@email.com")
+		if err != nil {
+			t.Fatalf("PreferenceServicePgImpl.UnsubscribeUrl() error = %v", err)
+		}
+		u, err := url.Parse(rawUrl)
+		if err != nil || u.Query().Get("token") == "" {
+			t.Fatalf("unexpected unsubscribe url %q, %v", rawUrl, err)
+		}
+		return u.Query().Get("token")
+	}
+
+	tok := unsubscribeToken()
+	category, err := s.Unsubscribe(ctx, tok)
+	if err != nil || category != models.BillingNotifications {
+		t.Fatalf("PreferenceServicePgImpl.Unsubscribe() = %s, %v", category, err)
+	}
+	if shouldSend(templates.PaymentAccepted) {
+		t.Errorf("the user should be opted out of billing emails")
+	}
+
+	for name, tok := range map[string]string{
+		"tampered":   tok + "x",
+		"other key":  token.Sign([]byte("other-key"), "1:billing"),
+		"other user": token.Sign([]byte("unsubscribe-key"), "2:billing"),
+		"no user":    token.Sign([]byte("unsubscribe-key"), "billing"),
+	} {
+		if _, err := s.Unsubscribe(ctx, tok); !errors.Is(err, token.ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+
+	// links issued before a revocation stop working, the ones issued after do
+	if err := s.RevokeUnsubscribeUrls(ctx, 1); err != nil {
+		t.Fatalf("PreferenceServicePgImpl.RevokeUnsubscribeUrls() error = %v", err)
+	}
+	if _, err := s.Unsubscribe(ctx, tok); !errors.Is(err, token.ErrInvalidToken) {
+		t.Errorf("a revoked link should be invalid, got %v", err)
+	}
+	if err := s.RevokeUnsubscribeUrls(ctx, 2); !errors.Is(err, constants.ErrNoRows) {
+		t.Errorf("revoking the links of no user should return ErrNoRows, got %v", err)
+	}
+	_, err = db.ExecContext(ctx, `UPDATE users SET unsubscribe_revoked_at = NOW() - INTERVAL '1 minute' WHERE user_id = 1;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Unsubscribe(ctx, unsubscribeToken()); err != nil {
+		t.Errorf("a link issued after the revocation should work, got %v", err)
+	}
+}
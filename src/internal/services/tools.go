@@ -0,0 +1,117 @@
+package services
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+)
+
+// errIfNoRowsAffected returns constants.ErrNoRows if the statement did not change any row.
+func errIfNoRowsAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return constants.ErrNoRows
+	}
+
+	return nil
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// checkBucket and checkObjectPath reject the buckets and object paths that could escape
+// their directory in the storage backends that map them to files.
+func checkBucket(bucket string) error {
+	if !fs.ValidPath(bucket) || bucket == "." || strings.Contains(bucket, "/") {
+		return fmt.Errorf("invalid bucket '%s'", bucket)
+	}
+	return nil
+}
+
+func checkObjectPath(bucket string, path string) error {
+	if err := checkBucket(bucket); err != nil {
+		return err
+	}
+	if !fs.ValidPath(path) || path == "." {
+		return fmt.Errorf("invalid object path '%s'", path)
+	}
+	return nil
+}
+
+// objectPage returns the page of List of the objects sorted by path.
+func objectPage(objs []models.ObjectInfo, prefix string, after string, limit int) ([]models.ObjectInfo, string, error) {
+	if limit < 1 {
+		return nil, "", fmt.Errorf("list limit must be positive")
+	}
+
+	page := []models.ObjectInfo{}
+	for _, obj := range objs {
+		if !strings.HasPrefix(obj.Path, prefix) || obj.Path <= after {
+			continue
+		}
+		if len(page) == limit {
+			return page, page[len(page)-1].Path, nil
+		}
+		page = append(page, obj)
+	}
+
+	return page, "", nil
+}
+
+// lowerKeys copies a map of metadata with its keys in lowercase, as S3 stores them.
+func lowerKeys(m map[string]string) map[string]string {
+	lower := map[string]string{}
+	for k, v := range m {
+		lower[strings.ToLower(k)] = v
+	}
+	return lower
+}
+
+// sha256Hex is the hex checksum of data, as in models.ObjectOptions.
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func checkPartNumber(partNumber int) error {
+	if partNumber < 1 || partNumber > 10000 {
+		return fmt.Errorf("invalid part number %d", partNumber)
+	}
+	return nil
+}
+
+// checkCompleteParts checks the parts completing a multipart upload against those uploaded,
+// as S3 does for the storage backends that implement uploads on their own.
+func checkCompleteParts(parts []models.ObjectPart, uploaded map[int]models.ObjectPart) error {
+	if len(parts) == 0 {
+		return errors.Join(constants.ErrInvalidParts, errors.New("a multipart upload needs at least one part"))
+	}
+
+	for i, part := range parts {
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return errors.Join(constants.ErrInvalidParts, errors.New("parts must be in ascending order of number"))
+		}
+
+		up, ok := uploaded[part.PartNumber]
+		if !ok || up.ETag != strings.Trim(part.ETag, `"`) {
+			return errors.Join(constants.ErrInvalidParts, fmt.Errorf("part %d was not uploaded", part.PartNumber))
+		}
+		if i < len(parts)-1 && up.Size < constants.MinUploadPartSize {
+			return errors.Join(constants.ErrInvalidParts, fmt.Errorf("part %d has %d bytes, less than the minimum of %d", part.PartNumber, up.Size, constants.MinUploadPartSize))
+		}
+	}
+	return nil
+}
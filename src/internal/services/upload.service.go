@@ -0,0 +1,27 @@
+package services
+
+import (
+	"context"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+)
+
+// UploadService defines the interface for the uploads of users, whose files are uploaded
+// through ObjectService, in parts or directly to the storage.
+type UploadService interface {
+	// CreateUpload records an upload started in the storage.
+	CreateUpload(ctx context.Context, upload models.Upload) error
+
+	// GetUpload retrieves an upload of a user, constants.ErrNoRows if there is none.
+	GetUpload(ctx context.Context, userId uint32, uploadId string) (models.Upload, error)
+
+	// CompleteUpload records the size of an upload completed in the storage, constants.ErrNoRows
+	// if it was already completed.
+	CompleteUpload(ctx context.Context, uploadId string, size int64) error
+
+	// GetExpiredUploads retrieves up to limit uploads left incomplete past their expiration.
+	GetExpiredUploads(ctx context.Context, limit int) ([]models.Upload, error)
+
+	// DeleteUpload deletes the record of an upload, once aborted or its object deleted.
+	DeleteUpload(ctx context.Context, uploadId string) error
+}
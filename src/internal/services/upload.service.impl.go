@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/validators"
+)
+
+type UploadServicePgImpl struct {
+	db *sql.DB
+}
+
+func NewUploadServicePgImpl(db *sql.DB) UploadService {
+	return &UploadServicePgImpl{
+		db: db,
+	}
+}
+
+func (s *UploadServicePgImpl) CreateUpload(ctx context.Context, upload models.Upload) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO uploads (upload_id, user_id, organization_id, object_path, multipart_id, purpose, file_name, content_type, declared_size, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+		`,
+		upload.UploadId,
+		upload.UserId,
+		upload.OrganizationId,
+		upload.ObjectPath,
+		upload.MultipartId,
+		upload.Purpose,
+		upload.FileName,
+		upload.ContentType,
+		upload.DeclaredSize,
+		upload.ExpiresAt,
+	)
+	return errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+func (s *UploadServicePgImpl) GetUpload(ctx context.Context, userId uint32, uploadId string) (models.Upload, error) {
+	upload := models.Upload{}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			upload_id,
+			user_id,
+			organization_id,
+			object_path,
+			multipart_id,
+			purpose,
+			file_name,
+			content_type,
+			declared_size,
+			size,
+			created_at,
+			expires_at,
+			completed_at
+		FROM uploads
+		WHERE user_id = $1 AND upload_id = $2;
+		`,
+		userId,
+		uploadId,
+	).Scan(
+		&upload.UploadId,
+		&upload.UserId,
+		&upload.OrganizationId,
+		&upload.ObjectPath,
+		&upload.MultipartId,
+		&upload.Purpose,
+		&upload.FileName,
+		&upload.ContentType,
+		&upload.DeclaredSize,
+		&upload.Size,
+		&upload.CreatedAt,
+		&upload.ExpiresAt,
+		&upload.CompletedAt,
+	)
+	if err != nil {
+		return upload, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return upload, nil
+}
+
+func (s *UploadServicePgImpl) CompleteUpload(ctx context.Context, uploadId string, size int64) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE uploads
+		SET size = $2, completed_at = NOW()
+		WHERE upload_id = $1 AND completed_at IS NULL;
+		`,
+		uploadId,
+		size,
+	)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return errIfNoRowsAffected(res)
+}
+
+func (s *UploadServicePgImpl) GetExpiredUploads(ctx context.Context, limit int) ([]models.Upload, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			upload_id,
+			user_id,
+			organization_id,
+			object_path,
+			multipart_id,
+			purpose,
+			file_name,
+			content_type,
+			declared_size,
+			size,
+			created_at,
+			expires_at,
+			completed_at
+		FROM uploads
+		WHERE completed_at IS NULL AND expires_at < NOW()
+		ORDER BY expires_at
+		LIMIT $1;
+		`,
+		limit,
+	)
+	if err != nil {
+		return nil, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+	defer rows.Close()
+
+	uploads := []models.Upload{}
+	for rows.Next() {
+		upload := models.Upload{}
+		err := rows.Scan(
+			&upload.UploadId,
+			&upload.UserId,
+			&upload.OrganizationId,
+			&upload.ObjectPath,
+			&upload.MultipartId,
+			&upload.Purpose,
+			&upload.FileName,
+			&upload.ContentType,
+			&upload.DeclaredSize,
+			&upload.Size,
+			&upload.CreatedAt,
+			&upload.ExpiresAt,
+			&upload.CompletedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+
+	return uploads, rows.Err()
+}
+
+func (s *UploadServicePgImpl) DeleteUpload(ctx context.Context, uploadId string) error {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM uploads
+		WHERE upload_id = $1;
+		`,
+		uploadId,
+	)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	return errIfNoRowsAffected(res)
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/common"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/helpers"
+)
+
+func TestUploadServicePgImpl(t *testing.T) {
+	ctx := context.Background()
+
+	pgContainer, err := helpers.NewPostgresContainer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate pgContainer: %s", err)
+		}
+	})
+
+	db := pgContainer.DB
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO users (user_id, email, password_hash, first_name, last_name)
+		VALUES
+			(1, 'jane@email.com', 'hashtest', 'Jane', 'Doe'),
+			(2, 'john@email.com', 'hashtest', 'John', 'Doe');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewUploadServicePgImpl(db)
+
+	multipartId := "multipart-1"
+	declaredSize := int64(42)
+	for _, upload := range []models.Upload{
+		{
+			UploadId:    "resumable",
+			UserId:      1,
+			ObjectPath:  "private/uploads/1/resumable",
+			MultipartId: &multipartId,
+			Purpose:     models.FilePurpose,
+			FileName:    "video.mp4",
+			ContentType: "video/mp4",
+			ExpiresAt:   time.Now().Add(time.Hour),
+		},
+		{
+			UploadId:     "direct",
+			UserId:       1,
+			ObjectPath:   "private/uploads/1/direct",
+			Purpose:      models.DocumentPurpose,
+			FileName:     "receipt.pdf",
+			ContentType:  "application/pdf",
+			DeclaredSize: &declaredSize,
+			ExpiresAt:    time.Now().Add(-time.Minute),
+		},
+	} {
+		if err := s.CreateUpload(ctx, upload); err != nil {
+			t.Fatalf("UploadServicePgImpl.CreateUpload() error = %v", err)
+		}
+	}
+
+	upload, err := s.GetUpload(ctx, 1, "resumable")
+	if err != nil || upload.MultipartId == nil || *upload.MultipartId != multipartId || upload.CompletedAt != nil || upload.Size != nil {
+		t.Fatalf("UploadServicePgImpl.GetUpload() = %+v, %v", upload, err)
+	}
+
+	// users only get their own uploads
+	if _, err := s.GetUpload(ctx, 2, "resumable"); !errors.Is(err, constants.ErrNoRows) {
+		t.Errorf("getting the upload of another user should return ErrNoRows, got %v", err)
+	}
+
+	// only the upload past its expiration is expired, and not once completed
+	expired, err := s.GetExpiredUploads(ctx, 10)
+	if err != nil || len(expired) != 1 || expired[0].UploadId != "direct" || common.Deref(expired[0].DeclaredSize) != declaredSize {
+		t.Fatalf("UploadServicePgImpl.GetExpiredUploads() = %+v, %v", expired, err)
+	}
+
+	// concurrent completions only record the upload once
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.CompleteUpload(ctx, "resumable", 1024)
+		}()
+	}
+	wg.Wait()
+	completed := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			completed++
+		case !errors.Is(err, constants.ErrNoRows):
+			t.Errorf("UploadServicePgImpl.CompleteUpload() error = %v", err)
+		}
+	}
+	if completed != 1 {
+		t.Errorf("expected a single completion, got %d", completed)
+	}
+
+	upload, err = s.GetUpload(ctx, 1, "resumable")
+	if err != nil || upload.CompletedAt == nil || upload.Size == nil || *upload.Size != 1024 {
+		t.Errorf("expected the upload completed with its size, got %+v, %v", upload, err)
+	}
+
+	if err := s.CompleteUpload(ctx, "direct", 42); err != nil {
+		t.Fatal(err)
+	}
+	if expired, err := s.GetExpiredUploads(ctx, 10); err != nil || len(expired) != 0 {
+		t.Errorf("completed uploads should not expire, got %+v, %v", expired, err)
+	}
+
+	if err := s.DeleteUpload(ctx, "resumable"); err != nil {
+		t.Fatalf("UploadServicePgImpl.DeleteUpload() error = %v", err)
+	}
+	if _, err := s.GetUpload(ctx, 1, "resumable"); !errors.Is(err, constants.ErrNoRows) {
+		t.Errorf("a deleted upload should be gone, got %v", err)
+	}
+	if err := s.DeleteUpload(ctx, "resumable"); !errors.Is(err, constants.ErrNoRows) {
+		t.Errorf("deleting a deleted upload should return ErrNoRows, got %v", err)
+	}
+}
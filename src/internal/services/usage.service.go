@@ -0,0 +1,26 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+)
+
+// UsageService defines the interface for metering usage per organization.
+// Usage is recorded in memory and aggregated into hourly buckets by the daemons.
+type UsageService interface {
+	// Record adds quantity units of usage of the meter by the organization, without blocking.
+	Record(ctx context.Context, orgId string, meter models.Meter, quantity int64) error
+
+	// Flush adds the recorded usage to the hourly buckets. This method
+	// should be called periodically in a separate goroutine.
+	Flush() error
+
+	// SnapshotStorage records the current stored bytes of every organization
+	// in the hourly bucket, since storage is a gauge and not a counter.
+	SnapshotStorage() error
+
+	// GetUsage retrieves the hourly usage of an organization in [from, to).
+	GetUsage(ctx context.Context, orgId string, from time.Time, to time.Time) (models.UsageSummary, error)
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/validators"
+)
+
+// UsageServicePgAsyncImpl aggregates usage in memory into the hourly buckets, added to
+// the database by Flush. Recording never blocks on the database, and usage is billed, so
+// none is dropped: buckets that fail to flush are kept for the next one.
+type UsageServicePgAsyncImpl struct {
+	db      *sql.DB
+	mu      sync.Mutex
+	pending map[usageBucket]int64
+}
+
+func NewUsageServicePgAsyncImpl(db *sql.DB) UsageService {
+	return &UsageServicePgAsyncImpl{
+		db:      db,
+		pending: make(map[usageBucket]int64),
+	}
+}
+
+type usageBucket struct {
+	orgId       string
+	meter       models.Meter
+	periodStart time.Time
+}
+
+func (s *UsageServicePgAsyncImpl) Record(ctx context.Context, orgId string, meter models.Meter, quantity int64) error {
+	b := usageBucket{
+		orgId:       orgId,
+		meter:       meter,
+		periodStart: time.Now().Truncate(time.Hour),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[b] += quantity
+	return nil
+}
+
+func (s *UsageServicePgAsyncImpl) Flush() error {
+	s.mu.Lock()
+	buckets := s.pending
+	s.pending = make(map[usageBucket]int64)
+	s.mu.Unlock()
+
+	if len(buckets) == 0 {
+		return nil
+	}
+
+	err := s.addToBuckets(buckets)
+	if err != nil {
+		s.mu.Lock()
+		for b, quantity := range buckets {
+			s.pending[b] += quantity
+		}
+		s.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+func (s *UsageServicePgAsyncImpl) addToBuckets(buckets map[usageBucket]int64) error {
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// buckets already reported keep their reported_quantity, the rest is reported on its own
+	for b, quantity := range buckets {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO usage_records (organization_id, meter, period_start, quantity)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (organization_id, meter, period_start) DO UPDATE
+			SET quantity = usage_records.quantity + EXCLUDED.quantity;
+			`,
+			b.orgId,
+			b.meter,
+			b.periodStart,
+			quantity,
+		)
+		if err != nil {
+			return errors.Join(err, validators.FilterSqlPgError(err))
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *UsageServicePgAsyncImpl) SnapshotStorage() error {
+	_, err := s.db.Exec(`
+		INSERT INTO usage_records (organization_id, meter, period_start, quantity)
+		SELECT
+			organization_id,
+			$1,
+			DATE_TRUNC('hour', NOW()),
+			storage_bytes
+		FROM organizations
+		WHERE deleted_at IS NULL
+		ON CONFLICT (organization_id, meter, period_start) DO UPDATE
+		SET quantity = GREATEST(usage_records.quantity, EXCLUDED.quantity);
+		`,
+		models.StorageBytesMeter,
+	)
+	return errors.Join(err, validators.FilterSqlPgError(err))
+}
+
+func (s *UsageServicePgAsyncImpl) GetUsage(ctx context.Context, orgId string, from time.Time, to time.Time) (models.UsageSummary, error) {
+	summary := models.UsageSummary{
+		OrganizationId: orgId,
+		From:           from,
+		To:             to,
+		Totals:         make(map[models.Meter]int64),
+		Records:        []models.UsageRecord{},
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			organization_id,
+			meter,
+			period_start,
+			quantity,
+			reported_at
+		FROM usage_records
+		WHERE
+			organization_id = $1 AND
+			period_start >= $2 AND
+			period_start < $3
+		ORDER BY period_start, meter;
+		`,
+		orgId,
+		from,
+		to,
+	)
+	if err != nil {
+		return summary, errors.Join(err, validators.FilterSqlPgError(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := models.UsageRecord{}
+		err := rows.Scan(
+			&r.OrganizationId,
+			&r.Meter,
+			&r.PeriodStart,
+			&r.Quantity,
+			&r.ReportedAt,
+		)
+		if err != nil {
+			return summary, errors.Join(err, validators.FilterSqlPgError(err))
+		}
+
+		if r.Meter.IsGauge() {
+			summary.Totals[r.Meter] = max(summary.Totals[r.Meter], r.Quantity)
+		} else {
+			summary.Totals[r.Meter] += r.Quantity
+		}
+		summary.Records = append(summary.Records, r)
+	}
+
+	return summary, nil
+}
@@ -14,7 +14,7 @@ type UserService interface {
 	// CreateUser creates a new user.
 	CreateUser(ctx context.Context, user models.User) error
 
-	// CreateUnconfirmedUser creates a new unconfirmed user.
+	// CreateUnconfirmedUser creates a new unconfirmed user and enqueues its email confirmation.
 	CreateUnconfirmedUser(ctx context.Context, unconfirmedUser models.UnconfirmedUser) error
 
 	// ConfirmUser confirms a user using a one-time password (OTP).
@@ -32,7 +32,7 @@ type UserService interface {
 	// GetUserOrgs retrieves the organizations a user belongs to.
 	GetUserOrgs(ctx context.Context, userId uint32) ([]dto.OrganizationOutput, error)
 
-	// InitPasswordReset initializes a password reset for a user.
+	// InitPasswordReset initializes a password reset for a user and enqueues its password reset email.
 	InitPasswordReset(ctx context.Context, userId uint32, otp string) error
 
 	// GetPasswordReset retrieves a password reset request by its OTP.
@@ -8,18 +8,22 @@ import (
 
 	"github.com/LombardiDaniel/goliath/src/internal/dto"
 	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/templates"
+	"github.com/LombardiDaniel/goliath/src/pkg/common"
 	"github.com/LombardiDaniel/goliath/src/pkg/constants"
 	"github.com/LombardiDaniel/goliath/src/pkg/token"
 	"github.com/LombardiDaniel/goliath/src/pkg/validators"
 )
 
 type UserServicePgImpl struct {
-	db *sql.DB
+	db            *sql.DB
+	outboxService OutboxService
 }
 
-func NewUserServicePgImpl(db *sql.DB) UserService {
+func NewUserServicePgImpl(db *sql.DB, outboxService OutboxService) UserService {
 	return &UserServicePgImpl{
-		db: db,
+		db:            db,
+		outboxService: outboxService,
 	}
 }
 
@@ -66,15 +70,16 @@ func (s *UserServicePgImpl) CreateUnconfirmedUser(ctx context.Context, unconfirm
 	}
 
 	err = tx.QueryRowContext(ctx, `
-			INSERT INTO unconfirmed_users (email, otp, password_hash, first_name, last_name, date_of_birth)
-			VALUES ($1, $2, $3, $4, $5, $6)
+			INSERT INTO unconfirmed_users (email, otp, password_hash, first_name, last_name, date_of_birth, locale)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
 			ON CONFLICT (email) DO UPDATE
 			SET 
 				otp = EXCLUDED.otp,
 				password_hash = EXCLUDED.password_hash,
 				first_name = EXCLUDED.first_name,
 				last_name = EXCLUDED.last_name,
-				date_of_birth = EXCLUDED.date_of_birth;
+				date_of_birth = EXCLUDED.date_of_birth,
+				locale = EXCLUDED.locale;
 		`,
 		unconfirmedUser.Email,
 		unconfirmedUser.Otp,
@@ -82,12 +87,21 @@ func (s *UserServicePgImpl) CreateUnconfirmedUser(ctx context.Context, unconfirm
 		unconfirmedUser.FirstName,
 		unconfirmedUser.LastName,
 		unconfirmedUser.DateOfBirth,
+		unconfirmedUser.Locale,
 	).Err()
 
 	if err != nil {
 		return errors.Join(err, validators.FilterSqlPgError(err))
 	}
 
+	err = s.outboxService.Tx(tx).Send(ctx, templates.EmailConfirmation, unconfirmedUser.Email, common.Deref(unconfirmedUser.Locale), map[string]any{
+		"FirstName": unconfirmedUser.FirstName,
+		"Otp":       unconfirmedUser.Otp,
+	})
+	if err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
@@ -106,7 +120,8 @@ func (s *UserServicePgImpl) ConfirmUser(ctx context.Context, otp string) error {
 				password_hash,
 				first_name,
 				last_name,
-				date_of_birth
+				date_of_birth,
+				locale
 			FROM
 				unconfirmed_users
 			WHERE
@@ -118,20 +133,22 @@ func (s *UserServicePgImpl) ConfirmUser(ctx context.Context, otp string) error {
 		&unconfirmedUser.FirstName,
 		&unconfirmedUser.LastName,
 		&unconfirmedUser.DateOfBirth,
+		&unconfirmedUser.Locale,
 	)
 	if err != nil {
 		return errors.Join(err, validators.FilterSqlPgError(err))
 	}
 
 	_, err = tx.ExecContext(ctx, `
-			INSERT INTO users (email, password_hash, first_name, last_name, date_of_birth)
-			VALUES ($1, $2, $3, $4, $5);
+			INSERT INTO users (email, password_hash, first_name, last_name, date_of_birth, locale)
+			VALUES ($1, $2, $3, $4, $5, $6);
 		`,
 		unconfirmedUser.Email,
 		unconfirmedUser.PasswordHash,
 		unconfirmedUser.FirstName,
 		unconfirmedUser.LastName,
 		unconfirmedUser.DateOfBirth,
+		unconfirmedUser.Locale,
 	)
 	if err != nil {
 		return errors.Join(err, validators.FilterSqlPgError(err))
@@ -159,6 +176,7 @@ func (s *UserServicePgImpl) GetUser(ctx context.Context, email string) (models.U
 			last_name,
 			date_of_birth,
 			avatar_url,
+			locale,
 			created_at,
 			updated_at,
 			is_active
@@ -175,6 +193,7 @@ func (s *UserServicePgImpl) GetUser(ctx context.Context, email string) (models.U
 		&user.LastName,
 		&user.DateOfBirth,
 		&user.AvatarUrl,
+		&user.Locale,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&user.IsActive,
@@ -196,6 +215,7 @@ func (s *UserServicePgImpl) GetUserFromId(ctx context.Context, id uint32) (model
 			last_name,
 			date_of_birth,
 			avatar_url,
+			locale,
 			created_at,
 			updated_at,
 			is_active
@@ -212,6 +232,7 @@ func (s *UserServicePgImpl) GetUserFromId(ctx context.Context, id uint32) (model
 		&user.LastName,
 		&user.DateOfBirth,
 		&user.AvatarUrl,
+		&user.Locale,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&user.IsActive,
@@ -233,6 +254,7 @@ func (s *UserServicePgImpl) GetUsers(ctx context.Context) ([]models.User, error)
 			last_name,
 			date_of_birth,
 			avatar_url,
+			locale,
 			created_at,
 			updated_at,
 			is_active
@@ -257,6 +279,7 @@ func (s *UserServicePgImpl) GetUsers(ctx context.Context) ([]models.User, error)
 			&u.LastName,
 			&u.DateOfBirth,
 			&u.AvatarUrl,
+			&u.Locale,
 			&u.CreatedAt,
 			&u.UpdatedAt,
 			&u.IsActive,
@@ -304,18 +327,45 @@ func (s *UserServicePgImpl) GetUserOrgs(ctx context.Context, userId uint32) ([]d
 }
 
 func (s *UserServicePgImpl) InitPasswordReset(ctx context.Context, userId uint32, otp string) error {
-	query := `
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return errors.Join(err, constants.ErrDbTransactionCreate)
+	}
+	defer tx.Rollback()
+
+	var email, firstName, locale string
+	err = tx.QueryRowContext(ctx, `
+		SELECT email, first_name, COALESCE(locale, '')
+		FROM users
+		WHERE user_id = $1;
+		`,
+		userId,
+	).Scan(&email, &firstName, &locale)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
+
+	_, err = tx.ExecContext(ctx, `
 		INSERT INTO password_resets (user_id, otp, exp)
 		VALUES ($1, $2, $3);
-	`
-
-	_, err := s.db.ExecContext(ctx, query,
+		`,
 		userId,
 		otp,
 		time.Now().Add(24*time.Hour*time.Duration(constants.PasswordResetTimeoutDays)),
 	)
+	if err != nil {
+		return errors.Join(err, validators.FilterSqlPgError(err))
+	}
 
-	return errors.Join(err, validators.FilterSqlPgError(err))
+	err = s.outboxService.Tx(tx).Send(ctx, templates.PasswordReset, email, locale, map[string]any{
+		"FirstName": firstName,
+		"Otp":       otp,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (s *UserServicePgImpl) GetPasswordReset(ctx context.Context, otp string) (models.PasswordReset, error) {
@@ -373,12 +423,14 @@ func (s *UserServicePgImpl) EditUser(ctx context.Context, userId uint32, user dt
 			SET 
 				first_name = $1,
 				last_name = $2,
-				date_of_birth = $3
-			WHERE user_id = $4;
+				date_of_birth = $3,
+				locale = COALESCE($4, locale)
+			WHERE user_id = $5;
 		`,
 		user.FirstName,
 		user.LastName,
 		user.DateOfBirth,
+		user.Locale,
 		userId,
 	)
 	return errors.Join(err, validators.FilterSqlPgError(err))
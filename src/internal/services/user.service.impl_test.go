@@ -6,7 +6,9 @@ import (
 	"time"
 
 	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/internal/templates"
 	"github.com/LombardiDaniel/goliath/src/pkg/helpers"
+	"github.com/LombardiDaniel/goliath/src/pkg/it"
 )
 
 func TestUserServicePgImpl_CreateUser(t *testing.T) {
@@ -65,7 +67,8 @@ func TestUserServicePgImpl_CreateUser(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			s := &UserServicePgImpl{
-				db: pgContainer.DB,
+				db:            pgContainer.DB,
+				outboxService: NewOutboxServicePgImpl(pgContainer.DB, &EmailServiceMock{}, it.Must(templates.NewRegistry())),
 			}
 			if err := s.CreateUser(tt.args.ctx, tt.args.user); (err != nil) != tt.wantErr {
 				t.Errorf("UserServicePgImpl.CreateUser() error = %v, wantErr %v", err, tt.wantErr)
@@ -117,7 +120,8 @@ func TestUserServicePgImpl_CreateUnconfirmedUser(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			s := &UserServicePgImpl{
-				db: pgContainer.DB,
+				db:            pgContainer.DB,
+				outboxService: NewOutboxServicePgImpl(pgContainer.DB, &EmailServiceMock{}, it.Must(templates.NewRegistry())),
 			}
 			if err := s.CreateUnconfirmedUser(tt.args.ctx, tt.args.unconfirmedUser); (err != nil) != tt.wantErr {
 				t.Errorf("UserServicePgImpl.CreateUnconfirmedUser() error = %v, wantErr %v", err, tt.wantErr)
@@ -0,0 +1,43 @@
+package templates
+
+import "time"
+
+// Sample returns sample data for the email registered under name, with the keys it expects,
+// to preview it without going through the flow that sends it. Emails without sample data
+// get an empty map.
+func Sample(name string) map[string]any {
+	now := time.Now().UTC()
+
+	switch name {
+	case EmailConfirmation, PasswordReset:
+		return map[string]any{"FirstName": "Jane", "Otp": "sample-otp"}
+	case AccountCreated:
+		return map[string]any{"FirstName": "Jane"}
+	case OrganizationInvite:
+		return map[string]any{"FirstName": "Jane", "OrganizationName": "Acme", "Otp": "sample-otp"}
+	case PaymentAccepted, PaymentCanceled:
+		return map[string]any{"FirstName": "Jane", "PaymentId": "pay_sample", "Ammount": 4990, "Currency": "brl"}
+	case PaymentRefunded:
+		return map[string]any{"FirstName": "Jane", "PaymentId": "pay_sample", "Ammount": 4990, "RefundedAmmount": 1990, "Currency": "brl"}
+	case PaymentFailed:
+		return map[string]any{
+			"FirstName":        "Jane",
+			"OrganizationName": "Acme",
+			"AmmountDue":       4990,
+			"Currency":         "brl",
+			"NextAttemptAt":    now.AddDate(0, 0, 3),
+			"GraceEndsAt":      nil,
+		}
+	case SuspensionWarning:
+		return map[string]any{
+			"FirstName":        "Jane",
+			"OrganizationName": "Acme",
+			"AmmountDue":       4990,
+			"Currency":         "brl",
+			"GraceEndsAt":      now.AddDate(0, 0, 2),
+		}
+	case SubscriptionSuspended:
+		return map[string]any{"FirstName": "Jane", "OrganizationName": "Acme", "AmmountDue": 4990, "Currency": "brl"}
+	}
+	return map[string]any{}
+}
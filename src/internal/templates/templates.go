@@ -0,0 +1,204 @@
+// Package templates embeds the email templates of the app: the layouts and partials every
+// email is rendered within and one file per email at emails/, registered by its file name.
+// The text of the emails is translated by the catalogs at locales/emails/, the message
+// catalogs of the API are at locales/.
+package templates
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"maps"
+	"net/url"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/internal/models"
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/LombardiDaniel/goliath/src/pkg/i18n"
+	"github.com/LombardiDaniel/goliath/src/pkg/mail"
+)
+
+//go:embed layouts partials emails locales
+var FS embed.FS
+
+// Names of the emails at emails/.
+const (
+	EmailConfirmation     string = "email-confirmation"
+	AccountCreated        string = "account-created"
+	OrganizationInvite    string = "organization-invite"
+	PasswordReset         string = "password-reset"
+	PaymentAccepted       string = "payment-accepted"
+	PaymentRefunded       string = "payment-refunded"
+	PaymentCanceled       string = "payment-canceled"
+	PaymentFailed         string = "payment-failed"
+	SubscriptionSuspended string = "subscription-suspended"
+	SuspensionWarning     string = "suspension-warning"
+)
+
+// Keys are the keys the data of each email at emails/ must have, sending one without them fails.
+var Keys = map[string][]string{
+	EmailConfirmation:     {"FirstName", "Otp"},
+	AccountCreated:        {"FirstName"},
+	OrganizationInvite:    {"FirstName", "OrganizationName", "Otp"},
+	PasswordReset:         {"FirstName", "Otp"},
+	PaymentAccepted:       {"FirstName", "PaymentId", "Ammount", "Currency"},
+	PaymentRefunded:       {"FirstName", "PaymentId", "Ammount", "RefundedAmmount", "Currency"},
+	PaymentCanceled:       {"FirstName", "PaymentId"},
+	PaymentFailed:         {"FirstName", "OrganizationName", "AmmountDue", "Currency", "NextAttemptAt", "GraceEndsAt"},
+	SubscriptionSuspended: {"FirstName", "OrganizationName", "AmmountDue", "Currency"},
+	SuspensionWarning:     {"FirstName", "OrganizationName", "AmmountDue", "Currency", "GraceEndsAt"},
+}
+
+// EmailKind is the notification category of an email and whether it is transactional. Transactional
+// emails are sent regardless of the preferences of the recipient, they asked for them or their account
+// depends on them; the others respect opt-outs and carry an unsubscribe link.
+type EmailKind struct {
+	Category      models.NotificationCategory
+	Transactional bool
+}
+
+// Kinds are the kinds of the emails at emails/.
+var Kinds = map[string]EmailKind{
+	EmailConfirmation:     {models.SecurityNotifications, true},
+	AccountCreated:        {models.SecurityNotifications, true},
+	OrganizationInvite:    {models.OrgActivityNotifications, true},
+	PasswordReset:         {models.SecurityNotifications, true},
+	PaymentAccepted:       {models.BillingNotifications, false},
+	PaymentRefunded:       {models.BillingNotifications, false},
+	PaymentCanceled:       {models.BillingNotifications, false},
+	PaymentFailed:         {models.BillingNotifications, true},
+	SubscriptionSuspended: {models.BillingNotifications, true},
+	SuspensionWarning:     {models.BillingNotifications, true},
+}
+
+// Funcs are the functions available to the email templates.
+var Funcs = template.FuncMap{
+	"projectName": func() string { return constants.ProjectName },
+	"apiUrl":      func(path string, query ...string) (string, error) { return hostUrl(constants.ApiHostUrl, path, query) },
+	"appUrl":      func(path string, query ...string) (string, error) { return hostUrl(constants.AppHostUrl, path, query) },
+	"ammount":     formatAmmount,
+	"date":        formatDate,
+}
+
+// dateLayouts are how dates are written in each locale, the default locale is used for the others.
+var dateLayouts = map[string]string{
+	"pt-BR": "02/01/2006",
+	"en":    "Jan 2, 2006",
+}
+
+// NewRegistry creates the registry of the emails at emails/, more can be registered
+// on it from other file systems and they can use the layouts and partials of this one.
+// Along with Funcs, templates translate their text with t, as {{ t .Locale "Greeting" .FirstName }},
+// which formats the message of the key in the email catalogs with the args escaped.
+func NewRegistry() (*mail.Registry, error) {
+	catalog, err := i18n.NewCatalog(FS, "locales/emails", constants.Locales...)
+	if err != nil {
+		return nil, err
+	}
+
+	funcs := maps.Clone(Funcs)
+	funcs["t"] = translate(catalog)
+	registry, err := mail.NewRegistry(FS, constants.Locales, funcs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.RegisterDir(FS, "emails")
+	if err != nil {
+		return nil, err
+	}
+	for name, keys := range Keys {
+		registry.Require(name, keys...)
+	}
+	return registry, nil
+}
+
+// NewCatalog creates the catalog of the API messages in the supported locales.
+func NewCatalog() (*i18n.Catalog, error) {
+	return i18n.NewCatalog(FS, "locales", constants.Locales...)
+}
+
+// translate returns the t function of the email templates, messages of the catalog are trusted
+// HTML and their args are escaped, but the HTML returned by other calls to t.
+func translate(catalog *i18n.Catalog) func(locale string, key string, args ...any) (template.HTML, error) {
+	return func(locale string, key string, args ...any) (template.HTML, error) {
+		msg, ok := catalog.Message(locale, key)
+		if !ok {
+			return "", fmt.Errorf("email message '%s' is not in the catalog", key)
+		}
+		if len(args) == 0 {
+			return template.HTML(msg), nil
+		}
+
+		escaped := make([]any, len(args))
+		for i, arg := range args {
+			if h, ok := arg.(template.HTML); ok {
+				escaped[i] = h
+				continue
+			}
+			escaped[i] = template.HTMLEscapeString(fmt.Sprint(arg))
+		}
+		return template.HTML(fmt.Sprintf(msg, escaped...)), nil
+	}
+}
+
+// hostUrl joins the path to the host and adds the query, given as key and value pairs.
+func hostUrl(host string, path string, query []string) (string, error) {
+	if len(query)%2 != 0 {
+		return "", errors.New("url query expects key and value pairs")
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", err
+	}
+	u = u.JoinPath(path)
+
+	q := url.Values{}
+	for i := 0; i < len(query); i += 2 {
+		q.Add(query[i], query[i+1])
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// formatAmmount formats an ammount in the smallest currency unit in the locale, email data numbers are float64.
+func formatAmmount(ammount any, currency string, locale string) (string, error) {
+	switch v := ammount.(type) {
+	case float64:
+		return i18n.FormatAmmount(int64(v), currency, locale), nil
+	case json.Number:
+		n, err := v.Int64()
+		return i18n.FormatAmmount(n, currency, locale), err
+	case int64:
+		return i18n.FormatAmmount(v, currency, locale), nil
+	case int:
+		return i18n.FormatAmmount(int64(v), currency, locale), nil
+	}
+	return "", fmt.Errorf("invalid ammount %v", ammount)
+}
+
+// formatDate formats a date of the email data in the locale, times are RFC 3339 strings in the data.
+func formatDate(date any, locale string) (string, error) {
+	layout, ok := dateLayouts[locale]
+	if !ok {
+		layout = dateLayouts[constants.Locales[0]]
+	}
+
+	switch v := date.(type) {
+	case nil:
+		return "", nil
+	case time.Time:
+		return v.Format(layout), nil
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return "", err
+		}
+		return t.Format(layout), nil
+	}
+	return "", fmt.Errorf("invalid date %v", date)
+}
@@ -0,0 +1,152 @@
+package templates
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LombardiDaniel/goliath/src/pkg/mail"
+)
+
+func TestRegistry_RenderAll(t *testing.T) {
+	registry, err := NewRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := time.Date(2024, 5, 3, 12, 0, 0, 0, time.UTC)
+	data := map[string]any{
+		"FirstName":        "Jane",
+		"Otp":              "otp123",
+		"OrganizationName": "Acme & Co",
+		"PaymentId":        "pay_123",
+		"Ammount":          1990,
+		"RefundedAmmount":  990,
+		"AmmountDue":       4990,
+		"Currency":         "brl",
+		"NextAttemptAt":    &next,
+		"GraceEndsAt":      nil,
+	}
+
+	for _, name := range []string{
+		EmailConfirmation,
+		AccountCreated,
+		OrganizationInvite,
+		PasswordReset,
+		PaymentAccepted,
+		PaymentRefunded,
+		PaymentCanceled,
+		PaymentFailed,
+		SubscriptionSuspended,
+		SuspensionWarning,
+	} {
+		for locale, greeting := range map[string]string{"pt-BR": "Olá Jane", "en": "Hi Jane"} {
+			rendered, err := registry.Render(name, locale, data)
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			if rendered.Locale != locale {
+				t.Errorf("%s: rendered in %s instead of %s", name, rendered.Locale, locale)
+			}
+			if rendered.Subject == "" || !strings.Contains(rendered.Html, greeting) || !strings.Contains(rendered.Text, greeting) {
+				t.Errorf("%s: unexpected rendering %+v", name, rendered)
+			}
+			if strings.Contains(rendered.Text, "<") {
+				t.Errorf("%s: text has markup: %s", name, rendered.Text)
+			}
+		}
+	}
+
+	rendered, err := registry.Render(PaymentFailed, "", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rendered.Text, "R$ 49,90") || !strings.Contains(rendered.Text, "03/05/2024") || !strings.Contains(rendered.Html, "Acme &amp; Co") {
+		t.Errorf("unexpected payment failed email: %s", rendered.Text)
+	}
+
+	rendered, err = registry.Render(PaymentAccepted, "en-US", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rendered.Text, "R$ 19.90") || !strings.Contains(rendered.Html, `lang="en"`) {
+		t.Errorf("unexpected payment accepted email: %s", rendered.Text)
+	}
+
+	rendered, err = registry.Render(EmailConfirmation, "", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rendered.Text, "/v1/users/confirm?otp=otp123") {
+		t.Errorf("confirmation link missing: %s", rendered.Text)
+	}
+}
+
+func TestSample(t *testing.T) {
+	registry, err := NewRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range registry.Names() {
+		if len(Sample(name)) == 0 {
+			t.Errorf("%s: no sample data", name)
+		}
+		if _, ok := Kinds[name]; !ok {
+			t.Errorf("%s: no kind", name)
+		}
+		if _, ok := Keys[name]; !ok {
+			t.Errorf("%s: no keys", name)
+		}
+		if _, err := registry.Render(name, "", Sample(name)); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+
+	if err := registry.Validate(PaymentFailed, map[string]any{"FirstName": "Jane"}); !errors.Is(err, mail.ErrMissingData) {
+		t.Errorf("expected ErrMissingData for an email without its keys, got %v", err)
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	catalog, err := NewCatalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for header, locale := range map[string]string{
+		"":                   "pt-BR",
+		"en-US,en;q=0.9":     "en",
+		"pt;q=0.8, en;q=0.5": "pt-BR",
+		"fr-FR, de;q=0.9":    "pt-BR",
+		"fr-FR, en-GB;q=0.1": "en",
+	} {
+		if got := catalog.Match(header); got != locale {
+			t.Errorf("%q: matched %s instead of %s", header, got, locale)
+		}
+	}
+
+	// every locale translates all the messages of the default one, the API and the email ones
+	keys := func(dir string, locale string) []string {
+		b, err := FS.ReadFile(dir + "/" + locale + ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages := map[string]string{}
+		if err := json.Unmarshal(b, &messages); err != nil {
+			t.Fatal(err)
+		}
+		return slices.Sorted(maps.Keys(messages))
+	}
+	for _, dir := range []string{"locales", "locales/emails"} {
+		for _, locale := range catalog.Locales() {
+			if !slices.Equal(keys(dir, locale), keys(dir, catalog.Default())) {
+				t.Errorf("%s: %s messages differ from the default locale", locale, dir)
+			}
+		}
+	}
+}
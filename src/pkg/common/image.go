@@ -2,9 +2,16 @@ package common
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
 	"image"
-	_ "image/jpeg"
-	_ "image/png"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
 )
 
 type ImageFmt string
@@ -13,8 +20,14 @@ const (
 	JPEG ImageFmt = "jpeg"
 	PNG  ImageFmt = "png"
 	GIF  ImageFmt = "gif"
+	WEBP ImageFmt = "webp"
 )
 
+// ContentType is the media type of the format.
+func (f ImageFmt) ContentType() string {
+	return "image/" + string(f)
+}
+
 func ImageFormat(b []byte) (ImageFmt, error) {
 	_, format, err := image.Decode(bytes.NewReader(b))
 	if err != nil {
@@ -23,3 +36,195 @@ func ImageFormat(b []byte) (ImageFmt, error) {
 
 	return ImageFmt(format), nil
 }
+
+// DecodeImage decodes an image of up to maxPixels, the first frame of animated ones, upright
+// as its EXIF orientation says. Its metadata is left behind, so encoding it again strips it.
+func DecodeImage(b []byte, maxPixels int) (image.Image, ImageFmt, error) {
+	// the dimensions are checked first, a small file can decode to a huge image
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", err
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, "", fmt.Errorf("image of %dx%d exceeds %d pixels", cfg.Width, cfg.Height, maxPixels)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return orient(img, tiffOrientation(exif(b, ImageFmt(format)))), ImageFmt(format), nil
+}
+
+// exif finds the EXIF data (a TIFF header and its directories) of a JPEG, PNG or WebP, nil
+// if there is none. GIFs have none.
+func exif(b []byte, format ImageFmt) []byte {
+	switch format {
+	case JPEG:
+		return jpegExif(b)
+	case PNG:
+		return pngExif(b)
+	case WEBP:
+		return webpExif(b)
+	default:
+		return nil
+	}
+}
+
+// jpegExif reads the EXIF of the APP1 segment of a JPEG.
+func jpegExif(b []byte) []byte {
+	// segments are 0xFF, the marker and a big-endian length that includes itself, up to the
+	// start of scan, after which there is only image data
+	for i := 2; i+4 <= len(b) && b[i] == 0xFF; {
+		marker := b[i+1]
+		length := int(binary.BigEndian.Uint16(b[i+2:]))
+		if marker == 0xDA || length < 2 || i+2+length > len(b) {
+			break
+		}
+
+		segment := b[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		i += 2 + length
+	}
+	return nil
+}
+
+// pngExif reads the EXIF of the eXIf chunk of a PNG.
+func pngExif(b []byte) []byte {
+	// chunks are a big-endian length of their data, their type, the data and a crc, after
+	// the 8 bytes of the signature
+	for i := 8; i+8 <= len(b); {
+		length := int(binary.BigEndian.Uint32(b[i:]))
+		typ := string(b[i+4 : i+8])
+		if typ == "IEND" || length < 0 || i+12+length > len(b) {
+			break
+		}
+
+		if typ == "eXIf" {
+			return b[i+8 : i+8+length]
+		}
+		i += 12 + length
+	}
+	return nil
+}
+
+// webpExif reads the EXIF of the EXIF chunk of a WebP.
+func webpExif(b []byte) []byte {
+	// chunks are their fourcc, a little-endian length of their data and the data, padded
+	// to an even length, after the 12 bytes of the RIFF header
+	for i := 12; i+8 <= len(b); {
+		length := int(binary.LittleEndian.Uint32(b[i+4:]))
+		if length < 0 || i+8+length > len(b) {
+			break
+		}
+
+		if string(b[i:i+4]) == "EXIF" {
+			// some writers keep the prefix of the JPEG segment
+			return bytes.TrimPrefix(b[i+8:i+8+length], []byte("Exif\x00\x00"))
+		}
+		i += 8 + length + length%2
+	}
+	return nil
+}
+
+// tiffOrientation reads the orientation tag (0x0112) of the first directory of a TIFF header, 1
+// (upright) if there is none.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder = binary.BigEndian
+	if string(tiff[:2]) == "II" {
+		order = binary.LittleEndian
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := range entries {
+		entry := ifd + 2 + e*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			o := int(order.Uint16(tiff[entry+8:]))
+			if o < 1 || o > 8 {
+				return 1
+			}
+			return o
+		}
+	}
+	return 1
+}
+
+// orient turns an image of an EXIF orientation upright: 2 to 4 mirror or rotate it by 180
+// degrees, 5 to 8 also swap its width and height.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation == 1 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := range dh {
+		for x := range dw {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// Thumbnail crops the center square of an image and scales it to size by size.
+func Thumbnail(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	crop := image.Rect(0, 0, side, side).Add(b.Min).Add(image.Pt((b.Dx()-side)/2, (b.Dy()-side)/2))
+
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, crop, draw.Src, nil)
+	return dst
+}
+
+// EncodeImage encodes an image in the format. WebP is only decoded, there is no lossy encoder
+// of it in the standard library nor in golang.org/x/image.
+func EncodeImage(w io.Writer, img image.Image, format ImageFmt) error {
+	switch format {
+	case JPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	case PNG:
+		return png.Encode(w, img)
+	case GIF:
+		return gif.Encode(w, img, nil)
+	default:
+		return fmt.Errorf("unsupported image format '%s'", format)
+	}
+}
@@ -0,0 +1,146 @@
+package common
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// halves is a w by h image, red on its left half and blue on its right one.
+func halves(w int, h int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			c := color.NRGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.NRGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func isRed(c color.Color) bool {
+	r, _, b, _ := c.RGBA()
+	return r > 0xc000 && b < 0x4000
+}
+
+// halvesWebp is halves(8, 4) as a lossless WebP, there is no encoder of it to make one with.
+var halvesWebp, _ = base64.StdEncoding.DecodeString("UklGRlIAAABXRUJQVlA4TEYAAAAvB8AAAI1SRvQ/JAbItikAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAgA8OAAgAYP/nPwQQyUADAAAACnl/+gUA")
+
+// orientationTiff is EXIF data of only the orientation.
+func orientationTiff(orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00")
+	binary.BigEndian.PutUint16(tiff[18:], orientation)
+	return tiff
+}
+
+// withOrientation adds EXIF data of the orientation to a JPEG, PNG or WebP.
+func withOrientation(b []byte, format ImageFmt, orientation uint16) []byte {
+	tiff := orientationTiff(orientation)
+
+	switch format {
+	case JPEG: // an APP1 segment after the start of image
+		segment := append([]byte("Exif\x00\x00"), tiff...)
+		out := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+		out = binary.BigEndian.AppendUint16(out, uint16(len(segment)+2))
+		out = append(out, segment...)
+		return append(out, b[2:]...)
+	case PNG: // an eXIf chunk after the IHDR one
+		chunk := binary.BigEndian.AppendUint32(nil, uint32(len(tiff)))
+		chunk = append(chunk, "eXIf"...)
+		chunk = append(chunk, tiff...)
+		chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+		out := append([]byte{}, b[:33]...)
+		out = append(out, chunk...)
+		return append(out, b[33:]...)
+	case WEBP: // an EXIF chunk at the end, with the size of the RIFF updated
+		out := append([]byte{}, b...)
+		out = append(out, "EXIF"...)
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(tiff)))
+		out = append(out, tiff...)
+		binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+		return out
+	default:
+		return b
+	}
+}
+
+func TestDecodeImage(t *testing.T) {
+	for _, format := range []ImageFmt{JPEG, PNG, GIF, WEBP} {
+		var buf bytes.Buffer
+		if format == WEBP {
+			buf.Write(halvesWebp)
+		} else if err := EncodeImage(&buf, halves(8, 4), format); err != nil {
+			t.Fatal(err)
+		}
+
+		img, decoded, err := DecodeImage(buf.Bytes(), 32)
+		if err != nil || decoded != format {
+			t.Fatalf("%s: unexpected decode %s, %v", format, decoded, err)
+		}
+		if img.Bounds().Dx() != 8 || !isRed(img.At(1, 1)) || isRed(img.At(6, 1)) {
+			t.Errorf("%s: unexpected image %v", format, img.Bounds())
+		}
+
+		if _, _, err := DecodeImage(buf.Bytes(), 31); err == nil {
+			t.Errorf("%s: expected an error decoding an image of too many pixels", format)
+		}
+	}
+
+	if err := EncodeImage(&bytes.Buffer{}, halves(8, 4), WEBP); err == nil {
+		t.Error("expected an error encoding a WebP")
+	}
+}
+
+func TestDecodeImageOrientation(t *testing.T) {
+	for _, format := range []ImageFmt{JPEG, PNG, WEBP} {
+		var buf bytes.Buffer
+		if format == WEBP {
+			buf.Write(halvesWebp)
+		} else if err := EncodeImage(&buf, halves(8, 4), format); err != nil {
+			t.Fatal(err)
+		}
+
+		// 6 is rotated 90 degrees clockwise to be upright, the left half ends on top
+		img, decoded, err := DecodeImage(withOrientation(buf.Bytes(), format, 6), 1024)
+		if err != nil || decoded != format {
+			t.Fatalf("%s: unexpected decode %s, %v", format, decoded, err)
+		}
+		if img.Bounds().Dx() != 4 || img.Bounds().Dy() != 8 {
+			t.Fatalf("%s: not rotated: %v", format, img.Bounds())
+		}
+		if !isRed(img.At(2, 1)) || isRed(img.At(2, 6)) {
+			t.Errorf("%s: rotated the wrong way", format)
+		}
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	// the red columns on the sides are cropped out
+	img := image.NewNRGBA(image.Rect(0, 0, 300, 200))
+	for y := range 200 {
+		for x := range 300 {
+			c := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+			if x < 50 || x >= 250 {
+				c = color.NRGBA{R: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	thumb := Thumbnail(img, 64)
+	if thumb.Bounds() != image.Rect(0, 0, 64, 64) {
+		t.Fatalf("unexpected bounds %v", thumb.Bounds())
+	}
+	for _, p := range []image.Point{{0, 0}, {63, 0}, {0, 63}, {63, 63}, {32, 32}} {
+		if _, g, _, _ := thumb.At(p.X, p.Y).RGBA(); g < 0xf000 {
+			t.Errorf("pixel %v is not from the center", p)
+		}
+	}
+}
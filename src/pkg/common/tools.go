@@ -2,6 +2,7 @@ package common
 
 import (
 	"crypto/rand"
+	"fmt"
 	"math/big"
 	"net/url"
 	"os"
@@ -25,6 +26,25 @@ func SplitName(fullName string) (string, string) {
 	return firstName, names[1]
 }
 
+// FormatAmmount formats an ammount in the smallest currency unit, assuming two decimal places.
+func FormatAmmount(ammount int64, currency string) string {
+	sign := ""
+	if ammount < 0 {
+		sign = "-"
+		ammount = -ammount
+	}
+	return fmt.Sprintf("%s%d.%02d %s", sign, ammount/100, ammount%100, strings.ToUpper(currency))
+}
+
+// Deref returns the value p points to, or the zero value of T if p is nil.
+func Deref[T any](p *T) T {
+	if p == nil {
+		var zero T
+		return zero
+	}
+	return *p
+}
+
 // GetEnvVarDefault retrieves the value of the specified environment variable.
 // Returns a default value if the variable is not set.
 func GetEnvVarDefault(name string, def string) string {
@@ -7,16 +7,52 @@ import (
 )
 
 const (
-	TimestampStrFormat       string = time.RFC3339 // "yyyy-mm-ddThh:mm:ssZhh:mm" and "2006-01-02T15:04:05-07:00"
-	DefaultTimzone           string = "GMT-3"
-	GinCtxJwtClaimKeyName    string = "jwtClaims"
-	JwtTimeoutSecs           int    = 30 * 60
-	OptLen                   int    = 128
-	OrgInviteTimeoutDays     int    = 15
-	PasswordResetTimeoutDays int    = 1
-	MaxRequestSize           int64  = 5 * 1024 * 1024 // 5MB default
+	TimestampStrFormat          string        = time.RFC3339 // "yyyy-mm-ddThh:mm:ssZhh:mm" and "2006-01-02T15:04:05-07:00"
+	DefaultTimzone              string        = "GMT-3"
+	GinCtxJwtClaimKeyName       string        = "jwtClaims"
+	GinCtxLocaleKeyName         string        = "locale"
+	JwtTimeoutSecs              int           = 30 * 60
+	OptLen                      int           = 128
+	OrgInviteTimeoutDays        int           = 15
+	PasswordResetTimeoutDays    int           = 1
+	MaxRequestSize              int64         = 5 * 1024 * 1024 // 5MB default
+	MinUploadPartSize           int64         = 5 * 1024 * 1024 // of all parts of a multipart upload but the last, as in S3
+	MaxUploadPartSize           int64         = 64 * 1024 * 1024
+	MaxAvatarSize               int64         = 3 * 1024 * 1024 // fits MaxRequestSize in base64
+	MaxAvatarPixels             int           = 4096 * 4096     // of the decoded picture
+	DefaultAvatarSize           int           = 256
+	ReceiptUrlTimeout           time.Duration = 15 * time.Minute
+	UploadUrlTimeout            time.Duration = 15 * time.Minute
+	ResumableUploadExpiry       time.Duration = 7 * 24 * time.Hour // before uploads in parts left incomplete are deleted
+	DirectUploadExpiry          time.Duration = time.Hour          // before direct uploads left unconfirmed are deleted
+	PaymentReconcileGrace       time.Duration = 15 * time.Minute   // time webhooks have to settle a payment
+	PaymentReconcileMaxAttempts int64         = 8                  // failed checks against the gateway before a pending payment is left for a person to look at
+	WebhookClaimTimeout         time.Duration = 5 * time.Minute    // before a claimed webhook event left unprocessed can be claimed again
+	CheckoutSessionTimeout      time.Duration = 24 * time.Hour
+	DunningGracePeriod          time.Duration = 7 * 24 * time.Hour // after the last retry of a failed charge, before suspension
+	DunningSuspensionWarning    time.Duration = 2 * 24 * time.Hour // before the end of grace, when the owner is warned
+	DunningProcessBackoff       time.Duration = 15 * time.Minute   // doubled after each failed step of a case
+	DunningProcessAlertFailures int64         = 5                  // failed steps in a row before a case is alerted on
+	EmailOutboxMaxAttempts      int64         = 10
+	EmailOutboxBackoff          time.Duration = 30 * time.Second // doubled after each failed attempt
+	EmailOutboxLease            time.Duration = 5 * time.Minute  // a claimed email is left to its delivery, before it can be claimed again
+	EmailSoftBounceLimit        int64         = 3                // transient bounces in a row before the address is suppressed
+	NotificationsPgChannel      string        = "notifications"
+	NotificationStreamBuffer    int           = 16               // notifications a slow stream may lag behind before it misses some
+	NotificationStreamPing      time.Duration = 30 * time.Second // keeps proxies from closing idle streams
+	UnsubscribeUrlExpiry        time.Duration = 60 * 24 * time.Hour
+	EntitlementCacheTtl         time.Duration = 30 * time.Second // before plan changes apply to the api calls limit
 )
 
+// AvatarSizes are the sides of the squares avatars are scaled to, DefaultAvatarSize among them.
+var AvatarSizes = []int{64, 128, 256, 512}
+
+// Locales are the locales emails and API messages are available in, the first is the default.
+var Locales = []string{"pt-BR", "en"}
+
+// DunningRetrySchedule is how long to wait before each retry of a failed subscription charge.
+var DunningRetrySchedule = []time.Duration{3 * 24 * time.Hour, 5 * 24 * time.Hour, 7 * 24 * time.Hour}
+
 var (
 	ProjectName                       string = common.GetEnvVarDefault("PROJECT_NAME", "goliath")
 	NoreplyEmail                      string = common.GetEnvVarDefault("NO_REPLY_EMAIL", "no-reply@example.com")
@@ -27,4 +63,5 @@ var (
 	S3Endpoint                        string = common.GetEnvVarDefault("S3_ENDPOINT", "https://br-se1.magaluobjects.com")
 	S3Region                          string = common.GetEnvVarDefault("S3_REGION", "br-se1")
 	S3Bucket                          string = common.GetEnvVarDefault("S3_BUCKET", ProjectName+"-goliath")
+	ObjectsPublicUrl                  string = common.GetEnvVarDefault("OBJECTS_PUBLIC_URL", S3Endpoint) // where public objects are served from, <url>/<bucket>/<path>
 )
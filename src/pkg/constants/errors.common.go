@@ -7,4 +7,12 @@ var (
 	ErrAuth                = errors.New("auth error")
 	ErrDbConflict          = errors.New("db conflict error")
 	ErrDbTransactionCreate = errors.New("could not create DB transaction")
+	ErrLimitExceeded       = errors.New("plan limit exceeded")
+	ErrCouponNotApplicable = errors.New("coupon not applicable")
+	ErrEmailSuppressed     = errors.New("email address is suppressed")
+	ErrEmailOptedOut       = errors.New("recipient opted out of the email category")
+	ErrObjectNotFound      = errors.New("object not found")
+	ErrChecksumMismatch    = errors.New("content does not match its checksum")
+	ErrUploadNotFound      = errors.New("multipart upload not found")
+	ErrInvalidParts        = errors.New("invalid parts to complete the multipart upload")
 )
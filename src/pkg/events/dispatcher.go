@@ -0,0 +1,51 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Handler handles a single event of type T.
+type Handler[T any] func(ctx context.Context, event T) error
+
+// Dispatcher routes events to the Handlers registered for their type, use
+// Dispatcher.Register() to subscribe to an event type.
+type Dispatcher[T any] struct {
+	handlers map[string][]Handler[T]
+}
+
+// NewDispatcher creates an empty Dispatcher.
+func NewDispatcher[T any]() *Dispatcher[T] {
+	return &Dispatcher[T]{
+		handlers: make(map[string][]Handler[T]),
+	}
+}
+
+// Register subscribes a handler to an event type, handlers are called in
+// registration order.
+func (d *Dispatcher[T]) Register(eventType string, handler Handler[T]) {
+	d.handlers[eventType] = append(d.handlers[eventType], handler)
+}
+
+// Handles reports whether at least one handler is registered for the event type.
+func (d *Dispatcher[T]) Handles(eventType string) bool {
+	return len(d.handlers[eventType]) > 0
+}
+
+// Dispatch calls every handler registered for the event type, stopping at the
+// first error. Returns false if no handler is registered for the event type.
+func (d *Dispatcher[T]) Dispatch(ctx context.Context, eventType string, event T) (bool, error) {
+	handlers, ok := d.handlers[eventType]
+	if !ok {
+		return false, nil
+	}
+
+	for _, h := range handlers {
+		if err := h(ctx, event); err != nil {
+			return true, errors.Join(err, fmt.Errorf("could not handle event '%s'", eventType))
+		}
+	}
+
+	return true, nil
+}
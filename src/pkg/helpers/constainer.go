@@ -6,6 +6,8 @@ import (
 	"path/filepath"
 
 	_ "github.com/lib/pq"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/postgres"
 	"github.com/testcontainers/testcontainers-go/wait"
@@ -50,3 +52,40 @@ func NewPostgresContainer(ctx context.Context) (*PostgresContainer, error) {
 
 	return &PostgresContainer{DB: db, Container: pgContainer, ConnString: connStr}, nil
 }
+
+type MinioContainer struct {
+	Client    *minio.Client
+	Container testcontainers.Container
+}
+
+func NewMinioContainer(ctx context.Context) (*MinioContainer, error) {
+	minioContainer, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "minio/minio:RELEASE.2024-11-07T00-52-20Z",
+			Cmd:          []string{"server", "/data"},
+			ExposedPorts: []string{"9000/tcp"},
+			Env: map[string]string{
+				"MINIO_ROOT_USER":     "user",
+				"MINIO_ROOT_PASSWORD": "password",
+			},
+			WaitingFor: wait.ForHTTP("/minio/health/live").WithPort("9000/tcp"),
+		},
+		Started: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, err := minioContainer.PortEndpoint(ctx, "9000/tcp", "")
+	if err != nil {
+		return nil, err
+	}
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds: credentials.NewStaticV4("user", "password", ""),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MinioContainer{Client: client, Container: minioContainer}, nil
+}
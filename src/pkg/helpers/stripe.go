@@ -0,0 +1,91 @@
+package helpers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+
+	"github.com/stripe/stripe-go/v81"
+)
+
+// StripeRequest is a request received by the FakeStripeServer.
+type StripeRequest struct {
+	Method         string
+	Path           string
+	Form           url.Values
+	IdempotencyKey string
+}
+
+// FakeStripeServer is a local stand-in for the Stripe API, it records every
+// request and answers with the JSON registered for its path.
+type FakeStripeServer struct {
+	Server *httptest.Server
+
+	mu        sync.Mutex
+	requests  []StripeRequest
+	responses map[string]string
+}
+
+// NewFakeStripeServer starts the server, use Backends to point a stripe client to it.
+func NewFakeStripeServer() *FakeStripeServer {
+	f := &FakeStripeServer{
+		responses: make(map[string]string),
+	}
+
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		form, _ := url.ParseQuery(string(body))
+
+		f.mu.Lock()
+		f.requests = append(f.requests, StripeRequest{
+			Method:         r.Method,
+			Path:           r.URL.Path,
+			Form:           form,
+			IdempotencyKey: r.Header.Get("Idempotency-Key"),
+		})
+		res, ok := f.responses[r.Method+" "+r.URL.Path]
+		f.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if !ok {
+			res = `{"object": "unknown"}`
+		}
+		w.Write([]byte(res))
+	}))
+
+	return f
+}
+
+// Backends returns the stripe-go backends that send requests to the server.
+func (f *FakeStripeServer) Backends() *stripe.Backends {
+	backend := stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+		URL:               stripe.String(f.Server.URL),
+		MaxNetworkRetries: stripe.Int64(0),
+	})
+	return &stripe.Backends{
+		API:     backend,
+		Connect: backend,
+		Uploads: backend,
+	}
+}
+
+// Respond registers the JSON answered to requests on method and path.
+func (f *FakeStripeServer) Respond(method string, path string, json string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses[method+" "+path] = json
+}
+
+// Requests returns the requests received so far.
+func (f *FakeStripeServer) Requests() []StripeRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]StripeRequest{}, f.requests...)
+}
+
+// Close stops the server.
+func (f *FakeStripeServer) Close() {
+	f.Server.Close()
+}
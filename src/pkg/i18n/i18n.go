@@ -0,0 +1,112 @@
+package i18n
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"path"
+	"strings"
+
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// Catalog holds the messages of the supported locales, keyed by message key. Messages
+// missing in a locale fall back to the default one.
+type Catalog struct {
+	defaultLocale string
+	locales       []string
+	messages      map[string]map[string]string
+	matcher       language.Matcher
+}
+
+// NewCatalog loads the catalog of each locale from <dir>/<locale>.json of fsys, a flat JSON
+// object of message keys to messages. The default locale is the first one.
+func NewCatalog(fsys fs.FS, dir string, locales ...string) (*Catalog, error) {
+	if len(locales) == 0 {
+		return nil, errors.New("catalog needs at least one locale")
+	}
+
+	c := &Catalog{
+		defaultLocale: locales[0],
+		locales:       locales,
+		messages:      map[string]map[string]string{},
+	}
+
+	tags := []language.Tag{}
+	for _, locale := range locales {
+		tag, err := language.Parse(locale)
+		if err != nil {
+			return nil, errors.Join(err, fmt.Errorf("invalid locale '%s'", locale))
+		}
+		tags = append(tags, tag)
+
+		b, err := fs.ReadFile(fsys, path.Join(dir, locale+".json"))
+		if err != nil {
+			return nil, errors.Join(err, fmt.Errorf("could not read catalog of locale '%s'", locale))
+		}
+
+		messages := map[string]string{}
+		err = json.Unmarshal(b, &messages)
+		if err != nil {
+			return nil, errors.Join(err, fmt.Errorf("could not parse catalog of locale '%s'", locale))
+		}
+		c.messages[locale] = messages
+	}
+	c.matcher = language.NewMatcher(tags)
+
+	return c, nil
+}
+
+// Default returns the default locale.
+func (c *Catalog) Default() string {
+	return c.defaultLocale
+}
+
+// Locales returns the supported locales, the default first.
+func (c *Catalog) Locales() []string {
+	return c.locales
+}
+
+// Match returns the supported locale that best matches an Accept-Language header,
+// the default one if none does.
+func (c *Catalog) Match(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return c.defaultLocale
+	}
+
+	_, i, confidence := c.matcher.Match(tags...)
+	if confidence == language.No {
+		return c.defaultLocale
+	}
+	return c.locales[i]
+}
+
+// Message returns the message of key in the locale, or in the default locale if the
+// locale is not supported or lacks it.
+func (c *Catalog) Message(locale string, key string) (string, bool) {
+	if msg, ok := c.messages[locale][key]; ok {
+		return msg, true
+	}
+	msg, ok := c.messages[c.defaultLocale][key]
+	return msg, ok
+}
+
+// FormatAmmount formats an ammount in the smallest currency unit the way the locale writes
+// money, e.g. "R$ 1.234,50" in pt-BR and "R$ 1,234.50" in en.
+func FormatAmmount(ammount int64, currencyCode string, locale string) string {
+	unit, err := currency.ParseISO(currencyCode)
+	if err != nil {
+		return fmt.Sprintf("%.2f %s", float64(ammount)/100, strings.ToUpper(currencyCode))
+	}
+
+	scale, _ := currency.Standard.Rounding(unit)
+	value := float64(ammount) / math.Pow10(scale)
+
+	p := message.NewPrinter(language.Make(locale))
+	return p.Sprint(currency.Symbol(unit.Amount(value)))
+}
@@ -0,0 +1,396 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+type smtpRecord struct {
+	auth string
+	from string
+	rcpt []string
+	data string
+}
+
+// startSmtpStandIn serves a single SMTP session on localhost, speaking just enough of the
+// protocol for a plaintext delivery with PLAIN auth.
+func startSmtpStandIn(t *testing.T) (int, <-chan smtpRecord) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	records := make(chan smtpRecord, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		rec := smtpRecord{}
+		r := bufio.NewReader(conn)
+		reply := func(s string) { io.WriteString(conn, s+"\r\n") }
+
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+			switch cmd {
+			case "EHLO":
+				reply("250-localhost")
+				reply("250 AUTH PLAIN")
+			case "AUTH":
+				cred, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "AUTH PLAIN "))
+				rec.auth = string(cred)
+				reply("235 ok")
+			case "MAIL":
+				rec.from = line
+				reply("250 ok")
+			case "RCPT":
+				rec.rcpt = append(rec.rcpt, line)
+				reply("250 ok")
+			case "DATA":
+				reply("354 go ahead")
+				data := new(strings.Builder)
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				rec.data = data.String()
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				records <- rec
+				return
+			default:
+				reply("502 unknown")
+			}
+		}
+	}()
+
+	return l.Addr().(*net.TCPAddr).Port, records
+}
+
+func testMessage() Message {
+	return Message{
+		From:    "Goliath <no-reply@example.com>",
+		To:      []string{"jane@example.com"},
+		Subject: "Confirmação de conta",
+		Html:    "<p>Olá Jane, sua conta foi criada.</p>\n",
+	}
+}
+
+func TestSmtpSender_Send(t *testing.T) {
+	port, records := startSmtpStandIn(t)
+
+	sender, err := NewSmtpSender(SmtpConfig{
+		Host:     "localhost",
+		Port:     port,
+		Username: "user",
+		Password: "secret",
+		Security: SmtpNone,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := testMessage()
+	msg.Headers = map[string]string{"list-unsubscribe": "<https://api.example.com/unsubscribe>"}
+	err = sender.Send(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := <-records
+	if rec.auth != "\x00user\x00secret" {
+		t.Errorf("unexpected credentials %q", rec.auth)
+	}
+	if rec.from != "MAIL FROM:<no-reply@example.com> BODY=8BITMIME" && rec.from != "MAIL FROM:<no-reply@example.com>" {
+		t.Errorf("unexpected envelope sender %q", rec.from)
+	}
+	if len(rec.rcpt) != 1 || rec.rcpt[0] != "RCPT TO:<jane@example.com>" {
+		t.Errorf("unexpected envelope recipients %v", rec.rcpt)
+	}
+
+	header, body, ok := strings.Cut(rec.data, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("message without body: %q", rec.data)
+	}
+	if !strings.Contains(header, "Subject: =?utf-8?q?Confirma=C3=A7=C3=A3o_de_conta?=") {
+		t.Errorf("subject is not encoded: %q", header)
+	}
+	if !strings.Contains(header, "List-Unsubscribe: <https://api.example.com/unsubscribe>") {
+		t.Errorf("extra header is missing: %q", header)
+	}
+	html, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(html), "Olá Jane") {
+		t.Errorf("unexpected body %q", html)
+	}
+}
+
+func TestSmtpSender_InvalidMessage(t *testing.T) {
+	sender, err := NewSmtpSender(SmtpConfig{Host: "localhost", Port: 1, Security: SmtpNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := testMessage()
+	msg.To = nil
+	if err := sender.Send(context.Background(), msg); err == nil {
+		t.Error("expected an error for a message without recipients")
+	}
+}
+
+func TestMailbox(t *testing.T) {
+	box := NewMailbox(2)
+	srv := httptest.NewServer(box)
+	t.Cleanup(srv.Close)
+
+	for _, to := range []string{"a@example.com", "b@example.com", "a@example.com"} {
+		msg := testMessage()
+		msg.To = []string{to}
+		if err := box.Send(context.Background(), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := http.Get(srv.URL + "/?to=a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []MailboxMessage
+	err = json.NewDecoder(res.Body).Decode(&messages)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first message was dropped by the capacity
+	if len(messages) != 1 || messages[0].Id != 3 {
+		t.Fatalf("unexpected messages %+v", messages)
+	}
+
+	res, err = http.Get(srv.URL + "/" + strconv.FormatInt(messages[0].Id, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	html, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(html) != messages[0].Html {
+		t.Errorf("unexpected html %d %q", res.StatusCode, html)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/", nil)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if len(box.Messages("")) != 0 {
+		t.Error("mailbox was not cleared")
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	fsys := fstest.MapFS{
+		"layouts/base.html":       {Data: []byte(`<html><body>{{ template "content" . }}{{ template "sign" }}</body></html>`)},
+		"partials/sign.html":      {Data: []byte(`{{ define "sign" }}<p>{{ team }}</p>{{ end }}`)},
+		"emails/welcome.html":     {Data: []byte(`{{ define "subject" }}Hi {{ .Name }} & co{{ end }}{{ define "content" }}<p>Hello <b>{{ .Name }}</b></p>{{ end }}{{ template "base.html" . }}`)},
+		"emails/plain.html":       {Data: []byte(`{{ define "subject" }}Plain{{ end }}{{ define "text" }}Just {{ .Name }}{{ end }}<p>Hello</p>`)},
+		"emails/no-subject.html":  {Data: []byte(`<p>Hello</p>`)},
+		"emails/plain.pt-BR.html": {Data: []byte(`{{ define "subject" }}Simples{{ end }}{{ define "text" }}Só {{ .Name }} em {{ .Locale }}{{ end }}<p>Olá</p>`)},
+	}
+
+	funcs := map[string]any{"team": func() string { return "The team" }}
+	registry, err := NewRegistry(fsys, []string{"en"}, funcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Register("no-subject", "", fsys, "emails/no-subject.html"); err == nil {
+		t.Fatal("expected an error for an email without subject")
+	}
+	for _, file := range []string{"welcome.html", "plain.html", "plain.pt-BR.html"} {
+		name, locale, _ := strings.Cut(strings.TrimSuffix(file, ".html"), ".")
+		if err := registry.Register(name, locale, fsys, "emails/"+file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rendered, err := registry.Render("welcome", "pt-BR", map[string]string{"Name": "<Jane>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rendered.Subject != "Hi <Jane> & co" {
+		t.Errorf("unexpected subject %q", rendered.Subject)
+	}
+	if rendered.Html != "<html><body><p>Hello <b>&lt;Jane&gt;</b></p><p>The team</p></body></html>" {
+		t.Errorf("unexpected html %q", rendered.Html)
+	}
+	if rendered.Text != "Hello <Jane>\n\nThe team" {
+		t.Errorf("unexpected text %q", rendered.Text)
+	}
+
+	rendered, err = registry.Render("plain", "en-US", map[string]string{"Name": "Jane"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rendered.Text != "Just Jane" {
+		t.Errorf("unexpected text %q", rendered.Text)
+	}
+
+	// the variant of the same language is picked over the default one
+	rendered, err = registry.Render("plain", "pt", map[string]string{"Name": "Jane"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rendered.Locale != "pt-BR" || rendered.Text != "Só Jane em pt-BR" {
+		t.Errorf("unexpected variant %q: %q", rendered.Locale, rendered.Text)
+	}
+
+	if _, err := registry.Render("missing", "", nil); !errors.Is(err, ErrUnknownTemplate) {
+		t.Errorf("expected ErrUnknownTemplate, got %v", err)
+	}
+
+	// emails with one variant are rendered in every locale of the registry
+	localized, err := NewRegistry(fsys, []string{"en", "pt-BR"}, funcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := localized.Register("plain", "", fsys, "emails/plain.html"); err != nil {
+		t.Fatal(err)
+	}
+	for locale, want := range map[string]string{"pt": "pt-BR", "pt-BR": "pt-BR", "en-GB": "en", "fr": "en"} {
+		rendered, err = localized.Render("plain", locale, nil)
+		if err != nil || rendered.Locale != want {
+			t.Errorf("%s: rendered in %q instead of %q, %v", locale, rendered.Locale, want, err)
+		}
+	}
+	if locales := localized.Locales("plain"); !slices.Equal(locales, []string{"en", "pt-BR"}) {
+		t.Errorf("unexpected locales %v", locales)
+	}
+
+	// required keys may be null, but not left out
+	registry.Require("welcome", "Name")
+	if _, err := registry.Render("welcome", "", map[string]string{"Nome": "Jane"}); !errors.Is(err, ErrMissingData) {
+		t.Errorf("expected ErrMissingData rendering without a required key, got %v", err)
+	}
+	if err := registry.Validate("welcome", nil); !errors.Is(err, ErrMissingData) {
+		t.Errorf("expected ErrMissingData validating without data, got %v", err)
+	}
+	if err := registry.Validate("welcome", map[string]any{"Name": nil}); err != nil {
+		t.Errorf("expected a null required key to validate, got %v", err)
+	}
+	if err := registry.Validate("missing", nil); !errors.Is(err, ErrUnknownTemplate) {
+		t.Errorf("expected ErrUnknownTemplate, got %v", err)
+	}
+}
+
+func TestSignedWebhook(t *testing.T) {
+	webhook := NewSignedWebhook("relay-secret")
+	payload := []byte(`{"id":"evt_1","type":"bounced","recipients":["gone@example.com"],"permanent":true,"occurredAt":"2024-11-22T23:41:12Z"}`)
+
+	signed := func(unix int64, sig string) http.Header {
+		header := http.Header{}
+		header.Set("Mail-Signature", "t="+strconv.FormatInt(unix, 10)+",v1="+sig)
+		return header
+	}
+	now := time.Now().Unix()
+
+	event, err := webhook.ParseDeliveryEvent(payload, signed(now, webhook.Sign(now, payload)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Id != "evt_1" || event.Type != BouncedEvent || !event.Permanent || len(event.Recipients) != 1 || event.OccurredAt.IsZero() {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	stale := time.Now().Add(-time.Hour).Unix()
+	for name, header := range map[string]http.Header{
+		"unsigned":         {},
+		"tampered payload": signed(now, webhook.Sign(now, append([]byte(" "), payload...))),
+		"other secret":     signed(now, NewSignedWebhook("other-secret").Sign(now, payload)),
+		"stale timestamp":  signed(stale, webhook.Sign(stale, payload)),
+		"bad timestamp":    {"Mail-Signature": {"t=now,v1=" + webhook.Sign(now, payload)}},
+	} {
+		if _, err := webhook.ParseDeliveryEvent(payload, header); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: expected ErrInvalidSignature, got %v", name, err)
+		}
+	}
+
+	// without a secret nothing verifies, not even payloads signed with the empty key
+	unset := NewSignedWebhook("")
+	if _, err := unset.ParseDeliveryEvent(payload, signed(now, unset.Sign(now, payload))); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature without a secret, got %v", err)
+	}
+
+	noId := []byte(`{"type":"delivered","recipients":["jane@example.com"]}`)
+	if _, err := webhook.ParseDeliveryEvent(noId, signed(now, webhook.Sign(now, noId))); err == nil {
+		t.Errorf("expected an error for an event without id")
+	}
+}
+
+func TestResendWebhook(t *testing.T) {
+	secret := []byte("resend-webhook-secret")
+	webhook, err := NewResendWebhook("whsec_" + base64.StdEncoding.EncodeToString(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte(`{"type":"email.bounced","created_at":"2024-11-22T23:41:12.126Z","data":{"to":["gone@example.com"],"bounce":{"type":"Permanent","message":"mailbox does not exist"}}}`)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("msg_1." + ts + "."))
+	mac.Write(payload)
+
+	header := http.Header{}
+	header.Set("svix-id", "msg_1")
+	header.Set("svix-timestamp", ts)
+	header.Set("svix-signature", "v1,c3RhbGU= v1,"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+
+	event, err := webhook.ParseDeliveryEvent(payload, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Id != "msg_1" || event.Type != BouncedEvent || !event.Permanent || len(event.Recipients) != 1 || event.Recipients[0] != "gone@example.com" {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	if _, err := webhook.ParseDeliveryEvent(append(payload, ' '), header); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature for a tampered payload, got %v", err)
+	}
+	header.Set("svix-timestamp", strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+	if _, err := webhook.ParseDeliveryEvent(payload, header); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature for a stale timestamp, got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"net/textproto"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	RESEND_PROVIDER  string = "resend"
+	SMTP_PROVIDER    string = "smtp"
+	FILE_PROVIDER    string = "file"
+	MAILBOX_PROVIDER string = "mailbox"
+	MOCK_PROVIDER    string = "mock"
+)
+
+var ErrInvalidMessage = errors.New("invalid email message")
+
+// Message is a rendered email, ready to be handed to a Sender.
+type Message struct {
+	From    string   `json:"from"`
+	To      []string `json:"to"`
+	Subject string   `json:"subject"`
+	Html    string   `json:"html"`
+	// Text is the plaintext alternative of the HTML, if any.
+	Text string `json:"text,omitempty"`
+	// Headers are added to the standard ones, e.g. List-Unsubscribe.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Sender is an email transport, it delivers rendered messages.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// validate checks the message has a sender and recipients with valid addresses.
+func (m Message) validate() error {
+	if _, err := netmail.ParseAddress(m.From); err != nil {
+		return errors.Join(ErrInvalidMessage, fmt.Errorf("invalid from address '%s'", m.From))
+	}
+	if len(m.To) == 0 {
+		return errors.Join(ErrInvalidMessage, errors.New("message without recipients"))
+	}
+	for _, to := range m.To {
+		if _, err := netmail.ParseAddress(to); err != nil {
+			return errors.Join(ErrInvalidMessage, fmt.Errorf("invalid recipient address '%s'", to))
+		}
+	}
+	return nil
+}
+
+// Bytes encodes the message as a RFC 5322 email, its quoted-printable HTML body is
+// sent as a multipart/alternative along the plaintext one when there is one.
+func (m Message) Bytes(date time.Time) []byte {
+	domain := "localhost"
+	if addr, err := netmail.ParseAddress(m.From); err == nil {
+		if i := strings.LastIndex(addr.Address, "@"); i >= 0 {
+			domain = addr.Address[i+1:]
+		}
+	}
+
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+
+	buf := new(bytes.Buffer)
+	headers := [][2]string{
+		{"From", m.From},
+		{"To", strings.Join(m.To, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", m.Subject)},
+		{"Date", date.Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain)},
+		{"MIME-Version", "1.0"},
+	}
+	for _, key := range slices.Sorted(maps.Keys(m.Headers)) {
+		headers = append(headers, [2]string{textproto.CanonicalMIMEHeaderKey(key), m.Headers[key]})
+	}
+	for _, h := range headers {
+		fmt.Fprintf(buf, "%s: %s\r\n", h[0], h[1])
+	}
+
+	if m.Text == "" {
+		writePart(buf, "text/html", m.Html)
+		return buf.Bytes()
+	}
+
+	mw := multipart.NewWriter(buf)
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+
+	// the last part is the preferred one
+	for _, part := range [][2]string{{"text/plain", m.Text}, {"text/html", m.Html}} {
+		w, _ := mw.CreatePart(textproto.MIMEHeader{})
+		writePart(w, part[0], part[1])
+	}
+	_ = mw.Close()
+
+	return buf.Bytes()
+}
+
+// writePart writes the headers and quoted-printable body of a text part.
+func writePart(w io.Writer, contentType string, body string) {
+	fmt.Fprintf(w, "Content-Type: %s; charset=UTF-8\r\n", contentType)
+	fmt.Fprintf(w, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	// in text mode the writer also turns the line breaks of the body into CRLF
+	qp := quotedprintable.NewWriter(w)
+	_, _ = qp.Write([]byte(body))
+	_ = qp.Close()
+}
+
+// envelopeAddress is the bare address of an address that may have a display name.
+func envelopeAddress(address string) (string, error) {
+	addr, err := netmail.ParseAddress(address)
+	if err != nil {
+		return "", errors.Join(err, ErrInvalidMessage)
+	}
+	return addr.Address, nil
+}
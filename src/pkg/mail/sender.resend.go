@@ -0,0 +1,40 @@
+package mail
+
+import (
+	"context"
+	"errors"
+
+	"github.com/resendlabs/resend-go"
+)
+
+var errResend = errors.New("could not send email via resend")
+
+// ResendSender sends emails through the Resend API.
+type ResendSender struct {
+	client *resend.Client
+}
+
+func NewResendSender(apiKey string) *ResendSender {
+	return &ResendSender{
+		client: resend.NewClient(apiKey),
+	}
+}
+
+func (s *ResendSender) Send(ctx context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+
+	_, err := s.client.Emails.Send(&resend.SendEmailRequest{
+		From:    msg.From,
+		To:      msg.To,
+		Subject: msg.Subject,
+		Html:    msg.Html,
+		Text:    msg.Text,
+		Headers: msg.Headers,
+	})
+	if err != nil {
+		return errors.Join(err, errResend)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SmtpSecurity is how the connection to the SMTP server is encrypted.
+type SmtpSecurity string
+
+const (
+	// SmtpNone sends in plaintext, only meant for local SMTP stand-ins.
+	SmtpNone SmtpSecurity = "none"
+	// SmtpStartTls upgrades a plaintext connection with STARTTLS, usually on port 587.
+	SmtpStartTls SmtpSecurity = "starttls"
+	// SmtpTls connects over TLS from the start (implicit TLS), usually on port 465.
+	SmtpTls SmtpSecurity = "tls"
+)
+
+var errSmtp = errors.New("could not send email via smtp")
+
+type SmtpConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Security SmtpSecurity
+	// Timeout bounds the whole exchange with the server, defaults to 30s.
+	Timeout time.Duration
+	// TlsConfig overrides the TLS configuration, the server name defaults to Host.
+	TlsConfig *tls.Config
+}
+
+// SmtpSender sends emails through an SMTP server, authenticating with PLAIN
+// when a username is set.
+type SmtpSender struct {
+	cfg SmtpConfig
+}
+
+func NewSmtpSender(cfg SmtpConfig) (*SmtpSender, error) {
+	if cfg.Host == "" || cfg.Port <= 0 {
+		return nil, errors.New("smtp host and port are required")
+	}
+	switch cfg.Security {
+	case SmtpNone, SmtpStartTls, SmtpTls:
+	case "":
+		cfg.Security = SmtpStartTls
+	default:
+		return nil, fmt.Errorf("smtp security must be one of: none, starttls, tls, got '%s'", cfg.Security)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.TlsConfig == nil {
+		cfg.TlsConfig = &tls.Config{ServerName: cfg.Host}
+	}
+
+	return &SmtpSender{cfg: cfg}, nil
+}
+
+func (s *SmtpSender) Send(ctx context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+
+	err := s.send(ctx, msg)
+	if err != nil {
+		return errors.Join(err, errSmtp)
+	}
+	return nil
+}
+
+func (s *SmtpSender) send(ctx context.Context, msg Message) error {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	dialer := &net.Dialer{}
+
+	var conn net.Conn
+	var err error
+	if s.cfg.Security == SmtpTls {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: s.cfg.TlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	deadline, _ := ctx.Deadline()
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if s.cfg.Security == SmtpStartTls {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server does not support STARTTLS")
+		}
+		err = c.StartTLS(s.cfg.TlsConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.cfg.Username != "" {
+		// PlainAuth refuses to send the credentials unencrypted, unless to localhost
+		err = c.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	from, err := envelopeAddress(msg.From)
+	if err != nil {
+		return err
+	}
+	err = c.Mail(from)
+	if err != nil {
+		return err
+	}
+
+	for _, to := range msg.To {
+		rcpt, err := envelopeAddress(to)
+		if err != nil {
+			return err
+		}
+		err = c.Rcpt(rcpt)
+		if err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg.Bytes(time.Now()))
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
@@ -0,0 +1,48 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileSink is a development Sender that writes each message as an .eml file
+// to a directory instead of delivering it, they open in any email client.
+type FileSink struct {
+	dir string
+
+	mu  sync.Mutex
+	seq int64
+}
+
+func NewFileSink(dir string) (*FileSink, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, errors.Join(err, fmt.Errorf("could not create email sink directory '%s'", dir))
+	}
+
+	return &FileSink{dir: dir}, nil
+}
+
+func (s *FileSink) Send(ctx context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	s.mu.Lock()
+	s.seq++
+	name := fmt.Sprintf("%s-%04d.eml", now.UTC().Format("20060102T150405.000000000"), s.seq%10000)
+	s.mu.Unlock()
+
+	err := os.WriteFile(filepath.Join(s.dir, name), msg.Bytes(now), 0o644)
+	if err != nil {
+		return errors.Join(err, errors.New("could not write email to sink"))
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package mail
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MailboxMessage is a message received by the Mailbox.
+type MailboxMessage struct {
+	Id         int64     `json:"id"`
+	ReceivedAt time.Time `json:"receivedAt"`
+	Message
+}
+
+// Mailbox is a development Sender that keeps the last messages in memory instead of
+// delivering them. It is an http.Handler exposing them, meant for local runs and
+// integration tests only, as it shows one-time passwords to anyone who can reach it.
+type Mailbox struct {
+	mu       sync.Mutex
+	seq      int64
+	capacity int
+	messages []MailboxMessage
+}
+
+// NewMailbox creates a Mailbox that keeps up to capacity messages, dropping the oldest.
+func NewMailbox(capacity int) *Mailbox {
+	return &Mailbox{
+		capacity: max(capacity, 1),
+		messages: []MailboxMessage{},
+	}
+}
+
+func (b *Mailbox) Send(ctx context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	msg.To = slices.Clone(msg.To)
+	b.messages = append(b.messages, MailboxMessage{Id: b.seq, ReceivedAt: time.Now(), Message: msg})
+	if len(b.messages) > b.capacity {
+		b.messages = slices.Clone(b.messages[len(b.messages)-b.capacity:])
+	}
+	return nil
+}
+
+// Messages returns the messages sent to the recipient (any if empty), newest first.
+func (b *Mailbox) Messages(to string) []MailboxMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	messages := []MailboxMessage{}
+	for i := len(b.messages) - 1; i >= 0; i-- {
+		m := b.messages[i]
+		if to == "" || slices.ContainsFunc(m.To, func(addr string) bool { return strings.EqualFold(addr, to) }) {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
+
+// Message returns the message with the ID.
+func (b *Mailbox) Message(id int64) (MailboxMessage, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, m := range b.messages {
+		if m.Id == id {
+			return m, true
+		}
+	}
+	return MailboxMessage{}, false
+}
+
+// Clear drops every message.
+func (b *Mailbox) Clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.messages = []MailboxMessage{}
+}
+
+// ServeHTTP lists the messages as JSON, newest first and optionally filtered by the
+// "to" query, serves the HTML of a message at /{id} and its .eml at /{id}/raw and
+// clears the mailbox on DELETE.
+func (b *Mailbox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+
+	if path == "" {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			err := json.NewEncoder(w).Encode(b.Messages(r.URL.Query().Get("to")))
+			if err != nil {
+				slog.Error(err.Error())
+			}
+		case http.MethodDelete:
+			b.Clear()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || len(parts) > 2 || (len(parts) == 2 && parts[1] != "raw") || r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+
+	m, ok := b.Message(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if len(parts) == 2 {
+		w.Header().Set("Content-Type", "message/rfc822")
+		_, err = w.Write(m.Bytes(m.ReceivedAt))
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, err = w.Write([]byte(m.Html))
+	}
+	if err != nil {
+		slog.Error(err.Error())
+	}
+}
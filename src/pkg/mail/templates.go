@@ -0,0 +1,367 @@
+package mail
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownTemplate = errors.New("unknown email template")
+	ErrMissingData     = errors.New("email data is missing required keys")
+)
+
+// Rendered is an email rendered from its template.
+type Rendered struct {
+	Locale  string
+	Subject string
+	Html    string
+	Text    string
+}
+
+// Registry holds the email templates by name, each in one or more locales. Every email is
+// parsed along the layouts and partials of the registry, in its own namespace, and must define
+// a "subject" template. Its file body is the HTML of the email, usually just a call to a layout
+// that renders the "content" it defines. A "text" template replaces the plaintext generated
+// from the HTML. The keys the data of an email must have can be declared with Require.
+type Registry struct {
+	mu            sync.RWMutex
+	base          *template.Template
+	defaultLocale string
+	locales       []string
+	emails        map[string]map[string]*template.Template
+	required      map[string][]string
+}
+
+// NewRegistry creates a registry with the layouts at layouts/*.html and the partials at
+// partials/*.html of fsys, both are optional. The funcs are available to every template,
+// along with dict, which builds a map from key and value pairs to pass to partials. Every email
+// can be rendered in the locales, the first is the default one, and those it has variants in.
+// Emails without a variant in the locale they are rendered in use the one of the default locale,
+// templates that translate their text themselves, by the "Locale" key, need only one variant.
+func NewRegistry(fsys fs.FS, locales []string, funcs template.FuncMap) (*Registry, error) {
+	if len(locales) == 0 {
+		return nil, errors.New("email registry needs at least one locale")
+	}
+
+	base := template.New("").Funcs(template.FuncMap{"dict": dict}).Funcs(funcs)
+
+	for _, pattern := range []string{"layouts/*.html", "partials/*.html"} {
+		files, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			continue
+		}
+
+		base, err = base.ParseFS(fsys, files...)
+		if err != nil {
+			return nil, errors.Join(err, fmt.Errorf("could not parse email templates at '%s'", pattern))
+		}
+	}
+
+	return &Registry{
+		base:          base,
+		defaultLocale: locales[0],
+		locales:       locales,
+		emails:        map[string]map[string]*template.Template{},
+		required:      map[string][]string{},
+	}, nil
+}
+
+// Register parses the email at file of fsys and adds it to the registry under name, as its
+// variant in the locale, the default one if empty.
+func (r *Registry) Register(name string, locale string, fsys fs.FS, file string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if locale == "" {
+		locale = r.defaultLocale
+	}
+	if _, ok := r.emails[name][locale]; ok {
+		return fmt.Errorf("email template '%s' is already registered in '%s'", name, locale)
+	}
+	if r.base.Lookup(path.Base(file)) != nil {
+		return fmt.Errorf("email template file '%s' has the name of a layout or partial", file)
+	}
+
+	t, err := r.base.Clone()
+	if err != nil {
+		return err
+	}
+	t, err = t.ParseFS(fsys, file)
+	if err != nil {
+		return errors.Join(err, fmt.Errorf("could not parse email template '%s'", name))
+	}
+	if t.Lookup("subject") == nil {
+		return fmt.Errorf("email template '%s' does not define a subject", name)
+	}
+
+	if r.emails[name] == nil {
+		r.emails[name] = map[string]*template.Template{}
+	}
+	r.emails[name][locale] = t.Lookup(path.Base(file))
+	return nil
+}
+
+// RegisterDir registers every .html file of dir in fsys, named after the file without its
+// extension. Files named <name>.<locale>.html are the variants of the email in other locales.
+func (r *Registry) RegisterDir(fsys fs.FS, dir string) error {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.html"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		name, locale, _ := strings.Cut(strings.TrimSuffix(path.Base(file), ".html"), ".")
+		err := r.Register(name, locale, fsys, file)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Require declares the keys the data of the email registered under name must have, rendering
+// it without any of them fails with ErrMissingData. The keys may hold null, as optional dates do.
+func (r *Registry) Require(name string, keys ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.required[name] = append(r.required[name], keys...)
+}
+
+// Validate checks that an email is registered under name and that data has the keys it requires,
+// so sends that would fail to render can fail before the email is stored.
+func (r *Registry) Validate(name string, data any) error {
+	if !r.Has(name) {
+		return errors.Join(ErrUnknownTemplate, fmt.Errorf("email template '%s' is not registered", name))
+	}
+
+	normalized, err := jsonData(data)
+	if err != nil {
+		return err
+	}
+	return r.checkRequired(name, normalized)
+}
+
+// checkRequired checks that data, in its JSON form, has the keys the email registered under name requires.
+func (r *Registry) checkRequired(name string, data any) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.required[name]) == 0 {
+		return nil
+	}
+
+	m, _ := data.(map[string]any)
+	missing := []string{}
+	for _, key := range r.required[name] {
+		if _, ok := m[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return errors.Join(ErrMissingData, fmt.Errorf("email '%s' is missing %s", name, strings.Join(missing, ", ")))
+	}
+	return nil
+}
+
+// Has reports whether an email is registered under name.
+func (r *Registry) Has(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.emails[name]
+	return ok
+}
+
+// Names returns the names of the registered emails, sorted.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.emails))
+	for name := range r.emails {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Locales returns the locales the email registered under name can be rendered in, sorted.
+func (r *Registry) Locales(name string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.emailLocales(name)
+}
+
+// emailLocales returns the locales of the registry and those the email registered under name has
+// variants in, sorted, the caller must hold the lock.
+func (r *Registry) emailLocales(name string) []string {
+	locales := slices.Collect(maps.Keys(r.emails[name]))
+	for _, locale := range r.locales {
+		if !slices.Contains(locales, locale) {
+			locales = append(locales, locale)
+		}
+	}
+	slices.Sort(locales)
+	return locales
+}
+
+// variant resolves the locale to the one of the email it matches, or the one of the same language,
+// or the default locale, and returns it along with the variant of the email rendered in it.
+func (r *Registry) variant(name string, locale string) (*template.Template, string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	variants, ok := r.emails[name]
+	if !ok {
+		return nil, "", false
+	}
+
+	resolved := r.defaultLocale
+	// the same language, as en for en-US, is the next best thing
+resolve:
+	for _, exact := range []bool{true, false} {
+		for _, l := range r.emailLocales(name) {
+			if strings.EqualFold(l, locale) || (!exact && strings.EqualFold(language(l), language(locale))) {
+				resolved = l
+				break resolve
+			}
+		}
+	}
+
+	if t, ok := variants[resolved]; ok {
+		return t, resolved, true
+	}
+	if t, ok := variants[r.defaultLocale]; ok {
+		return t, resolved, true
+	}
+	// emails registered only in other locales are rendered in the first of them
+	l := slices.Sorted(maps.Keys(variants))[0]
+	return variants[l], l, true
+}
+
+// Render renders the email registered under name in the locale. The templates see data in its
+// JSON form, so an email renders the same whether it is sent right away or after being stored,
+// and objects get the locale it is rendered in as their "Locale" key.
+func (r *Registry) Render(name string, locale string, data any) (Rendered, error) {
+	t, locale, ok := r.variant(name, locale)
+	if !ok {
+		return Rendered{}, errors.Join(ErrUnknownTemplate, fmt.Errorf("email template '%s' is not registered", name))
+	}
+
+	data, err := jsonData(data)
+	if err != nil {
+		return Rendered{}, err
+	}
+	err = r.checkRequired(name, data)
+	if err != nil {
+		return Rendered{}, err
+	}
+	switch d := data.(type) {
+	case map[string]any:
+		d["Locale"] = locale
+	case nil:
+		data = map[string]any{"Locale": locale}
+	}
+
+	subject := new(bytes.Buffer)
+	err = t.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return Rendered{}, errors.Join(err, fmt.Errorf("could not render the subject of email '%s'", name))
+	}
+
+	body := new(bytes.Buffer)
+	err = t.Execute(body, data)
+	if err != nil {
+		return Rendered{}, errors.Join(err, fmt.Errorf("could not render email '%s'", name))
+	}
+
+	rendered := Rendered{
+		Locale: locale,
+		// the subject is escaped as HTML text, but it is sent as a header
+		Subject: html.UnescapeString(strings.Join(strings.Fields(subject.String()), " ")),
+		Html:    body.String(),
+	}
+
+	if t.Lookup("text") != nil {
+		text := new(bytes.Buffer)
+		err = t.ExecuteTemplate(text, "text", data)
+		if err != nil {
+			return Rendered{}, errors.Join(err, fmt.Errorf("could not render the text of email '%s'", name))
+		}
+		rendered.Text = html.UnescapeString(strings.TrimSpace(text.String()))
+	} else {
+		rendered.Text = HtmlToText(rendered.Html)
+	}
+
+	return rendered, nil
+}
+
+// language is the language subtag of a locale, as pt for pt-BR.
+func language(locale string) string {
+	lang, _, _ := strings.Cut(locale, "-")
+	return lang
+}
+
+// WithValues returns data in its JSON form with the values added, data must be a JSON object or nil.
+func WithValues(data any, values map[string]any) (map[string]any, error) {
+	normalized, err := jsonData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[string]any{}
+	if normalized != nil {
+		var ok bool
+		if m, ok = normalized.(map[string]any); !ok {
+			return nil, errors.New("email data is not an object")
+		}
+	}
+
+	maps.Copy(m, values)
+	return m, nil
+}
+
+func jsonData(data any) (any, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("could not marshal email data"))
+	}
+
+	var normalized any
+	err = json.Unmarshal(b, &normalized)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("could not unmarshal email data"))
+	}
+	return normalized, nil
+}
+
+func dict(pairs ...any) (map[string]any, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("dict expects key and value pairs")
+	}
+
+	m := make(map[string]any, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict key %v is not a string", pairs[i])
+		}
+		m[key] = pairs[i+1]
+	}
+	return m, nil
+}
@@ -0,0 +1,100 @@
+package mail
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// HtmlToText generates the plaintext alternative of an HTML email: the text of its body
+// with a line per block element and links followed by their address.
+func HtmlToText(src string) string {
+	z := html.NewTokenizer(strings.NewReader(src))
+
+	out := new(strings.Builder)
+	line := new(strings.Builder)
+	blank := true // whether the last line written was blank
+	skip := 0     // depth within elements whose text is not shown
+	hrefs := []string{}
+	linkText := []string{}
+
+	flush := func(breaks int) {
+		text := strings.Join(strings.Fields(line.String()), " ")
+		line.Reset()
+		if text != "" {
+			out.WriteString(text)
+			out.WriteString("\n")
+			blank = false
+		}
+		if breaks > 1 && !blank {
+			out.WriteString("\n")
+			blank = true
+		}
+	}
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			flush(1)
+			return strings.TrimSpace(out.String())
+
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			text := string(z.Text())
+			line.WriteString(text)
+			if len(hrefs) > 0 {
+				linkText[len(linkText)-1] += text
+			}
+
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			a := atom.Lookup(name)
+			start := tt != html.EndTagToken
+
+			switch a {
+			case atom.Head, atom.Style, atom.Script, atom.Title:
+				if tt == html.StartTagToken {
+					skip++
+				} else if tt == html.EndTagToken && skip > 0 {
+					skip--
+				}
+			case atom.Br:
+				flush(1)
+			case atom.P, atom.Div, atom.Table, atom.Ul, atom.Ol, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+				flush(2)
+			case atom.Li:
+				flush(1)
+				if start {
+					line.WriteString("- ")
+				}
+			case atom.Tr:
+				flush(1)
+			case atom.Td, atom.Th:
+				line.WriteString(" ")
+			case atom.A:
+				if tt == html.StartTagToken {
+					href := ""
+					for hasAttr {
+						var key, val []byte
+						key, val, hasAttr = z.TagAttr()
+						if string(key) == "href" {
+							href = string(val)
+						}
+					}
+					hrefs = append(hrefs, href)
+					linkText = append(linkText, "")
+				} else if tt == html.EndTagToken && len(hrefs) > 0 {
+					href, text := hrefs[len(hrefs)-1], strings.TrimSpace(linkText[len(linkText)-1])
+					hrefs, linkText = hrefs[:len(hrefs)-1], linkText[:len(linkText)-1]
+					if href != "" && href != text && !strings.HasPrefix(href, "#") {
+						line.WriteString(" (" + href + ")")
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package mail
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type DeliveryEventType string
+
+const (
+	DeliveredEvent  DeliveryEventType = "delivered"
+	BouncedEvent    DeliveryEventType = "bounced"
+	ComplainedEvent DeliveryEventType = "complained"
+)
+
+const (
+	signedWebhookHeader    string        = "Mail-Signature"
+	webhookSignatureMaxAge time.Duration = 5 * time.Minute
+)
+
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// DeliveryEvent is what a provider reports about an email it sent. Events of other types than
+// the ones above, as opens or clicks, keep the type the provider gave them.
+type DeliveryEvent struct {
+	Id         string            `json:"id"`
+	Type       DeliveryEventType `json:"type"`
+	Recipients []string          `json:"recipients"`
+	Permanent  bool              `json:"permanent"` // of bounces, transient ones may succeed on a later send
+	Reason     string            `json:"reason,omitempty"`
+	OccurredAt time.Time         `json:"occurredAt"`
+}
+
+// WebhookParser verifies and parses the delivery events a provider posts to the webhook.
+type WebhookParser interface {
+	ParseDeliveryEvent(payload []byte, header http.Header) (DeliveryEvent, error)
+}
+
+// SignedWebhook parses DeliveryEvent JSON signed with a shared secret, for the providers without
+// webhooks of their own, e.g. a relay in front of an SMTP server. The signature is at the
+// Mail-Signature header as "t=<unix time>,v1=<hex HMAC-SHA256 of '<unix time>.<payload>'>".
+type SignedWebhook struct {
+	secret []byte
+}
+
+func NewSignedWebhook(secret string) *SignedWebhook {
+	return &SignedWebhook{
+		secret: []byte(secret),
+	}
+}
+
+func (w *SignedWebhook) ParseDeliveryEvent(payload []byte, header http.Header) (DeliveryEvent, error) {
+	event := DeliveryEvent{}
+
+	ts, sig, ok := strings.Cut(header.Get(signedWebhookHeader), ",")
+	if !ok || len(w.secret) == 0 {
+		return event, ErrInvalidSignature
+	}
+
+	unix, err := strconv.ParseInt(strings.TrimPrefix(ts, "t="), 10, 64)
+	if err != nil {
+		return event, ErrInvalidSignature
+	}
+	if time.Since(time.Unix(unix, 0)).Abs() > webhookSignatureMaxAge {
+		return event, ErrInvalidSignature
+	}
+
+	if !hmac.Equal([]byte(strings.TrimPrefix(sig, "v1=")), []byte(w.Sign(unix, payload))) {
+		return event, ErrInvalidSignature
+	}
+
+	err = json.Unmarshal(payload, &event)
+	if err != nil {
+		return event, errors.Join(err, errors.New("could not unmarshal delivery event"))
+	}
+	if event.Id == "" {
+		return event, errors.New("delivery event without id")
+	}
+
+	return event, nil
+}
+
+// Sign returns the signature of the payload at the unix time, as senders of events compute it.
+func (w *SignedWebhook) Sign(unix int64, payload []byte) string {
+	mac := hmac.New(sha256.New, w.secret)
+	fmt.Fprintf(mac, "%d.", unix)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
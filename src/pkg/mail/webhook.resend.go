@@ -0,0 +1,106 @@
+package mail
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResendWebhook parses the webhook events of Resend, signed the Svix way: a HMAC-SHA256 of
+// '<svix-id>.<svix-timestamp>.<payload>' with the base64 secret after its whsec_ prefix.
+type ResendWebhook struct {
+	secret []byte
+}
+
+func NewResendWebhook(secret string) (*ResendWebhook, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+	if err != nil {
+		return nil, errors.Join(err, errors.New("invalid resend webhook secret"))
+	}
+
+	return &ResendWebhook{
+		secret: key,
+	}, nil
+}
+
+type resendEvent struct {
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      struct {
+		To     []string `json:"to"`
+		Bounce *struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"bounce"`
+	} `json:"data"`
+}
+
+func (w *ResendWebhook) ParseDeliveryEvent(payload []byte, header http.Header) (DeliveryEvent, error) {
+	event := DeliveryEvent{}
+
+	id := header.Get("svix-id")
+	ts := header.Get("svix-timestamp")
+	if id == "" || len(w.secret) == 0 {
+		return event, ErrInvalidSignature
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return event, ErrInvalidSignature
+	}
+	if time.Since(time.Unix(unix, 0)).Abs() > webhookSignatureMaxAge {
+		return event, ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, w.secret)
+	mac.Write([]byte(id + "." + ts + "."))
+	mac.Write(payload)
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	// the header lists the signatures of every active secret, e.g. during a rotation
+	valid := false
+	for _, sig := range strings.Fields(header.Get("svix-signature")) {
+		version, sig, _ := strings.Cut(sig, ",")
+		if version == "v1" && hmac.Equal([]byte(sig), []byte(expected)) {
+			valid = true
+		}
+	}
+	if !valid {
+		return event, ErrInvalidSignature
+	}
+
+	e := resendEvent{}
+	err = json.Unmarshal(payload, &e)
+	if err != nil {
+		return event, errors.Join(err, errors.New("could not unmarshal resend event"))
+	}
+
+	event = DeliveryEvent{
+		Id:         id,
+		Type:       DeliveryEventType(e.Type),
+		Recipients: e.Data.To,
+		OccurredAt: e.CreatedAt,
+	}
+	switch e.Type {
+	case "email.delivered":
+		event.Type = DeliveredEvent
+	case "email.bounced":
+		event.Type = BouncedEvent
+		// resend only reports bounces the recipient server gave up on, unless told otherwise
+		event.Permanent = true
+		if e.Data.Bounce != nil {
+			event.Permanent = e.Data.Bounce.Type == "Permanent"
+			event.Reason = e.Data.Bounce.Message
+		}
+	case "email.complained":
+		event.Type = ComplainedEvent
+	}
+
+	return event, nil
+}
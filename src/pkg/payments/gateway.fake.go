@@ -0,0 +1,853 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fakeSignatureHeader    string        = "Fake-Signature"
+	fakeSignatureTolerance time.Duration = 5 * time.Minute
+)
+
+var fakeCheckoutPage = template.Must(template.New("checkout").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Fake Checkout</title></head>
+<body>
+	<h1>Fake Checkout</h1>
+	<p>{{ .Item.Name }}: {{ .Item.UnitAmount }} {{ .Item.Currency }}{{ if .Item.Interval }} / {{ .Item.Interval }}{{ end }}{{ if .Item.Quantity }} x {{ .Item.Quantity }}{{ end }}</p>
+	<form method="POST" action="{{ .Id }}/pay"><button type="submit">Pay</button></form>
+	<form method="POST" action="{{ .Id }}/fail"><button type="submit">Fail payment</button></form>
+	<form method="POST" action="{{ .Id }}/cancel"><button type="submit">Cancel</button></form>
+</body>
+</html>
+`))
+
+var fakePortalPage = template.Must(template.New("portal").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Fake Billing Portal</title></head>
+<body>
+	<h1>Fake Billing Portal</h1>
+	<ul>
+	{{ range .Methods }}<li>{{ .Brand }} **** {{ .Last4 }} {{ .ExpMonth }}/{{ .ExpYear }}{{ if .IsDefault }} (default){{ end }}</li>
+	{{ end }}</ul>
+	<a href="{{ .ReturnUrl }}">Return</a>
+</body>
+</html>
+`))
+
+type fakeCustomer struct {
+	params         CustomerParams
+	paymentMethods []PaymentMethod
+	defaultMethod  string
+	declining      bool // charges fail until a new payment method is added
+}
+
+type fakeCheckout struct {
+	session CheckoutSession
+	params  CheckoutParams
+}
+
+type fakePayment struct {
+	amount   int64
+	refunded int64
+	currency string
+}
+
+// FakeGateway is an in-process payment gateway for development and integration
+// tests. It serves its own checkout and portal pages (it is an http.Handler, to be mounted
+// at baseUrl) and delivers signed webhook events to webhookUrl as payments happen.
+type FakeGateway struct {
+	baseUrl       string
+	webhookUrl    string
+	webhookSecret string
+	httpClient    *http.Client
+
+	mu            sync.Mutex
+	seq           int
+	checkouts     map[string]*fakeCheckout
+	subscriptions map[string]*Subscription
+	renewals      map[string]CheckoutParams // checkout of each subscription, its renewals bill the same item
+	invoices      map[string]*Invoice
+	customers     map[string]*fakeCustomer
+	payments      map[string]*fakePayment
+	refunds       map[string]Refund // by idempotency key
+	disputes      map[string]*Dispute
+	coupons       map[string]*Coupon
+	usage         []UsageReport
+}
+
+func NewFakeGateway(baseUrl string, webhookUrl string, webhookSecret string) *FakeGateway {
+	return &FakeGateway{
+		baseUrl:       strings.TrimSuffix(baseUrl, "/"),
+		webhookUrl:    webhookUrl,
+		webhookSecret: webhookSecret,
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+		checkouts:     make(map[string]*fakeCheckout),
+		subscriptions: make(map[string]*Subscription),
+		renewals:      make(map[string]CheckoutParams),
+		invoices:      make(map[string]*Invoice),
+		customers:     make(map[string]*fakeCustomer),
+		payments:      make(map[string]*fakePayment),
+		refunds:       make(map[string]Refund),
+		disputes:      make(map[string]*Dispute),
+		coupons:       make(map[string]*Coupon),
+	}
+}
+
+// nextId must be called with the lock held.
+func (g *FakeGateway) nextId(prefix string) string {
+	g.seq++
+	return fmt.Sprintf("%s_fake_%d", prefix, g.seq)
+}
+
+func (g *FakeGateway) CreateCheckoutSession(ctx context.Context, params CheckoutParams) (CheckoutSession, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if params.CustomerId != nil {
+		if _, ok := g.customers[*params.CustomerId]; !ok {
+			return CheckoutSession{}, fmt.Errorf("customer '%s' not found", *params.CustomerId)
+		}
+	}
+
+	if params.ProviderCouponId != nil {
+		coupon, ok := g.coupons[*params.ProviderCouponId]
+		if !ok || !coupon.Active {
+			return CheckoutSession{}, fmt.Errorf("coupon '%s' not found", *params.ProviderCouponId)
+		}
+	}
+
+	id := g.nextId("cs")
+	cs := CheckoutSession{
+		Id:            id,
+		Url:           g.baseUrl + "/checkout/" + id,
+		Mode:          params.Mode,
+		ReferenceId:   params.ReferenceId,
+		Status:        CheckoutOpen,
+		PaymentStatus: Unpaid,
+	}
+	g.checkouts[id] = &fakeCheckout{session: cs, params: params}
+
+	return cs, nil
+}
+
+func (g *FakeGateway) GetCheckoutSession(ctx context.Context, sessionId string) (CheckoutSession, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c, ok := g.checkouts[sessionId]
+	if !ok {
+		return CheckoutSession{}, fmt.Errorf("checkout session '%s' not found", sessionId)
+	}
+	return c.session, nil
+}
+
+// Pay completes the checkout as the customer would, creating the subscription
+// in SubscriptionMode, and delivers the resulting webhook events.
+func (g *FakeGateway) Pay(ctx context.Context, sessionId string) (CheckoutSession, error) {
+	g.mu.Lock()
+	c, ok := g.checkouts[sessionId]
+	if !ok {
+		g.mu.Unlock()
+		return CheckoutSession{}, fmt.Errorf("checkout session '%s' not found", sessionId)
+	}
+	if c.session.Status != CheckoutOpen {
+		g.mu.Unlock()
+		return c.session, fmt.Errorf("checkout session '%s' is %s", sessionId, c.session.Status)
+	}
+
+	c.session.Status = CheckoutComplete
+	c.session.PaymentStatus = Paid
+
+	events := []Event{}
+	if c.params.Mode == SubscriptionMode {
+		var customerId string
+		if c.params.CustomerId != nil {
+			customerId = *c.params.CustomerId
+		} else {
+			customerId = g.nextId("cus")
+			g.customers[customerId] = &fakeCustomer{}
+		}
+		// subscriptions keep the card they were paid with for the next periods
+		g.attachCard(customerId, "visa", "4242")
+
+		itemId := g.nextId("si")
+		sub := &Subscription{
+			Id:              g.nextId("sub"),
+			CustomerId:      &customerId,
+			Status:          "active",
+			ItemId:          &itemId,
+			ProviderPriceId: c.params.LineItem.ProviderPriceId,
+			Metadata:        c.params.Metadata,
+		}
+		if c.params.LineItem.Quantity != nil {
+			sub.Quantity = *c.params.LineItem.Quantity
+		}
+		periodEnd := fakePeriodEnd(time.Now(), c.params.LineItem.Interval)
+		amountDue := c.params.LineItem.UnitAmount * sub.Quantity
+		amountDue -= g.discount(c.params, amountDue)
+		if c.params.TrialDays > 0 {
+			// the first period is the trial, billed at zero
+			periodEnd = time.Now().AddDate(0, 0, int(c.params.TrialDays))
+			sub.Status = "trialing"
+			sub.TrialEnd = &periodEnd
+			amountDue = 0
+		}
+		sub.CurrentPeriodEnd = &periodEnd
+
+		g.subscriptions[sub.Id] = sub
+		g.renewals[sub.Id] = c.params
+		c.session.SubscriptionId = &sub.Id
+
+		subCopy := *sub
+		events = append(events, Event{Id: g.nextId("evt"), Type: SubscriptionUpdatedEvent, Subscription: &subCopy})
+
+		// metered items are charged in arrears, the first invoice only has the licensed ones
+		invoice := &Invoice{
+			Id:             g.nextId("in"),
+			SubscriptionId: &sub.Id,
+			AmountDue:      amountDue,
+			AmountPaid:     amountDue,
+			Currency:       c.params.LineItem.Currency,
+			PeriodStart:    time.Now(),
+			PeriodEnd:      periodEnd,
+		}
+		g.invoices[invoice.Id] = invoice
+		invoiceCopy := *invoice
+		events = append(events, Event{Id: g.nextId("evt"), Type: InvoicePaidEvent, Invoice: &invoiceCopy})
+	}
+
+	if c.params.Mode == PaymentMode {
+		quantity := int64(1)
+		if c.params.LineItem.Quantity != nil {
+			quantity = *c.params.LineItem.Quantity
+		}
+		amount := c.params.LineItem.UnitAmount * quantity
+		amount -= g.discount(c.params, amount)
+
+		// fully discounted checkouts charge nothing, so there is no payment
+		if amount == 0 {
+			c.session.PaymentStatus = NoPaymentRequired
+		} else {
+			paymentId := g.nextId("pi")
+			g.payments[paymentId] = &fakePayment{
+				amount:   amount,
+				currency: c.params.LineItem.Currency,
+			}
+			c.session.PaymentId = &paymentId
+		}
+	}
+
+	cs := c.session
+	events = append(events, Event{Id: g.nextId("evt"), Type: CheckoutCompletedEvent, CheckoutSession: &cs})
+	g.mu.Unlock()
+
+	return cs, g.deliver(ctx, events...)
+}
+
+// FailPayment fails the payment of the checkout as an async payment method would.
+func (g *FakeGateway) FailPayment(ctx context.Context, sessionId string) (CheckoutSession, error) {
+	g.mu.Lock()
+	c, ok := g.checkouts[sessionId]
+	if !ok {
+		g.mu.Unlock()
+		return CheckoutSession{}, fmt.Errorf("checkout session '%s' not found", sessionId)
+	}
+
+	c.session.Status = CheckoutComplete
+	c.session.PaymentStatus = Unpaid
+	cs := c.session
+	event := Event{Id: g.nextId("evt"), Type: CheckoutPaymentFailedEvent, CheckoutSession: &cs}
+	g.mu.Unlock()
+
+	return cs, g.deliver(ctx, event)
+}
+
+func (g *FakeGateway) ExpireCheckoutSession(ctx context.Context, sessionId string) (CheckoutSession, error) {
+	g.mu.Lock()
+	c, ok := g.checkouts[sessionId]
+	if !ok {
+		g.mu.Unlock()
+		return CheckoutSession{}, fmt.Errorf("checkout session '%s' not found", sessionId)
+	}
+	if c.session.Status != CheckoutOpen {
+		g.mu.Unlock()
+		return c.session, fmt.Errorf("checkout session '%s' is %s", sessionId, c.session.Status)
+	}
+
+	c.session.Status = CheckoutExpired
+	cs := c.session
+	event := Event{Id: g.nextId("evt"), Type: CheckoutExpiredEvent, CheckoutSession: &cs}
+	g.mu.Unlock()
+
+	return cs, g.deliver(ctx, event)
+}
+
+func (g *FakeGateway) CreateCustomer(ctx context.Context, params CustomerParams) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for id, c := range g.customers {
+		if params.IdempotencyKey != "" && c.params.IdempotencyKey == params.IdempotencyKey {
+			return id, nil
+		}
+	}
+
+	id := g.nextId("cus")
+	g.customers[id] = &fakeCustomer{params: params}
+	return id, nil
+}
+
+func (g *FakeGateway) CreatePortalSession(ctx context.Context, customerId string, returnUrl string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.customers[customerId]; !ok {
+		return "", fmt.Errorf("customer '%s' not found", customerId)
+	}
+	return g.baseUrl + "/portal/" + customerId + "?return_url=" + url.QueryEscape(returnUrl), nil
+}
+
+func (g *FakeGateway) ListPaymentMethods(ctx context.Context, customerId string) ([]PaymentMethod, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.paymentMethods(customerId)
+}
+
+// paymentMethods must be called with the lock held.
+func (g *FakeGateway) paymentMethods(customerId string) ([]PaymentMethod, error) {
+	c, ok := g.customers[customerId]
+	if !ok {
+		return nil, fmt.Errorf("customer '%s' not found", customerId)
+	}
+
+	methods := []PaymentMethod{}
+	for _, pm := range c.paymentMethods {
+		pm.IsDefault = pm.Id == c.defaultMethod
+		methods = append(methods, pm)
+	}
+	return methods, nil
+}
+
+func (g *FakeGateway) SetDefaultPaymentMethod(ctx context.Context, customerId string, paymentMethodId string, subscriptionId *string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c, ok := g.customers[customerId]
+	if !ok {
+		return fmt.Errorf("customer '%s' not found", customerId)
+	}
+	if subscriptionId != nil {
+		sub, ok := g.subscriptions[*subscriptionId]
+		if !ok || sub.CustomerId == nil || *sub.CustomerId != customerId {
+			return fmt.Errorf("subscription '%s' not found for customer '%s'", *subscriptionId, customerId)
+		}
+	}
+	for _, pm := range c.paymentMethods {
+		if pm.Id == paymentMethodId {
+			c.defaultMethod = paymentMethodId
+			return nil
+		}
+	}
+	return fmt.Errorf("payment method '%s' not found for customer '%s'", paymentMethodId, customerId)
+}
+
+// AddPaymentMethod stores a card for the customer as they would in the billing portal.
+func (g *FakeGateway) AddPaymentMethod(ctx context.Context, customerId string, brand string, last4 string) (PaymentMethod, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c, ok := g.customers[customerId]
+	if !ok {
+		return PaymentMethod{}, fmt.Errorf("customer '%s' not found", customerId)
+	}
+	c.declining = false
+	return g.attachCard(customerId, brand, last4), nil
+}
+
+// attachCard stores a card for an existing customer, the first one is its default.
+// It must be called with the lock held.
+func (g *FakeGateway) attachCard(customerId string, brand string, last4 string) PaymentMethod {
+	c := g.customers[customerId]
+	pm := PaymentMethod{
+		Id:       g.nextId("pm"),
+		Type:     "card",
+		Brand:    brand,
+		Last4:    last4,
+		ExpMonth: 12,
+		ExpYear:  int64(time.Now().Year() + 3),
+	}
+	c.paymentMethods = append(c.paymentMethods, pm)
+	if c.defaultMethod == "" {
+		c.defaultMethod = pm.Id
+		pm.IsDefault = true
+	}
+	return pm
+}
+
+func (g *FakeGateway) Refund(ctx context.Context, params RefundParams) (Refund, error) {
+	g.mu.Lock()
+	if refund, ok := g.refunds[params.IdempotencyKey]; ok && params.IdempotencyKey != "" {
+		g.mu.Unlock()
+		return refund, nil
+	}
+
+	p, ok := g.payments[params.ProviderPaymentId]
+	if !ok {
+		g.mu.Unlock()
+		return Refund{}, fmt.Errorf("payment '%s' not found", params.ProviderPaymentId)
+	}
+	if params.Amount <= 0 || p.refunded+params.Amount > p.amount {
+		g.mu.Unlock()
+		return Refund{}, fmt.Errorf("refund of %d exceeds the %d left of payment '%s'", params.Amount, p.amount-p.refunded, params.ProviderPaymentId)
+	}
+
+	p.refunded += params.Amount
+	refund := Refund{
+		Id:                g.nextId("re"),
+		ProviderPaymentId: params.ProviderPaymentId,
+		Amount:            params.Amount,
+		AmountRefunded:    p.refunded,
+		Currency:          p.currency,
+	}
+	g.refunds[params.IdempotencyKey] = refund
+	event := Event{Id: g.nextId("evt"), Type: PaymentRefundedEvent, Refund: &refund}
+	g.mu.Unlock()
+
+	return refund, g.deliver(ctx, event)
+}
+
+// OpenDispute disputes a payment as the customer would through their bank.
+func (g *FakeGateway) OpenDispute(ctx context.Context, providerPaymentId string, reason string) (Dispute, error) {
+	g.mu.Lock()
+	p, ok := g.payments[providerPaymentId]
+	if !ok {
+		g.mu.Unlock()
+		return Dispute{}, fmt.Errorf("payment '%s' not found", providerPaymentId)
+	}
+
+	dispute := &Dispute{
+		Id:                g.nextId("dp"),
+		ProviderPaymentId: providerPaymentId,
+		Amount:            p.amount - p.refunded,
+		Status:            DisputeOpen,
+		Reason:            reason,
+	}
+	g.disputes[dispute.Id] = dispute
+	disputeCopy := *dispute
+	event := Event{Id: g.nextId("evt"), Type: DisputeOpenedEvent, Dispute: &disputeCopy}
+	g.mu.Unlock()
+
+	return disputeCopy, g.deliver(ctx, event)
+}
+
+// CloseDispute resolves an open dispute in favor of the merchant if won.
+func (g *FakeGateway) CloseDispute(ctx context.Context, disputeId string, won bool) (Dispute, error) {
+	g.mu.Lock()
+	dispute, ok := g.disputes[disputeId]
+	if !ok || dispute.Status != DisputeOpen {
+		g.mu.Unlock()
+		return Dispute{}, fmt.Errorf("open dispute '%s' not found", disputeId)
+	}
+
+	dispute.Status = DisputeLost
+	if won {
+		dispute.Status = DisputeWon
+	}
+	disputeCopy := *dispute
+	event := Event{Id: g.nextId("evt"), Type: DisputeClosedEvent, Dispute: &disputeCopy}
+	g.mu.Unlock()
+
+	return disputeCopy, g.deliver(ctx, event)
+}
+
+func (g *FakeGateway) GetSubscription(ctx context.Context, subscriptionId string) (Subscription, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	sub, ok := g.subscriptions[subscriptionId]
+	if !ok {
+		return Subscription{}, fmt.Errorf("subscription '%s' not found", subscriptionId)
+	}
+	return *sub, nil
+}
+
+func (g *FakeGateway) ChangeSubscription(ctx context.Context, subscriptionId string, providerPriceId string, quantity int64) (Subscription, error) {
+	return g.updateSubscription(ctx, subscriptionId, func(sub *Subscription) {
+		sub.ProviderPriceId = &providerPriceId
+		sub.Quantity = quantity
+	})
+}
+
+func (g *FakeGateway) CancelSubscriptionAtPeriodEnd(ctx context.Context, subscriptionId string) (Subscription, error) {
+	return g.updateSubscription(ctx, subscriptionId, func(sub *Subscription) {
+		sub.CancelAtPeriodEnd = true
+	})
+}
+
+// CancelSubscription ends the subscription right away, its open invoices are left unpaid.
+func (g *FakeGateway) CancelSubscription(ctx context.Context, subscriptionId string) (Subscription, error) {
+	return g.updateSubscription(ctx, subscriptionId, func(sub *Subscription) {
+		sub.Status = "canceled"
+		sub.CancelAtPeriodEnd = false
+	})
+}
+
+// FailRenewal renews the subscription into its next period with a charge that the
+// card of the customer declines, as do its retries until a new payment method is added.
+// The subscription is past_due until the returned invoice is paid.
+func (g *FakeGateway) FailRenewal(ctx context.Context, subscriptionId string) (Invoice, error) {
+	g.mu.Lock()
+	sub, ok := g.subscriptions[subscriptionId]
+	if !ok || sub.Status == "canceled" {
+		g.mu.Unlock()
+		return Invoice{}, fmt.Errorf("live subscription '%s' not found", subscriptionId)
+	}
+
+	params := g.renewals[subscriptionId]
+	amountDue := params.LineItem.UnitAmount * sub.Quantity
+	if coupon, ok := g.coupons[ptrValue(params.ProviderCouponId)]; ok && coupon.Duration == ForeverCoupon {
+		amountDue -= coupon.Discount(amountDue)
+	}
+
+	periodStart := time.Now()
+	if sub.CurrentPeriodEnd != nil {
+		periodStart = *sub.CurrentPeriodEnd
+	}
+	invoice := &Invoice{
+		Id:             g.nextId("in"),
+		SubscriptionId: &sub.Id,
+		AmountDue:      amountDue,
+		Currency:       params.LineItem.Currency,
+		PeriodStart:    periodStart,
+		PeriodEnd:      fakePeriodEnd(periodStart, params.LineItem.Interval),
+	}
+	g.invoices[invoice.Id] = invoice
+	if c, ok := g.customers[ptrValue(sub.CustomerId)]; ok {
+		c.declining = true
+	}
+
+	sub.Status = "past_due"
+	subCopy := *sub
+	invoiceCopy := *invoice
+	events := []Event{
+		{Id: g.nextId("evt"), Type: SubscriptionUpdatedEvent, Subscription: &subCopy},
+		{Id: g.nextId("evt"), Type: InvoicePaymentFailedEvent, Invoice: &invoiceCopy},
+	}
+	g.mu.Unlock()
+
+	return invoiceCopy, g.deliver(ctx, events...)
+}
+
+// PayInvoice charges an open invoice again, paying it brings its subscription back to
+// active in the invoiced period.
+func (g *FakeGateway) PayInvoice(ctx context.Context, invoiceId string) (Invoice, error) {
+	g.mu.Lock()
+	invoice, ok := g.invoices[invoiceId]
+	if !ok {
+		g.mu.Unlock()
+		return Invoice{}, fmt.Errorf("invoice '%s' not found", invoiceId)
+	}
+	if invoice.AmountPaid == invoice.AmountDue {
+		g.mu.Unlock()
+		return *invoice, nil
+	}
+
+	sub := g.subscriptions[ptrValue(invoice.SubscriptionId)]
+	if sub == nil || sub.Status == "canceled" {
+		g.mu.Unlock()
+		return *invoice, fmt.Errorf("invoice '%s' belongs to no live subscription", invoiceId)
+	}
+
+	if c, ok := g.customers[ptrValue(sub.CustomerId)]; ok && c.declining {
+		invoiceCopy := *invoice
+		event := Event{Id: g.nextId("evt"), Type: InvoicePaymentFailedEvent, Invoice: &invoiceCopy}
+		g.mu.Unlock()
+
+		return invoiceCopy, errors.Join(ErrPaymentFailed, g.deliver(ctx, event))
+	}
+
+	invoice.AmountPaid = invoice.AmountDue
+	sub.Status = "active"
+	periodEnd := invoice.PeriodEnd
+	sub.CurrentPeriodEnd = &periodEnd
+	subCopy := *sub
+	invoiceCopy := *invoice
+	events := []Event{
+		{Id: g.nextId("evt"), Type: SubscriptionUpdatedEvent, Subscription: &subCopy},
+		{Id: g.nextId("evt"), Type: InvoicePaidEvent, Invoice: &invoiceCopy},
+	}
+	g.mu.Unlock()
+
+	return invoiceCopy, g.deliver(ctx, events...)
+}
+
+func (g *FakeGateway) updateSubscription(ctx context.Context, subscriptionId string, update func(sub *Subscription)) (Subscription, error) {
+	g.mu.Lock()
+	sub, ok := g.subscriptions[subscriptionId]
+	if !ok {
+		g.mu.Unlock()
+		return Subscription{}, fmt.Errorf("subscription '%s' not found", subscriptionId)
+	}
+
+	update(sub)
+	subCopy := *sub
+	event := Event{Id: g.nextId("evt"), Type: SubscriptionUpdatedEvent, Subscription: &subCopy}
+	g.mu.Unlock()
+
+	return subCopy, g.deliver(ctx, event)
+}
+
+func (g *FakeGateway) SyncProduct(ctx context.Context, product Product) (string, error) {
+	if product.ProviderId != nil {
+		return *product.ProviderId, nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.nextId("prod"), nil
+}
+
+func (g *FakeGateway) SyncPrice(ctx context.Context, price Price, providerProductId string) (string, error) {
+	if price.ProviderId != nil {
+		return *price.ProviderId, nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.nextId("price"), nil
+}
+
+func (g *FakeGateway) SyncCoupon(ctx context.Context, coupon Coupon) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if coupon.ProviderId != nil {
+		if c, ok := g.coupons[*coupon.ProviderId]; ok {
+			c.Active = coupon.Active
+		}
+		return *coupon.ProviderId, nil
+	}
+
+	id := g.nextId("co")
+	coupon.ProviderId = &id
+	g.coupons[id] = &coupon
+	return id, nil
+}
+
+// discount is how much the coupon of the checkout takes off amount, it must be called with the lock held.
+func (g *FakeGateway) discount(params CheckoutParams, amount int64) int64 {
+	if params.ProviderCouponId == nil {
+		return 0
+	}
+
+	coupon, ok := g.coupons[*params.ProviderCouponId]
+	if !ok {
+		return 0
+	}
+	return coupon.Discount(amount)
+}
+
+func (g *FakeGateway) ReportUsage(ctx context.Context, report UsageReport) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, r := range g.usage {
+		if r.IdempotencyKey != "" && r.IdempotencyKey == report.IdempotencyKey {
+			return nil
+		}
+	}
+	g.usage = append(g.usage, report)
+	return nil
+}
+
+// Usage returns the usage reported so far.
+func (g *FakeGateway) Usage() []UsageReport {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]UsageReport{}, g.usage...)
+}
+
+func (g *FakeGateway) ParseWebhookEvent(payload []byte, header http.Header) (Event, error) {
+	event := Event{}
+
+	ts, sig, ok := strings.Cut(header.Get(fakeSignatureHeader), ",")
+	if !ok {
+		return event, ErrInvalidSignature
+	}
+
+	unix, err := strconv.ParseInt(strings.TrimPrefix(ts, "t="), 10, 64)
+	if err != nil {
+		return event, ErrInvalidSignature
+	}
+	if time.Since(time.Unix(unix, 0)).Abs() > fakeSignatureTolerance {
+		return event, ErrInvalidSignature
+	}
+
+	expected := g.sign(unix, payload)
+	if !hmac.Equal([]byte(strings.TrimPrefix(sig, "v1=")), []byte(expected)) {
+		return event, ErrInvalidSignature
+	}
+
+	err = json.Unmarshal(payload, &event)
+	if err != nil {
+		return event, errors.Join(err, errors.New("could not unmarshal fake gateway event"))
+	}
+
+	return event, nil
+}
+
+func (g *FakeGateway) sign(unix int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(g.webhookSecret))
+	fmt.Fprintf(mac, "%d.", unix)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver sends the events to the webhook in order, stopping at the first failure.
+func (g *FakeGateway) deliver(ctx context.Context, events ...Event) error {
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.webhookUrl, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+
+		unix := time.Now().Unix()
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(fakeSignatureHeader, fmt.Sprintf("t=%d,v1=%s", unix, g.sign(unix, payload)))
+
+		res, err := g.httpClient.Do(req)
+		if err != nil {
+			return errors.Join(err, fmt.Errorf("could not deliver event '%s'", event.Id))
+		}
+		res.Body.Close()
+
+		if res.StatusCode >= 300 {
+			return fmt.Errorf("webhook answered %d to event '%s'", res.StatusCode, event.Id)
+		}
+	}
+
+	return nil
+}
+
+// ServeHTTP serves the checkout and billing portal pages, the pay, fail and cancel
+// actions redirect back to the success or cancel URLs of the checkout.
+func (g *FakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	if len(parts) == 2 && parts[0] == "portal" && r.Method == http.MethodGet {
+		g.servePortal(w, r, parts[1])
+		return
+	}
+	if len(parts) < 2 || parts[0] != "checkout" {
+		http.NotFound(w, r)
+		return
+	}
+
+	sessionId := parts[1]
+	g.mu.Lock()
+	c, ok := g.checkouts[sessionId]
+	var params CheckoutParams
+	if ok {
+		params = c.params
+	}
+	g.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if len(parts) == 2 && r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := fakeCheckoutPage.Execute(w, map[string]any{"Id": sessionId, "Item": params.LineItem})
+		if err != nil {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	if len(parts) != 3 || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+
+	var err error
+	redirect := params.SuccessUrl
+	switch parts[2] {
+	case "pay":
+		_, err = g.Pay(r.Context(), sessionId)
+	case "fail":
+		_, err = g.FailPayment(r.Context(), sessionId)
+		redirect = params.CancelUrl
+	case "cancel":
+		redirect = params.CancelUrl
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// servePortal lists the payment methods of the customer, with a link back to the app.
+func (g *FakeGateway) servePortal(w http.ResponseWriter, r *http.Request, customerId string) {
+	g.mu.Lock()
+	methods, err := g.paymentMethods(customerId)
+	g.mu.Unlock()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = fakePortalPage.Execute(w, map[string]any{"Methods": methods, "ReturnUrl": r.URL.Query().Get("return_url")})
+	if err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+func ptrValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func fakePeriodEnd(from time.Time, interval string) time.Time {
+	switch interval {
+	case "day":
+		return from.AddDate(0, 0, 1)
+	case "week":
+		return from.AddDate(0, 0, 7)
+	case "year":
+		return from.AddDate(1, 0, 0)
+	default:
+		return from.AddDate(0, 1, 0)
+	}
+}
@@ -0,0 +1,363 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type webhookRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func newFakeGatewayWithWebhook(t *testing.T) (*FakeGateway, *webhookRecorder) {
+	rec := &webhookRecorder{}
+	var g *FakeGateway
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ := io.ReadAll(r.Body)
+		event, err := g.ParseWebhookEvent(payload, r.Header)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		rec.mu.Lock()
+		rec.events = append(rec.events, event)
+		rec.mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+
+	g = NewFakeGateway("http://localhost/fake-gateway", srv.URL, "whsec_test")
+	return g, rec
+}
+
+func TestFakeGateway_PaySubscription(t *testing.T) {
+	ctx := context.Background()
+	g, rec := newFakeGatewayWithWebhook(t)
+
+	seats := int64(3)
+	cs, err := g.CreateCheckoutSession(ctx, CheckoutParams{
+		Mode:        SubscriptionMode,
+		ReferenceId: "ref",
+		LineItem: LineItem{
+			Name:       "pro",
+			Currency:   "usd",
+			UnitAmount: 1000,
+			Interval:   "month",
+			Quantity:   &seats,
+		},
+		Metadata: map[string]string{"subscription_id": "ref"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.Status != CheckoutOpen || cs.IsPaid() {
+		t.Fatalf("new checkout session should be open and unpaid, got %+v", cs)
+	}
+
+	cs, err = g.Pay(ctx, cs.Id)
+	if err != nil {
+		t.Fatalf("FakeGateway.Pay() error = %v", err)
+	}
+	if !cs.IsPaid() || cs.SubscriptionId == nil {
+		t.Fatalf("paid subscription checkout should be paid and have a subscription, got %+v", cs)
+	}
+
+	if len(rec.events) != 3 {
+		t.Fatalf("expected 3 webhook events, got %d", len(rec.events))
+	}
+	if rec.events[0].Type != SubscriptionUpdatedEvent || rec.events[0].Subscription.Metadata["subscription_id"] != "ref" {
+		t.Errorf("expected subscription event with the checkout metadata first, got %+v", rec.events[0])
+	}
+	if rec.events[1].Type != InvoicePaidEvent || rec.events[1].Invoice.AmountPaid != 3000 || *rec.events[1].Invoice.SubscriptionId != *cs.SubscriptionId {
+		t.Errorf("expected invoice paid event for the seats second, got %+v", rec.events[1])
+	}
+	if rec.events[2].Type != CheckoutCompletedEvent || rec.events[2].CheckoutSession.Id != cs.Id {
+		t.Errorf("expected checkout completed event last, got %+v", rec.events[2])
+	}
+
+	sub, err := g.GetSubscription(ctx, *cs.SubscriptionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Quantity != seats || sub.Status != "active" {
+		t.Errorf("unexpected subscription %+v", sub)
+	}
+
+	_, err = g.Pay(ctx, cs.Id)
+	if err == nil {
+		t.Errorf("paying a completed checkout session should fail")
+	}
+}
+
+func TestFakeGateway_ParseWebhookEvent(t *testing.T) {
+	g := NewFakeGateway("http://localhost/fake-gateway", "", "whsec_test")
+	other := NewFakeGateway("http://localhost/fake-gateway", "", "whsec_other")
+
+	var captured *http.Request
+	var payload []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ = io.ReadAll(r.Body)
+		captured = r
+	}))
+	t.Cleanup(srv.Close)
+	g.webhookUrl = srv.URL
+
+	err := g.deliver(context.Background(), Event{Id: "evt_1", Type: CheckoutCompletedEvent})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := g.ParseWebhookEvent(payload, captured.Header)
+	if err != nil || event.Id != "evt_1" {
+		t.Errorf("FakeGateway.ParseWebhookEvent() = %+v, %v", event, err)
+	}
+
+	_, err = other.ParseWebhookEvent(payload, captured.Header)
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature for another secret, got %v", err)
+	}
+
+	tampered := append([]byte{}, payload...)
+	tampered[len(tampered)-2] = ' '
+	_, err = g.ParseWebhookEvent(tampered, captured.Header)
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature for a tampered payload, got %v", err)
+	}
+}
+
+func TestFakeGateway_Refund(t *testing.T) {
+	ctx := context.Background()
+	g, rec := newFakeGatewayWithWebhook(t)
+
+	cs, err := g.CreateCheckoutSession(ctx, CheckoutParams{
+		Mode:        PaymentMode,
+		ReferenceId: "ref",
+		LineItem:    LineItem{Name: "credits", Currency: "usd", UnitAmount: 1000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err = g.Pay(ctx, cs.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.PaymentId == nil {
+		t.Fatalf("paid checkout session should have a payment, got %+v", cs)
+	}
+
+	params := RefundParams{ProviderPaymentId: *cs.PaymentId, Amount: 400, Reason: RequestedByCustomerRefund, IdempotencyKey: "refund-1"}
+	for range 2 {
+		_, err = g.Refund(ctx, params)
+		if err != nil {
+			t.Fatalf("FakeGateway.Refund() error = %v", err)
+		}
+	}
+
+	_, err = g.Refund(ctx, RefundParams{ProviderPaymentId: *cs.PaymentId, Amount: 700, IdempotencyKey: "refund-2"})
+	if err == nil {
+		t.Errorf("refunding more than what is left of the payment should fail")
+	}
+
+	last := rec.events[len(rec.events)-1]
+	if len(rec.events) != 2 || last.Type != PaymentRefundedEvent || last.Refund.AmountRefunded != 400 {
+		t.Errorf("expected a single refund event with 400 refunded, got %+v", rec.events)
+	}
+}
+
+func TestFakeGateway_CouponAndTrial(t *testing.T) {
+	ctx := context.Background()
+	g, rec := newFakeGatewayWithWebhook(t)
+
+	percentOff := int64(25)
+	couponId, err := g.SyncCoupon(ctx, Coupon{ReferenceId: "1", Name: "LAUNCH", PercentOff: &percentOff, Duration: OnceCoupon, Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs, err := g.CreateCheckoutSession(ctx, CheckoutParams{
+		Mode:             PaymentMode,
+		ReferenceId:      "ref",
+		LineItem:         LineItem{Name: "credits", Currency: "usd", UnitAmount: 1010},
+		ProviderCouponId: &couponId,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err = g.Pay(ctx, cs.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 25% of 1010 is 252.5, rounded half up
+	_, err = g.Refund(ctx, RefundParams{ProviderPaymentId: *cs.PaymentId, Amount: 758})
+	if err == nil {
+		t.Errorf("refunding more than the discounted payment should fail")
+	}
+
+	seats := int64(2)
+	cs, err = g.CreateCheckoutSession(ctx, CheckoutParams{
+		Mode:             SubscriptionMode,
+		ReferenceId:      "ref",
+		LineItem:         LineItem{Name: "pro", Currency: "usd", UnitAmount: 1000, Interval: "month", Quantity: &seats},
+		ProviderCouponId: &couponId,
+		TrialDays:        14,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err = g.Pay(ctx, cs.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := g.GetSubscription(ctx, *cs.SubscriptionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Status != "trialing" || sub.TrialEnd == nil || !sub.TrialEnd.Equal(*sub.CurrentPeriodEnd) {
+		t.Errorf("subscription with trial days should be trialing until its trial end, got %+v", sub)
+	}
+
+	invoice := rec.events[len(rec.events)-2]
+	if invoice.Type != InvoicePaidEvent || invoice.Invoice.AmountPaid != 0 {
+		t.Errorf("expected a zero invoice for the trial period, got %+v", invoice)
+	}
+
+	_, err = g.SyncCoupon(ctx, Coupon{ProviderId: &couponId, Active: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.CreateCheckoutSession(ctx, CheckoutParams{Mode: PaymentMode, ProviderCouponId: &couponId})
+	if err == nil {
+		t.Errorf("checkouts with a deleted coupon should fail")
+	}
+}
+
+func TestFakeGateway_CustomerPaymentMethods(t *testing.T) {
+	ctx := context.Background()
+	g, _ := newFakeGatewayWithWebhook(t)
+
+	params := CustomerParams{ReferenceId: "org", Email: "owner@example.com", Name: "Org", IdempotencyKey: "customer-org"}
+	customerId, err := g.CreateCustomer(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := g.CreateCustomer(ctx, params)
+	if err != nil || again != customerId {
+		t.Fatalf("creating a customer with the same idempotency key should return it, got %s, %v", again, err)
+	}
+
+	seats := int64(1)
+	cs, err := g.CreateCheckoutSession(ctx, CheckoutParams{
+		Mode:       SubscriptionMode,
+		CustomerId: &customerId,
+		LineItem:   LineItem{Name: "pro", Currency: "usd", UnitAmount: 1000, Interval: "month", Quantity: &seats},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err = g.Pay(ctx, cs.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := g.GetSubscription(ctx, *cs.SubscriptionId)
+	if err != nil || *sub.CustomerId != customerId {
+		t.Fatalf("subscription should belong to the checkout customer, got %+v, %v", sub, err)
+	}
+
+	added, err := g.AddPaymentMethod(ctx, customerId, "mastercard", "4444")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.SetDefaultPaymentMethod(ctx, customerId, added.Id, &sub.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	methods, err := g.ListPaymentMethods(ctx, customerId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(methods) != 2 || methods[0].IsDefault || !methods[1].IsDefault {
+		t.Errorf("expected the checkout card and the added default card, got %+v", methods)
+	}
+
+	err = g.SetDefaultPaymentMethod(ctx, customerId, "pm_unknown", nil)
+	if err == nil {
+		t.Errorf("setting a payment method of another customer as default should fail")
+	}
+
+	other, err := g.CreateCustomer(ctx, CustomerParams{ReferenceId: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.SetDefaultPaymentMethod(ctx, other, added.Id, &sub.Id)
+	if err == nil {
+		t.Errorf("setting the default of a subscription of another customer should fail")
+	}
+}
+
+func TestFakeGateway_FailedRenewal(t *testing.T) {
+	ctx := context.Background()
+	g, rec := newFakeGatewayWithWebhook(t)
+
+	seats := int64(2)
+	cs, err := g.CreateCheckoutSession(ctx, CheckoutParams{
+		Mode:     SubscriptionMode,
+		LineItem: LineItem{Name: "pro", Currency: "usd", UnitAmount: 1000, Interval: "month", Quantity: &seats},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err = g.Pay(ctx, cs.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invoice, err := g.FailRenewal(ctx, *cs.SubscriptionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invoice.AmountDue != 2000 || invoice.AmountPaid != 0 {
+		t.Errorf("expected an unpaid renewal invoice of 2000, got %+v", invoice)
+	}
+	last := rec.events[len(rec.events)-1]
+	if last.Type != InvoicePaymentFailedEvent || last.Invoice.Id != invoice.Id {
+		t.Errorf("expected invoice payment failed event last, got %+v", last)
+	}
+
+	_, err = g.PayInvoice(ctx, invoice.Id)
+	if !errors.Is(err, ErrPaymentFailed) {
+		t.Fatalf("retrying with the declining card should fail with ErrPaymentFailed, got %v", err)
+	}
+	sub, _ := g.GetSubscription(ctx, *cs.SubscriptionId)
+	if sub.Status != "past_due" {
+		t.Errorf("subscription should be past_due while the invoice is unpaid, got %s", sub.Status)
+	}
+
+	_, err = g.AddPaymentMethod(ctx, *sub.CustomerId, "mastercard", "4444")
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoice, err = g.PayInvoice(ctx, invoice.Id)
+	if err != nil || invoice.AmountPaid != 2000 {
+		t.Fatalf("retrying with a new card should pay the invoice, got %+v, %v", invoice, err)
+	}
+	sub, _ = g.GetSubscription(ctx, *cs.SubscriptionId)
+	if sub.Status != "active" || !sub.CurrentPeriodEnd.Equal(invoice.PeriodEnd) {
+		t.Errorf("paid renewal should make the subscription active in the invoiced period, got %+v", sub)
+	}
+
+	sub, err = g.CancelSubscription(ctx, sub.Id)
+	if err != nil || sub.Status != "canceled" {
+		t.Errorf("subscription should be canceled right away, got %+v, %v", sub, err)
+	}
+}
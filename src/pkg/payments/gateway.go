@@ -0,0 +1,66 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+const (
+	STRIPE_GATEWAY string = "stripe"
+	FAKE_GATEWAY   string = "fake"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrPaymentFailed    = errors.New("payment failed")
+)
+
+// Gateway is a payment provider, it hosts the checkout pages, charges customers
+// and notifies back through webhook events.
+type Gateway interface {
+	CreateCheckoutSession(ctx context.Context, params CheckoutParams) (CheckoutSession, error)
+	GetCheckoutSession(ctx context.Context, sessionId string) (CheckoutSession, error)
+	// ExpireCheckoutSession expires an open checkout so it can no longer be paid.
+	ExpireCheckoutSession(ctx context.Context, sessionId string) (CheckoutSession, error)
+
+	// CreateCustomer creates a customer, returns its provider ID. Checkouts of the
+	// same customer share its payment methods.
+	CreateCustomer(ctx context.Context, params CustomerParams) (string, error)
+	// CreatePortalSession creates a session of the hosted page where the customer
+	// manages its payment methods and subscriptions, returns its URL.
+	CreatePortalSession(ctx context.Context, customerId string, returnUrl string) (string, error)
+	ListPaymentMethods(ctx context.Context, customerId string) ([]PaymentMethod, error)
+	// SetDefaultPaymentMethod makes a payment method of the customer its default and, if subscriptionId
+	// is not nil, the default of that subscription of the customer.
+	SetDefaultPaymentMethod(ctx context.Context, customerId string, paymentMethodId string, subscriptionId *string) error
+
+	// Refund returns part or all of a payment to the customer.
+	Refund(ctx context.Context, params RefundParams) (Refund, error)
+
+	GetSubscription(ctx context.Context, subscriptionId string) (Subscription, error)
+	// ChangeSubscription moves the single item of a subscription to another price and/or quantity, prorating the difference.
+	ChangeSubscription(ctx context.Context, subscriptionId string, providerPriceId string, quantity int64) (Subscription, error)
+	CancelSubscriptionAtPeriodEnd(ctx context.Context, subscriptionId string) (Subscription, error)
+	// CancelSubscription ends a subscription right away, without refunding the current period.
+	CancelSubscription(ctx context.Context, subscriptionId string) (Subscription, error)
+
+	// PayInvoice charges an open invoice again with the default payment method of its customer,
+	// returns ErrPaymentFailed if the charge was declined.
+	PayInvoice(ctx context.Context, invoiceId string) (Invoice, error)
+
+	// SyncProduct creates or updates a product, returns its provider ID.
+	SyncProduct(ctx context.Context, product Product) (string, error)
+	// SyncPrice creates a price (or updates its active flag, prices are immutable), returns its provider ID.
+	SyncPrice(ctx context.Context, price Price, providerProductId string) (string, error)
+
+	// SyncCoupon creates a coupon (or deletes it once inactive, coupons are immutable), returns its provider ID.
+	// Customers that already redeemed a deleted coupon keep their discount.
+	SyncCoupon(ctx context.Context, coupon Coupon) (string, error)
+
+	ReportUsage(ctx context.Context, report UsageReport) error
+
+	// ParseWebhookEvent verifies the signature in the headers and parses the payload,
+	// returns ErrInvalidSignature if it does not match.
+	ParseWebhookEvent(payload []byte, header http.Header) (Event, error)
+}
@@ -0,0 +1,631 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/client"
+	"github.com/stripe/stripe-go/v81/webhook"
+)
+
+// https://docs.stripe.com/payments/accept-a-payment?platform=web&ui=stripe-hosted
+// https://docs.stripe.com/webhooks#verify-official-libraries
+
+type StripeGateway struct {
+	api           *client.API
+	webhookSecret string
+}
+
+// NewStripeGateway creates the Stripe gateway, backends may be nil to use
+// the Stripe API, or point to a local stand-in in tests.
+func NewStripeGateway(apiKey string, webhookSecret string, backends *stripe.Backends) Gateway {
+	return &StripeGateway{
+		api:           client.New(apiKey, backends),
+		webhookSecret: webhookSecret,
+	}
+}
+
+func (g *StripeGateway) CreateCheckoutSession(ctx context.Context, p CheckoutParams) (CheckoutSession, error) {
+	lineItem := &stripe.CheckoutSessionLineItemParams{
+		Quantity: p.LineItem.Quantity,
+	}
+	if p.LineItem.ProviderPriceId != nil {
+		lineItem.Price = p.LineItem.ProviderPriceId
+	} else {
+		lineItem.PriceData = &stripe.CheckoutSessionLineItemPriceDataParams{
+			Currency: stripe.String(p.LineItem.Currency),
+			ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
+				Name: stripe.String(p.LineItem.Name),
+			},
+			UnitAmount: stripe.Int64(p.LineItem.UnitAmount),
+		}
+		if p.LineItem.Interval != "" {
+			lineItem.PriceData.Recurring = &stripe.CheckoutSessionLineItemPriceDataRecurringParams{
+				Interval: stripe.String(p.LineItem.Interval),
+			}
+		}
+	}
+
+	params := &stripe.CheckoutSessionParams{
+		ClientReferenceID: stripe.String(p.ReferenceId),
+		Customer:          p.CustomerId,
+		Mode:              stripe.String(string(p.Mode)),
+		LineItems:         []*stripe.CheckoutSessionLineItemParams{lineItem},
+		SuccessURL:        stripe.String(p.SuccessUrl),
+		CancelURL:         stripe.String(p.CancelUrl),
+	}
+	params.Context = ctx
+
+	if p.ProviderCouponId != nil {
+		params.Discounts = []*stripe.CheckoutSessionDiscountParams{
+			{Coupon: p.ProviderCouponId},
+		}
+	}
+
+	if p.Mode == SubscriptionMode {
+		params.SubscriptionData = &stripe.CheckoutSessionSubscriptionDataParams{}
+		for k, v := range p.Metadata {
+			params.SubscriptionData.AddMetadata(k, v)
+		}
+		if p.TrialDays > 0 {
+			params.SubscriptionData.TrialPeriodDays = stripe.Int64(p.TrialDays)
+		}
+	} else {
+		for k, v := range p.Metadata {
+			params.AddMetadata(k, v)
+		}
+	}
+
+	cs, err := g.api.CheckoutSessions.New(params)
+	if err != nil {
+		return CheckoutSession{}, errors.Join(err, errors.New("could not create stripe session"))
+	}
+
+	return stripeCheckoutSession(cs), nil
+}
+
+func (g *StripeGateway) GetCheckoutSession(ctx context.Context, sessionId string) (CheckoutSession, error) {
+	params := &stripe.CheckoutSessionParams{}
+	params.Context = ctx
+
+	cs, err := g.api.CheckoutSessions.Get(sessionId, params)
+	if err != nil {
+		return CheckoutSession{}, errors.Join(err, errors.New("could not get session from stripe"))
+	}
+
+	return stripeCheckoutSession(cs), nil
+}
+
+func (g *StripeGateway) ExpireCheckoutSession(ctx context.Context, sessionId string) (CheckoutSession, error) {
+	params := &stripe.CheckoutSessionExpireParams{}
+	params.Context = ctx
+
+	cs, err := g.api.CheckoutSessions.Expire(sessionId, params)
+	if err != nil {
+		return CheckoutSession{}, errors.Join(err, errors.New("could not expire stripe session"))
+	}
+
+	return stripeCheckoutSession(cs), nil
+}
+
+func (g *StripeGateway) CreateCustomer(ctx context.Context, p CustomerParams) (string, error) {
+	params := &stripe.CustomerParams{
+		Email: stripe.String(p.Email),
+		Name:  stripe.String(p.Name),
+	}
+	params.Context = ctx
+	params.AddMetadata("reference_id", p.ReferenceId)
+	params.SetIdempotencyKey(p.IdempotencyKey)
+
+	customer, err := g.api.Customers.New(params)
+	if err != nil {
+		return "", errors.Join(err, errors.New("could not create stripe customer"))
+	}
+	return customer.ID, nil
+}
+
+func (g *StripeGateway) CreatePortalSession(ctx context.Context, customerId string, returnUrl string) (string, error) {
+	params := &stripe.BillingPortalSessionParams{
+		Customer:  stripe.String(customerId),
+		ReturnURL: stripe.String(returnUrl),
+	}
+	params.Context = ctx
+
+	session, err := g.api.BillingPortalSessions.New(params)
+	if err != nil {
+		return "", errors.Join(err, errors.New("could not create stripe billing portal session"))
+	}
+	return session.URL, nil
+}
+
+func (g *StripeGateway) ListPaymentMethods(ctx context.Context, customerId string) ([]PaymentMethod, error) {
+	customerParams := &stripe.CustomerParams{}
+	customerParams.Context = ctx
+
+	customer, err := g.api.Customers.Get(customerId, customerParams)
+	if err != nil {
+		return nil, errors.Join(err, errors.New("could not get stripe customer"))
+	}
+
+	var defaultId string
+	if customer.InvoiceSettings != nil && customer.InvoiceSettings.DefaultPaymentMethod != nil {
+		defaultId = customer.InvoiceSettings.DefaultPaymentMethod.ID
+	}
+
+	params := &stripe.PaymentMethodListParams{
+		Customer: stripe.String(customerId),
+	}
+	params.Context = ctx
+
+	methods := []PaymentMethod{}
+	iter := g.api.PaymentMethods.List(params)
+	for iter.Next() {
+		pm := iter.PaymentMethod()
+		method := PaymentMethod{
+			Id:        pm.ID,
+			Type:      string(pm.Type),
+			IsDefault: pm.ID == defaultId,
+		}
+		if pm.Card != nil {
+			method.Brand = string(pm.Card.Brand)
+			method.Last4 = pm.Card.Last4
+			method.ExpMonth = pm.Card.ExpMonth
+			method.ExpYear = pm.Card.ExpYear
+		}
+		methods = append(methods, method)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, errors.Join(err, errors.New("could not list stripe payment methods"))
+	}
+
+	return methods, nil
+}
+
+func (g *StripeGateway) SetDefaultPaymentMethod(ctx context.Context, customerId string, paymentMethodId string, subscriptionId *string) error {
+	params := &stripe.CustomerParams{
+		InvoiceSettings: &stripe.CustomerInvoiceSettingsParams{
+			DefaultPaymentMethod: stripe.String(paymentMethodId),
+		},
+	}
+	params.Context = ctx
+
+	_, err := g.api.Customers.Update(customerId, params)
+	if err != nil {
+		return errors.Join(err, errors.New("could not update stripe customer"))
+	}
+
+	if subscriptionId == nil {
+		return nil
+	}
+
+	// subscriptions created by checkout have their own default, which wins over the customer one
+	subParams := &stripe.SubscriptionParams{
+		DefaultPaymentMethod: stripe.String(paymentMethodId),
+	}
+	subParams.Context = ctx
+
+	_, err = g.api.Subscriptions.Update(*subscriptionId, subParams)
+	if err != nil {
+		return errors.Join(err, errors.New("could not update stripe subscription"))
+	}
+
+	return nil
+}
+
+func (g *StripeGateway) Refund(ctx context.Context, p RefundParams) (Refund, error) {
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(p.ProviderPaymentId),
+		Amount:        stripe.Int64(p.Amount),
+		Reason:        stripe.String(string(p.Reason)),
+	}
+	params.Context = ctx
+	params.SetIdempotencyKey(p.IdempotencyKey)
+
+	refund, err := g.api.Refunds.New(params)
+	if err != nil {
+		return Refund{}, errors.Join(err, errors.New("could not create stripe refund"))
+	}
+
+	return Refund{
+		Id:                refund.ID,
+		ProviderPaymentId: p.ProviderPaymentId,
+		Amount:            refund.Amount,
+		Currency:          string(refund.Currency),
+	}, nil
+}
+
+func (g *StripeGateway) GetSubscription(ctx context.Context, subscriptionId string) (Subscription, error) {
+	params := &stripe.SubscriptionParams{}
+	params.Context = ctx
+
+	sub, err := g.api.Subscriptions.Get(subscriptionId, params)
+	if err != nil {
+		return Subscription{}, errors.Join(err, errors.New("could not get subscription from stripe"))
+	}
+
+	return stripeSubscription(sub), nil
+}
+
+func (g *StripeGateway) ChangeSubscription(ctx context.Context, subscriptionId string, providerPriceId string, quantity int64) (Subscription, error) {
+	sub, err := g.GetSubscription(ctx, subscriptionId)
+	if err != nil {
+		return sub, err
+	}
+	if sub.ItemId == nil {
+		return sub, fmt.Errorf("stripe subscription '%s' has no items", subscriptionId)
+	}
+
+	params := &stripe.SubscriptionParams{
+		Items: []*stripe.SubscriptionItemsParams{
+			{
+				ID:       sub.ItemId,
+				Price:    stripe.String(providerPriceId),
+				Quantity: stripe.Int64(quantity),
+			},
+		},
+		ProrationBehavior: stripe.String("create_prorations"),
+	}
+	params.Context = ctx
+
+	updated, err := g.api.Subscriptions.Update(subscriptionId, params)
+	if err != nil {
+		return sub, errors.Join(err, errors.New("could not update stripe subscription"))
+	}
+
+	return stripeSubscription(updated), nil
+}
+
+func (g *StripeGateway) CancelSubscriptionAtPeriodEnd(ctx context.Context, subscriptionId string) (Subscription, error) {
+	params := &stripe.SubscriptionParams{
+		CancelAtPeriodEnd: stripe.Bool(true),
+	}
+	params.Context = ctx
+
+	sub, err := g.api.Subscriptions.Update(subscriptionId, params)
+	if err != nil {
+		return Subscription{}, errors.Join(err, errors.New("could not cancel stripe subscription"))
+	}
+
+	return stripeSubscription(sub), nil
+}
+
+func (g *StripeGateway) CancelSubscription(ctx context.Context, subscriptionId string) (Subscription, error) {
+	params := &stripe.SubscriptionCancelParams{}
+	params.Context = ctx
+
+	sub, err := g.api.Subscriptions.Cancel(subscriptionId, params)
+	if err != nil {
+		return Subscription{}, errors.Join(err, errors.New("could not cancel stripe subscription"))
+	}
+
+	return stripeSubscription(sub), nil
+}
+
+func (g *StripeGateway) PayInvoice(ctx context.Context, invoiceId string) (Invoice, error) {
+	params := &stripe.InvoicePayParams{}
+	params.Context = ctx
+
+	in, err := g.api.Invoices.Pay(invoiceId, params)
+	if err != nil {
+		var stripeErr *stripe.Error
+		if errors.As(err, &stripeErr) && stripeErr.Type == stripe.ErrorTypeCard {
+			return Invoice{}, errors.Join(err, ErrPaymentFailed)
+		}
+		return Invoice{}, errors.Join(err, errors.New("could not pay stripe invoice"))
+	}
+
+	return stripeInvoice(in), nil
+}
+
+func (g *StripeGateway) SyncProduct(ctx context.Context, product Product) (string, error) {
+	params := &stripe.ProductParams{
+		Name:   stripe.String(product.Name),
+		Active: stripe.Bool(product.Active),
+	}
+	params.Context = ctx
+
+	if product.ProviderId != nil {
+		prod, err := g.api.Products.Update(*product.ProviderId, params)
+		if err != nil {
+			return "", errors.Join(err, errors.New("could not update stripe product"))
+		}
+		return prod.ID, nil
+	}
+
+	params.AddMetadata("reference_id", product.ReferenceId)
+	prod, err := g.api.Products.New(params)
+	if err != nil {
+		return "", errors.Join(err, errors.New("could not create stripe product"))
+	}
+	return prod.ID, nil
+}
+
+func (g *StripeGateway) SyncPrice(ctx context.Context, p Price, providerProductId string) (string, error) {
+	if p.ProviderId != nil {
+		params := &stripe.PriceParams{
+			Active: stripe.Bool(p.Active),
+		}
+		params.Context = ctx
+
+		stripePrice, err := g.api.Prices.Update(*p.ProviderId, params)
+		if err != nil {
+			return "", errors.Join(err, errors.New("could not update stripe price"))
+		}
+		return stripePrice.ID, nil
+	}
+
+	params := &stripe.PriceParams{
+		Product:    stripe.String(providerProductId),
+		Currency:   stripe.String(p.Currency),
+		UnitAmount: stripe.Int64(p.UnitAmount),
+		Active:     stripe.Bool(p.Active),
+	}
+	params.Context = ctx
+
+	if p.Interval != "" {
+		params.Recurring = &stripe.PriceRecurringParams{
+			Interval: stripe.String(p.Interval),
+		}
+	}
+	if p.Meter != nil && params.Recurring != nil {
+		params.Recurring.UsageType = stripe.String(string(stripe.PriceRecurringUsageTypeMetered))
+		params.Recurring.AggregateUsage = stripe.String(string(stripe.PriceRecurringAggregateUsageSum))
+		if p.MeterGauge {
+			params.Recurring.AggregateUsage = stripe.String(string(stripe.PriceRecurringAggregateUsageMax))
+		}
+		params.AddMetadata("meter", *p.Meter)
+	}
+	params.AddMetadata("reference_id", p.ReferenceId)
+
+	stripePrice, err := g.api.Prices.New(params)
+	if err != nil {
+		return "", errors.Join(err, errors.New("could not create stripe price"))
+	}
+	return stripePrice.ID, nil
+}
+
+func (g *StripeGateway) SyncCoupon(ctx context.Context, c Coupon) (string, error) {
+	if c.ProviderId != nil {
+		if c.Active {
+			return *c.ProviderId, nil
+		}
+
+		params := &stripe.CouponParams{}
+		params.Context = ctx
+
+		_, err := g.api.Coupons.Del(*c.ProviderId, params)
+		if err != nil {
+			return "", errors.Join(err, errors.New("could not delete stripe coupon"))
+		}
+		return *c.ProviderId, nil
+	}
+
+	params := &stripe.CouponParams{
+		Name:           stripe.String(c.Name),
+		Duration:       stripe.String(string(c.Duration)),
+		MaxRedemptions: c.MaxRedemptions,
+	}
+	params.Context = ctx
+
+	if c.PercentOff != nil {
+		params.PercentOff = stripe.Float64(float64(*c.PercentOff))
+	}
+	if c.AmountOff != nil {
+		params.AmountOff = c.AmountOff
+		params.Currency = stripe.String(c.Currency)
+	}
+	if c.RedeemBy != nil {
+		params.RedeemBy = stripe.Int64(c.RedeemBy.Unix())
+	}
+	params.AddMetadata("reference_id", c.ReferenceId)
+
+	coupon, err := g.api.Coupons.New(params)
+	if err != nil {
+		return "", errors.Join(err, errors.New("could not create stripe coupon"))
+	}
+	return coupon.ID, nil
+}
+
+func (g *StripeGateway) ReportUsage(ctx context.Context, report UsageReport) error {
+	params := &stripe.UsageRecordParams{
+		SubscriptionItem: stripe.String(report.SubscriptionItemId),
+		Quantity:         stripe.Int64(report.Quantity),
+		Timestamp:        stripe.Int64(report.Ts.Unix()),
+		Action:           stripe.String(stripe.UsageRecordActionIncrement),
+	}
+	params.Context = ctx
+	params.SetIdempotencyKey(report.IdempotencyKey)
+
+	_, err := g.api.UsageRecords.New(params)
+	if err != nil {
+		return errors.Join(err, errors.New("could not report usage record to stripe"))
+	}
+	return nil
+}
+
+func (g *StripeGateway) ParseWebhookEvent(payload []byte, header http.Header) (Event, error) {
+	stripeEvent, err := webhook.ConstructEventWithOptions(payload, header.Get("Stripe-Signature"), g.webhookSecret, webhook.ConstructEventOptions{
+		IgnoreAPIVersionMismatch: true,
+	})
+	if err != nil {
+		return Event{}, errors.Join(err, ErrInvalidSignature)
+	}
+
+	event := Event{
+		Id:   stripeEvent.ID,
+		Type: EventType(stripeEvent.Type),
+	}
+
+	switch stripeEvent.Type {
+	case stripe.EventTypeCheckoutSessionCompleted,
+		stripe.EventTypeCheckoutSessionAsyncPaymentSucceeded,
+		stripe.EventTypeCheckoutSessionAsyncPaymentFailed,
+		stripe.EventTypeCheckoutSessionExpired:
+		var cs stripe.CheckoutSession
+		err := json.Unmarshal(stripeEvent.Data.Raw, &cs)
+		if err != nil {
+			return event, errors.Join(err, errors.New("could not unmarshal checkout session"))
+		}
+		checkoutSession := stripeCheckoutSession(&cs)
+		event.CheckoutSession = &checkoutSession
+
+		switch stripeEvent.Type {
+		case stripe.EventTypeCheckoutSessionAsyncPaymentFailed:
+			event.Type = CheckoutPaymentFailedEvent
+		case stripe.EventTypeCheckoutSessionExpired:
+			event.Type = CheckoutExpiredEvent
+		default:
+			event.Type = CheckoutCompletedEvent
+		}
+
+	case stripe.EventTypeCustomerSubscriptionCreated,
+		stripe.EventTypeCustomerSubscriptionUpdated,
+		stripe.EventTypeCustomerSubscriptionDeleted,
+		stripe.EventTypeCustomerSubscriptionPaused,
+		stripe.EventTypeCustomerSubscriptionResumed:
+		var s stripe.Subscription
+		err := json.Unmarshal(stripeEvent.Data.Raw, &s)
+		if err != nil {
+			return event, errors.Join(err, errors.New("could not unmarshal subscription"))
+		}
+		sub := stripeSubscription(&s)
+		event.Subscription = &sub
+		event.Type = SubscriptionUpdatedEvent
+
+	case stripe.EventTypeInvoicePaid,
+		stripe.EventTypeInvoicePaymentFailed:
+		var in stripe.Invoice
+		err := json.Unmarshal(stripeEvent.Data.Raw, &in)
+		if err != nil {
+			return event, errors.Join(err, errors.New("could not unmarshal invoice"))
+		}
+		invoice := stripeInvoice(&in)
+		event.Invoice = &invoice
+		event.Type = InvoicePaidEvent
+		if stripeEvent.Type == stripe.EventTypeInvoicePaymentFailed {
+			event.Type = InvoicePaymentFailedEvent
+		}
+
+	case stripe.EventTypeChargeRefunded:
+		var ch stripe.Charge
+		err := json.Unmarshal(stripeEvent.Data.Raw, &ch)
+		if err != nil {
+			return event, errors.Join(err, errors.New("could not unmarshal charge"))
+		}
+		refund := Refund{
+			AmountRefunded: ch.AmountRefunded,
+			Currency:       string(ch.Currency),
+		}
+		if ch.PaymentIntent != nil {
+			refund.ProviderPaymentId = ch.PaymentIntent.ID
+		}
+		if ch.Refunds != nil && len(ch.Refunds.Data) > 0 {
+			refund.Id = ch.Refunds.Data[0].ID
+			refund.Amount = ch.Refunds.Data[0].Amount
+		}
+		event.Refund = &refund
+		event.Type = PaymentRefundedEvent
+
+	case stripe.EventTypeChargeDisputeCreated,
+		stripe.EventTypeChargeDisputeClosed:
+		var d stripe.Dispute
+		err := json.Unmarshal(stripeEvent.Data.Raw, &d)
+		if err != nil {
+			return event, errors.Join(err, errors.New("could not unmarshal dispute"))
+		}
+		dispute := Dispute{
+			Id:     d.ID,
+			Amount: d.Amount,
+			Status: DisputeOpen,
+			Reason: string(d.Reason),
+		}
+		if d.PaymentIntent != nil {
+			dispute.ProviderPaymentId = d.PaymentIntent.ID
+		}
+		event.Dispute = &dispute
+		event.Type = DisputeOpenedEvent
+
+		if stripeEvent.Type == stripe.EventTypeChargeDisputeClosed {
+			// warning_closed inquiries never moved funds, as a won dispute
+			dispute.Status = DisputeWon
+			if d.Status == stripe.DisputeStatusLost {
+				dispute.Status = DisputeLost
+			}
+			event.Type = DisputeClosedEvent
+		}
+	}
+
+	return event, nil
+}
+
+func stripeCheckoutSession(cs *stripe.CheckoutSession) CheckoutSession {
+	checkoutSession := CheckoutSession{
+		Id:            cs.ID,
+		Url:           cs.URL,
+		Mode:          CheckoutMode(cs.Mode),
+		ReferenceId:   cs.ClientReferenceID,
+		Status:        CheckoutStatus(cs.Status),
+		PaymentStatus: PaymentStatus(cs.PaymentStatus),
+	}
+	if cs.Subscription != nil {
+		checkoutSession.SubscriptionId = &cs.Subscription.ID
+	}
+	if cs.PaymentIntent != nil {
+		checkoutSession.PaymentId = &cs.PaymentIntent.ID
+	}
+	return checkoutSession
+}
+
+func stripeInvoice(in *stripe.Invoice) Invoice {
+	invoice := Invoice{
+		Id:          in.ID,
+		AmountDue:   in.AmountDue,
+		AmountPaid:  in.AmountPaid,
+		Currency:    string(in.Currency),
+		PeriodStart: time.Unix(in.PeriodStart, 0),
+		PeriodEnd:   time.Unix(in.PeriodEnd, 0),
+	}
+	// the invoice period is the one before the billed period, its lines have the billed one
+	if in.Lines != nil && len(in.Lines.Data) > 0 && in.Lines.Data[0].Period != nil {
+		invoice.PeriodStart = time.Unix(in.Lines.Data[0].Period.Start, 0)
+		invoice.PeriodEnd = time.Unix(in.Lines.Data[0].Period.End, 0)
+	}
+	if in.Subscription != nil {
+		invoice.SubscriptionId = &in.Subscription.ID
+	}
+	return invoice
+}
+
+func stripeSubscription(s *stripe.Subscription) Subscription {
+	sub := Subscription{
+		Id:                s.ID,
+		Status:            string(s.Status),
+		CancelAtPeriodEnd: s.CancelAtPeriodEnd,
+		Metadata:          s.Metadata,
+	}
+	if s.Customer != nil {
+		sub.CustomerId = &s.Customer.ID
+	}
+	if s.CurrentPeriodEnd != 0 {
+		t := time.Unix(s.CurrentPeriodEnd, 0)
+		sub.CurrentPeriodEnd = &t
+	}
+	if s.TrialEnd != 0 {
+		t := time.Unix(s.TrialEnd, 0)
+		sub.TrialEnd = &t
+	}
+	if s.Items != nil && len(s.Items.Data) > 0 {
+		item := s.Items.Data[0]
+		sub.ItemId = &item.ID
+		sub.Quantity = item.Quantity
+		if item.Price != nil {
+			sub.ProviderPriceId = &item.Price.ID
+		}
+	}
+	return sub
+}
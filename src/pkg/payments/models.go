@@ -0,0 +1,237 @@
+package payments
+
+import "time"
+
+type CheckoutMode string
+
+const (
+	PaymentMode      CheckoutMode = "payment"
+	SubscriptionMode CheckoutMode = "subscription"
+)
+
+type CheckoutStatus string
+
+const (
+	CheckoutOpen     CheckoutStatus = "open"
+	CheckoutComplete CheckoutStatus = "complete"
+	CheckoutExpired  CheckoutStatus = "expired"
+)
+
+type PaymentStatus string
+
+const (
+	Paid              PaymentStatus = "paid"
+	Unpaid            PaymentStatus = "unpaid"
+	NoPaymentRequired PaymentStatus = "no_payment_required"
+)
+
+// LineItem is the single item sold in a checkout, either a price that exists at
+// the provider (ProviderPriceId) or one created inline from the other fields.
+type LineItem struct {
+	ProviderPriceId *string
+	Name            string
+	Currency        string
+	UnitAmount      int64
+	Interval        string // empty for one-time prices
+	Quantity        *int64 // nil for metered prices
+}
+
+type CheckoutParams struct {
+	Mode        CheckoutMode
+	ReferenceId string
+	CustomerId  *string // nil creates a customer, one per checkout
+	LineItem    LineItem
+	Metadata    map[string]string // copied to the subscription in SubscriptionMode
+	SuccessUrl  string
+	CancelUrl   string
+
+	ProviderCouponId *string // discount applied to the checkout
+	TrialDays        int64   // free days before the first charge, SubscriptionMode only
+}
+
+type CheckoutSession struct {
+	Id             string         `json:"id"`
+	Url            string         `json:"url"`
+	Mode           CheckoutMode   `json:"mode"`
+	ReferenceId    string         `json:"referenceId"`
+	Status         CheckoutStatus `json:"status"`
+	PaymentStatus  PaymentStatus  `json:"paymentStatus"`
+	SubscriptionId *string        `json:"subscriptionId"`
+	PaymentId      *string        `json:"paymentId"` // provider payment, once paid in PaymentMode
+}
+
+// IsPaid reports whether the payment of the session is done, async payment
+// methods complete the session before it is.
+func (cs CheckoutSession) IsPaid() bool {
+	return cs.PaymentStatus == Paid || cs.PaymentStatus == NoPaymentRequired
+}
+
+type CustomerParams struct {
+	ReferenceId    string
+	Email          string
+	Name           string
+	IdempotencyKey string
+}
+
+// PaymentMethod is a payment method stored for a customer, the card fields
+// are empty for other types.
+type PaymentMethod struct {
+	Id        string `json:"id"`
+	Type      string `json:"type"`
+	Brand     string `json:"brand"`
+	Last4     string `json:"last4"`
+	ExpMonth  int64  `json:"expMonth"`
+	ExpYear   int64  `json:"expYear"`
+	IsDefault bool   `json:"isDefault"`
+}
+
+type Subscription struct {
+	Id                string            `json:"id"`
+	CustomerId        *string           `json:"customerId"`
+	Status            string            `json:"status"`
+	ItemId            *string           `json:"itemId"`
+	ProviderPriceId   *string           `json:"providerPriceId"`
+	Quantity          int64             `json:"quantity"`
+	CurrentPeriodEnd  *time.Time        `json:"currentPeriodEnd"`
+	TrialEnd          *time.Time        `json:"trialEnd"`
+	CancelAtPeriodEnd bool              `json:"cancelAtPeriodEnd"`
+	Metadata          map[string]string `json:"metadata"`
+}
+
+type Product struct {
+	ReferenceId string
+	ProviderId  *string
+	Name        string
+	Active      bool
+}
+
+type Price struct {
+	ReferenceId string
+	ProviderId  *string
+	Currency    string
+	UnitAmount  int64
+	Interval    string // empty for one-time prices
+	Meter       *string
+	MeterGauge  bool // gauges bill the max usage of the period instead of the sum
+	Active      bool
+}
+
+// CouponDuration is how many invoices of a subscription a coupon discounts.
+type CouponDuration string
+
+const (
+	OnceCoupon    CouponDuration = "once"
+	ForeverCoupon CouponDuration = "forever"
+)
+
+// Coupon is a discount applied to checkouts, either PercentOff or AmountOff (in Currency) is set.
+type Coupon struct {
+	ReferenceId    string
+	ProviderId     *string
+	Name           string
+	PercentOff     *int64
+	AmountOff      *int64
+	Currency       string
+	Duration       CouponDuration
+	MaxRedemptions *int64
+	RedeemBy       *time.Time
+	Active         bool
+}
+
+// Discount is how much the coupon takes off amount, percentages are rounded half up.
+func (c Coupon) Discount(amount int64) int64 {
+	if c.PercentOff != nil {
+		return (amount**c.PercentOff + 50) / 100
+	}
+	if c.AmountOff != nil {
+		return min(*c.AmountOff, amount)
+	}
+	return 0
+}
+
+type UsageReport struct {
+	SubscriptionItemId string
+	Quantity           int64
+	Ts                 time.Time
+	IdempotencyKey     string
+}
+
+// Invoice is an invoice of a subscription period, AmountPaid is zero until it is paid.
+type Invoice struct {
+	Id             string    `json:"id"`
+	SubscriptionId *string   `json:"subscriptionId"`
+	AmountDue      int64     `json:"amountDue"`
+	AmountPaid     int64     `json:"amountPaid"`
+	Currency       string    `json:"currency"`
+	PeriodStart    time.Time `json:"periodStart"`
+	PeriodEnd      time.Time `json:"periodEnd"`
+}
+
+// RefundReason is why a payment is refunded.
+type RefundReason string
+
+const (
+	DuplicateRefund           RefundReason = "duplicate"
+	FraudulentRefund          RefundReason = "fraudulent"
+	RequestedByCustomerRefund RefundReason = "requested_by_customer"
+)
+
+type RefundParams struct {
+	ProviderPaymentId string
+	Amount            int64
+	Reason            RefundReason
+	IdempotencyKey    string
+}
+
+// Refund is money returned from a payment. AmountRefunded is the total refunded
+// from the payment so far, it is only known in events.
+type Refund struct {
+	Id                string `json:"id"`
+	ProviderPaymentId string `json:"providerPaymentId"`
+	Amount            int64  `json:"amount"`
+	AmountRefunded    int64  `json:"amountRefunded"`
+	Currency          string `json:"currency"`
+}
+
+type DisputeStatus string
+
+const (
+	DisputeOpen DisputeStatus = "open"
+	DisputeWon  DisputeStatus = "won"
+	DisputeLost DisputeStatus = "lost"
+)
+
+// Dispute is a chargeback of a payment requested by the customer to their bank.
+type Dispute struct {
+	Id                string        `json:"id"`
+	ProviderPaymentId string        `json:"providerPaymentId"`
+	Amount            int64         `json:"amount"`
+	Status            DisputeStatus `json:"status"`
+	Reason            string        `json:"reason"`
+}
+
+type EventType string
+
+const (
+	CheckoutCompletedEvent     EventType = "checkout.completed"
+	CheckoutPaymentFailedEvent EventType = "checkout.payment_failed"
+	CheckoutExpiredEvent       EventType = "checkout.expired"
+	PaymentRefundedEvent       EventType = "payment.refunded"
+	DisputeOpenedEvent         EventType = "dispute.opened"
+	DisputeClosedEvent         EventType = "dispute.closed"
+	SubscriptionUpdatedEvent   EventType = "subscription.updated"
+	InvoicePaidEvent           EventType = "invoice.paid"
+	InvoicePaymentFailedEvent  EventType = "invoice.payment_failed"
+)
+
+// Event is a webhook event of the gateway, unknown provider events keep their
+// provider type and carry no object.
+type Event struct {
+	Id              string           `json:"id"`
+	Type            EventType        `json:"type"`
+	CheckoutSession *CheckoutSession `json:"checkoutSession,omitempty"`
+	Subscription    *Subscription    `json:"subscription,omitempty"`
+	Invoice         *Invoice         `json:"invoice,omitempty"`
+	Refund          *Refund          `json:"refund,omitempty"`
+	Dispute         *Dispute         `json:"dispute,omitempty"`
+}
@@ -0,0 +1,40 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestDocument_Bytes(t *testing.T) {
+	d := New()
+	d.Text(50, 50, 12, true, "Receipt (paid) \\ café")
+	d.Line(50, 60, 545, 60)
+	d.AddPage()
+	d.TextRight(545, 50, 10, false, "page 2")
+
+	b := d.Bytes()
+
+	m := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(b)
+	if m == nil {
+		t.Fatalf("missing startxref trailer")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(b[xref:], []byte("xref\n0 9\n")) {
+		t.Fatalf("startxref does not point to an xref of 8 objects: %q", b[xref:xref+16])
+	}
+
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(b[xref:], -1)
+	for i, e := range entries {
+		off, _ := strconv.Atoi(string(e[1]))
+		if !bytes.HasPrefix(b[off:], fmt.Appendf(nil, "%d 0 obj", i+1)) {
+			t.Errorf("xref entry %d does not point to its object", i+1)
+		}
+	}
+
+	if !bytes.Contains(b, []byte(`(Receipt \(paid\) \\ caf\351)`)) {
+		t.Errorf("text was not escaped to a WinAnsi literal string")
+	}
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"maps"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	S3_BACKEND     string = "s3"
+	FS_BACKEND     string = "fs"
+	MEMORY_BACKEND string = "memory"
+)
+
+var ErrInvalidSignature = errors.New("invalid or expired object url signature")
+
+// UrlSigner signs the URLs of the objects served by the API itself, for the storage
+// backends without signed URLs of their own. The URLs are baseUrl/<bucket>/<path>,
+// valid for a single method until they expire.
+type UrlSigner struct {
+	baseUrl string
+	secret  []byte
+}
+
+func NewUrlSigner(baseUrl string, secret []byte) *UrlSigner {
+	return &UrlSigner{
+		baseUrl: baseUrl,
+		secret:  secret,
+	}
+}
+
+func (s *UrlSigner) signature(method string, bucket string, path string, params url.Values, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(strings.Join([]string{method, bucket, path, params.Encode(), strconv.FormatInt(expires, 10)}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns the URL of the object, valid for method during exp. The params are added to
+// its query and signed with it, so the request made with the URL can be checked against them.
+func (s *UrlSigner) Sign(method string, bucket string, path string, params url.Values, exp time.Duration) (string, error) {
+	u, err := url.JoinPath(s.baseUrl, bucket, path)
+	if err != nil {
+		return "", err
+	}
+
+	expires := time.Now().Add(exp).Unix()
+	query := url.Values{}
+	maps.Copy(query, params)
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", s.signature(method, bucket, path, params, expires))
+	return u + "?" + query.Encode(), nil
+}
+
+// UploadParams are the params of the URL of an upload of a body of the size and content type, see CheckUpload.
+func UploadParams(size int64, contentType string) url.Values {
+	return url.Values{
+		"size":         {strconv.FormatInt(size, 10)},
+		"content-type": {contentType},
+	}
+}
+
+// CheckUpload checks the size and content type of the body of an upload against the params of
+// its URL, see UploadParams.
+func CheckUpload(query url.Values, size int64, contentType string) error {
+	if query.Get("size") != strconv.FormatInt(size, 10) || query.Get("content-type") != contentType {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Verify checks the query of a request for the object made with a URL of Sign, with the params it was signed with.
+func (s *UrlSigner) Verify(method string, bucket string, path string, query url.Values) error {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return ErrInvalidSignature
+	}
+
+	params := url.Values{}
+	maps.Copy(params, query)
+	params.Del("expires")
+	params.Del("signature")
+	if !hmac.Equal([]byte(query.Get("signature")), []byte(s.signature(method, bucket, path, params, expires))) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"net/url"
 	"path"
+	"strings"
 
 	"github.com/LombardiDaniel/goliath/src/pkg/constants"
 )
@@ -11,10 +12,12 @@ type storageDir string
 
 const (
 	UserAvatars storageDir = "user-avatars"
+	Receipts    storageDir = "receipts"
+	Uploads     storageDir = "uploads"
 )
 
 func GetFullObjUrl(objPath string) (string, error) {
-	return url.JoinPath(constants.S3Endpoint, constants.S3Bucket, objPath)
+	return url.JoinPath(constants.ObjectsPublicUrl, constants.S3Bucket, objPath)
 }
 
 func GetPublicPath(p storageDir, filename string) string {
@@ -24,3 +27,8 @@ func GetPublicPath(p storageDir, filename string) string {
 func GetPrivatePath(p storageDir, filename string) string {
 	return path.Join("private", string(p), filename)
 }
+
+// IsPublicPath reports whether the object is readable by anyone, see GetPublicPath.
+func IsPublicPath(objPath string) bool {
+	return strings.HasPrefix(objPath, "public/")
+}
@@ -0,0 +1,68 @@
+package token
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeriveKey(t *testing.T) {
+	secret := []byte("jwt-secret")
+
+	unsubscribe, err := DeriveKey(secret, "unsubscribe-links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := DeriveKey(secret, "unsubscribe-links")
+	if err != nil || !bytes.Equal(unsubscribe, again) {
+		t.Errorf("keys of the same purpose should be the same, got %v", err)
+	}
+
+	objects, err := DeriveKey(secret, "object-urls")
+	if err != nil || bytes.Equal(unsubscribe, objects) || bytes.Equal(unsubscribe, secret) {
+		t.Errorf("keys of other purposes should differ from each other and from the secret, got %v", err)
+	}
+
+	if _, err := DeriveKey(nil, "unsubscribe-links"); err == nil {
+		t.Errorf("expected an error deriving from an empty secret")
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	secret := []byte("signing-key")
+	now := time.Now()
+
+	tok := Sign(secret, "42:billing")
+	value, issuedAt, err := Verify(secret, tok, time.Hour)
+	if err != nil || value != "42:billing" || issuedAt.Sub(now).Abs() > time.Second {
+		t.Fatalf("Verify() = %q, %s, %v", value, issuedAt, err)
+	}
+
+	// values may hold the separator of the token
+	if value, _, err := Verify(secret, Sign(secret, "a.b.c"), time.Hour); err != nil || value != "a.b.c" {
+		t.Errorf("Verify() = %q, %v, want the value with its dots", value, err)
+	}
+
+	version, unix, encValue, sig := func() (string, string, string, string) {
+		parts := strings.Split(tok, ".")
+		return parts[0], parts[1], parts[2], parts[3]
+	}()
+
+	for name, tok := range map[string]string{
+		"empty":          "",
+		"other key":      Sign([]byte("other-key"), "42:billing"),
+		"tampered value": version + "." + unix + "." + encValue + "x." + sig,
+		"tampered time":  version + ".1." + encValue + "." + sig,
+		"other version":  "v0." + unix + "." + encValue + "." + base64.RawURLEncoding.EncodeToString(signature(secret, "v0."+unix+"."+encValue)),
+		"expired":        signAt(secret, "42:billing", now.Add(-2*time.Hour)),
+		"issued ahead":   signAt(secret, "42:billing", now.Add(time.Hour)),
+		"not a token":    "42:billing",
+	} {
+		if _, _, err := Verify(secret, tok, time.Hour); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+}
@@ -1,10 +1,18 @@
 package validators
 
-import "github.com/LombardiDaniel/goliath/src/pkg/constants"
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/LombardiDaniel/goliath/src/pkg/constants"
+	"github.com/lib/pq"
+)
 
 const (
 	errUniqueConstraint string = "duplicate key value violates unique constraint"
 	errNoRows           string = "no rows in result"
+
+	pqUniqueViolation pq.ErrorCode = "23505"
 )
 
 func FilterSqlPgError(err error) error {
@@ -12,6 +20,15 @@ func FilterSqlPgError(err error) error {
 		return nil
 	}
 
+	if errors.Is(err, sql.ErrNoRows) {
+		return constants.ErrNoRows
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation {
+		return constants.ErrDbConflict
+	}
+
 	errStr := err.Error()
 
 	switch errStr {
@@ -8,9 +8,12 @@ CREATE TABLE users (
     last_name VARCHAR(50) NOT NULL,
     date_of_birth DATE,
     avatar_url VARCHAR DEFAULT NULL,
+    locale VARCHAR(35) DEFAULT NULL, -- NULL follows the organization or the default locale
+    unsubscribe_revoked_at TIMESTAMPTZ, -- unsubscribe links issued before no longer work
     created_at TIMESTAMPTZ DEFAULT NOW(),
     updated_at TIMESTAMPTZ DEFAULT NOW(),
     is_active BOOLEAN NOT NULL DEFAULT true,
+    is_admin BOOLEAN NOT NULL DEFAULT false,
 
     UNIQUE (user_id, email)
 );
@@ -35,17 +38,68 @@ CREATE TABLE unconfirmed_users (
     password_hash VARCHAR(255) NOT NULL,
     first_name VARCHAR(50),
     last_name VARCHAR(50),
-    date_of_birth DATE
+    date_of_birth DATE,
+    locale VARCHAR(35) DEFAULT NULL
+);
+
+-- billing plans catalog
+CREATE TABLE plans (
+    plan_id SERIAL PRIMARY KEY,
+    plan_name VARCHAR(100) UNIQUE NOT NULL,
+    features JSONB DEFAULT '[]' NOT NULL,
+    limits JSONB DEFAULT '{}' NOT NULL, -- absent limits are unlimited
+    is_active BOOLEAN DEFAULT true NOT NULL,
+    provider_product_id VARCHAR(255) DEFAULT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+-- prices are immutable, to change one create a new price and deactivate the old one
+CREATE TABLE prices (
+    price_id SERIAL PRIMARY KEY,
+    plan_id INT REFERENCES plans (plan_id) NOT NULL,
+    unit_ammount BIGINT NOT NULL CHECK (unit_ammount >= 0),
+    unit_currency CHAR(3) NOT NULL,
+    billing_interval TEXT CHECK (billing_interval IN ('one_time', 'day', 'week', 'month', 'year')) DEFAULT 'one_time' NOT NULL,
+    meter VARCHAR(64) DEFAULT NULL, -- metered prices charge unit_ammount per unit of usage
+    trial_days INT DEFAULT 0 NOT NULL CHECK (trial_days BETWEEN 0 AND 730), -- offered once per organization
+    is_active BOOLEAN DEFAULT true NOT NULL,
+    provider_price_id VARCHAR(255) DEFAULT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+
+    CHECK (meter IS NULL OR billing_interval <> 'one_time'),
+    CHECK (trial_days = 0 OR billing_interval <> 'one_time')
+);
+
+-- discounts redeemed by code at checkout, coupons without plan_ids apply to all plans
+CREATE TABLE coupons (
+    coupon_id SERIAL PRIMARY KEY,
+    code VARCHAR(64) UNIQUE NOT NULL, -- always uppercase
+    percent_off INT CHECK (percent_off BETWEEN 1 AND 100),
+    ammount_off BIGINT CHECK (ammount_off > 0),
+    currency CHAR(3),
+    duration TEXT CHECK (duration IN ('once', 'forever')) DEFAULT 'once' NOT NULL,
+    plan_ids JSONB DEFAULT '[]' NOT NULL,
+    max_redemptions INT CHECK (max_redemptions > 0),
+    times_redeemed INT DEFAULT 0 NOT NULL,
+    expires_at TIMESTAMPTZ,
+    is_active BOOLEAN DEFAULT true NOT NULL,
+    provider_coupon_id VARCHAR(255) DEFAULT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+
+    CHECK ((percent_off IS NULL) <> (ammount_off IS NULL)),
+    CHECK ((ammount_off IS NULL) = (currency IS NULL))
 );
 
 -- organizations
 CREATE TABLE organizations ( 
     organization_id CHAR(5) PRIMARY KEY,
     organization_name VARCHAR(100) NOT NULL,
-    billing_plan_id INT,
+    billing_plan_id INT REFERENCES plans (plan_id),
+    storage_bytes BIGINT DEFAULT 0 NOT NULL,
     created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
     deleted_at TIMESTAMPTZ,
     owner_user_id INT REFERENCES users (user_id) NOT NULL ,
+    default_locale VARCHAR(35) DEFAULT NULL, -- of the members without a locale of their own
 
     UNIQUE (organization_name, owner_user_id)
 );
@@ -122,6 +176,17 @@ CREATE TABLE oauth_users (
     PRIMARY KEY (email, oauth_provider)
 );
 
+-- customers at the payment provider, reused by every checkout of their user or organization
+CREATE TABLE billing_customers (
+    billing_customer_id SERIAL PRIMARY KEY,
+    user_id INT UNIQUE REFERENCES users (user_id),
+    organization_id CHAR(5) UNIQUE REFERENCES organizations (organization_id),
+    provider_customer_id VARCHAR(255) UNIQUE NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+
+    CHECK ((user_id IS NULL) <> (organization_id IS NULL))
+);
+
 -- NOTE: There needs to be a way to link it to the product, coding that is up to the final user
 -- payments
 CREATE TABLE payments (
@@ -129,13 +194,292 @@ CREATE TABLE payments (
     -- reverse: the product sold references the payment
     -- product_id VARCHAR(255), -- REFERENCES products(product_id),
     user_id INT REFERENCES users (user_id) NOT NULL,
+    price_id INT REFERENCES prices (price_id),
     -- ammount DECIMAL(20, 2) NOT NULL,
     unit_ammount BIGINT NOT NULL,
     unit_currency CHAR(3) NOT NULL,
-    payment_status TEXT CHECK (payment_status IN ('pending', 'complete', 'canceled')) DEFAULT 'pending',
-    stripe_checkout_session_id VARCHAR(255) NULL,
+    payment_status TEXT CHECK (payment_status IN ('pending', 'complete', 'canceled', 'expired', 'partially_refunded', 'refunded', 'disputed', 'dispute_lost')) DEFAULT 'pending',
+    refunded_ammount BIGINT DEFAULT 0 NOT NULL CHECK (refunded_ammount >= 0 AND refunded_ammount <= unit_ammount),
+    coupon_id INT REFERENCES coupons (coupon_id), -- unit_ammount is what is charged, after discount_ammount
+    discount_ammount BIGINT DEFAULT 0 NOT NULL CHECK (discount_ammount >= 0),
+    provider_checkout_session_id VARCHAR(255) NULL,
+    provider_payment_intent_id VARCHAR(255) UNIQUE,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    completed_at TIMESTAMPTZ DEFAULT NULL,
+    -- checks against the gateway of payments left pending, failed ones are retried at next_reconcile_at
+    reconcile_attempts INT DEFAULT 0 NOT NULL,
+    last_reconciled_at TIMESTAMPTZ,
+    next_reconcile_at TIMESTAMPTZ
+);
+
+CREATE INDEX payments_reconcile_idx ON payments (last_reconciled_at NULLS FIRST, created_at) WHERE payment_status = 'pending';
+
+-- history of a payment, event_type is the status it moved to or the action taken on it
+CREATE TABLE payment_events (
+    payment_event_id BIGSERIAL PRIMARY KEY,
+    payment_id UUID REFERENCES payments (payment_id) NOT NULL,
+    event_type VARCHAR(64) NOT NULL,
+    ammount BIGINT,
+    details TEXT,
+    actor_user_id INT REFERENCES users (user_id),
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX payment_events_payment_idx ON payment_events (payment_id, created_at);
+
+-- organization subscriptions, state is driven by the provider webhooks
+CREATE TABLE subscriptions (
+    subscription_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id CHAR(5) REFERENCES organizations (organization_id) NOT NULL,
+    plan_id INT REFERENCES plans (plan_id) NOT NULL,
+    price_id INT REFERENCES prices (price_id) NOT NULL,
+    seats INT DEFAULT 1 NOT NULL CHECK (seats > 0),
+    subscription_status TEXT CHECK (subscription_status IN ('incomplete', 'incomplete_expired', 'trialing', 'active', 'past_due', 'canceled', 'unpaid', 'paused')) DEFAULT 'incomplete' NOT NULL,
+    provider_subscription_id VARCHAR(255) UNIQUE,
+    provider_subscription_item_id VARCHAR(255),
+    provider_customer_id VARCHAR(255),
+    provider_checkout_session_id VARCHAR(255) UNIQUE,
+    current_period_end TIMESTAMPTZ,
+    trial_end TIMESTAMPTZ,
+    cancel_at_period_end BOOLEAN DEFAULT false NOT NULL,
+    coupon_id INT REFERENCES coupons (coupon_id),
+    discount_ammount BIGINT DEFAULT 0 NOT NULL CHECK (discount_ammount >= 0), -- off the first charge
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+-- an organization has at most one live subscription
+CREATE UNIQUE INDEX subscriptions_live_organization_idx ON subscriptions (organization_id)
+WHERE subscription_status NOT IN ('canceled', 'incomplete_expired');
+
+CREATE TRIGGER update_subscriptions_updated_at_trigger
+BEFORE UPDATE ON subscriptions
+FOR EACH ROW EXECUTE PROCEDURE update_updated_at();
+
+-- coupons redeemed by completed payments (of a user) and subscription checkouts (of an organization)
+CREATE TABLE coupon_redemptions (
+    coupon_redemption_id BIGSERIAL PRIMARY KEY,
+    coupon_id INT REFERENCES coupons (coupon_id) NOT NULL,
+    user_id INT REFERENCES users (user_id),
+    organization_id CHAR(5) REFERENCES organizations (organization_id),
+    payment_id UUID UNIQUE REFERENCES payments (payment_id),
+    subscription_id UUID UNIQUE REFERENCES subscriptions (subscription_id),
+    discount_ammount BIGINT NOT NULL,
+    currency CHAR(3) NOT NULL,
+    redeemed_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+
+    CHECK ((payment_id IS NULL) <> (subscription_id IS NULL))
+);
+
+CREATE INDEX coupon_redemptions_coupon_idx ON coupon_redemptions (coupon_id, redeemed_at DESC);
+
+-- failed renewal charges of subscriptions, retried on a schedule, then given a grace period before the
+-- subscription is suspended. next_attempt_at is set while retrying, grace_ends_at once in grace, warned_at
+-- once the owner is warned of the suspension. Steps that fail are deferred to process_after
+CREATE TABLE dunning_cases (
+    dunning_case_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    subscription_id UUID REFERENCES subscriptions (subscription_id) NOT NULL,
+    organization_id CHAR(5) REFERENCES organizations (organization_id) NOT NULL,
+    provider_invoice_id VARCHAR(255) UNIQUE NOT NULL,
+    ammount_due BIGINT NOT NULL CHECK (ammount_due >= 0),
+    currency CHAR(3) NOT NULL,
+    dunning_status TEXT CHECK (dunning_status IN ('retrying', 'grace', 'recovered', 'suspended', 'canceled')) DEFAULT 'retrying' NOT NULL,
+    attempt_count INT DEFAULT 1 NOT NULL CHECK (attempt_count > 0),
+    next_attempt_at TIMESTAMPTZ,
+    grace_ends_at TIMESTAMPTZ,
+    warned_at TIMESTAMPTZ,
+    last_error TEXT,
+    process_failures INT DEFAULT 0 NOT NULL,
+    process_after TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    resolved_at TIMESTAMPTZ,
+
+    CHECK ((dunning_status = 'retrying') = (next_attempt_at IS NOT NULL)),
+    CHECK ((dunning_status IN ('retrying', 'grace')) = (resolved_at IS NULL))
+);
+
+-- a subscription is dunned for one invoice at a time
+CREATE UNIQUE INDEX dunning_cases_open_subscription_idx ON dunning_cases (subscription_id)
+WHERE dunning_status IN ('retrying', 'grace');
+
+CREATE INDEX dunning_cases_organization_idx ON dunning_cases (organization_id, created_at DESC);
+
+CREATE TRIGGER update_dunning_cases_updated_at_trigger
+BEFORE UPDATE ON dunning_cases
+FOR EACH ROW EXECUTE PROCEDURE update_updated_at();
+
+-- usage per organization in hourly buckets, reported_quantity of it was sent to the payment provider
+-- (last at reported_at), skipped_at is set on buckets past the period the provider still takes
+CREATE TABLE usage_records (
+    organization_id CHAR(5) REFERENCES organizations (organization_id) NOT NULL,
+    meter VARCHAR(64) NOT NULL,
+    period_start TIMESTAMPTZ NOT NULL,
+    quantity BIGINT DEFAULT 0 NOT NULL,
+    reported_quantity BIGINT DEFAULT 0 NOT NULL,
+    reported_at TIMESTAMPTZ,
+    skipped_at TIMESTAMPTZ,
+
+    PRIMARY KEY (organization_id, meter, period_start)
+);
+
+CREATE INDEX usage_records_unreported_idx ON usage_records (period_start)
+WHERE quantity > reported_quantity AND skipped_at IS NULL;
+
+-- billing details printed on the invoices of an organization
+CREATE TABLE organization_billing_details (
+    organization_id CHAR(5) PRIMARY KEY REFERENCES organizations (organization_id),
+    company_name VARCHAR(255) NOT NULL,
+    tax_id VARCHAR(64),
+    address_line1 VARCHAR(255) NOT NULL,
+    address_line2 VARCHAR(255),
+    city VARCHAR(100) NOT NULL,
+    state VARCHAR(100),
+    postal_code VARCHAR(20),
+    country CHAR(2) NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE TRIGGER update_organization_billing_details_updated_at_trigger
+BEFORE UPDATE ON organization_billing_details
+FOR EACH ROW EXECUTE PROCEDURE update_updated_at();
+
+-- invoices are issued for completed payments (billed to a user) and paid subscription
+-- periods (billed to an organization), billing details are a snapshot at issue time
+-- the last invoice number issued, incremented in the transaction that inserts the invoice,
+-- so a number is only used up by an invoice actually issued and numbers have no gaps
+CREATE TABLE invoice_numbers (
+    last_number BIGINT NOT NULL
+);
+
+INSERT INTO invoice_numbers (last_number) VALUES (0);
+
+CREATE TABLE invoices (
+    invoice_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    invoice_number VARCHAR(32) UNIQUE NOT NULL,
+    user_id INT REFERENCES users (user_id),
+    organization_id CHAR(5) REFERENCES organizations (organization_id),
+    payment_id UUID UNIQUE REFERENCES payments (payment_id),
+    subscription_id UUID REFERENCES subscriptions (subscription_id),
+    provider_invoice_id VARCHAR(255) UNIQUE,
+    description VARCHAR(255) NOT NULL,
+    ammount BIGINT NOT NULL,
+    currency CHAR(3) NOT NULL,
+    billing_details JSONB DEFAULT '{}' NOT NULL,
+    period_start TIMESTAMPTZ,
+    period_end TIMESTAMPTZ,
+    receipt_path VARCHAR(255),
+    issued_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+
+    CHECK ((user_id IS NULL) <> (organization_id IS NULL))
+);
+
+CREATE INDEX invoices_user_idx ON invoices (user_id, issued_at DESC) WHERE user_id IS NOT NULL;
+CREATE INDEX invoices_organization_idx ON invoices (organization_id, issued_at DESC) WHERE organization_id IS NOT NULL;
+
+-- charges of the subscriptions of organizations by gateway invoice, failed ones become paid if a retry succeeds
+CREATE TABLE subscription_payments (
+    provider_invoice_id VARCHAR(255) PRIMARY KEY,
+    organization_id CHAR(5) REFERENCES organizations (organization_id) NOT NULL,
+    subscription_id UUID REFERENCES subscriptions (subscription_id) NOT NULL,
+    ammount BIGINT NOT NULL,
+    currency CHAR(3) NOT NULL,
+    payment_status TEXT CHECK (payment_status IN ('paid', 'failed')) NOT NULL,
+    period_start TIMESTAMPTZ,
+    period_end TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    paid_at TIMESTAMPTZ
+);
+
+CREATE INDEX subscription_payments_organization_idx ON subscription_payments (organization_id, created_at DESC);
+CREATE INDEX payments_user_idx ON payments (user_id, created_at DESC);
+
+-- emails enqueued with the change they notify about, delivered with retries. The payload holds
+-- the data of the template and is cleared once sent, as it may hold one-time passwords
+CREATE TABLE email_outbox (
+    email_id BIGSERIAL PRIMARY KEY,
+    template_name VARCHAR(64) NOT NULL,
+    recipient VARCHAR(255) NOT NULL,
+    locale VARCHAR(35) DEFAULT '' NOT NULL,
+    payload JSONB,
+    email_status TEXT CHECK (email_status IN ('pending', 'sent', 'dead', 'suppressed')) DEFAULT 'pending' NOT NULL,
+    attempts INT DEFAULT 0 NOT NULL,
+    next_attempt_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    locked_until TIMESTAMPTZ, -- while claimed by a delivery
+    last_error TEXT,
     created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
-    completed_at TIMESTAMPTZ DEFAULT NULL
+    sent_at TIMESTAMPTZ,
+
+    CHECK ((email_status IN ('sent', 'suppressed')) = (payload IS NULL))
+);
+
+CREATE INDEX email_outbox_due_idx ON email_outbox (next_attempt_at) WHERE email_status = 'pending';
+CREATE INDEX email_outbox_created_idx ON email_outbox (created_at DESC);
+
+-- the notification categories users opted in or out of, absent ones have their default
+CREATE TABLE notification_preferences (
+    user_id INT REFERENCES users (user_id) NOT NULL,
+    category TEXT CHECK (category IN ('billing', 'org_activity', 'product_news')) NOT NULL,
+    email_enabled BOOLEAN NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+
+    PRIMARY KEY (user_id, category)
+);
+
+-- in-app notifications, each insert is published on the 'notifications' channel for the live streams
+CREATE TABLE notifications (
+    notification_id BIGSERIAL PRIMARY KEY,
+    user_id INT REFERENCES users (user_id) NOT NULL,
+    kind VARCHAR(64) NOT NULL,
+    category TEXT CHECK (category IN ('security', 'billing', 'org_activity', 'product_news')) NOT NULL,
+    data JSONB DEFAULT '{}' NOT NULL,
+    read_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX notifications_user_idx ON notifications (user_id, created_at DESC);
+CREATE INDEX notifications_unread_idx ON notifications (user_id) WHERE read_at IS NULL;
+
+-- what email providers reported about each address, emails to suppressed ones are not sent
+CREATE TABLE email_deliverability (
+    email VARCHAR(255) PRIMARY KEY, -- lowercase
+    email_status TEXT CHECK (email_status IN ('delivered', 'soft_bounced', 'bounced', 'complained')) NOT NULL,
+    soft_bounces INT DEFAULT 0 NOT NULL, -- in a row
+    last_reason TEXT,
+    last_event_at TIMESTAMPTZ NOT NULL,
+    suppressed_at TIMESTAMPTZ
+);
+
+CREATE INDEX email_deliverability_suppressed_idx ON email_deliverability (suppressed_at DESC) WHERE suppressed_at IS NOT NULL;
+
+-- uploads of users, resumable in parts or direct to the storage through a presigned URL, their objects
+-- count in the storage of the organization once completed, those left incomplete are deleted once expired
+CREATE TABLE uploads (
+    upload_id VARCHAR(64) PRIMARY KEY,
+    user_id INT REFERENCES users (user_id) NOT NULL,
+    organization_id CHAR(5) REFERENCES organizations (organization_id),
+    object_path TEXT NOT NULL,
+    multipart_id TEXT, -- of the storage, NULL for direct uploads
+    purpose VARCHAR(32) DEFAULT 'file' NOT NULL,
+    file_name VARCHAR(255) NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    declared_size BIGINT, -- of direct uploads, checked once confirmed
+    size BIGINT,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX uploads_user_idx ON uploads (user_id);
+CREATE INDEX uploads_expires_at_idx ON uploads (expires_at) WHERE completed_at IS NULL;
+
+-- webhook events claimed or handled, providers retry deliveries so handlers must be idempotent; a claim
+-- left unprocessed past its timeout (the process died while handling it) is taken over by a retry
+CREATE TABLE processed_webhook_events (
+    event_id VARCHAR(255) PRIMARY KEY,
+    event_type VARCHAR(255) NOT NULL,
+    claimed_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    processed_at TIMESTAMPTZ
 );
 
 COMMIT;